@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+type createAPIKeyInput struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	/* Optional, RFC 3339; keys don't expire without it */
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+/*
+Keys are managed with the user's own token, a key can't mint or revoke keys.
+Writes the 403 and returns false for requests authenticated with a key.
+*/
+func (app *application) requireUserCredentials(w http.ResponseWriter, r *http.Request) bool {
+	if app.contextGetUser(r).Scopes != nil {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* The response is the only time the key itself is shown */
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	var input createAPIKeyInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	key := &data.APIKey{
+		UserID:    int64(user.ID),
+		Name:      input.Name,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), int64(user.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(r.Context(), key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Delete(r.Context(), id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* Requests with these methods are recorded in the audit log */
+var auditedMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+/* A resource before and after a request, see recordChange */
+type auditChange struct {
+	before any
+	after  any
+}
+
+type auditFieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+/*
+Records the request in the audit log once next is done with it. Wraps the
+route's handler, inside the authentication, so only the requests that were let
+through are recorded; the resource type is the first segment of the route after
+/v1, the resource ID its :id parameter.
+*/
+func (app *application) audit(rt route, next http.HandlerFunc) http.HandlerFunc {
+	if !slices.Contains(auditedMethods, rt.method) {
+		return next
+	}
+
+	/* The segment after the version, e.g. "movies" of /v2/movies/:id */
+	resourceType := strings.Split(rt.path, "/")[2]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		change := &auditChange{}
+
+		next(mw, r.WithContext(context.WithValue(r.Context(), auditContextKey, change)))
+
+		entry := &data.AuditEntry{
+			Method:       r.Method,
+			Route:        rt.path,
+			Path:         r.URL.Path,
+			ResourceType: resourceType,
+			Status:       mw.statusCode,
+			IP:           app.clientIP(r),
+			RequestID:    app.contextGetRequestID(r),
+		}
+
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			userID := int64(user.ID)
+			entry.UserID = &userID
+		}
+
+		if id := httprouter.ParamsFromContext(r.Context()).ByName("id"); id != "" {
+			entry.ResourceID = &id
+		}
+
+		if change.before != nil || change.after != nil {
+			changes, err := auditDiff(change.before, change.after)
+			if err != nil {
+				app.logError(r, err)
+			}
+			entry.Changes = changes
+		}
+
+		/* The response is sent, so the client going away doesn't cancel the insert */
+		err := app.models.AuditLog.Insert(context.WithoutCancel(r.Context()), entry)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+/*
+Records the resource the request changed, before is nil for a created resource
+and after nil for a deleted one. Only the JSON fields that differ are logged.
+*/
+func (app *application) recordChange(r *http.Request, before, after any) {
+	change, ok := r.Context().Value(auditContextKey).(*auditChange)
+	if !ok {
+		return
+	}
+
+	change.before, change.after = before, after
+}
+
+/* The fields of the JSON objects of before and after that differ */
+func auditDiff(before, after any) (json.RawMessage, error) {
+	toMap := func(v any) (map[string]any, error) {
+		m := map[string]any{}
+		if v == nil {
+			return m, nil
+		}
+
+		js, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return m, json.Unmarshal(js, &m)
+	}
+
+	b, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]auditFieldChange{}
+
+	for key, value := range b {
+		if !reflect.DeepEqual(value, a[key]) {
+			changes[key] = auditFieldChange{Before: value, After: a[key]}
+		}
+	}
+
+	for key, value := range a {
+		if _, ok := b[key]; !ok {
+			changes[key] = auditFieldChange{Before: nil, After: value}
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		search data.AuditSearch
+		f      data.Filters
+	)
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search.UserID = int64(app.readInt(qs, "user_id", 0, v))
+	search.ResourceType = app.readString(qs, "resource_type", "")
+	search.From = app.readTime(qs, "from", v)
+	search.To = app.readTime(qs, "to", v)
+
+	if search.From != nil && search.To != nil {
+		v.CheckField(search.From.Before(*search.To), "to", validator.Message("validation.after", "from"))
+	}
+
+	f.Page = app.readInt(qs, "page", 1, v)
+	f.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	/* Always newest first, the sort isn't read */
+	f.Sort = "-created_at"
+	f.SortSafelist = []string{"-created_at"}
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.AuditLog.GetAll(r.Context(), search, f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"metadata": metadata, "audit_log": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+/* Addresses or CIDR ranges (space separated), single addresses become /32 or /128 prefixes */
+func parseTrustedProxies(val string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+
+	for _, field := range strings.Fields(val) {
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (app *application) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range app.config.proxies.trusted {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+The client's IP address, for rate limiting and the audit log. X-Forwarded-For
+and X-Real-IP are only believed when the request comes from one of
+-trusted-proxies, anyone else could send them to pass as someone else. The
+client is the address nearest the end of X-Forwarded-For that isn't a trusted
+proxy, the ones before it were added by whoever sent the request to the proxy.
+*/
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !app.trustedProxy(peer) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			/* Garbage from before the first trusted proxy, the proxy that saw it is the best guess */
+			break
+		}
+
+		if !app.trustedProxy(addr) {
+			return addr.Unmap().String()
+		}
+
+		host = addr.Unmap().String()
+	}
+
+	if len(forwarded) == 1 && strings.TrimSpace(forwarded[0]) == "" {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+
+	return host
+}
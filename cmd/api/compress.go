@@ -0,0 +1,247 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Responses smaller than this are sent as is, compressing them isn't worth it */
+const minCompressSize = 1024
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}}
+	flateWriters = sync.Pool{New: func() any {
+		zw, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return zw
+	}}
+)
+
+/*
+Compresses responses with gzip or deflate, whichever the client prefers. The
+decision is put off until the first minCompressSize bytes are written, the
+response ends or the handler flushes, so small responses and ones that are
+compressed already (images, archives) or streamed (SSE) go out as they are.
+*/
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+/* gzip or deflate by their q-values, gzip when they tie; empty if neither is accepted */
+func negotiateEncoding(header string) string {
+	var (
+		best  string
+		bestQ float64
+	)
+
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if name == "*" {
+			name = "gzip"
+		}
+
+		if (name == "gzip" || name == "deflate") && q > 0 && (q > bestQ || (q == bestQ && name == "gzip")) {
+			best, bestQ = name, q
+		}
+	}
+
+	return best
+}
+
+/* Types that are compressed already, or streamed and must reach the client as they are written */
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasPrefix(mediaType, "image/svg"):
+		return true
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return false
+	}
+
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd", "application/octet-stream":
+		return false
+	}
+
+	return true
+}
+
+/*
+Holds back the status and the first bytes until it knows whether to compress.
+Unwrap gives http.ResponseController, and through it the metricsResponseWriter
+underneath, the original writer.
+*/
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	zw       interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	/* Informational responses, e.g. 103 Early Hints, don't end the headers */
+	if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	cw.status = status
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < minCompressSize {
+			return len(b), nil
+		}
+
+		err := cw.decide()
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.zw != nil {
+		return cw.zw.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+/* Sends what is buffered, compressed or not, a flushing handler is streaming */
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+
+	if cw.zw != nil {
+		cw.zw.Flush()
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+/* Sends the status and the buffered bytes, through a compressor if the response qualifies */
+func (cw *compressResponseWriter) decide() error {
+	cw.decided = true
+
+	h := cw.Header()
+
+	if len(cw.buf) >= minCompressSize &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified && cw.status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+
+		if cw.encoding == "gzip" {
+			cw.zw = gzipWriters.Get().(*gzip.Writer)
+		} else {
+			cw.zw = flateWriters.Get().(*flate.Writer)
+		}
+		cw.zw.Reset(cw.ResponseWriter)
+
+		/* net/http would sniff the type of the compressed bytes */
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.zw != nil {
+		_, err = cw.zw.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+/*
+Ends the response. A hijacked connection, e.g. a WebSocket, was never written
+to through cw, so nothing is sent on it.
+*/
+func (cw *compressResponseWriter) close() {
+	if !cw.decided {
+		if cw.buf == nil && cw.status == http.StatusOK {
+			/* Nothing written, net/http sends the 200 (or the hijacker took over) */
+			return
+		}
+		cw.decide()
+	}
+
+	if cw.zw == nil {
+		return
+	}
+
+	cw.zw.Close()
+
+	switch zw := cw.zw.(type) {
+	case *gzip.Writer:
+		zw.Reset(io.Discard)
+		gzipWriters.Put(zw)
+	case *flate.Writer:
+		zw.Reset(io.Discard)
+		flateWriters.Put(zw)
+	}
+}
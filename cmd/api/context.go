@@ -9,9 +9,20 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+	bodyLimitContextKey = contextKey("body_limit")
+	auditContextKey     = contextKey("audit")
+	/* See contextUserID */
+	requestLogUserContextKey = contextKey("request_log_user")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if logged, ok := r.Context().Value(requestLogUserContextKey).(*requestLogUser); ok && !user.IsAnonymous() {
+		logged.id = user.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -24,3 +35,25 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+/* Empty for requests that didn't go through logRequest, e.g. gRPC calls */
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetBodyLimit(r *http.Request, limit int64) *http.Request {
+	ctx := context.WithValue(r.Context(), bodyLimitContextKey, limit)
+	return r.WithContext(ctx)
+}
+
+/* Only set for routes with a limit of their own */
+func (app *application) contextGetBodyLimit(r *http.Request) (int64, bool) {
+	limit, ok := r.Context().Value(bodyLimitContextKey).(int64)
+	return limit, ok
+}
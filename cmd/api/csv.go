@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+const (
+	maxImportSize = 10 << 20
+	maxImportRows = 10_000
+	/* Exports stream the whole catalogue, the server's WriteTimeout is too short for that */
+	exportWriteTimeout = time.Minute
+)
+
+/* Columns of an export, genres are comma separated within their field */
+var movieCSVHeader = []string{"id", "title", "year", "runtime", "genres", "average_rating", "version"}
+
+/* Columns an import must have, any others (e.g. id from an export) are ignored */
+var movieImportColumns = []string{"title", "year", "runtime", "genres"}
+
+/* The parameters of an export, of GET /v1/movies/export and POST /v1/movies/export-jobs */
+type movieExport struct {
+	Format string
+	data.MovieSearch
+	data.Filters
+}
+
+/* The same filters as GET /v1/movies, sorted by id unless given */
+func (app *application) readMovieExport(qs url.Values, v *validator.Validator) movieExport {
+	var input movieExport
+
+	input.Format = app.readString(qs, "format", "csv")
+	input.MovieSearch = app.readMovieSearch(qs, v)
+
+	input.Sort = app.readString(qs, "sort", "id")
+	input.SortSafelist = movieSortSafelist
+
+	v.CheckField(validator.In(input.Format, "csv"), "format", validator.Message("validation.one_of", "csv"))
+	v.CheckField(validator.In(input.Sort, input.SortSafelist...), "sort", validator.Message("validation.invalid_sort"))
+	data.ValidateMovieSearch(v, input.MovieSearch)
+
+	return input
+}
+
+/* Writes every movie matching the same filters as GET /v1/movies, no paging */
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	input := app.readMovieExport(r.URL.Query(), v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var out *csv.Writer
+
+	/* Delay the headers until the first row so query errors still get a proper 500 */
+	start := func() error {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+		w.WriteHeader(http.StatusOK)
+
+		out = csv.NewWriter(w)
+		return out.Write(movieCSVHeader)
+	}
+
+	err := app.models.Movies.StreamAll(r.Context(), input.MovieSearch, input.Filters, func(movie *data.Movie) error {
+		if out == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		return out.Write(movieCSVRecord(movie))
+	})
+
+	switch {
+	case err != nil && out == nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	case err != nil:
+		/* Too late for an error response, abort so the client sees a broken body */
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	case out == nil:
+		err = start()
+	}
+
+	if err == nil {
+		out.Flush()
+		err = out.Error()
+	}
+
+	if err != nil {
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func movieCSVRecord(movie *data.Movie) []string {
+	var averageRating string
+	if movie.AverageRating != nil {
+		averageRating = strconv.FormatFloat(*movie.AverageRating, 'f', 2, 64)
+	}
+
+	return []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		strconv.FormatInt(int64(movie.Runtime), 10),
+		strings.Join(movie.Genres, ","),
+		averageRating,
+		strconv.FormatInt(int64(movie.Version), 10),
+	}
+}
+
+/*
+Expects a multipart/form-data body with the CSV in a "file" field, its first
+line naming the columns. Every row is validated before anything is inserted;
+errors are keyed by line, e.g. "rows[3].year" for line 3 counting the header.
+With ?dry_run=true the rows are only validated.
+*/
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	/* The body is capped by the route, see its maxBody */
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+			v.AddError("file", validator.Message("validation.required"))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.As(err, &maxBytesError):
+			v.AddError("file", validator.Message("validation.max_size", "10 MB"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	movies, err := readMovieCSV(v, file)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if dryRun {
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"dry_run": true, "valid_rows": len(movies)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Movies.InsertAll(r.Context(), movies)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	/* One event per movie, like movies created one at a time */
+	for _, movie := range movies {
+		app.publishEvent(r.Context(), events.MovieCreated, movie)
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"imported": len(movies)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+Reads and validates the movies of a CSV file. Problems with the rows are added
+to v, the error is only for files that aren't CSV at all.
+*/
+func readMovieCSV(v *validator.Validator, file io.Reader) ([]*data.Movie, error) {
+	in := csv.NewReader(file)
+	in.ReuseRecord = true
+	/* Short rows are reported with the other row errors rather than failing the file */
+	in.FieldsPerRecord = -1
+
+	header, err := in.Read()
+	switch {
+	case errors.Is(err, io.EOF):
+		v.AddError("file", validator.Message("validation.not_empty"))
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range movieImportColumns {
+		_, ok := columns[name]
+		v.CheckField(ok, "file", validator.Message("validation.column", name))
+	}
+
+	if !v.Valid() {
+		return nil, nil
+	}
+
+	var movies []*data.Movie
+
+	for {
+		record, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return nil, fmt.Errorf("file is not valid CSV: %w", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := in.FieldPos(0)
+
+		if len(movies) == maxImportRows {
+			v.AddError("file", validator.Message("validation.max_rows", maxImportRows))
+			return nil, nil
+		}
+
+		rv := validator.New()
+		movie := movieFromCSV(rv, record, columns)
+
+		/* A field that didn't parse only reports that, not the zero value it was left at */
+		mv := validator.New()
+		data.ValidateMovie(mv, movie)
+		for key, messages := range mv.Errors {
+			if _, ok := rv.Errors[key]; !ok {
+				rv.Errors[key] = messages
+			}
+		}
+
+		v.Merge(validator.IndexKey("rows", line), rv)
+
+		movies = append(movies, movie)
+	}
+
+	v.CheckField(len(movies) > 0, "file", validator.Message("validation.no_rows"))
+
+	return movies, nil
+}
+
+/* Fields that don't parse are reported to v and left at their zero value */
+func movieFromCSV(v *validator.Validator, record []string, columns map[string]int) *data.Movie {
+	field := func(name string) string {
+		if columns[name] >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[columns[name]])
+	}
+
+	movie := &data.Movie{Title: field("title")}
+
+	if s := field("year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			v.AddError("year", validator.Message("validation.integer"))
+		}
+		movie.Year = int32(year)
+	}
+
+	if s := field("runtime"); s != "" {
+		runtime, err := data.ParseRuntime(s)
+		if err != nil {
+			v.AddError("runtime", validator.Message("validation.runtime_format"))
+		}
+		movie.Runtime = runtime
+	}
+
+	if s := field("genres"); s != "" {
+		movie.Genres = strings.Split(s, ",")
+		for i := range movie.Genres {
+			movie.Genres[i] = strings.TrimSpace(movie.Genres[i])
+		}
+	}
+
+	return movie
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/mohafarman/greenlight/internal/msgpack"
+)
+
+/* Turns an envelope (or an unwrapped value, see shapeEnvelope) into a response body */
+type responseEncoder struct {
+	contentType string
+	/* r is nil when the response is written without negotiation, e.g. by writeJSON */
+	encode func(w io.Writer, r *http.Request, data any, compact bool) error
+	/* Encoders with their own document structure always get the untouched envelope */
+	ownsEnvelope bool
+}
+
+/* Supported response formats, the first one is the default */
+var responseEncoders = []responseEncoder{
+	{contentType: "application/json", encode: encodeJSON},
+	{contentType: "application/xml", encode: encodeXML},
+	{contentType: "text/xml", encode: encodeXML},
+	{contentType: "application/msgpack", encode: encodeMsgpack},
+	{contentType: "application/x-msgpack", encode: encodeMsgpack},
+	{contentType: "application/vnd.api+json", encode: encodeJSONAPI, ownsEnvelope: true},
+}
+
+/* Used for RFC 7807 error bodies */
+var problemEncoder = responseEncoder{contentType: "application/problem+json", encode: encodeJSON}
+
+/*
+Answers 406 before the handler runs when the route can't respond in any of the
+types the client accepts. Routes with a contentType of their own (CSV, SSE)
+offer it and JSON, the others every responseEncoder.
+*/
+func (app *application) requireAcceptable(rt route, next http.HandlerFunc) http.HandlerFunc {
+	offers := []string{rt.contentType, "application/json"}
+	if rt.contentType == "" {
+		offers = make([]string, 0, len(responseEncoders)+1)
+		for _, enc := range responseEncoders {
+			offers = append(offers, enc.contentType)
+		}
+		offers = append(offers, problemEncoder.contentType)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if negotiateContentType(r, offers...) == "" {
+			app.notAcceptableResponse(w, r, offers)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+/* Falls back to the default (JSON) encoder when nothing else is acceptable */
+func negotiateEncoder(r *http.Request) responseEncoder {
+	offers := make([]string, len(responseEncoders))
+	for i, enc := range responseEncoders {
+		offers[i] = enc.contentType
+	}
+
+	contentType := negotiateContentType(r, offers...)
+
+	for _, enc := range responseEncoders {
+		if enc.contentType == contentType {
+			return enc
+		}
+	}
+
+	return responseEncoders[0]
+}
+
+func encodeJSON(w io.Writer, _ *http.Request, data any, compact bool) error {
+	enc := json.NewEncoder(w)
+
+	// INFO: Indented output is nicer in a terminal but larger and slower, so
+	// production responses are written compact
+	if !compact {
+		enc.SetIndent("", "\t")
+	}
+
+	/* Encode terminates the value with a newline */
+	return enc.Encode(data)
+}
+
+func encodeXML(w io.Writer, _ *http.Request, data any, compact bool) error {
+	/* An unwrapped value still needs a single root element */
+	if _, ok := data.(envelope); !ok {
+		data = xmlRoot{data}
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	if !compact {
+		enc.Indent("", "\t")
+	}
+
+	err = enc.Encode(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func encodeMsgpack(w io.Writer, _ *http.Request, data any, _ bool) error {
+	b, err := msgpack.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+/* Response bodies are encoded into pooled buffers to save an allocation per request */
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+/* Buffers that grew past this are dropped instead of pinning the memory in the pool */
+const maxPooledBufferSize = 1 << 20
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
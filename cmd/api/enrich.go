@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/cache"
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/enrich"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/*
+The provider of -enrich-provider, nil when movies aren't enriched. Its answers
+are kept in the movie cache, or in one of their own with -cache-store=none.
+*/
+func openEnricher(cfg config, c cache.Cache) (enrich.Provider, error) {
+	var provider enrich.Provider
+
+	switch cfg.enrich.provider {
+	case "":
+		return nil, nil
+	case "tmdb":
+		provider = enrich.NewTMDB(cfg.enrich.apiKey)
+	case "omdb":
+		provider = enrich.NewOMDb(cfg.enrich.apiKey)
+	default:
+		return nil, fmt.Errorf("invalid -enrich-provider %q, must be tmdb or omdb", cfg.enrich.provider)
+	}
+
+	if cfg.enrich.apiKey == "" {
+		return nil, fmt.Errorf("-enrich-provider=%s requires -enrich-api-key", cfg.enrich.provider)
+	}
+
+	if c == nil {
+		c = cache.NewMemory(1_000)
+	}
+
+	return enrich.NewCached(provider, cfg.enrich.provider, c, cfg.enrich.cacheTTL), nil
+}
+
+/* Stores what was looked up about the movie */
+func (app *application) storeEnrichment(ctx context.Context, movie *data.Movie, md *enrich.Metadata) error {
+	movie.Plot = md.Plot
+	movie.IMDbID = md.IMDbID
+	movie.IMDbRating = md.IMDbRating
+	movie.Cast = md.Cast
+
+	err := app.models.Movies.SetEnrichment(ctx, movie, md.PosterURL)
+	if err != nil {
+		return err
+	}
+
+	app.publishEvent(ctx, events.MovieUpdated, movie)
+
+	return nil
+}
+
+/*
+Enriches a movie that was just created with -enrich-on-create, in the
+background so the client doesn't wait for the provider. It gets a copy, the
+handler's movie is still being written to the client, and the tenant of ctx.
+Failures are logged, the movie can still be enriched through
+POST /v1/movies/:id/enrich.
+*/
+func (app *application) enrichCreatedMovie(ctx context.Context, movie data.Movie) {
+	tenant := data.TenantID(ctx)
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(data.WithTenant(context.Background(), tenant), 30*time.Second)
+		defer cancel()
+
+		md, err := app.enricher.Lookup(ctx, movie.Title, movie.Year)
+		if err == nil {
+			err = app.storeEnrichment(ctx, &movie, md)
+		}
+
+		switch {
+		case errors.Is(err, enrich.ErrNotFound):
+			app.logger.Info("no metadata found for movie", "movie_id", movie.ID)
+		case err != nil:
+			app.logger.Error(err.Error(), "movie_id", movie.ID)
+		}
+	})
+}
+
+/* Fills in the plot, poster, IMDb rating and cast from the -enrich-provider */
+func (app *application) enrichMovieHandler(w http.ResponseWriter, r *http.Request) {
+	if app.enricher == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	md, err := app.enricher.Lookup(r.Context(), movie.Title, movie.Year)
+	if err != nil {
+		switch {
+		case errors.Is(err, enrich.ErrNotFound):
+			v := validator.New()
+			v.AddError("movie", validator.Message("validation.no_metadata_match"))
+			app.failedValidationResponse(w, r, v.Errors)
+		case r.Context().Err() != nil:
+			/* A 504 once the route's timeout is up */
+			app.serverErrorResponse(w, r, err)
+		default:
+			app.badGatewayResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.storeEnrichment(r.Context(), movie, md)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
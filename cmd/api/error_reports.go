@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/errreport"
+)
+
+/* The reporter of -error-report-dsn, nil when errors are only logged */
+func openErrorReporter(cfg config) (errreport.Reporter, error) {
+	if cfg.errorReport.dsn == "" {
+		return nil, nil
+	}
+
+	return errreport.NewSentry(errreport.SentryConfig{
+		DSN:         cfg.errorReport.dsn,
+		SampleRate:  cfg.errorReport.sampleRate,
+		Environment: cfg.env,
+		Release:     version,
+	})
+}
+
+/* What recoverPanic recovered, reported as a panic rather than as an error */
+type panicError struct {
+	value any
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprint(e.value)
+}
+
+/*
+Reports the error serverErrorResponse is about to answer with a 500, with the
+stack it was called with; for a panic that still contains the panicking frames.
+Sent in the background, the client doesn't wait for the error tracker.
+*/
+func (app *application) reportError(r *http.Request, err error, stack []errreport.Frame) {
+	if app.errorReporter == nil {
+		return
+	}
+
+	event := &errreport.Event{
+		Time:      time.Now(),
+		Type:      fmt.Sprintf("%T", innermost(err)),
+		Message:   err.Error(),
+		Stack:     stack,
+		Request:   r.Clone(context.Background()),
+		RequestID: app.contextGetRequestID(r),
+		UserID:    contextUserID(r.Context()),
+	}
+
+	if errors.As(err, new(panicError)) {
+		event.Type = "panic"
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := app.errorReporter.Report(ctx, event)
+		if err != nil {
+			app.logger.Error(err.Error(), "error_report", event.Message)
+		}
+	})
+}
+
+/* The error at the bottom of a chain of wrapped ones, which is what tells errors apart */
+func innermost(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
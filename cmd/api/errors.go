@@ -1,85 +1,240 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/errreport"
+	"github.com/mohafarman/greenlight/internal/validator"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Error(err, map[string]string{
-		"request_metod": r.Method,
-		"request_url":   r.URL.String(),
-	})
+	app.logger.ErrorContext(r.Context(), err.Error(),
+		"request_method", r.Method,
+		"request_url", r.URL.String(),
+	)
+}
+
+/* RFC 7807 problem details, written when -error-format=problem */
+type problemDetails struct {
+	Type     string              `json:"type"`
+	Title    string              `json:"title"`
+	Status   int                 `json:"status"`
+	Detail   string              `json:"detail,omitempty"`
+	Instance string              `json:"instance,omitempty"`
+	Errors   map[string][]string `json:"errors,omitempty"`
+	/* Extension member, lets support find the request in the logs */
+	RequestID string `json:"request_id,omitempty"`
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+	var err error
+
+	w.Header().Set("Content-Language", app.locale(r))
+	w.Header().Add("Vary", "Accept-Language")
+
+	if app.config.errorFormat == "problem" {
+		err = app.writeProblem(w, r, status, message)
+	} else {
+		/* Errors keep their envelope whatever -response-envelope says */
+		env := envelope{"error": message}
+		if id := app.contextGetRequestID(r); id != "" {
+			env["request_id"] = id
+		}
+		err = app.writeEncoded(w, r, status, env, nil, responseEncoders[0])
+	}
 
-	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+/* Validation errors become the "errors" extension member, other messages the detail */
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, status int, message any) error {
+	problem := problemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Instance:  r.URL.Path,
+		RequestID: app.contextGetRequestID(r),
+	}
+
+	switch message := message.(type) {
+	case map[string][]string:
+		problem.Detail = app.translate(r, "validation_failed")
+		problem.Errors = message
+	default:
+		problem.Detail = fmt.Sprint(message)
+	}
+
+	return app.writeEncoded(w, r, status, problem, nil, problemEncoder)
+}
+
+/*
+Body errors that concern a single field (e.g. a bad runtime) are reported as
+validation errors, bodies over the limit as 413s.
+*/
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var validationError *validator.ValidationError
+	if errors.As(err, &validationError) {
+		app.failedValidationResponse(w, r, validationError.Errors)
+		return
+	}
+
+	var tooLarge *bodyTooLargeError
+	if errors.As(err, &tooLarge) {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logError(r, err)
+	/* The queries were cancelled by requestTimeout, it's the route that was too slow */
+	if errors.Is(context.Cause(r.Context()), errRequestTimeout) {
+		app.logError(r, err)
+		app.requestTimeoutResponse(w, r)
+		return
+	}
+
+	/* The client went away and its queries were cancelled, there is nothing to fix */
+	if !(errors.Is(err, context.Canceled) && r.Context().Err() != nil) {
+		app.logError(r, err)
+		app.reportError(r, err, errreport.Stacktrace(1))
+	}
 
-	message := "the server encountered a problem and could not process your request"
+	message := app.translate(r, "server_error")
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	message := "rate limit exceeded"
+	message := app.translate(r, "rate_limit_exceeded")
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+/* The user's monthly quota is used up, see enforceQuota */
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "quota_exceeded")
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+/* The tenant's monthly quota is used up, which takes a bigger plan rather than waiting */
+func (app *application) tenantQuotaExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "tenant_quota_exceeded")
+	app.errorResponse(w, r, http.StatusPaymentRequired, message)
+}
+
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
+	message := app.translate(r, "not_found")
 	app.errorResponse(w, r, http.StatusNotFound, message)
 }
 
+/* None of the offered media types is in the client's Accept header */
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, offers []string) {
+	message := app.translate(r, "not_acceptable", strings.Join(offers, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
+
+/* A service the request depends on failed, e.g. the -enrich-provider */
+func (app *application) badGatewayResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	message := app.translate(r, "bad_gateway")
+	app.errorResponse(w, r, http.StatusBadGateway, message)
+}
+
+/* The route took longer than its timeout, see requestTimeout */
+func (app *application) requestTimeoutResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "request_timeout")
+	app.errorResponse(w, r, http.StatusGatewayTimeout, message)
+}
+
+/* /v1 after its -v1-sunset-at */
+func (app *application) versionRetiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "version_retired")
+	app.errorResponse(w, r, http.StatusGone, message)
+}
+
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	message := app.translate(r, "method_not_allowed", r.Method)
 	app.errorResponse(w, r, http.StatusNotFound, message)
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+/* Messages made with validator.Message are written out in the caller's language */
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string][]string) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, validator.Localize(errors, app.locale(r)))
+}
+
+/* Maps errors returned by the models to the matching response, anything unknown is a 500 */
+func (app *application) modelErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var validationError *validator.ValidationError
+
+	switch {
+	case errors.As(err, &validationError):
+		app.failedValidationResponse(w, r, validationError.Errors)
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the record due to an edit conflict, please try again"
+	message := app.translate(r, "edit_conflict")
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "precondition_failed")
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "precondition_required")
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	message := "invalid authentication credentials"
+	message := app.translate(r, "invalid_credentials")
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+/* The password was right, the login is retried with a totp_code or recovery_code */
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "two_factor_required")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
-	message := "invalid or missing authentication token"
+	message := app.translate(r, "invalid_authentication_token")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated to access this resource"
+	message := app.translate(r, "authentication_required")
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your account must be activated to access this resource"
+	message := app.translate(r, "inactive_account")
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account doesn't have the necessary permissions to access this resource"
+	message := app.translate(r, "not_permitted")
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/validator"
+	"github.com/mohafarman/greenlight/internal/websocket"
+)
+
+/* Event types streamed by /v1/events, user events only go to webhooks */
+var catalogueEventTypes = []string{events.MovieCreated, events.MovieUpdated, events.MovieDeleted}
+
+const (
+	/* Events queued per client before it is considered too slow and dropped */
+	eventStreamBuffer = 64
+	/* Keeps proxies from closing idle streams */
+	eventStreamHeartbeat    = 30 * time.Second
+	eventStreamWriteTimeout = 10 * time.Second
+)
+
+/* Publishes the event on the bus as one of the tenant in ctx */
+func (app *application) publishEvent(ctx context.Context, eventType string, payload any) {
+	app.events.Publish(data.TenantID(ctx), eventType, payload)
+}
+
+/*
+Streams catalogue changes as Server-Sent Events, or as WebSocket text messages
+when the request asks for an upgrade. Each message is a JSON encoded
+events.Event. A client that falls too far behind is disconnected and should
+reconnect and refetch what it shows.
+
+An event stream resumes after the event in the Last-Event-ID header, which
+EventSource sends when it reconnects, or ?last_event_id=. When the events since
+can't all be replayed, e.g. after a restart, a resync event comes first and the
+client should refetch what it shows.
+*/
+func (app *application) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	types := app.readCSV(r.URL.Query(), "types", catalogueEventTypes)
+
+	validator.Each(v, "types", types, func(v *validator.Validator, eventType string) {
+		v.CheckField(validator.In(eventType, catalogueEventTypes...), "", validator.Message("validation.catalogue_event_type"))
+	})
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var resumeAfter uint64
+	if lastEventID != "" {
+		var err error
+
+		resumeAfter, err = strconv.ParseUint(lastEventID, 10, 64)
+		v.CheckField(err == nil, "last_event_id", validator.Message("validation.event_id"))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	/* Upgrade before subscribing so a failed handshake can still get an error response */
+	var conn *websocket.Conn
+	if websocket.IsUpgrade(r) {
+		var err error
+
+		conn, err = websocket.Upgrade(w, r)
+		if err != nil {
+			switch {
+			case errors.Is(err, websocket.ErrBadHandshake):
+				app.badRequestResponse(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	queue := make(chan events.Event, eventStreamBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+
+	tenant := data.TenantID(r.Context())
+
+	handler := func(event events.Event) {
+		if !slices.Contains(types, event.Type) || event.Tenant != tenant {
+			return
+		}
+
+		/* Never block the publishing request on a slow client */
+		select {
+		case queue <- event:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	}
+
+	/* WebSocket messages carry no IDs to resume after */
+	if conn != nil {
+		unsubscribe := app.events.Subscribe(handler)
+		defer unsubscribe()
+
+		app.streamWebSocketEvents(conn, queue, overflow)
+		return
+	}
+
+	var (
+		missed   []events.Event
+		complete = true
+	)
+
+	if lastEventID != "" {
+		var unsubscribe func()
+		missed, complete, unsubscribe = app.events.SubscribeSince(resumeAfter, handler)
+		defer unsubscribe()
+
+		missed = slices.DeleteFunc(missed, func(event events.Event) bool {
+			return !slices.Contains(types, event.Type) || event.Tenant != tenant
+		})
+	} else {
+		unsubscribe := app.events.Subscribe(handler)
+		defer unsubscribe()
+	}
+
+	app.streamServerSentEvents(w, r, missed, complete, queue, overflow)
+}
+
+/* Writes the missed events, or a resync event when they are not complete, and then those of queue */
+func (app *application) streamServerSentEvents(w http.ResponseWriter, r *http.Request, missed []events.Event, complete bool, queue <-chan events.Event, overflow <-chan struct{}) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	/* Stops nginx from buffering the stream */
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	/* INFO: The server's WriteTimeout would end the stream, so the deadline is pushed back before every write */
+	write := func(message string) error {
+		err := rc.SetWriteDeadline(time.Now().Add(eventStreamWriteTimeout))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(w, message)
+		if err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	/* Ask EventSource to reconnect after 5 seconds */
+	err := write("retry: 5000\n\n")
+	if err != nil {
+		return
+	}
+
+	if !complete {
+		err = write("event: resync\ndata: {}\n\n")
+		if err != nil {
+			return
+		}
+	}
+
+	for _, event := range missed {
+		message, ok := app.serverSentEvent(event)
+		if !ok {
+			continue
+		}
+
+		err = write(message)
+		if err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(eventStreamHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		var message string
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.events.Done():
+			return
+		case <-overflow:
+			return
+		case <-ticker.C:
+			message = ": heartbeat\n\n"
+		case event := <-queue:
+			var ok bool
+			if message, ok = app.serverSentEvent(event); !ok {
+				continue
+			}
+		}
+
+		err := write(message)
+		if err != nil {
+			return
+		}
+	}
+}
+
+/* The event as an SSE message with its ID, false when it can't be encoded */
+func (app *application) serverSentEvent(event events.Event) (string, bool) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		app.logger.Error(err.Error(), "event_type", event.Type)
+		return "", false
+	}
+
+	return fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload), true
+}
+
+func (app *application) streamWebSocketEvents(conn *websocket.Conn, queue <-chan events.Event, overflow <-chan struct{}) {
+	/* INFO: Shutdown() doesn't wait for hijacked connections, so let shutdown wait until the close frame is sent */
+	app.wg.Add(1)
+	defer app.wg.Done()
+
+	ticker := time.NewTicker(eventStreamHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-conn.Done():
+			return
+		case <-app.events.Done():
+			conn.Close(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-overflow:
+			conn.Close(websocket.CloseTryAgainLater, "client too slow")
+			return
+		case <-ticker.C:
+			err = conn.Ping()
+		case event := <-queue:
+			payload, marshalErr := json.Marshal(event)
+			if marshalErr != nil {
+				app.logger.Error(marshalErr.Error(), "event_type", event.Type)
+				continue
+			}
+
+			err = conn.WriteText(payload)
+		}
+
+		if err != nil {
+			conn.Close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+	"github.com/mohafarman/greenlight/internal/worker"
+)
+
+const (
+	/* The progress of a running export is recorded every so many rows */
+	exportProgressRows = 1000
+	/* Writing and uploading the file, for catalogues too large for GET /v1/movies/export */
+	exportJobTimeout      = 30 * time.Minute
+	exportCleanupInterval = 10 * time.Minute
+)
+
+/*
+Queues an export of the movies matching the filters of GET /v1/movies/export,
+given in the query string as well. The job is run by the job queue, the client
+polls GET /v1/export-jobs/:id until it is completed and downloads the file.
+*/
+func (app *application) createExportJobHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	input := app.readMovieExport(r.URL.Query(), v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	job := &data.ExportJob{
+		UserID: int64(app.contextGetUser(r).ID),
+		Format: input.Format,
+		Search: input.MovieSearch,
+		Sort:   input.Sort,
+	}
+
+	err := app.models.ExportJobs.Insert(r.Context(), job, app.config.exports.ttl)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.jobs.Enqueue("export", func(ctx context.Context) error {
+		app.runExportJob(ctx, job)
+		return nil
+	})
+	if err != nil {
+		app.failExportJob(job, err)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v%d/export-jobs/%d", apiVersion(r), job.ID))
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"export_job": job}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* The status and progress of the user's own job, with the download URL once it is completed */
+func (app *application) showExportJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.ExportJobs.GetForUser(r.Context(), id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"export_job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+Writes the export to a temporary file and stores it under a key no one can
+guess, the storage's URLs are public. Jobs aren't retried, a failure is
+recorded for the client to see and the error logged. Jobs queued when the
+server stops are lost and stay queued until they expire.
+*/
+func (app *application) runExportJob(ctx context.Context, job *data.ExportJob) {
+	ctx, cancel := context.WithTimeout(data.WithTenant(ctx, job.TenantID), exportJobTimeout)
+	defer cancel()
+
+	err := app.writeExportJob(ctx, job)
+	if err != nil {
+		app.failExportJob(job, err)
+		return
+	}
+
+	app.logger.Info("completed export job", "export_job_id", job.ID, "rows", job.RowsDone)
+}
+
+func (app *application) writeExportJob(ctx context.Context, job *data.ExportJob) error {
+	total, err := app.models.Movies.Count(ctx, job.Search)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.ExportJobs.Start(ctx, job.ID, total)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp("", "greenlight-export-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	out := csv.NewWriter(file)
+
+	err = out.Write(movieCSVHeader)
+	if err != nil {
+		return err
+	}
+
+	f := data.Filters{Sort: job.Sort, SortSafelist: movieSortSafelist}
+
+	err = app.models.Movies.StreamAll(ctx, job.Search, f, func(movie *data.Movie) error {
+		err := out.Write(movieCSVRecord(movie))
+		if err != nil {
+			return err
+		}
+
+		job.RowsDone++
+		if job.RowsDone%exportProgressRows == 0 {
+			return app.models.ExportJobs.SetProgress(ctx, job.ID, job.RowsDone)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	if err = out.Error(); err != nil {
+		return err
+	}
+
+	job.FileSize, err = file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 16)
+	rand.Read(suffix)
+	job.FileKey = fmt.Sprintf("exports/%d-%s.%s", job.ID, hex.EncodeToString(suffix), job.Format)
+
+	err = app.storage.Put(ctx, job.FileKey, file, job.FileSize, "text/csv; charset=utf-8")
+	if err != nil {
+		return err
+	}
+
+	job.DownloadURL = app.storage.URL(job.FileKey)
+
+	err = app.models.ExportJobs.Complete(ctx, job)
+	if err != nil {
+		/* Nothing refers to the file, the job was deleted or can't be updated */
+		if err := app.storage.Delete(ctx, job.FileKey); err != nil {
+			app.logger.Error(err.Error(), "key", job.FileKey)
+		}
+		return err
+	}
+
+	return nil
+}
+
+/* Records that the job failed, whatever cancelled its context */
+func (app *application) failExportJob(job *data.ExportJob, err error) {
+	app.logger.Error(err.Error(), "export_job_id", job.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	message := "the export failed, please try again"
+	if errors.Is(err, worker.ErrQueueFull) {
+		message = "too many jobs are queued, please try again later"
+	}
+
+	err = app.models.ExportJobs.Fail(ctx, job.ID, message)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logger.Error(err.Error(), "export_job_id", job.ID)
+	}
+}
+
+/* Deletes the expired export jobs and their files every exportCleanupInterval until ctx is done */
+func (app *application) runExportCleanup(ctx context.Context) {
+	ticker := time.NewTicker(exportCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := app.jobs.Enqueue("export cleanup", func(ctx context.Context) error {
+			keys, err := app.models.ExportJobs.DeleteExpired(ctx)
+			if err != nil {
+				return err
+			}
+
+			/* A file that couldn't be deleted is left behind, its job is gone */
+			for _, key := range keys {
+				err := app.storage.Delete(ctx, key)
+				if err != nil {
+					app.logger.Error(err.Error(), "key", key)
+				}
+			}
+
+			app.logger.Info("deleted the files of expired export jobs", "count", len(keys))
+			return nil
+		})
+		if err != nil && !errors.Is(err, worker.ErrQueueClosed) {
+			app.logger.Error(err.Error(), "job", "export cleanup")
+		}
+	}
+}
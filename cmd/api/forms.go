@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/* Form parts bigger than this are spooled to disk by ParseMultipartForm */
+const maxMultipartMemory = 10 << 20 // 10 MB
+
+/* Upper bound for multipart bodies, which may carry files */
+const maxMultipartBytes = 20 << 20 // 20 MB
+
+var fileHeaderType = reflect.TypeFor[*multipart.FileHeader]()
+
+/*
+Reads the request body into dst according to its Content-Type: JSON (the
+default), application/x-www-form-urlencoded or multipart/form-data. Form keys
+are matched against the json tags of dst so the same input struct works for all
+three, and multipart file parts fill fields of type *multipart.FileHeader.
+*/
+func (app *application) readBody(w http.ResponseWriter, r *http.Request, dst any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		r.Body = http.MaxBytesReader(w, r.Body, app.bodyLimit(r, app.config.maxBodyBytes))
+
+		err := r.ParseForm()
+		if err != nil {
+			return formError(err)
+		}
+
+		return app.decodeForm(r.PostForm, nil, dst)
+
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, app.bodyLimit(r, maxMultipartBytes))
+
+		err := r.ParseMultipartForm(maxMultipartMemory)
+		if err != nil {
+			return formError(err)
+		}
+
+		return app.decodeForm(r.MultipartForm.Value, r.MultipartForm.File, dst)
+
+	default:
+		return app.readJSON(w, r, dst)
+	}
+}
+
+func formError(err error) error {
+	var maxBytesError *http.MaxBytesError
+
+	if errors.As(err, &maxBytesError) {
+		return &bodyTooLargeError{limit: maxBytesError.Limit}
+	}
+
+	return fmt.Errorf("body contains a badly-formed form: %w", err)
+}
+
+/*
+Converts the form values to JSON shaped after dst's fields and decodes that,
+reusing readJSON's handling of custom types (e.g. data.Runtime) and unknown keys.
+*/
+func (app *application) decodeForm(values url.Values, files map[string][]*multipart.FileHeader, dst any) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("decodeForm: dst must be a pointer to a struct, got %T", dst))
+	}
+
+	fields := formFields(rv.Elem().Type())
+	doc := make(map[string]json.RawMessage, len(values))
+
+	for key, vals := range values {
+		field, ok := fields[key]
+		if !ok || field.Type == fileHeaderType {
+			return fmt.Errorf("body contains unknown key %q", key)
+		}
+
+		raw, err := formValueToJSON(field.Type, vals)
+		if err != nil {
+			return fmt.Errorf("body contains incorrect value for field %q", key)
+		}
+
+		doc[key] = raw
+	}
+
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	err = app.decodeJSON(bytes.NewReader(js), dst)
+	if err != nil {
+		return err
+	}
+
+	for key, headers := range files {
+		field, ok := fields[key]
+		if !ok || field.Type != fileHeaderType {
+			return fmt.Errorf("body contains unexpected file %q", key)
+		}
+
+		rv.Elem().FieldByIndex(field.Index).Set(reflect.ValueOf(headers[0]))
+	}
+
+	return nil
+}
+
+/* Exported struct fields keyed by their json name */
+func formFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+
+		fields[name] = field
+	}
+
+	return fields
+}
+
+/* Encodes the form values for a field of type t as the JSON the field expects */
+func formValueToJSON(t reflect.Type, vals []string) (json.RawMessage, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	/* Custom types such as data.Runtime parse their own string form */
+	if reflect.PointerTo(t).Implements(reflect.TypeFor[json.Unmarshaler]()) ||
+		reflect.PointerTo(t).Implements(reflect.TypeFor[encoding.TextUnmarshaler]()) {
+		return json.Marshal(vals[0])
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		items := make([]json.RawMessage, 0, len(vals))
+		for _, val := range vals {
+			item, err := formValueToJSON(t.Elem(), []string{val})
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return json.Marshal(items)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(vals[0])
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		/* ParseFloat also takes "Inf" and hex, which aren't JSON numbers */
+		_, err := strconv.ParseFloat(vals[0], 64)
+		if err != nil || !json.Valid([]byte(vals[0])) {
+			return nil, fmt.Errorf("invalid number %q", vals[0])
+		}
+		return json.RawMessage(vals[0]), nil
+
+	default:
+		return json.Marshal(vals[0])
+	}
+}
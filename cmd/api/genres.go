@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Same as GET /v1/movies?genres=:name, but a 404 for genres no movie has */
+func (app *application) listGenreMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+	var f data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	f.Page = app.readInt(qs, "page", 1, v)
+	f.PageSize = app.readInt(qs, "page_size", 20, v)
+	f.Sort = app.readString(qs, "sort", "id")
+	f.SortSafelist = movieSortSafelist
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(r.Context(), name)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), data.MovieSearch{Genres: []string{genre.Name}}, f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genre": genre, "metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/graphql"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/*
+Serves GraphQL queries sent as a JSON body with POST or as query string
+parameters with GET. Responses are always 200 with the GraphQL "data" and
+"errors" members, authorization is checked per field.
+*/
+func (app *application) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+
+	if r.Method == http.MethodGet {
+		qs := r.URL.Query()
+
+		req.Query = qs.Get("query")
+		req.OperationName = qs.Get("operationName")
+
+		if variables := qs.Get("variables"); variables != "" {
+			err := app.decodeJSON(strings.NewReader(variables), &req.Variables)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+		}
+	} else {
+		err := app.readJSON(w, r, &req)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	if req.Query == "" {
+		app.badRequestResponse(w, r, errors.New("query must be provided"))
+		return
+	}
+
+	schema := app.graphqlSchema(r)
+	/* GET must be safe, mutations are only accepted with POST */
+	if r.Method == http.MethodGet {
+		schema.Mutation = nil
+	}
+
+	res := schema.Execute(r.Context(), req)
+
+	err := app.writeEncoded(w, r, http.StatusOK, res, nil, responseEncoders[0])
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+The schema is built per request so resolvers can authorize against, and
+translate errors for, the user of that request.
+*/
+func (app *application) graphqlSchema(r *http.Request) *graphql.Schema {
+	review := &graphql.Object{
+		Name: "Review",
+		Fields: map[string]*graphql.Field{
+			"id": {},
+			"userId": {Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				return source.(*data.Review).UserID, nil
+			}},
+			"rating": {},
+			"body":   {},
+			"createdAt": {Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				return source.(*data.Review).CreatedAt, nil
+			}},
+		},
+	}
+
+	movie := &graphql.Object{
+		Name: "Movie",
+		Fields: map[string]*graphql.Field{
+			"id":    {},
+			"title": {},
+			"year":  {},
+			/* Plain minutes rather than the "107 mins" of the REST API */
+			"runtime": {Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				return int32(source.(*data.Movie).Runtime), nil
+			}},
+			"genres":  {},
+			"version": {},
+			"averageRating": {Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				return source.(*data.Movie).AverageRating, nil
+			}},
+			"posterUrl": {Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				return source.(*data.Movie).PosterURL, nil
+			}},
+			/* The latest reviews, fetched for all movies of the list in one query */
+			"reviews": {
+				Type: review,
+				Args: []string{"limit"},
+				ResolveBatch: func(ctx context.Context, sources []any, args graphql.Args) ([]any, error) {
+					limit, err := args.Int("limit", 5)
+					if err != nil {
+						return nil, err
+					}
+					if limit < 1 || limit > 100 {
+						return nil, errors.New("argument \"limit\" must be between 1 and 100")
+					}
+
+					ids := make([]int64, len(sources))
+					for i, source := range sources {
+						ids[i] = source.(*data.Movie).ID
+					}
+
+					reviews, err := app.models.Reviews.GetLatestForMovies(ctx, ids, limit)
+					if err != nil {
+						return nil, app.graphqlServerError(r, err)
+					}
+
+					resolved := make([]any, len(sources))
+					for i, id := range ids {
+						/* An empty list rather than null for movies without reviews */
+						resolved[i] = append([]*data.Review{}, reviews[id]...)
+					}
+
+					return resolved, nil
+				},
+			},
+		},
+	}
+
+	user := &graphql.Object{
+		Name: "User",
+		Fields: map[string]*graphql.Field{
+			"id":        {},
+			"name":      {},
+			"email":     {},
+			"activated": {},
+			"createdAt": {Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				return source.(*data.User).CreatedAt, nil
+			}},
+			"permissions": {Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+				permissions, err := app.models.Permissions.GetAllForUser(ctx, int64(source.(*data.User).ID))
+				if err != nil {
+					return nil, app.graphqlServerError(r, err)
+				}
+				return permissions, nil
+			}},
+		},
+	}
+
+	listArgs := []string{"page", "page_size", "sort"}
+	movieArgs := []string{"title", "year", "runtime", "genres"}
+
+	return &graphql.Schema{
+		Query: &graphql.Object{
+			Name: "Query",
+			Fields: map[string]*graphql.Field{
+				"movie": {
+					Type: movie,
+					Args: []string{"id"},
+					Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+						if err := app.graphqlAuthorize(r, "movies:read"); err != nil {
+							return nil, err
+						}
+
+						id, err := args.Int("id", 0)
+						if err != nil {
+							return nil, err
+						}
+
+						movie, err := app.models.Movies.Get(r.Context(), int64(id))
+						switch {
+						case errors.Is(err, data.ErrRecordNotFound):
+							return nil, nil
+						case err != nil:
+							return nil, app.graphqlServerError(r, err)
+						}
+						return movie, nil
+					},
+				},
+				"movies": {
+					Type: movie,
+					Args: append([]string{"title", "genres"}, listArgs...),
+					Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+						title, err := args.String("title", "")
+						if err != nil {
+							return nil, err
+						}
+
+						genres, err := args.Strings("genres")
+						if err != nil {
+							return nil, err
+						}
+
+						return app.graphqlMovies(r, title, genres, "id", args)
+					},
+				},
+				/* Full-text search on the title, ranked like GET /v1/movies?title= */
+				"search": {
+					Type: movie,
+					Args: append([]string{"query"}, listArgs...),
+					Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+						query, err := args.String("query", "")
+						if err != nil {
+							return nil, err
+						}
+						if query == "" {
+							return nil, errors.New("argument \"query\" must be provided")
+						}
+
+						/* Best matches first unless asked otherwise */
+						return app.graphqlMovies(r, query, nil, "relevance", args)
+					},
+				},
+				"me": {
+					Type: user,
+					Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+						if err := app.graphqlAuthorize(r, ""); err != nil {
+							return nil, err
+						}
+
+						return app.contextGetUser(r), nil
+					},
+				},
+			},
+		},
+		/* The same validation, events and audit log as the REST handlers */
+		Mutation: &graphql.Object{
+			Name: "Mutation",
+			Fields: map[string]*graphql.Field{
+				"createMovie": {
+					Type: movie,
+					Args: movieArgs,
+					Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+						if err := app.graphqlAuthorize(r, "movies:write"); err != nil {
+							return nil, err
+						}
+
+						movie := &data.Movie{}
+						if err := graphqlMovieArgs(movie, args); err != nil {
+							return nil, err
+						}
+
+						v := validator.New()
+						if data.ValidateMovie(v, movie); !v.Valid() {
+							return nil, app.graphqlModelError(r, v.Err())
+						}
+
+						err := app.models.Movies.Insert(r.Context(), movie)
+						if err != nil {
+							return nil, app.graphqlModelError(r, err)
+						}
+
+						app.publishEvent(r.Context(), events.MovieCreated, movie)
+						app.recordChange(r, nil, movie)
+
+						return movie, nil
+					},
+				},
+				/* Only the given arguments are changed, a version fails with an edit conflict if the movie has moved on */
+				"updateMovie": {
+					Type: movie,
+					Args: append([]string{"id", "version"}, movieArgs...),
+					Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+						if err := app.graphqlAuthorize(r, "movies:write"); err != nil {
+							return nil, err
+						}
+
+						movie, err := app.graphqlMovieVersion(r, args)
+						if err != nil {
+							return nil, err
+						}
+
+						before := *movie
+
+						if err := graphqlMovieArgs(movie, args); err != nil {
+							return nil, err
+						}
+
+						v := validator.New()
+						if data.ValidateMovie(v, movie); !v.Valid() {
+							return nil, app.graphqlModelError(r, v.Err())
+						}
+
+						err = app.models.Movies.Update(r.Context(), movie, int64(app.contextGetUser(r).ID))
+						if err != nil {
+							return nil, app.graphqlModelError(r, err)
+						}
+
+						app.publishEvent(r.Context(), events.MovieUpdated, movie)
+						app.recordChange(r, &before, movie)
+
+						return movie, nil
+					},
+				},
+				/* Resolves to the movie as it was before the deletion */
+				"deleteMovie": {
+					Type: movie,
+					Args: []string{"id", "version"},
+					Resolve: func(ctx context.Context, source any, args graphql.Args) (any, error) {
+						if err := app.graphqlAuthorize(r, "movies:write"); err != nil {
+							return nil, err
+						}
+
+						movie, err := app.graphqlMovieVersion(r, args)
+						if err != nil {
+							return nil, err
+						}
+
+						err = app.models.Movies.Delete(r.Context(), movie.ID, movie.Version)
+						if err != nil {
+							return nil, app.graphqlModelError(r, err)
+						}
+
+						app.publishEvent(r.Context(), events.MovieDeleted, envelope{"id": movie.ID})
+						app.recordChange(r, movie, nil)
+
+						return movie, nil
+					},
+				},
+			},
+		},
+	}
+}
+
+/* The movie of the "id" argument, checked against the optional "version" argument like an If-Match header */
+func (app *application) graphqlMovieVersion(r *http.Request, args graphql.Args) (*data.Movie, error) {
+	id, err := args.Int("id", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), int64(id))
+	if err != nil {
+		return nil, app.graphqlModelError(r, err)
+	}
+
+	if args.Has("version") {
+		version, err := args.Int("version", 0)
+		if err != nil {
+			return nil, err
+		}
+		if int32(version) != movie.Version {
+			return nil, app.graphqlModelError(r, data.ErrEditConflict)
+		}
+	}
+
+	return movie, nil
+}
+
+/* Sets the fields of movie given as arguments, runtime in minutes like the Movie type */
+func graphqlMovieArgs(movie *data.Movie, args graphql.Args) error {
+	var err error
+
+	if args.Has("title") {
+		if movie.Title, err = args.String("title", ""); err != nil {
+			return err
+		}
+	}
+
+	if args.Has("year") {
+		year, err := args.Int("year", 0)
+		if err != nil {
+			return err
+		}
+		movie.Year = int32(year)
+	}
+
+	if args.Has("runtime") {
+		runtime, err := args.Int("runtime", 0)
+		if err != nil {
+			return err
+		}
+		movie.Runtime = data.Runtime(runtime)
+	}
+
+	if args.Has("genres") {
+		if movie.Genres, err = args.Strings("genres"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Lists movies with the same filters and validation as listMoviesHandler */
+func (app *application) graphqlMovies(r *http.Request, title string, genres []string, defaultSort string, args graphql.Args) ([]*data.Movie, error) {
+	if err := app.graphqlAuthorize(r, "movies:read"); err != nil {
+		return nil, err
+	}
+
+	var f data.Filters
+	var err error
+
+	if f.Page, err = args.Int("page", 1); err != nil {
+		return nil, err
+	}
+	if f.PageSize, err = args.Int("page_size", 20); err != nil {
+		return nil, err
+	}
+	if f.Sort, err = args.String("sort", defaultSort); err != nil {
+		return nil, err
+	}
+	f.SortSafelist = movieSortSafelist
+
+	v := validator.New()
+	if data.ValidateFilters(v, f); !v.Valid() {
+		return nil, app.graphqlModelError(r, v.Err())
+	}
+
+	movies, _, err := app.models.Movies.GetAll(r.Context(), data.MovieSearch{Title: title, Genres: genres}, f)
+	if err != nil {
+		return nil, app.graphqlServerError(r, err)
+	}
+
+	return movies, nil
+}
+
+/*
+Applies the checks of requirePermission to a single field: an authenticated,
+activated user with the permission code, if one is given.
+*/
+func (app *application) graphqlAuthorize(r *http.Request, code string) error {
+	user := app.contextGetUser(r)
+
+	switch {
+	case user.IsAnonymous():
+		return errors.New(app.translate(r, "authentication_required"))
+	case !user.Activated:
+		return errors.New(app.translate(r, "inactive_account"))
+	case code == "":
+		return nil
+	}
+
+	permitted, err := app.userHasPermission(r.Context(), user, code)
+	if err != nil {
+		return app.graphqlServerError(r, err)
+	}
+
+	if !permitted {
+		return errors.New(app.translate(r, "not_permitted"))
+	}
+
+	return nil
+}
+
+/* The GraphQL counterpart of modelErrorResponse, validation errors are translated like failedValidationResponse */
+func (app *application) graphqlModelError(r *http.Request, err error) error {
+	var validationError *validator.ValidationError
+
+	switch {
+	case errors.As(err, &validationError):
+		return &validator.ValidationError{Errors: validator.Localize(validationError.Errors, app.locale(r)), Err: validationError.Err}
+	case errors.Is(err, data.ErrRecordNotFound):
+		return errors.New(app.translate(r, "not_found"))
+	case errors.Is(err, data.ErrEditConflict):
+		return errors.New(app.translate(r, "edit_conflict"))
+	}
+
+	return app.graphqlServerError(r, err)
+}
+
+/* Logs err and returns the generic message, database errors are not for clients */
+func (app *application) graphqlServerError(r *http.Request, err error) error {
+	app.logError(r, err)
+	return errors.New(app.translate(r, "server_error"))
+}
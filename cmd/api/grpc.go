@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/greenlightpb"
+	"github.com/mohafarman/greenlight/internal/grpc"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* Permission required for every gRPC method, see proto/greenlight/v1/greenlight.proto */
+var grpcPermissions = map[string]string{
+	greenlightpb.CatalogueGetMovie:     "movies:read",
+	greenlightpb.CatalogueListMovies:   "movies:read",
+	greenlightpb.CatalogueCreateMovie:  "movies:write",
+	greenlightpb.CatalogueUpdateMovie:  "movies:write",
+	greenlightpb.CatalogueDeleteMovie:  "movies:write",
+	greenlightpb.TokensIntrospectToken: "tokens:introspect",
+}
+
+/* Interceptors in the same order as the HTTP middleware chain in routes() */
+func (app *application) grpcServer() http.Handler {
+	srv := grpc.NewServer(app.grpcRecoverPanic, app.grpcAuthenticate, app.grpcRequirePermission)
+
+	srv.Handle(greenlightpb.CatalogueGetMovie,
+		func() grpc.Message { return &greenlightpb.GetMovieRequest{} },
+		func(ctx context.Context, req grpc.Message) (grpc.Message, error) {
+			return app.grpcGetMovie(ctx, req.(*greenlightpb.GetMovieRequest))
+		})
+
+	srv.Handle(greenlightpb.CatalogueListMovies,
+		func() grpc.Message { return &greenlightpb.ListMoviesRequest{} },
+		func(ctx context.Context, req grpc.Message) (grpc.Message, error) {
+			return app.grpcListMovies(ctx, req.(*greenlightpb.ListMoviesRequest))
+		})
+
+	srv.Handle(greenlightpb.CatalogueCreateMovie,
+		func() grpc.Message { return &greenlightpb.CreateMovieRequest{} },
+		func(ctx context.Context, req grpc.Message) (grpc.Message, error) {
+			return app.grpcCreateMovie(ctx, req.(*greenlightpb.CreateMovieRequest))
+		})
+
+	srv.Handle(greenlightpb.CatalogueUpdateMovie,
+		func() grpc.Message { return &greenlightpb.UpdateMovieRequest{} },
+		func(ctx context.Context, req grpc.Message) (grpc.Message, error) {
+			return app.grpcUpdateMovie(ctx, req.(*greenlightpb.UpdateMovieRequest))
+		})
+
+	srv.Handle(greenlightpb.CatalogueDeleteMovie,
+		func() grpc.Message { return &greenlightpb.DeleteMovieRequest{} },
+		func(ctx context.Context, req grpc.Message) (grpc.Message, error) {
+			return app.grpcDeleteMovie(ctx, req.(*greenlightpb.DeleteMovieRequest))
+		})
+
+	srv.Handle(greenlightpb.TokensIntrospectToken,
+		func() grpc.Message { return &greenlightpb.IntrospectTokenRequest{} },
+		func(ctx context.Context, req grpc.Message) (grpc.Message, error) {
+			return app.grpcIntrospectToken(ctx, req.(*greenlightpb.IntrospectTokenRequest))
+		})
+
+	return srv
+}
+
+func (app *application) grpcGetMovie(ctx context.Context, req *greenlightpb.GetMovieRequest) (*greenlightpb.Movie, error) {
+	if req.ID < 1 {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "id must be a positive integer")
+	}
+
+	movie, err := app.models.Movies.Get(ctx, req.ID)
+	if err != nil {
+		return nil, grpcModelError(err, req.ID)
+	}
+
+	return grpcMovie(movie), nil
+}
+
+/* Same validation and events as createMovieHandler */
+func (app *application) grpcCreateMovie(ctx context.Context, req *greenlightpb.CreateMovieRequest) (*greenlightpb.Movie, error) {
+	if req.Movie == nil {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "movie must be provided")
+	}
+
+	movie := &data.Movie{}
+	grpcApplyMovie(movie, req.Movie, nil)
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "%s", v.Err())
+	}
+
+	err := app.models.Movies.Insert(ctx, movie)
+	if err != nil {
+		return nil, grpcModelError(err, 0)
+	}
+
+	app.publishEvent(ctx, events.MovieCreated, movie)
+
+	return grpcMovie(movie), nil
+}
+
+/* Changes the fields in the update mask, like a partial updateMovieHandler */
+func (app *application) grpcUpdateMovie(ctx context.Context, req *greenlightpb.UpdateMovieRequest) (*greenlightpb.Movie, error) {
+	if req.ID < 1 {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "id must be a positive integer")
+	}
+	if req.Movie == nil {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "movie must be provided")
+	}
+
+	for _, path := range req.UpdateMask {
+		if !slices.Contains(grpcMovieUpdateMask, path) {
+			return nil, grpc.Errorf(grpc.InvalidArgument, "update_mask must only contain %s", strings.Join(grpcMovieUpdateMask, ", "))
+		}
+	}
+
+	movie, err := app.models.Movies.Get(ctx, req.ID)
+	if err != nil {
+		return nil, grpcModelError(err, req.ID)
+	}
+
+	if req.Movie.Version != 0 && req.Movie.Version != movie.Version {
+		return nil, grpcModelError(data.ErrEditConflict, req.ID)
+	}
+
+	grpcApplyMovie(movie, req.Movie, req.UpdateMask)
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "%s", v.Err())
+	}
+
+	err = app.models.Movies.Update(ctx, movie, int64(ctx.Value(userContextKey).(*data.User).ID))
+	if err != nil {
+		return nil, grpcModelError(err, req.ID)
+	}
+
+	app.publishEvent(ctx, events.MovieUpdated, movie)
+
+	return grpcMovie(movie), nil
+}
+
+func (app *application) grpcDeleteMovie(ctx context.Context, req *greenlightpb.DeleteMovieRequest) (*greenlightpb.DeleteMovieResponse, error) {
+	if req.ID < 1 {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "id must be a positive integer")
+	}
+
+	movie, err := app.models.Movies.Get(ctx, req.ID)
+	if err != nil {
+		return nil, grpcModelError(err, req.ID)
+	}
+
+	if req.Version != 0 && req.Version != movie.Version {
+		return nil, grpcModelError(data.ErrEditConflict, req.ID)
+	}
+
+	err = app.models.Movies.Delete(ctx, movie.ID, movie.Version)
+	if err != nil {
+		return nil, grpcModelError(err, req.ID)
+	}
+
+	app.publishEvent(ctx, events.MovieDeleted, envelope{"id": movie.ID})
+
+	return &greenlightpb.DeleteMovieResponse{}, nil
+}
+
+/* Fields of greenlightpb.Movie an UpdateMovie call may change */
+var grpcMovieUpdateMask = []string{"title", "year", "runtime_minutes", "genres"}
+
+/* Copies the fields in mask from the message to movie, all of them for an empty mask */
+func grpcApplyMovie(movie *data.Movie, msg *greenlightpb.Movie, mask []string) {
+	if len(mask) == 0 {
+		mask = grpcMovieUpdateMask
+	}
+
+	for _, path := range mask {
+		switch path {
+		case "title":
+			movie.Title = msg.Title
+		case "year":
+			movie.Year = msg.Year
+		case "runtime_minutes":
+			movie.Runtime = data.Runtime(msg.RuntimeMinutes)
+		case "genres":
+			movie.Genres = msg.Genres
+		}
+	}
+}
+
+/* The gRPC counterpart of modelErrorResponse, unknown errors become INTERNAL in grpcRecoverPanic */
+func grpcModelError(err error, id int64) error {
+	var validationError *validator.ValidationError
+
+	switch {
+	case errors.As(err, &validationError):
+		return grpc.Errorf(grpc.InvalidArgument, "%s", err)
+	case errors.Is(err, data.ErrRecordNotFound):
+		return grpc.Errorf(grpc.NotFound, "movie %d not found", id)
+	case errors.Is(err, data.ErrEditConflict):
+		return grpc.Errorf(grpc.Aborted, "unable to update the record due to an edit conflict, please try again")
+	default:
+		return err
+	}
+}
+
+/* Same defaults and validation as listMoviesHandler */
+func (app *application) grpcListMovies(ctx context.Context, req *greenlightpb.ListMoviesRequest) (*greenlightpb.ListMoviesResponse, error) {
+	f := data.Filters{
+		Page:         int(req.Page),
+		PageSize:     int(req.PageSize),
+		Sort:         req.Sort,
+		SortSafelist: movieSortSafelist,
+	}
+
+	if f.Page == 0 {
+		f.Page = 1
+	}
+	if f.PageSize == 0 {
+		f.PageSize = 20
+	}
+	if f.Sort == "" {
+		f.Sort = "id"
+	}
+
+	v := validator.New()
+	if data.ValidateFilters(v, f); !v.Valid() {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "%s", v.Err())
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(ctx, data.MovieSearch{Title: req.Title, Genres: req.Genres}, f)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &greenlightpb.ListMoviesResponse{
+		Metadata: &greenlightpb.Metadata{
+			CurrentPage:  int32(metadata.CurrentPage),
+			PageSize:     int32(metadata.PageSize),
+			FirstPage:    int32(metadata.FirstPage),
+			LastPage:     int32(metadata.LastPage),
+			TotalRecords: int32(metadata.TotalRecords),
+		},
+	}
+
+	for _, movie := range movies {
+		res.Movies = append(res.Movies, grpcMovie(movie))
+	}
+
+	return res, nil
+}
+
+/* Unknown, expired and malformed tokens are all just inactive */
+func (app *application) grpcIntrospectToken(ctx context.Context, req *greenlightpb.IntrospectTokenRequest) (*greenlightpb.IntrospectTokenResponse, error) {
+	user, err := app.userForToken(ctx, req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidAuthenticationToken):
+			return &greenlightpb.IntrospectTokenResponse{}, nil
+		default:
+			return nil, err
+		}
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, int64(user.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &greenlightpb.IntrospectTokenResponse{
+		Active:      true,
+		UserID:      int64(user.ID),
+		Email:       user.Email,
+		Activated:   user.Activated,
+		Permissions: permissions,
+	}, nil
+}
+
+func grpcMovie(movie *data.Movie) *greenlightpb.Movie {
+	return &greenlightpb.Movie{
+		ID:             movie.ID,
+		Title:          movie.Title,
+		Year:           movie.Year,
+		RuntimeMinutes: int32(movie.Runtime),
+		Genres:         movie.Genres,
+		Version:        movie.Version,
+	}
+}
+
+/* Mirrors recoverPanic, and hides internal errors from the client like serverErrorResponse */
+func (app *application) grpcRecoverPanic(ctx context.Context, info *grpc.MethodInfo, req grpc.Message, next grpc.UnaryHandler) (res grpc.Message, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%s", p)
+		}
+
+		var st *grpc.Status
+		if err != nil && !errors.As(err, &st) {
+			app.logger.Error(err.Error(), "grpc_method", info.FullMethod)
+			res, err = nil, grpc.Errorf(grpc.Internal, "the server encountered a problem and could not process your request")
+		}
+	}()
+
+	return next(ctx, req)
+}
+
+/* Mirrors authenticate: a missing authorization header makes the caller anonymous */
+func (app *application) grpcAuthenticate(ctx context.Context, info *grpc.MethodInfo, req grpc.Message, next grpc.UnaryHandler) (grpc.Message, error) {
+	user, err := app.userForAuthorizationHeader(ctx, grpc.IncomingMetadata(ctx).Get("Authorization"))
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidAuthenticationToken):
+			return nil, grpc.Errorf(grpc.Unauthenticated, "invalid or missing authentication token")
+		default:
+			return nil, err
+		}
+	}
+
+	return next(context.WithValue(ctx, userContextKey, user), req)
+}
+
+/* Mirrors requirePermission, every method needs an activated user with its permission */
+func (app *application) grpcRequirePermission(ctx context.Context, info *grpc.MethodInfo, req grpc.Message, next grpc.UnaryHandler) (grpc.Message, error) {
+	user := ctx.Value(userContextKey).(*data.User)
+
+	switch {
+	case user.IsAnonymous():
+		return nil, grpc.Errorf(grpc.Unauthenticated, "you must be authenticated to access this resource")
+	case !user.Activated:
+		return nil, grpc.Errorf(grpc.PermissionDenied, "your user account must be activated to access this resource")
+	}
+
+	code, ok := grpcPermissions[info.FullMethod]
+	if !ok {
+		/* Logical error in our codebase, fail closed */
+		return nil, fmt.Errorf("no permission configured for %s", info.FullMethod)
+	}
+
+	permitted, err := app.userHasPermission(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !permitted {
+		return nil, grpc.Errorf(grpc.PermissionDenied, "your user account doesn't have the necessary permissions to access this resource")
+	}
+
+	return next(ctx, req)
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
 )
 
+/* How long a dependency gets to answer a readiness probe */
+const probeTimeout = 2 * time.Second
+
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	env := envelope{
 		"status": "available",
@@ -13,9 +20,97 @@ func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Reques
 		},
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeResponse(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* The process is up and serving, restart it if this fails */
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"status": "available"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type dependencyStatus struct {
+	Status string `json:"status"`
+	/* A dependency that isn't critical being down only degrades the service */
+	Critical bool   `json:"critical"`
+	Latency  string `json:"latency"`
+	Error    string `json:"error,omitempty"`
+}
+
+type probe struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+/*
+Probes the dependencies concurrently. Answers 503 when a critical one is down so
+load balancers stop sending traffic, a failing SMTP server only delays emails.
+*/
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	probes := []probe{
+		{name: "database", critical: true, check: app.db.PingContext},
+		{name: "smtp", check: app.mailer.Ping},
+	}
+
+	/* Not critical, reads fall back to the primary */
+	if app.replicaDB != nil {
+		probes = append(probes, probe{name: "database_replica", check: app.replicaDB.PingContext})
+	}
+
+	/* Not critical, the rate limiter fails open without it */
+	if app.redis != nil {
+		probes = append(probes, probe{name: "redis", check: app.redis.Ping})
+	}
+
+	var wg sync.WaitGroup
+	checks := make(map[string]dependencyStatus, len(probes))
+	results := make([]dependencyStatus, len(probes))
+
+	for i, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := p.check(ctx)
+
+			results[i] = dependencyStatus{Status: "up", Critical: p.critical, Latency: time.Since(start).String()}
+			if err != nil {
+				results[i].Status = "down"
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	status, code := "available", http.StatusOK
+
+	for i, p := range probes {
+		checks[p.name] = results[i]
+
+		if results[i].Status == "down" {
+			app.logError(r, fmt.Errorf("readiness: %s: %s", p.name, results[i].Error))
+
+			if p.critical {
+				status, code = "unavailable", http.StatusServiceUnavailable
+			} else if code == http.StatusOK {
+				status = "degraded"
+			}
+		}
+	}
+
+	err := app.writeResponse(w, r, code, envelope{"status": status, "checks": checks}, nil)
 	if err != nil {
-		app.logger.Error(err, nil)
 		app.serverErrorResponse(w, r, err)
 	}
 }
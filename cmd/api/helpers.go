@@ -1,62 +1,190 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/jsonpatch"
 	"github.com/mohafarman/greenlight/internal/validator"
 )
 
 type envelope map[string]any
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+/* For routes with more than one ID, e.g. /v1/movies/:id/reviews/:review_id */
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	// INFO: Convert string to int using a base 10 and bite size 64,
 	// if param can not be converted or id is < 0 we know it is invalid so
 	// return error
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	// INFO: Prints out with whitespace for a prettier print to terminals
-	// NB! Slower performance compared to json.Marshal
-	js, err := json.MarshalIndent(data, "", "\t")
+	return app.writeEncoded(w, nil, status, app.shapeEnvelope(nil, data), headers, responseEncoders[0])
+}
+
+/* Writes data in the format the client's Accept header prefers, JSON by default */
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	/* The body now depends on the Accept header */
+	w.Header().Add("Vary", "Accept")
+
+	data = withRuntimes(data, runtimeFormat(r))
+	if apiVersion(r) >= 2 {
+		data = withDefaultMetadata(data)
+	}
+
+	enc := negotiateEncoder(r)
+	if enc.ownsEnvelope {
+		return app.writeEncoded(w, r, status, data, headers, enc)
+	}
+
+	return app.writeEncoded(w, r, status, app.shapeEnvelope(r, data), headers, enc)
+}
+
+/* Adds the soft validation failures to env under "warnings", written out in the caller's language */
+func (app *application) withWarnings(r *http.Request, env envelope, warnings map[string][]string) envelope {
+	if len(warnings) > 0 {
+		env["warnings"] = validator.Localize(warnings, app.locale(r))
+	}
+
+	return env
+}
+
+func (app *application) writeEncoded(w http.ResponseWriter, r *http.Request, status int, data any, headers http.Header, enc responseEncoder) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	/* Encode fully before writing anything so an error can still become a 500 */
+	err := enc.encode(buf, r, data, app.config.env == "production")
 	if err != nil {
 		return err
 	}
 
-	// INFO: For nice print to terminals
-	js = append(js, '\n')
-
 	for k, v := range headers {
 		w.Header()[k] = v
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", enc.contentType)
 	w.WriteHeader(status)
-	w.Write(js)
+	buf.WriteTo(w)
 
 	return nil
 }
 
+/*
+Applies the envelope style asked for by an "envelope" Accept parameter (e.g.
+"Accept: application/json; envelope=none") or else by -response-envelope:
+"none" returns the wrapped value on its own, any other name replaces the
+resource key, e.g. {"data": {...}} instead of {"movie": {...}}. Envelopes
+carrying more than one resource, or metadata, are never unwrapped.
+*/
+func (app *application) shapeEnvelope(r *http.Request, data envelope) any {
+	style := app.config.responseEnvelope
+	if r != nil {
+		if param := acceptParam(r, "envelope"); param != "" {
+			style = param
+		}
+	}
+
+	if style == "" {
+		return data
+	}
+
+	/* The resource is the only key that isn't "metadata" */
+	resourceKey := ""
+	for key := range data {
+		if key == "metadata" {
+			continue
+		}
+		if resourceKey != "" {
+			return data
+		}
+		resourceKey = key
+	}
+
+	_, hasMetadata := data["metadata"]
+
+	switch {
+	case resourceKey == "":
+		return data
+	case style == "none" && hasMetadata:
+		return data
+	case style == "none":
+		return data[resourceKey]
+	}
+
+	shaped := envelope{style: data[resourceKey]}
+	if hasMetadata {
+		shaped["metadata"] = data["metadata"]
+	}
+
+	return shaped
+}
+
+/* Reported as a 413 by badRequestResponse, with the limit the body went over */
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	switch {
+	case e.limit%(1<<20) == 0:
+		return fmt.Sprintf("body must not be larger than %d MB", e.limit>>20)
+	case e.limit%(1<<10) == 0:
+		return fmt.Sprintf("body must not be larger than %d KB", e.limit>>10)
+	default:
+		return fmt.Sprintf("body must not be larger than %d bytes", e.limit)
+	}
+}
+
+/* The route's own limit, see limitBody, or fallback */
+func (app *application) bodyLimit(r *http.Request, fallback int64) int64 {
+	if limit, ok := app.contextGetBodyLimit(r); ok {
+		return limit
+	}
+	return fallback
+}
+
+/* Caps the route's request bodies at limit rather than -max-body-bytes, see route.maxBody */
+func (app *application) limitBody(limit int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, app.contextSetBodyLimit(r, limit))
+	}
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
-	maxBytes := 1_048_576 // 1 MB
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, app.bodyLimit(r, app.config.maxBodyBytes))
 
-	dec := json.NewDecoder(r.Body)
+	return app.decodeJSON(r.Body, dst)
+}
+
+/* Decodes a single JSON value from body into dst with plain-english errors */
+func (app *application) decodeJSON(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
 	// INFO: If client includes fields that is unknown to our decoder then an error
 	// will be raised instead of ignoring the field
 	dec.DisallowUnknownFields()
@@ -77,6 +205,11 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		case errors.Is(err, io.ErrUnexpectedEOF):
 			return errors.New("body contains badly-formed JSON")
 
+		/* Report a bad runtime against its field like any other validation error */
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			return validator.NewFieldError(err, fieldOfType(dst, reflect.TypeFor[data.Runtime]()),
+				validator.Message("validation.runtime_format"))
+
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
 				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
@@ -93,7 +226,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			return &bodyTooLargeError{limit: maxBytesError.Limit}
 
 			// If a non-nil pointer is passed to Decode(),
 			// a server error rather than a client error
@@ -116,6 +249,57 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+/*
+Returns the json name of the first field of the struct dst points to whose type
+is t (or *t). The decoder doesn't say which field a custom UnmarshalJSON error
+came from, so this names it for the error response.
+*/
+func fieldOfType(dst any, t reflect.Type) string {
+	rv := reflect.Indirect(reflect.ValueOf(dst))
+	if rv.Kind() != reflect.Struct {
+		return "body"
+	}
+
+	for name, field := range formFields(rv.Type()) {
+		if field.Type == t || field.Type == reflect.PointerTo(t) {
+			return name
+		}
+	}
+
+	return "body"
+}
+
+func isJSONPatch(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json-patch+json"
+}
+
+/*
+Reads an RFC 6902 JSON Patch from the body, applies it to the JSON form of current
+and decodes the result into dst. A failed "test" operation is reported as
+jsonpatch.ErrTestFailed.
+*/
+func (app *application) readJSONPatch(w http.ResponseWriter, r *http.Request, current any, dst any) error {
+	var ops []jsonpatch.Operation
+
+	err := app.readJSON(w, r, &ops)
+	if err != nil {
+		return err
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	patched, err := jsonpatch.Apply(doc, ops)
+	if err != nil {
+		return err
+	}
+
+	return app.decodeJSON(bytes.NewReader(patched), dst)
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 
@@ -133,7 +317,15 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 		return defaultValue
 	}
 
-	return strings.Split(csv, ",")
+	/* Drop surrounding whitespace and empty entries, e.g. "drama, ,crime" */
+	values := []string{}
+	for _, value := range strings.Split(csv, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
 }
 
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
@@ -145,13 +337,171 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, validator.Message("validation.integer"))
 		return defaultValue
 	}
 
 	return i
 }
 
+/* Like readInt, nil when not set */
+func (app *application) readOptionalInt(qs url.Values, key string, v *validator.Validator) *int {
+	if qs.Get(key) == "" {
+		return nil
+	}
+
+	i := app.readInt(qs, key, 0, v)
+	return &i
+}
+
+/* Accepts the values understood by strconv.ParseBool, e.g. "true", "1", "f" */
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, validator.Message("validation.boolean"))
+		return defaultValue
+	}
+
+	return b
+}
+
+/* Accepts RFC 3339 timestamps and dates, e.g. "2024-05-01", which are midnight UTC; nil when not set */
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return &t
+		}
+	}
+
+	v.AddError(key, validator.Message("validation.timestamp"))
+	return nil
+}
+
+/* Reads a comma separated list of field names, e.g. "id,title,year", each of which must be in safelist */
+func (app *application) readFields(qs url.Values, key string, safelist []string, v *validator.Validator) []string {
+	fields := app.readCSV(qs, key, []string{})
+
+	for _, field := range fields {
+		v.CheckField(validator.In(field, safelist...), key, validator.Message("validation.only", strings.Join(safelist, ", ")))
+	}
+
+	return fields
+}
+
+/*
+The fields of a resource that were asked for with ?fields=. It is written as the
+object it was made from, without the other keys, and under the same XML name.
+*/
+type partial struct {
+	name   string
+	fields map[string]any
+}
+
+func (p partial) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.fields)
+}
+
+func (p partial) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: p.name}
+	return encodeXMLMap(e, start, reflect.ValueOf(p.fields))
+}
+
+/* Keeps only the given JSON keys of value, which must encode to an object */
+func selectFields(value any, fields []string) (partial, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return partial{}, err
+	}
+
+	var all map[string]any
+	err = json.Unmarshal(js, &all)
+	if err != nil {
+		return partial{}, err
+	}
+
+	p := partial{name: xmlItemName(reflect.ValueOf(value)), fields: make(map[string]any, len(fields))}
+	for _, field := range fields {
+		/* Keys left out by omitempty stay left out */
+		if v, ok := all[field]; ok {
+			p.fields[field] = v
+		}
+	}
+
+	return p, nil
+}
+
+func selectEachFields[T any](values []T, fields []string) ([]partial, error) {
+	selected := make([]partial, len(values))
+
+	for i, value := range values {
+		p, err := selectFields(value, fields)
+		if err != nil {
+			return nil, err
+		}
+		selected[i] = p
+	}
+
+	return selected, nil
+}
+
+/*
+Reduces the movies of env to fields, nothing is filtered when fields is empty.
+Runtimes are converted first, writeResponse can't see into a partial.
+*/
+func (app *application) selectMovieFields(r *http.Request, env envelope, fields []string) (envelope, error) {
+	if len(fields) == 0 {
+		return env, nil
+	}
+
+	env = withRuntimes(env, runtimeFormat(r))
+
+	selected := make(envelope, len(env))
+
+	for key, value := range env {
+		switch value := value.(type) {
+		case *data.Movie, movieISO8601, movieMinutes:
+			p, err := selectFields(value, fields)
+			if err != nil {
+				return nil, err
+			}
+			selected[key] = p
+		case []*data.Movie:
+			movies, err := selectEachFields(value, fields)
+			if err != nil {
+				return nil, err
+			}
+			selected[key] = movies
+		case []movieISO8601:
+			movies, err := selectEachFields(value, fields)
+			if err != nil {
+				return nil, err
+			}
+			selected[key] = movies
+		default:
+			selected[key] = value
+		}
+	}
+
+	return selected, nil
+}
+
+/*
+Runs fn in a goroutine that serve() waits for on shutdown, a panic in it is
+logged rather than crashing the server.
+*/
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
@@ -160,10 +510,54 @@ func (app *application) background(fn func()) {
 
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.Info(fmt.Sprintf("%s", err), nil)
+				app.logger.Error(fmt.Sprint(err), "background", "panic")
 			}
 		}()
 
 		fn()
 	}()
 }
+
+/* Strong entity tag of a versioned record, e.g. "3" */
+func etag(version int32) string {
+	return `"` + strconv.FormatInt(int64(version), 10) + `"`
+}
+
+/*
+Whether an If-Match or If-None-Match header lists the entity tag, "*" matches
+any. Weak tags only compare equal when weak is true, as If-None-Match allows.
+*/
+func etagListed(header, tag string, weak bool) bool {
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Requires an If-Match header naming the current version of the record, so a
+client can only change what it has seen. Writes a 428 or 412 response and
+returns false otherwise.
+*/
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, version int32) bool {
+	header := r.Header.Get("If-Match")
+
+	switch {
+	case header == "":
+		app.preconditionRequiredResponse(w, r)
+		return false
+	case !etagListed(header, etag(version), false):
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
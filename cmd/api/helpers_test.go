@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/* A ResponseWriter that drops the body, so only the encoding is measured */
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: make(http.Header)}
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+/* Like net/http's, so io.WriteString doesn't copy the string */
+func (w *discardResponseWriter) WriteString(s string) (int, error) {
+	return len(s), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+func benchmarkMovies(n int) []*data.Movie {
+	rating := 4.2
+	movies := make([]*data.Movie, n)
+
+	for i := range movies {
+		movies[i] = &data.Movie{
+			ID:            int64(i + 1),
+			Title:         fmt.Sprintf("Movie %d", i+1),
+			Year:          int32(1950 + i%70),
+			Runtime:       data.Runtime(90 + i%60),
+			Genres:        []string{"drama", "comedy"},
+			AverageRating: &rating,
+			Version:       1,
+		}
+	}
+
+	return movies
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	movies := benchmarkMovies(1000)
+
+	for _, env := range []string{"development", "production"} {
+		for _, n := range []int{1, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/movies=%d", env, n), func(b *testing.B) {
+				app := &application{config: config{env: env}}
+				env := envelope{"movies": movies[:n], "metadata": data.Metadata{CurrentPage: 1, PageSize: n}}
+				w := newDiscardResponseWriter()
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					err := app.writeJSON(w, http.StatusOK, env, nil)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
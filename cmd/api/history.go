@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* The :version of the history routes, false once a 404 has been sent */
+func (app *application) readVersionParam(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	version, err := app.readNamedIDParam(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	return int32(version), true
+}
+
+/* The updates of a movie in the catalogue, newest first */
+func (app *application) listMovieHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	f := data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", 20, v),
+		/* Always newest first, the sort isn't read */
+		Sort:         "-version",
+		SortSafelist: []string{"-version"},
+	}
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	/* Movies in the trash have no history to show, like they can't be shown */
+	_, err = app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	entries, metadata, err := app.models.MovieHistory.GetAll(r.Context(), id, f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"metadata": metadata, "history": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* The update that gave the movie a version, with the fields it changed */
+func (app *application) showMovieHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, ok := app.readVersionParam(w, r)
+	if !ok {
+		return
+	}
+
+	_, err = app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	entry, err := app.models.MovieHistory.Get(r.Context(), id, version)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"history": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+Gives the movie back the title, year, runtime and genres it had at an earlier
+version. The revert is an update like any other, with a new version and its
+own entry in the history, so it can be reverted too.
+*/
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, ok := app.readVersionParam(w, r)
+	if !ok {
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.checkIfMatch(w, r, movie.Version) {
+		return
+	}
+
+	v := validator.New()
+	v.CheckField(version < movie.Version, "version", validator.Message("validation.earlier_version"))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	values, err := app.models.MovieHistory.ValuesAt(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			/* The movie was never updated, it still has the values it had then */
+			v.AddError("version", validator.Message("validation.changed_version"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	before := *movie
+	values.Apply(movie)
+
+	err = app.models.Movies.Update(r.Context(), movie, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	app.publishEvent(r.Context(), events.MovieUpdated, movie)
+	app.recordChange(r, &before, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
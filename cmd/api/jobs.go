@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/metrics"
+	"github.com/mohafarman/greenlight/internal/worker"
+)
+
+const tokenCleanupInterval = time.Hour
+
+/* Sends an email from the job queue, which retries it if the SMTP server fails */
+func (app *application) sendEmail(recipient, templateFile string, data any) error {
+	return app.jobs.Enqueue("email "+templateFile, func(ctx context.Context) error {
+		return app.mailer.Send(recipient, templateFile, data)
+	})
+}
+
+/* Queues a cleanup of the expired tokens every tokenCleanupInterval until ctx is done */
+func (app *application) runTokenCleanup(ctx context.Context) {
+	ticker := time.NewTicker(tokenCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := app.jobs.Enqueue("token cleanup", func(ctx context.Context) error {
+			deleted, err := app.models.Tokens.DeleteExpired(ctx)
+			if err != nil {
+				return err
+			}
+
+			app.logger.Info("deleted expired tokens", "count", deleted)
+			return nil
+		})
+		/* The next tick tries again, and the queue is only closed after ctx is done */
+		if err != nil && !errors.Is(err, worker.ErrQueueClosed) {
+			app.logger.Error(err.Error(), "job", "token cleanup")
+		}
+	}
+}
+
+func registerJobMetrics(registry *metrics.Registry, jobs *worker.Queue) {
+	expvar.Publish("jobs", expvar.Func(func() any {
+		return jobs.Stats()
+	}))
+
+	stat := func(fn func(worker.Stats) float64) func() float64 {
+		return func() float64 {
+			return fn(jobs.Stats())
+		}
+	}
+
+	registry.NewGaugeFunc("greenlight_jobs_queued", "Number of jobs waiting for a worker.",
+		stat(func(s worker.Stats) float64 { return float64(s.Queued) }))
+	registry.NewGaugeFunc("greenlight_jobs_running", "Number of jobs being run.",
+		stat(func(s worker.Stats) float64 { return float64(s.Running) }))
+	registry.NewCounterFunc("greenlight_jobs_succeeded_total", "Number of jobs that succeeded.",
+		stat(func(s worker.Stats) float64 { return float64(s.Succeeded) }))
+	registry.NewCounterFunc("greenlight_jobs_retried_total", "Number of failed job attempts that were retried.",
+		stat(func(s worker.Stats) float64 { return float64(s.Retried) }))
+	registry.NewCounterFunc("greenlight_jobs_dead_total", "Number of jobs given up on after their last attempt.",
+		stat(func(s worker.Stats) float64 { return float64(s.Dead) }))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/* JSON:API resource type for each envelope key that holds resources */
+var jsonapiTypes = map[string]string{
+	"movie":   "movies",
+	"movies":  "movies",
+	"review":  "reviews",
+	"reviews": "reviews",
+	"user":    "users",
+}
+
+type jsonapiResource struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+type jsonapiDocument struct {
+	Data  any               `json:"data,omitempty"`
+	Meta  map[string]any    `json:"meta,omitempty"`
+	Links map[string]string `json:"links,omitempty"`
+}
+
+/*
+Renders the envelope as a JSON:API document (https://jsonapi.org): resources found
+under a known key become "data", everything else goes to "meta", and list
+responses get pagination links built from the request URL.
+*/
+func encodeJSONAPI(w io.Writer, r *http.Request, v any, compact bool) error {
+	env, ok := v.(envelope)
+	if !ok {
+		return fmt.Errorf("jsonapi: cannot encode %T", v)
+	}
+
+	doc := jsonapiDocument{Meta: map[string]any{}}
+
+	for key, value := range env {
+		resourceType, ok := jsonapiTypes[key]
+		if !ok {
+			doc.Meta[key] = value
+			continue
+		}
+
+		resources, err := toJSONAPIResources(resourceType, value)
+		if err != nil {
+			return err
+		}
+		doc.Data = resources
+	}
+
+	if metadata, ok := env["metadata"].(data.Metadata); ok && r != nil {
+		doc.Links = paginationLinks(r, metadata)
+	}
+
+	if len(doc.Meta) == 0 {
+		doc.Meta = nil
+	}
+
+	return encodeJSON(w, r, doc, compact)
+}
+
+/* Converts a single value or a slice of values into resource objects */
+func toJSONAPIResources(resourceType string, value any) (any, error) {
+	rv := reflect.ValueOf(value)
+
+	if rv.Kind() == reflect.Slice {
+		resources := make([]jsonapiResource, 0, rv.Len())
+
+		for i := range rv.Len() {
+			resource, err := toJSONAPIResource(resourceType, rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, resource)
+		}
+
+		return resources, nil
+	}
+
+	return toJSONAPIResource(resourceType, value)
+}
+
+/* The JSON representation minus "id" becomes the resource attributes */
+func toJSONAPIResource(resourceType string, value any) (jsonapiResource, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return jsonapiResource{}, err
+	}
+
+	var attributes map[string]any
+	err = json.Unmarshal(js, &attributes)
+	if err != nil {
+		return jsonapiResource{}, err
+	}
+
+	id := fmt.Sprint(attributes["id"])
+	delete(attributes, "id")
+
+	return jsonapiResource{
+		Type:       resourceType,
+		ID:         id,
+		Attributes: attributes,
+	}, nil
+}
+
+func paginationLinks(r *http.Request, metadata data.Metadata) map[string]string {
+	if metadata.CurrentPage == 0 {
+		return nil
+	}
+
+	link := func(page int) string {
+		u := *r.URL
+		qs := u.Query()
+		qs.Set("page", strconv.Itoa(page))
+		u.RawQuery = qs.Encode()
+		return u.RequestURI()
+	}
+
+	links := map[string]string{
+		"self":  link(metadata.CurrentPage),
+		"first": link(metadata.FirstPage),
+		"last":  link(metadata.LastPage),
+	}
+
+	if metadata.CurrentPage > metadata.FirstPage {
+		links["prev"] = link(metadata.CurrentPage - 1)
+	}
+
+	if metadata.CurrentPage < metadata.LastPage {
+		links["next"] = link(metadata.CurrentPage + 1)
+	}
+
+	return links
+}
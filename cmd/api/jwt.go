@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/jwt"
+)
+
+/* The JWT codec for -auth-mode=jwt, nil in the default token mode */
+func openJWT(cfg config) (*jwt.Codec, error) {
+	switch cfg.auth.mode {
+	case "token":
+		return nil, nil
+	case "jwt":
+	default:
+		return nil, fmt.Errorf("invalid -auth-mode %q, must be token or jwt", cfg.auth.mode)
+	}
+
+	switch cfg.auth.jwt.alg {
+	case "HS256":
+		return jwt.NewHS256([]byte(cfg.auth.jwt.secret))
+	case "RS256":
+		key, err := os.ReadFile(cfg.auth.jwt.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return jwt.NewRS256(key)
+	default:
+		return nil, fmt.Errorf("invalid -jwt-alg %q, must be HS256 or RS256", cfg.auth.jwt.alg)
+	}
+}
+
+/*
+Issues an authentication token for the user, an opaque token stored in the
+database, in the family of the login's refresh tokens, or with -auth-mode=jwt
+a signed JWT. JWTs can't be revoked nor listed as sessions, a password reset
+only signs the user out once theirs expire.
+*/
+func (app *application) newAuthenticationToken(ctx context.Context, user *data.User, family []byte) (*data.Token, error) {
+	if app.jwtCodec == nil {
+		return app.models.Tokens.NewInFamily(ctx, int64(user.ID), app.config.auth.tokenTTL, family)
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.auth.tokenTTL)
+
+	signed, err := app.jwtCodec.Sign(jwt.Claims{
+		Subject:   strconv.Itoa(user.ID),
+		Issuer:    app.config.auth.jwt.issuer,
+		Audience:  jwt.Audience{app.config.auth.jwt.audience},
+		ExpiresAt: expiry.Unix(),
+		IssuedAt:  now.Unix(),
+		Name:      user.Name,
+		Email:     user.Email,
+		Activated: user.Activated,
+		Tenant:    data.TenantID(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: signed,
+		UserID:    int64(user.ID),
+		Expiry:    time.Unix(expiry.Unix(), 0),
+		Scope:     data.ScopeAuthentication,
+	}, nil
+}
+
+/*
+The user is built from the claims, no database lookup. Tokens without a tenant
+were issued before tenancy, to users of the default tenant.
+*/
+func (app *application) userForJWT(ctx context.Context, token string) (*data.User, error) {
+	claims, err := app.jwtCodec.Verify(token, app.config.auth.jwt.issuer, app.config.auth.jwt.audience, time.Now())
+	if err != nil {
+		return nil, errInvalidAuthenticationToken
+	}
+
+	if max(claims.Tenant, data.DefaultTenantID) != data.TenantID(ctx) {
+		return nil, errInvalidAuthenticationToken
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, errors.Join(errInvalidAuthenticationToken, err)
+	}
+
+	return &data.User{
+		ID:        id,
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: claims.Activated,
+	}, nil
+}
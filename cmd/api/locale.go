@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/i18n"
+)
+
+/* Locale for client-facing messages, from the Accept-Language header */
+func (app *application) locale(r *http.Request) string {
+	return i18n.MatchLocale(r.Header.Get("Accept-Language"))
+}
+
+/* Looks up a message from the embedded catalogue in the caller's language */
+func (app *application) translate(r *http.Request, key string, args ...any) string {
+	return i18n.Translate(app.locale(r), key, args...)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/*
+The logger of the API: -log-format picks JSON or logfmt-like text, -log-level
+the minimum level, which reloadConfig changes through the returned LevelVar.
+Messages logged with a request's context get its request_id and user_id.
+*/
+func openLogger(cfg config, out io.Writer) (*slog.Logger, *slog.LevelVar) {
+	level := new(slog.LevelVar)
+	/* Valid, validateConfig checked it */
+	level.UnmarshalText([]byte(cfg.logLevel))
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if cfg.logFormat == "text" {
+		handler = slog.NewTextHandler(out, opts)
+	} else {
+		handler = slog.NewJSONHandler(out, opts)
+	}
+
+	return slog.New(contextHandler{handler}), level
+}
+
+func validLogLevel(s string) bool {
+	var level slog.Level
+	return level.UnmarshalText([]byte(s)) == nil
+}
+
+/* For the errors main can't go on after, there is no slog.LevelFatal */
+func fatal(logger *slog.Logger, err error) {
+	logger.Error(err.Error())
+	os.Exit(1)
+}
+
+/* Adds the request ID and the authenticated user from the context to every record */
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+
+	if userID := contextUserID(ctx); userID != 0 {
+		record.AddAttrs(slog.Int("user_id", userID))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+/*
+The authenticated user's ID, 0 for anonymous requests. logRequest's context
+doesn't have the user authenticate adds further in, contextSetUser records it
+in the requestLogUser logRequest adds for its log line.
+*/
+func contextUserID(ctx context.Context) int {
+	if user, ok := ctx.Value(userContextKey).(*data.User); ok && !user.IsAnonymous() {
+		return user.ID
+	}
+
+	if logged, ok := ctx.Value(requestLogUserContextKey).(*requestLogUser); ok {
+		return logged.id
+	}
+
+	return 0
+}
+
+type requestLogUser struct {
+	id int
+}
+
+/* The http.Server ErrorLog, so TLS handshake and connection errors aren't lost */
+func (app *application) serverErrorLog(server string) *log.Logger {
+	return slog.NewLogLogger(app.logger.With("server", server).Handler(), slog.LevelError)
+}
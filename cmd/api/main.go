@@ -2,21 +2,40 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net/netip"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/mohafarman/greenlight/internal/acme"
+	"github.com/mohafarman/greenlight/internal/cache"
 	"github.com/mohafarman/greenlight/internal/data"
-	"github.com/mohafarman/greenlight/internal/jsonlog"
+	"github.com/mohafarman/greenlight/internal/enrich"
+	"github.com/mohafarman/greenlight/internal/errreport"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/jwt"
 	"github.com/mohafarman/greenlight/internal/mailer"
+	"github.com/mohafarman/greenlight/internal/metrics"
+	"github.com/mohafarman/greenlight/internal/notify"
+	"github.com/mohafarman/greenlight/internal/oauth"
+	"github.com/mohafarman/greenlight/internal/ratelimit"
+	"github.com/mohafarman/greenlight/internal/redis"
+	"github.com/mohafarman/greenlight/internal/storage"
+	"github.com/mohafarman/greenlight/internal/totp"
 	"github.com/mohafarman/greenlight/internal/vcs"
+	"github.com/mohafarman/greenlight/internal/worker"
 )
 
 var (
@@ -24,18 +43,50 @@ var (
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port             int
+	env              string
+	errorFormat      string
+	logLevel         string
+	logFormat        string
+	responseEnvelope string
+	/* Request body limit of routes without one of their own, see route.maxBody */
+	maxBodyBytes int64
+	/* Time the handlers of routes without one of their own get, see route.timeout */
+	requestTimeout time.Duration
+	/* Of the updates that opted in to be merged, see updateMovieHandler */
+	editConflictRetries int
+	db                  struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		/* A read-only replica for movie reads, see data.Replica */
+		replicaDSN string
+		/* Apply the pending migrations before serving */
+		migrate bool
 	}
+	/* Tiers: anonymous clients by IP, authenticated users and admins by user ID */
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps        float64
+		burst      int
+		userRPS    float64
+		userBurst  int
+		adminRPS   float64
+		adminBurst int
+		enabled    bool
+		/* memory or redis, which shares the limits between instances */
+		store string
+		/* Stricter limits by route, e.g. "POST /v1/users", see rateLimitFor */
+		routes map[string]ratelimit.Limit
+	}
+	redis struct {
+		url string
+	}
+	/* Movie reads, see data.NewModels */
+	cache struct {
+		/* none, memory or redis, which shares the cache between instances */
+		store string
+		ttl   time.Duration
 	}
 	smtp struct {
 		host     string
@@ -44,64 +95,260 @@ type config struct {
 		password string
 		sender   string
 	}
+	proxies struct {
+		/* Peers whose X-Forwarded-For is believed, see clientIP */
+		trusted []netip.Prefix
+	}
 	cors struct {
-		trustedOrigins []string
+		trustedOrigins   []string
+		maxAge           time.Duration
+		allowCredentials bool
+	}
+	docs struct {
+		enabled bool
+	}
+	/* Retirement of /v1, see deprecateV1 */
+	versions struct {
+		v1DeprecatedAt time.Time
+		v1SunsetAt     time.Time
+	}
+	grpc struct {
+		port int
+	}
+	/* See openTLS */
+	tls struct {
+		certFile      string
+		keyFile       string
+		autocertHosts []string
+		autocertDir   string
+		autocertEmail string
+		acmeDirectory string
+		redirectPort  int
+	}
+	metrics struct {
+		token string
+	}
+	errorReport struct {
+		dsn        string
+		sampleRate float64
+	}
+	/* See openEnricher */
+	enrich struct {
+		provider string
+		apiKey   string
+		onCreate bool
+		cacheTTL time.Duration
+	}
+	/* See resolveTenant */
+	tenancy struct {
+		mode   string
+		domain string
+	}
+	/* See enforceQuota */
+	quota struct {
+		enabled       bool
+		userMonthly   int64
+		tenantMonthly int64
+	}
+	/* See showStatsHandler */
+	stats struct {
+		cacheTTL time.Duration
+	}
+	/* See createExportJobHandler */
+	exports struct {
+		ttl time.Duration
+	}
+	auth struct {
+		mode       string
+		tokenTTL   time.Duration
+		refreshTTL time.Duration
+		jwt        struct {
+			alg      string
+			secret   string
+			keyFile  string
+			issuer   string
+			audience string
+		}
+		/* Encrypts the TOTP secrets, 2FA is unavailable without it */
+		totpKey string
+	}
+	oauth struct {
+		/* The API's public URL, providers redirect to /v1/auth/:provider/callback on it */
+		redirectBaseURL string
+		google          struct {
+			clientID     string
+			clientSecret string
+		}
+		github struct {
+			clientID     string
+			clientSecret string
+		}
+	}
+	jobs struct {
+		workers     int
+		queueSize   int
+		maxAttempts int
+	}
+	storage struct {
+		backend string
+		dir     string
+		baseURL string
+		s3      struct {
+			endpoint  string
+			region    string
+			bucket    string
+			accessKey string
+			secretKey string
+		}
 	}
 }
 
 type application struct {
 	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup // No need to initialize
+	logger *slog.Logger
+	/* Changed by reloadConfig */
+	logLevel *slog.LevelVar
+	models   data.Models
+	/* For the readiness probe, everything else goes through models */
+	db *sql.DB
+	/* Set with -db-replica-dsn */
+	replicaDB *sql.DB
+	/* Set with -redis-url */
+	redis   *redis.Client
+	limiter ratelimit.Store
+	mailer  mailer.Mailer
+	events  *events.Bus
+	/* The WebSocket connections of each user, see notifications.go */
+	notifications *notify.Hub
+	storage       storage.Storage
+	/* Emails and other work that can be retried, see sendEmail */
+	jobs *worker.Queue
+	/* Set with -auth-mode=jwt, see newAuthenticationToken */
+	jwtCodec *jwt.Codec
+	/* Set with -totp-key, see two_factor.go */
+	totpCipher *totp.Cipher
+	/* The configured OAuth providers by name, see oauth.go */
+	oauthProviders map[string]*oauth.Provider
+	/* Changed by reloadConfig, see settings */
+	live atomic.Pointer[liveSettings]
+	/* Served at /metrics, see metricsHandler */
+	metricsRegistry *metrics.Registry
+	/* Set with -error-report-dsn, see reportError */
+	errorReporter errreport.Reporter
+	/* Set with -enrich-provider, see enrich.go */
+	enricher enrich.Provider
+	/* The -cache-store, or one of the process's own, see showStatsHandler */
+	statsCache cache.Cache
+	/* The IDs of the tenants by slug, filled by resolveTenant as they are first seen */
+	tenantIDs sync.Map
+	/* Set with -tls-cert or -tls-autocert-hosts, see openTLS */
+	tlsConfig *tls.Config
+	acme      *acme.Manager
+	wg        sync.WaitGroup // No need to initialize
 }
 
 func main() {
 	var cfg config
 
-	flag.IntVar(&cfg.port, "port", 4000, "API server port.")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
-
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "d5402d45cc83f6", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "1b7d221faa09f4", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.net>", "SMTP sender")
-
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space seperated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
+	defineFlags(flag.CommandLine, &cfg)
 
+	flag.String("config", "", "Config file, see config.go; flags and GREENLIGHT_* environment variables take precedence")
+	displayConfig := flag.Bool("print-config", false, "Print the effective configuration, secrets redacted, and exit")
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
-	flag.Parse()
+	/* Exits on an invalid flag, like flag.Parse */
+	err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	if *displayVersion {
 		fmt.Printf("Version: \t%s\n", version)
 		os.Exit(0)
 	}
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	/* Before the validation, an invalid config is what one would want to look at */
+	if *displayConfig {
+		printConfig(flag.CommandLine, os.Stdout)
+		os.Exit(0)
+	}
 
-	db, err := openDB(cfg)
+	err = validateConfig(cfg)
 	if err != nil {
-		logger.Fatal(err, nil)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, logLevel := openLogger(cfg, os.Stdout)
+
+	db, err := openDB(cfg, cfg.db.dsn)
+	if err != nil {
+		fatal(logger, err)
 	}
 
 	defer db.Close()
 
-	logger.Info("database connection pool established", nil)
+	logger.Info("database connection pool established")
+
+	/* "api [flags] migrate up|down [N]|version" runs the migrations and exits */
+	if flag.Arg(0) == "migrate" {
+		result, err := migrateCommand(db, flag.Args()[1:])
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		logger.Info(result)
+		return
+	}
+
+	/* "api [flags] seed [-movies N] [-users N] [-tenant SLUG]" fills a development database and exits */
+	if flag.Arg(0) == "seed" {
+		result, err := seedCommand(data.NewModels(db, nil, nil, 0), flag.Args()[1:])
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		logger.Info(result)
+		return
+	}
+
+	/* "api [flags] tenants list|create SLUG NAME|quota SLUG N|default" manages the tenants and exits */
+	if flag.Arg(0) == "tenants" {
+		result, err := tenantsCommand(data.NewModels(db, nil, nil, 0), flag.Args()[1:])
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		logger.Info(result)
+		return
+	}
+
+	if cfg.db.migrate {
+		result, err := migrateCommand(db, []string{"up"})
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		logger.Info(result)
+	}
+
+	var (
+		replicaDB *sql.DB
+		replica   *data.Replica
+	)
+
+	/* Not pinged, the replica being down only sends reads to the primary until it is back */
+	if cfg.db.replicaDSN != "" {
+		replicaDB, err = newDBPool(cfg, cfg.db.replicaDSN)
+		if err != nil {
+			fatal(logger, err)
+		}
+
+		defer replicaDB.Close()
+
+		replica = data.NewReplica(replicaDB)
+	}
 
 	// Publish a new "version" variable in the expvar handler containing our application
 	// version number
@@ -119,22 +366,281 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	registry := metrics.NewRegistry()
+	registerRuntimeMetrics(registry)
+	registerDBMetrics(registry, db)
+
+	jobs := worker.New(worker.Config{
+		Workers:     cfg.jobs.workers,
+		QueueSize:   cfg.jobs.queueSize,
+		MaxAttempts: cfg.jobs.maxAttempts,
+		BaseDelay:   time.Second,
+	}, logger)
+	registerJobMetrics(registry, jobs)
+
+	redisClient, err := openRedis(cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	limiter, err := openLimiter(cfg, redisClient)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	movieCache, err := openCache(cfg, redisClient)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	jwtCodec, err := openJWT(cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	totpCipher, err := openTOTPCipher(cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	store, err := openStorage(cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	tlsConfig, acmeManager, err := openTLS(cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	errorReporter, err := openErrorReporter(cfg)
+	if err != nil {
+		fatal(logger, err)
+	}
+
+	enricher, err := openEnricher(cfg, movieCache)
+	if err != nil {
+		fatal(logger, err)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:   cfg,
+		logger:   logger,
+		logLevel: logLevel,
+		models:   data.NewModels(db, replica, movieCache, cfg.cache.ttl),
+		db:       db,
+		redis:    redisClient,
+		limiter:  limiter,
+		mailer:   mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		events:   events.NewBus(),
+		storage:  store,
+		jobs:     jobs,
+
+		errorReporter: errorReporter,
+		enricher:      enricher,
+		statsCache:    openStatsCache(movieCache),
+
+		notifications: notify.NewHub(),
+
+		replicaDB: replicaDB,
+
+		jwtCodec:       jwtCodec,
+		totpCipher:     totpCipher,
+		oauthProviders: openOAuth(cfg),
+
+		metricsRegistry: registry,
+
+		tlsConfig: tlsConfig,
+		acme:      acmeManager,
 	}
 
+	app.live.Store(newLiveSettings(cfg))
+
+	app.events.Subscribe(app.enqueueWebhooks)
+	app.events.Subscribe(app.notifyWatchlists)
+	app.events.Subscribe(app.alertSavedSearches)
+
 	err = app.serve()
 	if err != nil {
-		logger.Fatal(err, nil)
+		fatal(logger, err)
 	}
 
 }
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+/*
+Defines the settings' flags on fs, bound to cfg. Called again on a fresh
+FlagSet by reloadConfig.
+*/
+func defineFlags(fs *flag.FlagSet, cfg *config) {
+	fs.IntVar(&cfg.port, "port", 4000, "API server port.")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+
+	fs.StringVar(&cfg.errorFormat, "error-format", "envelope", "Error response format (envelope|problem)")
+	fs.Int64Var(&cfg.maxBodyBytes, "max-body-bytes", 1<<20, "Maximum request body size in bytes, some routes have a limit of their own")
+	fs.IntVar(&cfg.editConflictRetries, "edit-conflict-retries", 3, "Times a PATCH with If-Match: * is merged into the movie again after a concurrent update before answering 409")
+	fs.DurationVar(&cfg.requestTimeout, "request-timeout", 5*time.Second, "Time a request may take before it's answered with 504 and its queries are cancelled, 0 for none; some routes have a timeout of their own")
+	fs.StringVar(&cfg.logLevel, "log-level", "info", "Minimum level of the logged messages (debug|info|warn|error), reloaded on SIGHUP")
+	fs.StringVar(&cfg.logFormat, "log-format", "json", "Log format (json|text)")
+	fs.StringVar(&cfg.responseEnvelope, "response-envelope", "", "Response envelope key, \"none\" to return resources unwrapped (default resource name)")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.StringVar(&cfg.db.replicaDSN, "db-replica-dsn", "", "PostgreSQL DSN of a read replica for movie reads, which can then lag behind writes")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	fs.BoolVar(&cfg.db.migrate, "migrate", false, "Apply the pending database migrations at startup")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second of anonymous clients, by IP")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst of anonymous clients")
+	fs.Float64Var(&cfg.limiter.userRPS, "limiter-user-rps", 10, "Rate limiter maximum requests per second of authenticated users, 0 for unlimited")
+	fs.IntVar(&cfg.limiter.userBurst, "limiter-user-burst", 20, "Rate limiter maximum burst of authenticated users")
+	fs.Float64Var(&cfg.limiter.adminRPS, "limiter-admin-rps", 0, "Rate limiter maximum requests per second of admins, 0 for unlimited")
+	fs.IntVar(&cfg.limiter.adminBurst, "limiter-admin-burst", 0, "Rate limiter maximum burst of admins")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	/* Logins and sign ups are what gets brute forced */
+	cfg.limiter.routes = map[string]ratelimit.Limit{
+		"POST /v1/tokens/authentication": {Rate: 5.0 / 60, Burst: 5},
+		"POST /v1/users":                 {Rate: 5.0 / 60, Burst: 5},
+	}
+	fs.Func("limiter-route", "Rate limit of a route, e.g. \"POST /v1/users=5/m\" or \"POST /v1/users=off\" (repeatable)", func(val string) error {
+		route, limit, err := parseRouteLimit(val)
+		if err != nil {
+			return err
+		}
+		if limit.Unlimited() {
+			delete(cfg.limiter.routes, route)
+		} else {
+			cfg.limiter.routes[route] = limit
+		}
+		return nil
+	})
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Where rate limits are kept (memory|redis), redis enforces them across instances")
+
+	fs.StringVar(&cfg.redis.url, "redis-url", "", "Redis URL, e.g. redis://:password@localhost:6379/0")
+
+	fs.StringVar(&cfg.cache.store, "cache-store", "none", "Where movie reads are cached (none|memory|redis), memory is per instance and can serve stale movies for -cache-ttl after writes on another one")
+	fs.DurationVar(&cfg.cache.ttl, "cache-ttl", 30*time.Second, "How long movie reads are cached")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "d5402d45cc83f6", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "1b7d221faa09f4", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.net>", "SMTP sender")
+
+	fs.Func("trusted-proxies", "Addresses or CIDR ranges (space separated) of the reverse proxies whose X-Forwarded-For is believed", func(val string) error {
+		var err error
+		cfg.proxies.trusted, err = parseTrustedProxies(val)
+		return err
+	})
+
+	fs.Func("cors-trusted-origins", "Trusted CORS origins (space seperated), e.g. https://*.example.com for any subdomain", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		for _, origin := range cfg.cors.trustedOrigins {
+			/* The wildcard only stands for the subdomain */
+			_, host, wildcard := strings.Cut(origin, "://*.")
+			if strings.Contains(origin, "*") && (!wildcard || strings.Contains(host, "*")) {
+				return fmt.Errorf("invalid origin %q, a wildcard must be of the form scheme://*.domain", origin)
+			}
+		}
+		return nil
+	})
+	fs.DurationVar(&cfg.cors.maxAge, "cors-max-age", 0, "How long browsers may cache preflight responses, not sent when 0")
+	fs.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Allow trusted origins to send credentials, i.e. cookies")
+
+	fs.IntVar(&cfg.grpc.port, "grpc-port", 0, "gRPC server port, 0 disables the gRPC server")
+
+	fs.StringVar(&cfg.tls.certFile, "tls-cert", "", "PEM file of the TLS certificate chain, serves HTTPS (and HTTP/2) on -port")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key", "", "PEM file of the TLS private key")
+	fs.Func("tls-autocert-hosts", "Hosts (space separated) to obtain certificates for from the ACME CA, instead of -tls-cert", func(val string) error {
+		cfg.tls.autocertHosts = strings.Fields(val)
+		return nil
+	})
+	fs.StringVar(&cfg.tls.autocertDir, "tls-autocert-dir", "./certs", "Directory the ACME account key and certificates are kept in")
+	fs.StringVar(&cfg.tls.autocertEmail, "tls-autocert-email", "", "Contact email of the ACME account, for expiry notices")
+	fs.StringVar(&cfg.tls.acmeDirectory, "tls-acme-directory", acme.LetsEncryptURL, "ACME directory URL, e.g. Let's Encrypt's staging one for testing")
+	fs.IntVar(&cfg.tls.redirectPort, "http-redirect-port", 0, "Port of a plain HTTP listener redirecting to HTTPS, 0 disables it")
+
+	fs.Func("v1-deprecated-at", "Date from which /v1 responses carry a Deprecation header, e.g. 2026-01-01", func(val string) error {
+		return parseDate(val, &cfg.versions.v1DeprecatedAt)
+	})
+	fs.Func("v1-sunset-at", "Date announced in a Sunset header of /v1 responses, /v1 answers 410 Gone from then on", func(val string) error {
+		return parseDate(val, &cfg.versions.v1SunsetAt)
+	})
+
+	fs.BoolVar(&cfg.docs.enabled, "docs-enabled", false, "Serve Swagger UI for the OpenAPI document at /docs")
+
+	fs.StringVar(&cfg.metrics.token, "metrics-token", "", "Bearer token required to scrape /metrics, no authentication when empty")
+
+	fs.StringVar(&cfg.errorReport.dsn, "error-report-dsn", "", "Sentry (or compatible) DSN that server errors and panics are reported to, not reported when empty")
+	fs.Float64Var(&cfg.errorReport.sampleRate, "error-report-sample-rate", 1, "Fraction of the errors that are reported, between 0 and 1")
+
+	fs.StringVar(&cfg.enrich.provider, "enrich-provider", "", "Metadata service movies are enriched from (tmdb|omdb), POST /v1/movies/:id/enrich is a 404 when empty")
+	fs.StringVar(&cfg.enrich.apiKey, "enrich-api-key", "", "API key of the -enrich-provider")
+	fs.BoolVar(&cfg.enrich.onCreate, "enrich-on-create", false, "Enrich movies in the background as they are created")
+	fs.DurationVar(&cfg.enrich.cacheTTL, "enrich-cache-ttl", 24*time.Hour, "How long the provider's answers are kept, so movies with the same title and year aren't looked up again")
+
+	fs.StringVar(&cfg.tenancy.mode, "tenancy", "none", "How requests name their tenant (none|subdomain|header), every request is the default tenant's with none")
+	fs.StringVar(&cfg.tenancy.domain, "tenant-domain", "", "Domain the tenants are subdomains of with -tenancy=subdomain, e.g. example.com for acme.example.com")
+
+	fs.BoolVar(&cfg.quota.enabled, "quota-enabled", false, "Count the requests of authenticated users against monthly quotas, GET /v1/me/usage is a 404 when disabled")
+	fs.Int64Var(&cfg.quota.userMonthly, "quota-user-monthly", 0, "Monthly requests of each user unless given a quota of their own, 0 for unlimited")
+	fs.Int64Var(&cfg.quota.tenantMonthly, "quota-tenant-monthly", 0, "Monthly requests of all users of a tenant unless given a quota of its own, 0 for unlimited")
+
+	fs.DurationVar(&cfg.exports.ttl, "export-ttl", 24*time.Hour, "How long export jobs and their files are kept before they are deleted")
+	fs.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 5*time.Minute, "How long GET /v1/admin/stats is cached, 0 to compute it for every request")
+
+	fs.StringVar(&cfg.auth.mode, "auth-mode", "token", "Kind of authentication tokens issued (token|jwt), JWTs are verified without a database lookup")
+	fs.DurationVar(&cfg.auth.tokenTTL, "auth-token-ttl", time.Hour, "Lifetime of authentication tokens")
+	fs.DurationVar(&cfg.auth.refreshTTL, "auth-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens, renewed with every refresh")
+
+	fs.StringVar(&cfg.auth.jwt.alg, "jwt-alg", "HS256", "JWT signing algorithm (HS256|RS256)")
+	fs.StringVar(&cfg.auth.jwt.secret, "jwt-secret", "", "HS256 secret, at least 32 bytes")
+	fs.StringVar(&cfg.auth.jwt.keyFile, "jwt-key-file", "", "PEM file of the RS256 private key")
+	fs.StringVar(&cfg.auth.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer (iss) claim, issued and required")
+	fs.StringVar(&cfg.auth.jwt.audience, "jwt-audience", "greenlight-api", "JWT audience (aud) claim, issued and required")
+
+	fs.StringVar(&cfg.auth.totpKey, "totp-key", "", "Base64 encoded 32 byte key encrypting TOTP secrets, two-factor authentication is unavailable without it")
+
+	fs.StringVar(&cfg.oauth.redirectBaseURL, "oauth-redirect-base-url", "http://localhost:4000", "Public base URL of the API that OAuth providers redirect back to")
+	fs.StringVar(&cfg.oauth.google.clientID, "oauth-google-client-id", "", "Google OAuth client ID, enables Sign in with Google")
+	fs.StringVar(&cfg.oauth.google.clientSecret, "oauth-google-client-secret", "", "Google OAuth client secret")
+	fs.StringVar(&cfg.oauth.github.clientID, "oauth-github-client-id", "", "GitHub OAuth app client ID, enables Sign in with GitHub")
+	fs.StringVar(&cfg.oauth.github.clientSecret, "oauth-github-client-secret", "", "GitHub OAuth app client secret")
+
+	fs.IntVar(&cfg.jobs.workers, "jobs-workers", 4, "Number of background job workers")
+	fs.IntVar(&cfg.jobs.queueSize, "jobs-queue-size", 1000, "Number of background jobs that can be queued")
+	fs.IntVar(&cfg.jobs.maxAttempts, "jobs-max-attempts", 5, "Attempts at a background job before it is given up on")
+
+	fs.StringVar(&cfg.storage.backend, "storage", "local", "Storage for uploaded files (local|s3)")
+	fs.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory of the local storage, served at /uploads")
+	fs.StringVar(&cfg.storage.baseURL, "storage-url", "", "Public base URL of uploaded files (default /uploads or the S3 bucket URL)")
+	fs.StringVar(&cfg.storage.s3.endpoint, "s3-endpoint", "", "S3-compatible endpoint, e.g. https://s3.eu-north-1.amazonaws.com")
+	fs.StringVar(&cfg.storage.s3.region, "s3-region", "us-east-1", "S3 region")
+	fs.StringVar(&cfg.storage.s3.bucket, "s3-bucket", "", "S3 bucket")
+	fs.StringVar(&cfg.storage.s3.accessKey, "s3-access-key", "", "S3 access key ID")
+	fs.StringVar(&cfg.storage.s3.secretKey, "s3-secret-key", "", "S3 secret access key")
+}
+
+func openDB(cfg config, dsn string) (*sql.DB, error) {
+	db, err := newDBPool(cfg, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+/* A pool sized by the -db-max-* flags, connections are only made when it is used */
+func newDBPool(cfg config, dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -149,13 +655,102 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	db.SetConnMaxIdleTime(duration)
 
+	return db, nil
+}
+
+/* nil without -redis-url */
+func openRedis(cfg config) (*redis.Client, error) {
+	if cfg.redis.url == "" {
+		return nil, nil
+	}
+
+	client, err := redis.Open(cfg.redis.url)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err = db.PingContext(ctx)
+	err = client.Ping(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return db, nil
+	return client, nil
+}
+
+func openLimiter(cfg config, client *redis.Client) (ratelimit.Store, error) {
+	switch cfg.limiter.store {
+	case "memory":
+		return ratelimit.NewMemory(), nil
+	case "redis":
+		if client == nil {
+			return nil, errors.New("-limiter-store=redis requires -redis-url")
+		}
+		return ratelimit.NewRedis(client, "greenlight:ratelimit:"), nil
+	default:
+		return nil, fmt.Errorf("invalid -limiter-store %q, must be memory or redis", cfg.limiter.store)
+	}
+}
+
+/* nil for -cache-store=none */
+func openCache(cfg config, client *redis.Client) (cache.Cache, error) {
+	switch cfg.cache.store {
+	case "none":
+		return nil, nil
+	case "memory":
+		return cache.NewMemory(10_000), nil
+	case "redis":
+		if client == nil {
+			return nil, errors.New("-cache-store=redis requires -redis-url")
+		}
+		return cache.NewRedis(client, "greenlight:cache:"), nil
+	default:
+		return nil, fmt.Errorf("invalid -cache-store %q, must be none, memory or redis", cfg.cache.store)
+	}
+}
+
+/* A date, e.g. 2026-01-01, or an RFC 3339 time */
+func parseDate(val string, t *time.Time) error {
+	parsed, err := time.Parse(time.DateOnly, val)
+	if err != nil {
+		parsed, err = time.Parse(time.RFC3339, val)
+		if err != nil {
+			return fmt.Errorf("invalid date %q, must be YYYY-MM-DD or RFC 3339", val)
+		}
+	}
+
+	*t = parsed
+	return nil
+}
+
+/*
+Parses "METHOD /path=N/unit", N requests per second, minute or hour (s|m|h) with
+bursts of up to N, or "METHOD /path=off".
+*/
+func parseRouteLimit(val string) (string, ratelimit.Limit, error) {
+	route, spec, ok := strings.Cut(val, "=")
+	if fields := strings.Fields(route); !ok || len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+		return "", ratelimit.Limit{}, fmt.Errorf("invalid route limit %q, must be of the form \"METHOD /path=N/unit\"", val)
+	}
+	route = strings.Join(strings.Fields(route), " ")
+
+	if spec == "off" {
+		return route, ratelimit.Limit{}, nil
+	}
+
+	count, unit, _ := strings.Cut(spec, "/")
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return "", ratelimit.Limit{}, fmt.Errorf("invalid route limit %q, N must be a positive number", val)
+	}
+
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if per == 0 {
+		return "", ratelimit.Limit{}, fmt.Errorf("invalid route limit %q, unit must be s, m or h", val)
+	}
+
+	return route, ratelimit.Limit{Rate: float64(n) / per.Seconds(), Burst: n}, nil
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/mohafarman/greenlight/internal/metrics"
+)
+
+const routeContextKey = contextKey("route")
+
+/* Route label of requests no route matched, their paths would make unbounded series */
+const unmatchedRoute = "unmatched"
+
+/*
+Records the route pattern of the request, e.g. /v1/movies/:id, for the
+metrics middleware which runs before the router has matched it.
+*/
+func tagRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+			*route = pattern
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+/* A holder for tagRoute in the request context, read once the request is served */
+func contextWithRoute(r *http.Request) (*http.Request, *string) {
+	route := new(string)
+	return r.WithContext(context.WithValue(r.Context(), routeContextKey, route)), route
+}
+
+/* Connection pool statistics, read from db when scraped */
+func registerDBMetrics(registry *metrics.Registry, db *sql.DB) {
+	stat := func(fn func(sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return fn(db.Stats())
+		}
+	}
+
+	registry.NewGaugeFunc("greenlight_db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	registry.NewGaugeFunc("greenlight_db_open_connections", "Number of established connections, in use or idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	registry.NewGaugeFunc("greenlight_db_in_use_connections", "Number of connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	registry.NewGaugeFunc("greenlight_db_idle_connections", "Number of idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	registry.NewCounterFunc("greenlight_db_wait_count_total", "Number of connections waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	registry.NewCounterFunc("greenlight_db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	registry.NewCounterFunc("greenlight_db_max_idle_closed_total", "Connections closed due to -db-max-idle-conns.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	registry.NewCounterFunc("greenlight_db_max_idle_time_closed_total", "Connections closed due to -db-max-idle-time.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+}
+
+func registerRuntimeMetrics(registry *metrics.Registry) {
+	registry.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+/*
+Serves the metrics in the Prometheus text format. With -metrics-token set,
+scrapers must send it as a bearer token.
+*/
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.metrics.token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.metrics.token)) != 1 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := app.metricsRegistry.Write(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -1,92 +1,276 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"expvar"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
-	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/jwt"
+	"github.com/mohafarman/greenlight/internal/metrics"
+	"github.com/mohafarman/greenlight/internal/ratelimit"
 	"github.com/mohafarman/greenlight/internal/validator"
-	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
 )
 
+/*
+Gives every request an ID, taken from X-Request-ID when the client (or a proxy)
+sent a sensible one, echoes it back and logs the request once it is served.
+*/
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestLogUserContextKey, &requestLogUser{}))
+
+		mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		/* Deferred so aborted requests (a panic with http.ErrAbortHandler) are logged too */
+		defer func() {
+			app.logger.InfoContext(r.Context(), "request",
+				"request_method", r.Method,
+				"request_url", r.URL.RequestURI(),
+				"status", mw.statusCode,
+				"bytes", mw.bytesWritten,
+				"duration", time.Since(start),
+			)
+		}()
+
+		next.ServeHTTP(mw, r)
+	})
+}
+
+/* A random (version 4) UUID */
+func newRequestID() string {
+	var u [16]byte
+	rand.Read(u[:])
+
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+/* Incoming IDs end up in the logs, so only short IDs of safe characters are kept */
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Runs after authenticate: users are limited by their ID, with the tier of their
+role, and only anonymous clients by IP. Every response tells the client what is
+left of its limit. The limits are kept in app.limiter, see -limiter-store.
+*/
 func (app *application) rateLimiter(next http.Handler) http.Handler {
-	// Any code here will run only once, when we wrap something with the middleware.
-	// Allow 2 requests per second, with a maximum of 4 requests in a burst.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+	/* Whether users are admins, looked up again once a minute rather than with every request */
+	type adminEntry struct {
+		admin   bool
+		expires time.Time
 	}
 
 	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
+		mu     sync.Mutex
+		admins = make(map[int]adminEntry)
 	)
 
-	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
-	go func() {
-		for {
-			time.Sleep(time.Minute)
+	isAdmin := func(ctx context.Context, userID int) (bool, error) {
+		mu.Lock()
+		entry, found := admins[userID]
+		mu.Unlock()
 
-			/* Lock to prevent any rate limiter checks while cleaning up */
-			mu.Lock()
+		if found && time.Now().Before(entry.expires) {
+			return entry.admin, nil
+		}
 
-			/* Delete client if they haven't been since within the last 3 minutes */
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
+		admin, err := app.models.Roles.UserHas(ctx, int64(userID), data.RoleAdmin)
+		if err != nil {
+			return false, err
+		}
+
+		mu.Lock()
+		/* Dropping the expired entries keeps the map to the users of the last minute */
+		for id, e := range admins {
+			if time.Now().After(e.expires) {
+				delete(admins, id)
 			}
-			mu.Unlock()
 		}
-	}()
+		admins[userID] = adminEntry{admin: admin, expires: time.Now().Add(time.Minute)}
+		mu.Unlock()
+
+		return admin, nil
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Any code here will run for every request that the middleware handles.
 
-		if app.config.limiter.enabled {
-			// ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			// if err != nil {
-			// 	app.serverErrorResponse(w, r, err)
-			// 	return
-			// }
+		settings := app.settings()
+
+		if !settings.limiterEnabled {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-			ip := realip.FromRequest(r)
+		user := app.contextGetUser(r)
 
-			// INFO: Because each request spins up its own goroutine we need to lock
-			// before writing to the map
-			mu.Lock()
+		key := "ip:" + app.clientIP(r)
+		limit := settings.anonymousLimit
 
-			// If no rate limiter (client) exists for the current user, create one
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
-			clients[ip].lastSeen = time.Now()
+		if !user.IsAnonymous() {
+			key = "user:" + strconv.Itoa(user.ID)
+			limit = settings.userLimit
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+			admin, err := isAdmin(r.Context(), user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
 				return
 			}
-			// DON'T use defer to unlock the mutex, as that would mean
-			// that the mutex isn't unlocked until all the handlers downstream of this
-			// middleware have also returned.
-			mu.Unlock()
+
+			if admin {
+				limit = settings.adminLimit
+			}
+		}
+
+		if app.takeToken(w, r, key, limit) {
+			next.ServeHTTP(w, r)
 		}
-		next.ServeHTTP(w, r)
 	})
 }
 
+/*
+A limit of its own for a route, e.g. logins, on top of the global one; see
+-limiter-route. Clients are told about whichever limit they are closer to.
+*/
+func (app *application) rateLimitFor(route string, limit ratelimit.Limit, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.settings().limiterEnabled {
+			next(w, r)
+			return
+		}
+
+		key := "ip:" + app.clientIP(r)
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			key = "user:" + strconv.Itoa(user.ID)
+		}
+
+		if app.takeToken(w, r, "route:"+route+":"+key, limit) {
+			next(w, r)
+		}
+	}
+}
+
+/* Writes the X-RateLimit-* headers, or the 429, and returns whether the request may go on */
+func (app *application) takeToken(w http.ResponseWriter, r *http.Request, key string, limit ratelimit.Limit) bool {
+	if limit.Unlimited() {
+		return true
+	}
+
+	result, err := app.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		/* Fails open, an unreachable Redis shouldn't take the API down with it */
+		app.logError(r, err)
+		return true
+	}
+
+	remaining, err := strconv.Atoi(w.Header().Get("X-RateLimit-Remaining"))
+	if err != nil || result.Remaining <= remaining || !result.Allowed {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	}
+
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+		app.rateLimitExceededResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+/* The cause of the context requestTimeout cancels, see serverErrorResponse */
+var errRequestTimeout = errors.New("request timeout")
+
+/* The route's own timeout or -request-timeout, 0 for none */
+func (app *application) routeTimeout(rt route) time.Duration {
+	switch {
+	case rt.timeout == noTimeout:
+		return 0
+	case rt.timeout > 0:
+		return rt.timeout
+	default:
+		return app.config.requestTimeout
+	}
+}
+
+/*
+Gives the handler timeout to answer. Its context is cancelled then, which
+cancels the queries it is waiting for; serverErrorResponse turns the errors
+they return into a 504. A handler that finishes late without having answered
+gets the 504 too. The handler runs in the request's goroutine, so it isn't
+interrupted by anything but its context.
+*/
+func (app *application) requestTimeout(timeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeoutCause(r.Context(), timeout, errRequestTimeout)
+		defer cancel()
+
+		tw := &timeoutResponseWriter{ResponseWriter: w}
+		next(tw, r.WithContext(ctx))
+
+		if !tw.wroteHeader && errors.Is(context.Cause(ctx), errRequestTimeout) {
+			app.requestTimeoutResponse(w, r)
+		}
+	}
+}
+
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(statusCode int) {
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (tw *timeoutResponseWriter) Write(b []byte) (int, error) {
+	tw.wroteHeader = true
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		/* INFO: A deferred function will always be run in the event
@@ -94,51 +278,111 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		Only works in the same goroutine that executed the recoverPanic() middleware */
 		defer func() {
 			if err := recover(); err != nil {
+				/* Deliberate aborts (e.g. a failed stream) are left to net/http */
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				/* INFO: Tells the client that the connection is closed.
 				   Works with HTTP/2 as well. */
 				w.Header().Set("Connection", "close")
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				app.serverErrorResponse(w, r, panicError{err})
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) authenticate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		/* Tells the caches that this kv pair may vary */
-		w.Header().Add("Vary", "Authorization")
+/*
+Scopes the request to its tenant, which the models take from the context: the
+subdomain of -tenant-domain or the X-Tenant header, by -tenancy. A request not
+naming one is the default tenant's, naming an unknown one is a 404. Runs before
+authenticate, tokens and API keys are only valid with their user's tenant.
+*/
+func (app *application) resolveTenant(next http.Handler) http.Handler {
+	if app.config.tenancy.mode == "none" {
+		return next
+	}
 
-		/* empty == "" */
-		authorizationHeader := r.Header.Get("Authorization")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var slug string
+
+		switch app.config.tenancy.mode {
+		case "subdomain":
+			slug = tenantSubdomain(r.Host, app.config.tenancy.domain)
+		case "header":
+			w.Header().Add("Vary", "X-Tenant")
+			slug = strings.ToLower(r.Header.Get("X-Tenant"))
+		}
 
-		/* Set anonymous user if header is empty */
-		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.AnonymousUser)
+		if slug == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		/* Expected format: "Bearer <token>" */
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 && headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
+		/* INFO: Tenants are never deleted nor renamed, only unknown slugs are looked up every time */
+		id, found := app.tenantIDs.Load(slug)
+		if !found {
+			tenant, err := app.models.Tenants.GetBySlug(r.Context(), slug)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.notFoundResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			id, _ = app.tenantIDs.LoadOrStore(slug, tenant.ID)
 		}
 
-		token := headerParts[1]
+		r = r.WithContext(data.WithTenant(r.Context(), id.(int64)))
 
-		v := validator.New()
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
+/* The label before domain in host, e.g. acme for acme.example.com; empty for domain itself or other hosts */
+func tenantSubdomain(host, domain string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	sub, found := strings.CutSuffix(host, "."+strings.ToLower(domain))
+	if !found {
+		return ""
+	}
+
+	return sub
+}
+
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		/* Tells the caches that this kv pair may vary */
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		var (
+			user *data.User
+			err  error
+		)
+
+		/* Machine clients send an API key instead, one or the other */
+		switch key := r.Header.Get("X-API-Key"); {
+		case key != "" && r.Header.Get("Authorization") != "":
+			err = errInvalidAuthenticationToken
+		case key != "":
+			user, err = app.userForAPIKey(r.Context(), key)
+		default:
+			user, err = app.userForAuthorizationHeader(r.Context(), r.Header.Get("Authorization"))
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
+			case errors.Is(err, errInvalidAuthenticationToken):
 				app.invalidAuthenticationTokenResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -148,10 +392,123 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		r = app.contextSetUser(r, user)
 
+		/* Shown in the session list, failing to record it doesn't fail the request */
+		if token := bearerToken(r); token != "" && !user.IsAnonymous() && !(app.jwtCodec != nil && jwt.LooksLikeJWT(token)) {
+			err = app.models.Tokens.Touch(r.Context(), token, app.clientIP(r), r.UserAgent())
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+/* The token of an "Authorization: Bearer <token>" header, empty without one */
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == r.Header.Get("Authorization") {
+		return ""
+	}
+	return token
+}
+
+var errInvalidAuthenticationToken = errors.New("invalid authentication token")
+
+/*
+Looks up the user for an "Authorization: Bearer <token>" header value, the
+anonymous user if it is empty. Shared by the HTTP and gRPC servers.
+*/
+func (app *application) userForAuthorizationHeader(ctx context.Context, authorizationHeader string) (*data.User, error) {
+	/* Set anonymous user if header is empty */
+	if authorizationHeader == "" {
+		return data.AnonymousUser, nil
+	}
+
+	/* Expected format: "Bearer <token>" */
+	headerParts := strings.Split(authorizationHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return nil, errInvalidAuthenticationToken
+	}
+
+	return app.userForToken(ctx, headerParts[1])
+}
+
+/* Looks up the user for an authentication token, or a JWT with -auth-mode=jwt */
+func (app *application) userForToken(ctx context.Context, token string) (*data.User, error) {
+	/* Opaque tokens issued before switching to JWTs keep working until they expire */
+	if app.jwtCodec != nil && jwt.LooksLikeJWT(token) {
+		return app.userForJWT(ctx, token)
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return nil, errInvalidAuthenticationToken
+	}
+
+	user, err := app.models.Users.GetForToken(ctx, data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errInvalidAuthenticationToken
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (app *application) userForAPIKey(ctx context.Context, key string) (*data.User, error) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, key); !v.Valid() {
+		return nil, errInvalidAuthenticationToken
+	}
+
+	user, err := app.models.APIKeys.GetUserForKey(ctx, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errInvalidAuthenticationToken
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+/*
+Falls back to an ?access_token= query parameter (RFC 6750 section 2.3) for
+clients that can't set the Authorization header, like EventSource and browser
+WebSockets. Only used on routes that need it, tokens in URLs end up in logs.
+*/
+func (app *application) authenticateQueryToken(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+
+		if token == "" || !app.contextGetUser(r).IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.userForToken(r.Context(), token)
+		if err != nil {
+			switch {
+			case errors.Is(err, errInvalidAuthenticationToken):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetUser(r, user))
+	})
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -190,16 +547,14 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		/* Get slices of permissions */
-		permissions, err := app.models.Permissions.GetAllForUser(int64(user.ID))
+		permitted, err := app.userHasPermission(r.Context(), user, code)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		/* Check if slice includes (contains) required permissions, otherwise */
-		/* return a 403 forbidden response */
-		if !permissions.Include(code) {
+		/* Return a 403 forbidden response if the user lacks the permission */
+		if !permitted {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -213,7 +568,49 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
-func (app *application) enableCORS(next http.Handler) http.Handler {
+/* API keys act with permissions only, never with a role */
+func (app *application) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.Scopes != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		has, err := app.models.Roles.UserHas(r.Context(), int64(user.ID), role)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !has {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedUser(fn)
+}
+
+func (app *application) userHasPermission(ctx context.Context, user *data.User, code string) (bool, error) {
+	/* Get slices of permissions */
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, int64(user.ID))
+	if err != nil {
+		return false, err
+	}
+
+	/* Check if slice includes (contains) required permissions */
+	return permissions.Include(code) && user.InScope(code), nil
+}
+
+/* The methods a preflight may be answered with, if the router has a route for them */
+var corsMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+/* The router is asked which methods the preflight's path has routes for */
+func (app *application) enableCORS(router *httprouter.Router, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		/* must be added if what we return depends on a header */
 		/* otherwise might be cause of subtle bugs */
@@ -226,14 +623,31 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		if origin != "" {
 			/* checks to see if the trusted origins contains origin, only then */
 			/* allow CORS */
-			if slices.Contains(app.config.cors.trustedOrigins, origin) {
+			if app.trustedOrigin(origin) {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
+				/* Scripts need the ETag to send it back in If-Match */
+				w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID")
+
+				if app.config.cors.allowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
 
 				/* Check if it's a preflight CORS request */
 				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					methods := []string{http.MethodOptions}
+					for _, method := range corsMethods {
+						if handle, _, _ := router.Lookup(method, r.URL.Path); handle != nil {
+							methods = append(methods, method)
+						}
+					}
+
 					/* Set necessary preflight response headers */
-					w.Header().Set("Access-Control-Allow-Method", "OPTIONS, PUT, PATCH, DELETE")
-					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+					w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, X-API-Key, X-Tenant")
+
+					if app.config.cors.maxAge > 0 {
+						w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.config.cors.maxAge.Seconds())))
+					}
 
 					/* Write the headers with a 200 OK status */
 					/* Instead of 204 No Content because we actualy don't have a body */
@@ -248,6 +662,34 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+/*
+Exact matches, or patterns like https://*.example.com matching any subdomain,
+but not example.com itself, with the same scheme and port.
+*/
+func (app *application) trustedOrigin(origin string) bool {
+	for _, trusted := range app.settings().trustedOrigins {
+		scheme, host, ok := strings.Cut(trusted, "://*.")
+		if !ok {
+			if origin == trusted {
+				return true
+			}
+			continue
+		}
+
+		subdomain, ok := strings.CutPrefix(origin, scheme+"://")
+		if !ok {
+			continue
+		}
+
+		subdomain, ok = strings.CutSuffix(subdomain, "."+host)
+		if ok && subdomain != "" && !strings.ContainsAny(subdomain, "/:@") {
+			return true
+		}
+	}
+
+	return false
+}
+
 /* Embeds http.ResponseWriter so we can record the http status codes */
 /* that are sent to the client. We record the status code and a bool to indicate */
 /* wether we have stored it or not */
@@ -255,6 +697,7 @@ type metricsResponseWriter struct {
 	http.ResponseWriter
 	statusCode    int
 	headerWritten bool
+	bytesWritten  int
 }
 
 func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
@@ -274,7 +717,10 @@ func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
 		mw.headerWritten = true
 	}
 
-	return mw.ResponseWriter.Write(b)
+	n, err := mw.ResponseWriter.Write(b)
+	mw.bytesWritten += n
+
+	return n, err
 }
 
 func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
@@ -287,17 +733,64 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponsesSent              = expvar.NewInt("total_responses_sent")
 		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_µs")
 		totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status")
+		totalClientErrors               = expvar.NewInt("total_client_errors")
+		totalServerErrors               = expvar.NewInt("total_server_errors")
+	)
+
+	/* Keyed by "<method> <route>", e.g. "GET /v1/movies/:id", to find the slow handlers */
+	var (
+		requestsByRoute       = expvar.NewMap("requests_by_route")
+		processingTimeByRoute = expvar.NewMap("processing_µs_by_route")
+		clientErrorsByRoute   = expvar.NewMap("client_errors_by_route")
+		serverErrorsByRoute   = expvar.NewMap("server_errors_by_route")
+	)
+
+	var (
+		requests = app.metricsRegistry.NewCounterVec("greenlight_http_requests_total",
+			"Number of HTTP requests served.", "method", "route", "status")
+		requestDuration = app.metricsRegistry.NewHistogramVec("greenlight_http_request_duration_seconds",
+			"Time taken to serve HTTP requests.", metrics.DefaultBuckets, "method", "route", "status")
+		requestsInFlight = app.metricsRegistry.NewGauge("greenlight_http_requests_in_flight",
+			"Number of HTTP requests being served.")
 	)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		totalRequestsReceived.Add(1)
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		/* A handler that writes nothing still sends a 200 */
+		mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
-		mw := &metricsResponseWriter{ResponseWriter: w}
+		r, route := contextWithRoute(r)
 
 		next.ServeHTTP(mw, r)
 
+		if *route == "" {
+			*route = unmatchedRoute
+		}
+
+		duration := time.Since(start)
+
+		status := strconv.Itoa(mw.statusCode)
+		requests.Inc(r.Method, *route, status)
+		requestDuration.Observe(duration.Seconds(), r.Method, *route, status)
+
+		routeKey := r.Method + " " + *route
+		requestsByRoute.Add(routeKey, 1)
+		processingTimeByRoute.Add(routeKey, duration.Microseconds())
+
+		switch {
+		case mw.statusCode >= 500:
+			totalServerErrors.Add(1)
+			serverErrorsByRoute.Add(routeKey, 1)
+		case mw.statusCode >= 400:
+			totalClientErrors.Add(1)
+			clientErrorsByRoute.Add(routeKey, 1)
+		}
+
 		// On the way back up the middleware chain, increment the number of responses
 		// sent by 1.
 		totalResponsesSent.Add(1)
@@ -306,7 +799,6 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		// so we need to change the code into a string
 		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
 
-		dur := time.Since(start).Milliseconds()
-		totalProcessingTimeMicroseconds.Add(dur)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
 	})
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/migrations"
+)
+
+/* Applying every migration to an empty database takes a while */
+const migrateTimeout = 5 * time.Minute
+
+/*
+Runs "migrate up", "migrate down [N]" (1 by default) or "migrate version",
+the arguments after the flags, e.g. "api -db-dsn=... migrate up".
+*/
+func migrateCommand(db *sql.DB, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: migrate up|down [N]|version")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("applied %d migrations", applied), nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return "", fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+
+		reverted, err := migrations.Down(ctx, db, steps)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("reverted %d migrations", reverted), nil
+
+	case "version":
+		version, dirty, err := migrations.Version(ctx, db)
+		if err != nil {
+			return "", err
+		}
+		if dirty {
+			return fmt.Sprintf("version %d (dirty)", version), nil
+		}
+		return fmt.Sprintf("version %d", version), nil
+
+	default:
+		return "", fmt.Errorf("unknown migrate command %q, must be up, down or version", args[0])
+	}
+}
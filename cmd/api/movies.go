@@ -4,8 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/jsonpatch"
 	"github.com/mohafarman/greenlight/internal/validator"
 )
 
@@ -16,32 +20,78 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	includeCredits := app.readIncludeCredits(r.URL.Query(), v)
+	fields := app.readFields(r.URL.Query(), "fields", movieFieldSafelist, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
-			return
-		} else {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(movie.Version))
+
+	/* The client's copy is current, the body would be the same. Credits don't change the version */
+	if match := r.Header.Get("If-None-Match"); match != "" && !includeCredits && etagListed(match, etag(movie.Version), true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if includeCredits {
+		err = app.loadCredits(r.Context(), movie)
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	env, err := app.selectMovieFields(r, envelope{"movie": movie}, fields)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
+
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+type createMovieInput struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
 }
 
+/*
+Expected data from the user
+Using pointers so that if user does not include anything
+it will deafult to nil
+*/
+type updateMovieInput struct {
+	Title   *string       `json:"title"`
+	Year    *int32        `json:"year"`
+	Runtime *data.Runtime `json:"runtime"`
+	Genres  []string      `json:"genres"`
+}
+
+/* Supported values for sort safelist, relevance ranks by the title search */
+var movieSortSafelist = []string{"id", "title", "year", "runtime", "relevance", "-id", "-title", "-year", "-runtime"}
+
+/* The keys of a movie that ?fields= can pick, see selectMovieFields */
+var movieFieldSafelist = []string{"id", "title", "year", "runtime", "genres", "average_rating", "poster_url", "credits", "deleted_at", "version"}
+
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
-	}
+	var input createMovieInput
 
-	err := app.readJSON(w, r, &input)
+	err := app.readBody(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -60,24 +110,34 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.modelErrorResponse(w, r, err)
 		return
 	}
 
+	app.publishEvent(r.Context(), events.MovieCreated, movie)
+	app.recordChange(r, nil, movie)
+
+	if app.enricher != nil && app.config.enrich.onCreate {
+		app.enrichCreatedMovie(r.Context(), *movie)
+	}
+
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", fmt.Sprintf("/v%d/movies/%d", apiVersion(r), movie.ID))
+	headers.Set("ETag", etag(movie.Version))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, app.withWarnings(r, envelope{"movie": movie}, v.Warnings), headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
-
-	fmt.Fprintf(w, "%+v\n", input)
 }
 
+/*
+A client that sends If-Match: * and a merge body, rather than a JSON Patch, only
+means to set the fields it sends: when another update gets in between, they are
+set on the movie as that left it, up to -edit-conflict-retries more times.
+*/
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -85,34 +145,75 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
-			return
-		} else {
-			app.serverErrorResponse(w, r, err)
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.checkIfMatch(w, r, movie.Version) {
+		return
+	}
+
+	/* Fields are replaced rather than modified, so a shallow copy keeps the old values */
+	before := *movie
+
+	var input updateMovieInput
+
+	merge := !isJSONPatch(r)
+	if merge {
+		err = app.readBody(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
 			return
 		}
+	} else if !app.applyMoviePatch(w, r, movie) {
+		return
 	}
 
-	/* Expected data from the user */
-	/* Using pointers so that if user does not include anything */
-	/* it will deafult to nil */
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	/* Those of the last attempt, the one that was saved */
+	var warnings map[string][]string
+
+	mutate := func(movie *data.Movie) error {
+		if merge {
+			input.apply(movie)
+		}
+
+		v := validator.New()
+		data.ValidateMovie(v, movie)
+		warnings = v.Warnings
+		return v.Err()
 	}
 
-	err = app.readJSON(w, r, &input)
+	editorID := int64(app.contextGetUser(r).ID)
+
+	if merge && strings.TrimSpace(r.Header.Get("If-Match")) == "*" {
+		movie, err = app.models.Movies.UpdateWithRetry(r.Context(), id, editorID, app.config.editConflictRetries+1, func(movie *data.Movie) error {
+			before = *movie
+			return mutate(movie)
+		})
+	} else if err = mutate(movie); err == nil {
+		err = app.models.Movies.Update(r.Context(), movie, editorID)
+	}
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.modelErrorResponse(w, r, err)
 		return
 	}
 
-	/* Handles partial updates by checking for nil, i.e. no input */
+	app.publishEvent(r.Context(), events.MovieUpdated, movie)
+	app.recordChange(r, &before, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, app.withWarnings(r, envelope{"movie": movie}, warnings), headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Handles partial updates by checking for nil, i.e. no input */
+func (input updateMovieInput) apply(movie *data.Movie) {
 	if input.Title != nil {
 		movie.Title = *input.Title
 	}
@@ -128,28 +229,62 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Genres != nil {
 		movie.Genres = input.Genres
 	}
+}
 
-	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
+/*
+The members of a movie a JSON Patch applies to: the editable ones along with
+the read-only id and version. The rest, such as average_rating, poster_url and
+the enrichment, are left out so a patch neither sees nor trips over them.
+*/
+type moviePatchDocument struct {
+	ID      int64        `json:"id"`
+	Title   string       `json:"title"`
+	Year    int32        `json:"year,omitempty"`
+	Runtime data.Runtime `json:"runtime,omitempty"`
+	Genres  []string     `json:"genres,omitempty"`
+	Version int32        `json:"version"`
+}
+
+/*
+Applies a JSON Patch body to the editable members of movie, see
+moviePatchDocument. Removed members become zero values so the usual validation
+rejects them; id and version are read-only. Returns false if an error response
+has already been sent.
+*/
+func (app *application) applyMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	current := moviePatchDocument{
+		ID:      movie.ID,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		Version: movie.Version,
 	}
 
-	err = app.models.Movies.Update(movie)
+	var patched moviePatchDocument
+
+	err := app.readJSONPatch(w, r, current, &patched)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.patchTestFailedResponse(w, r, err)
 		default:
-			app.serverErrorResponse(w, r, err)
+			app.badRequestResponse(w, r, err)
 		}
-		return
+		return false
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if patched.ID != movie.ID || patched.Version != movie.Version {
+		app.badRequestResponse(w, r, errors.New("body must not modify id or version"))
+		return false
 	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return true
 }
 
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -159,35 +294,61 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
-			return
-		} else {
-			app.serverErrorResponse(w, r, err)
-			return
-		}
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.checkIfMatch(w, r, movie.Version) {
+		return
 	}
 
+	err = app.models.Movies.Delete(r.Context(), movie.ID, movie.Version)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	app.publishEvent(r.Context(), events.MovieDeleted, envelope{"id": id})
+	app.recordChange(r, movie, nil)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+/* The search parameters of GET /v1/movies and the export */
+func (app *application) readMovieSearch(qs url.Values, v *validator.Validator) data.MovieSearch {
+	return data.MovieSearch{
+		Title:         app.readString(qs, "title", ""),
+		Genres:        app.readCSV(qs, "genres", []string{}),
+		GenresAny:     app.readCSV(qs, "genres_any", []string{}),
+		Director:      app.readString(qs, "director", ""),
+		Actor:         app.readString(qs, "actor", ""),
+		YearGTE:       app.readOptionalInt(qs, "year_gte", v),
+		YearLTE:       app.readOptionalInt(qs, "year_lte", v),
+		RuntimeGTE:    app.readOptionalInt(qs, "runtime_gte", v),
+		RuntimeLTE:    app.readOptionalInt(qs, "runtime_lte", v),
+		CreatedAfter:  app.readTime(qs, "created_after", v),
+		CreatedBefore: app.readTime(qs, "created_before", v),
+	}
+}
+
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieSearch
 		data.Filters
 	}
 
 	v := validator.New()
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieSearch = app.readMovieSearch(qs, v)
+
+	includeCredits := app.readIncludeCredits(qs, v)
+	fields := app.readFields(qs, "fields", movieFieldSafelist, v)
 
 	input.Page = app.readInt(qs, "page", 1, v)
 	input.PageSize = app.readInt(qs, "page_size", 20, v)
@@ -195,22 +356,113 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	/* Defaults to ascending sort based on ID */
 	input.Sort = app.readString(qs, "sort", "id")
 
-	/* Supported values for sort safelist */
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = movieSortSafelist
+
+	/* ?cursor= switches to keyset pagination, an empty cursor starts at the first page */
+	if qs.Has("cursor") {
+		cursor, err := data.DecodeCursor(qs.Get("cursor"))
+		if err != nil {
+			v.AddError("cursor", validator.Message("validation.cursor"))
+		}
+		input.Filters.Cursor = cursor
+
+		/* A rank is no stable position to continue from */
+		v.CheckField(input.Sort != "relevance", "cursor", validator.Message("validation.not_with", "sort=relevance"))
+	}
+
+	/* Streamed responses are never held in memory so they can be much larger */
+	stream := app.readBool(qs, "stream", false, v)
+	if stream {
+		input.Filters.MaxPageSize = 5_000
+
+		/* Credits are loaded for a whole page at once, which a stream doesn't have */
+		v.CheckField(!includeCredits, "include", validator.Message("validation.not_with", "stream"))
+	}
+
+	data.ValidateMovieSearch(v, input.MovieSearch)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	if stream {
+		app.streamMovies(w, r, input.MovieSearch, input.Filters, fields)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.MovieSearch, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if includeCredits {
+		err = app.loadCredits(r.Context(), movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env, err := app.selectMovieFields(r, envelope{"metadata": metadata, "movies": movies}, fields)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+/* Writes the movies straight from the database cursor to the client as JSON, reduced to fields if any */
+func (app *application) streamMovies(w http.ResponseWriter, r *http.Request, search data.MovieSearch, filters data.Filters, fields []string) {
+	var stream *jsonArrayStream
+
+	format := runtimeFormat(r)
+
+	metadata, err := app.models.Movies.Stream(r.Context(), search, filters, func(movie *data.Movie) error {
+		/* Delay the headers until the first row so query errors still get a proper 500 */
+		if stream == nil {
+			var err error
+			stream, err = app.startJSONArrayStream(w, http.StatusOK, "movies", nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(fields) > 0 {
+			p, err := selectFields(withRuntime(movie, format), fields)
+			if err != nil {
+				return err
+			}
+			return stream.Write(p)
+		}
+
+		return stream.Write(withRuntime(movie, format))
+	})
+
+	switch {
+	case err != nil && stream == nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	case err != nil:
+		/* Too late for an error response, abort so the client sees a broken body */
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	case stream == nil:
+		stream, err = app.startJSONArrayStream(w, http.StatusOK, "movies", nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = stream.Close(envelope{"metadata": metadata})
+	if err != nil {
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/* A movie with everything a patch can't change set: a rating, a poster and the enrichment */
+func patchTestMovie() *data.Movie {
+	rating, imdbRating := 4.2, 7.9
+	enrichedAt := time.Now()
+
+	return &data.Movie{
+		ID:            7,
+		Title:         "Moana",
+		Year:          2016,
+		Runtime:       107,
+		Genres:        []string{"animation", "adventure"},
+		AverageRating: &rating,
+		PosterURL:     "https://example.com/posters/7.jpg",
+		Plot:          "A girl sails across the ocean.",
+		IMDbID:        "tt3521164",
+		IMDbRating:    &imdbRating,
+		Cast:          []string{"Auliʻi Cravalho", "Dwayne Johnson"},
+		EnrichedAt:    &enrichedAt,
+		Version:       3,
+	}
+}
+
+func TestApplyMoviePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		status  int
+		title   string
+		year    int32
+		genres  []string
+		runtime data.Runtime
+	}{
+		{"replace title", `[{"op":"replace","path":"/title","value":"Moana 2"}]`, 0, "Moana 2", 2016, []string{"animation", "adventure"}, 107},
+		{"add genre", `[{"op":"add","path":"/genres/-","value":"musical"}]`, 0, "Moana", 2016, []string{"animation", "adventure", "musical"}, 107},
+		{"test and replace", `[{"op":"test","path":"/year","value":2016},{"op":"replace","path":"/runtime","value":"1h 47m"},{"op":"replace","path":"/year","value":2017}]`, 0, "Moana", 2017, []string{"animation", "adventure"}, 107},
+		{"remove year", `[{"op":"remove","path":"/year"}]`, 0, "Moana", 0, []string{"animation", "adventure"}, 107},
+		{"failed test", `[{"op":"test","path":"/title","value":"Frozen"}]`, http.StatusConflict, "", 0, nil, 0},
+		{"read-only id", `[{"op":"replace","path":"/id","value":8}]`, http.StatusBadRequest, "", 0, nil, 0},
+		{"read-only version", `[{"op":"replace","path":"/version","value":9}]`, http.StatusBadRequest, "", 0, nil, 0},
+		{"not editable", `[{"op":"replace","path":"/average_rating","value":5}]`, http.StatusBadRequest, "", 0, nil, 0},
+		{"unknown member", `[{"op":"add","path":"/poster_url","value":"https://example.com/x.jpg"}]`, http.StatusBadRequest, "", 0, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{config: config{maxBodyBytes: 1 << 20}}
+			movie := patchTestMovie()
+
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/7", strings.NewReader(tt.patch))
+			r.Header.Set("Content-Type", "application/json-patch+json")
+			rr := httptest.NewRecorder()
+
+			ok := app.applyMoviePatch(rr, r, movie)
+
+			if tt.status != 0 {
+				if ok || rr.Code != tt.status {
+					t.Fatalf("got ok %t, status %d; want an error response with %d", ok, rr.Code, tt.status)
+				}
+				return
+			}
+
+			if !ok {
+				t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+			}
+			if movie.Title != tt.title || movie.Year != tt.year || movie.Runtime != tt.runtime || !slices.Equal(movie.Genres, tt.genres) {
+				t.Errorf("got %q %d %d %v; want %q %d %d %v", movie.Title, movie.Year, movie.Runtime, movie.Genres, tt.title, tt.year, tt.runtime, tt.genres)
+			}
+
+			/* The members outside the patch are untouched */
+			want := patchTestMovie()
+			if *movie.AverageRating != *want.AverageRating || movie.PosterURL != want.PosterURL || movie.IMDbID != want.IMDbID || len(movie.Cast) != 2 || movie.EnrichedAt == nil || movie.Version != want.Version {
+				t.Errorf("got %+v; want the rating, poster and enrichment kept", movie)
+			}
+		})
+	}
+}
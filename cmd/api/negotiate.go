@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+Picks the offer the client prefers most according to its Accept header, honouring
+q-values and wildcards. Offers are listed in server preference order, which breaks
+ties and is used when the header is missing. Returns "" if nothing is acceptable.
+*/
+func negotiateContentType(r *http.Request, offers ...string) string {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return offers[0]
+	}
+
+	best, bestQ, bestSpecificity := "", 0.0, -1
+
+	for _, part := range strings.Split(header, ",") {
+		mediaRange, q := parseMediaRange(part)
+		if q <= 0 {
+			continue
+		}
+
+		for _, offer := range offers {
+			specificity := matchMediaRange(mediaRange, offer)
+			if specificity < 0 {
+				continue
+			}
+
+			if q > bestQ || (q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = offer, q, specificity
+			}
+		}
+	}
+
+	return best
+}
+
+/* Splits "application/xml;q=0.9" into its media range and quality */
+func parseMediaRange(s string) (string, float64) {
+	params := strings.Split(s, ";")
+	mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+
+	for _, param := range params[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, "q") {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return mediaRange, 0
+			}
+			q = f
+		}
+	}
+
+	return mediaRange, q
+}
+
+/* Returns how specifically mediaRange matches offer: 2 exactly, 1 by a subtype wildcard such as text/*, 0 by the full wildcard, or -1 */
+func matchMediaRange(mediaRange, offer string) int {
+	switch {
+	case mediaRange == offer:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
+
+/* Returns the value of the first media type parameter called name in the Accept header */
+func acceptParam(r *http.Request, name string) string {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		for _, param := range strings.Split(part, ";")[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, name) {
+				return strings.Trim(value, `"`)
+			}
+		}
+	}
+
+	return ""
+}
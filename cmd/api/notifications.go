@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/notify"
+	"github.com/mohafarman/greenlight/internal/validator"
+	"github.com/mohafarman/greenlight/internal/websocket"
+)
+
+const (
+	/* Time a connection without ?access_token= has to send its authenticate message */
+	notificationAuthTimeout = 10 * time.Second
+	/* Notifications queued per connection before it is considered too slow and dropped */
+	notificationBuffer = 64
+)
+
+type authenticateMessage struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+type broadcastInput struct {
+	Message string `json:"message"`
+}
+
+/*
+Sends the user's notifications as WebSocket text messages, each a JSON encoded
+notify.Notification. A connection without ?access_token= is authenticated by
+its first message, {"type": "authenticate", "token": "..."}, and closed with
+1008 if that fails.
+*/
+func (app *application) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	if !user.IsAnonymous() && !user.Activated {
+		app.inactiveAccountResponse(w, r)
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, websocket.ErrBadHandshake):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	/* INFO: Shutdown() doesn't wait for hijacked connections, so let shutdown wait until the close frame is sent */
+	app.wg.Add(1)
+	defer app.wg.Done()
+
+	if user.IsAnonymous() {
+		user, err = app.authenticateWebSocket(conn, data.TenantID(r.Context()))
+		if err != nil {
+			conn.Close(websocket.ClosePolicyViolation, err.Error())
+			return
+		}
+	}
+
+	queue := make(chan notify.Notification, notificationBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+
+	unregister := app.notifications.Register(data.TenantID(r.Context()), int64(user.ID), func(notification notify.Notification) {
+		/* Never block the notifying goroutine on a slow client */
+		select {
+		case queue <- notification:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	defer unregister()
+
+	ticker := time.NewTicker(eventStreamHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-conn.Done():
+			return
+		case <-app.notifications.Done():
+			conn.Close(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-overflow:
+			conn.Close(websocket.CloseTryAgainLater, "client too slow")
+			return
+		case <-ticker.C:
+			err = conn.Ping()
+		case notification := <-queue:
+			payload, marshalErr := json.Marshal(notification)
+			if marshalErr != nil {
+				app.logger.Error(marshalErr.Error(), "notification_type", notification.Type)
+				continue
+			}
+
+			err = conn.WriteText(payload)
+		}
+
+		if err != nil {
+			conn.Close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+var (
+	errAuthenticationTimeout = errors.New("authentication required")
+	errAuthenticationMessage = errors.New(`first message must be {"type": "authenticate", "token": "..."}`)
+	errInactiveAccount       = errors.New("your user account must be activated")
+)
+
+/*
+Waits for the authenticate message and returns its user, of the tenant the
+connection was opened with; the error is sent as the close reason.
+*/
+func (app *application) authenticateWebSocket(conn *websocket.Conn, tenant int64) (*data.User, error) {
+	var message []byte
+
+	select {
+	case message = <-conn.Messages():
+		if message == nil {
+			return nil, errAuthenticationTimeout
+		}
+	case <-time.After(notificationAuthTimeout):
+		return nil, errAuthenticationTimeout
+	case <-app.notifications.Done():
+		return nil, errAuthenticationTimeout
+	}
+
+	var input authenticateMessage
+
+	err := json.Unmarshal(message, &input)
+	if err != nil || input.Type != "authenticate" {
+		return nil, errAuthenticationMessage
+	}
+
+	ctx, cancel := context.WithTimeout(data.WithTenant(context.Background(), tenant), 3*time.Second)
+	defer cancel()
+
+	user, err := app.userForToken(ctx, input.Token)
+	if err != nil {
+		if !errors.Is(err, errInvalidAuthenticationToken) {
+			app.logger.Error(err.Error())
+		}
+		return nil, errInvalidAuthenticationToken
+	}
+
+	if !user.Activated {
+		return nil, errInactiveAccount
+	}
+
+	return user, nil
+}
+
+/* Sends an admin.broadcast notification with the message to every connected user of the admin's tenant */
+func (app *application) broadcastHandler(w http.ResponseWriter, r *http.Request) {
+	var input broadcastInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.CheckField(validator.NotBlank(input.Message), "message", validator.Message("validation.required"))
+	v.CheckField(validator.MaxChars(input.Message, 500), "message", validator.Message("validation.max_chars", 500))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	connections := app.notifications.Broadcast(data.TenantID(r.Context()), notify.AdminBroadcast, envelope{"message": input.Message})
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"connections": connections}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+Notifies the connected users who have the movie of a movie.updated or
+movie.deleted event on their watchlist. The lookup is a job, events are
+published from the request that made the change.
+*/
+func (app *application) notifyWatchlists(event events.Event) {
+	var (
+		notificationType string
+		movieID          int64
+	)
+
+	switch event.Type {
+	case events.MovieUpdated:
+		movie, ok := event.Data.(*data.Movie)
+		if !ok {
+			return
+		}
+		notificationType, movieID = notify.WatchlistMovieUpdated, movie.ID
+	case events.MovieDeleted:
+		deleted, ok := event.Data.(envelope)
+		if !ok {
+			return
+		}
+		id, ok := deleted["id"].(int64)
+		if !ok {
+			return
+		}
+		notificationType, movieID = notify.WatchlistMovieDeleted, id
+	default:
+		return
+	}
+
+	users := app.notifications.Users()
+	if len(users) == 0 {
+		return
+	}
+
+	err := app.jobs.Enqueue("watchlist notifications", func(ctx context.Context) error {
+		watching, err := app.models.Watchlists.UsersWatching(ctx, movieID, users)
+		if err != nil {
+			return err
+		}
+
+		for _, userID := range watching {
+			app.notifications.Notify(userID, notificationType, event.Data)
+		}
+
+		return nil
+	})
+	if err != nil {
+		app.logger.Error(err.Error(), "job", "watchlist notifications")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/oauth"
+)
+
+/* Holds the state and PKCE verifier between the redirect to the provider and the callback */
+const oauthCookie = "oauth_state"
+
+/* The providers with a client ID, the others 404 */
+func openOAuth(cfg config) map[string]*oauth.Provider {
+	providers := map[string]*oauth.Provider{}
+
+	callback := func(name string) string {
+		return strings.TrimSuffix(cfg.oauth.redirectBaseURL, "/") + "/v1/auth/" + name + "/callback"
+	}
+
+	if cfg.oauth.google.clientID != "" {
+		providers["google"] = oauth.Google(cfg.oauth.google.clientID, cfg.oauth.google.clientSecret, callback("google"))
+	}
+
+	if cfg.oauth.github.clientID != "" {
+		providers["github"] = oauth.GitHub(cfg.oauth.github.clientID, cfg.oauth.github.clientSecret, callback("github"))
+	}
+
+	return providers
+}
+
+func (app *application) oauthProvider(r *http.Request) (*oauth.Provider, bool) {
+	provider, ok := app.oauthProviders[httprouter.ParamsFromContext(r.Context()).ByName("provider")]
+	return provider, ok
+}
+
+/* Redirects the browser to the provider's sign in page */
+func (app *application) oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauthProvider(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	state, err := oauth.RandomString()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	verifier, err := oauth.RandomString()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	/* Lax, the callback is a top-level navigation from the provider's site */
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    state + "." + verifier,
+		Path:     "/v1/auth/" + provider.Name,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, verifier), http.StatusFound)
+}
+
+/*
+Where the provider sends the browser back. The account is signed in as the user
+it is linked to, or linked to the user with its verified email, or a new user
+is created for it. Either way the response is the one of a password login.
+*/
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauthProvider(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	/* Single use, whatever the outcome */
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Path:     "/v1/auth/" + provider.Name,
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+
+	/* e.g. access_denied when the user cancelled */
+	if query.Get("error") != "" {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthCookie)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("missing OAuth state cookie, sign in again"))
+		return
+	}
+
+	state, verifier, _ := strings.Cut(cookie.Value, ".")
+	if state == "" || query.Get("state") != state {
+		app.badRequestResponse(w, r, errors.New("OAuth state mismatch, sign in again"))
+		return
+	}
+
+	if query.Get("code") == "" {
+		app.badRequestResponse(w, r, errors.New("missing OAuth authorization code"))
+		return
+	}
+
+	accessToken, err := provider.Exchange(r.Context(), query.Get("code"), verifier)
+	if err != nil {
+		app.logError(r, err)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	identity, err := provider.Identity(r.Context(), accessToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrNoVerifiedEmail):
+			app.badRequestResponse(w, r, errors.New("the account has no verified email address"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.userForIdentity(r.Context(), provider.Name, identity)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	/* The code can't be sent through the provider, such users sign in with their password */
+	err = app.checkSecondFactor(r.Context(), int64(user.ID), "", "")
+	if err != nil {
+		switch {
+		case errors.Is(err, errTwoFactorRequired):
+			app.twoFactorRequiredResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeLoginTokens(w, r, user)
+}
+
+/* Finds, links or provisions the user of an account at provider */
+func (app *application) userForIdentity(ctx context.Context, provider string, identity *oauth.Identity) (*data.User, error) {
+	userID, err := app.models.Identities.GetUserID(ctx, provider, identity.Subject)
+	switch {
+	case err == nil:
+		return app.models.Users.Get(ctx, userID)
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	var user *data.User
+
+	/* The user, their role and the identity are written together, a failure leaves no half-provisioned user */
+	err = app.models.WithTx(ctx, func(tx data.Models) error {
+		user, err = app.linkIdentity(ctx, tx, provider, identity)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+/* Links the identity to the user with its email, provisioning one if there is none */
+func (app *application) linkIdentity(ctx context.Context, models data.Models, provider string, identity *oauth.Identity) (*data.User, error) {
+	user, err := models.Users.GetByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		/*
+			Nobody proved they own the email of an inactive user, whoever registered
+			it may not be the account holder: their password is replaced.
+		*/
+		if !user.Activated {
+			err = app.setRandomPassword(user)
+			if err != nil {
+				return nil, err
+			}
+
+			user.Activated = true
+
+			err = models.Users.Update(ctx, user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.provisionUser(ctx, models, identity)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = models.Identities.Insert(ctx, &data.Identity{
+		Provider: provider,
+		Subject:  identity.Subject,
+		UserID:   int64(user.ID),
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+/* An activated user, the provider verified the email; a password can be set with a reset */
+func (app *application) provisionUser(ctx context.Context, models data.Models, identity *oauth.Identity) (*data.User, error) {
+	user := &data.User{
+		Name:      identity.Name,
+		Email:     identity.Email,
+		Activated: true,
+	}
+
+	if user.Name == "" {
+		user.Name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	err := app.setRandomPassword(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = models.Users.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = models.Roles.AddForUser(ctx, int64(user.ID), data.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+/* A password nobody knows, for users that sign in through a provider */
+func (app *application) setRandomPassword(user *data.User) error {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+
+	return user.Password.Set(base64.RawURLEncoding.EncodeToString(b))
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/jsonpatch"
+	"github.com/mohafarman/greenlight/internal/openapi"
+)
+
+//go:embed swagger.html
+var swaggerHTML []byte
+
+var runtimeFormatParameter = &openapi.Parameter{
+	Name: "runtime_format", In: "query",
+	Description: "Write runtimes as ISO 8601 durations (\"PT1H47M\"), minutes (107) or hours and minutes (\"1h 47m\") instead of \"107 mins\", minutes is the default of /v2",
+	Schema:      &openapi.Schema{Type: "string", Enum: []any{"iso8601", "minutes", "human"}},
+}
+
+var includeParameter = &openapi.Parameter{
+	Name: "include", In: "query",
+	Description: "Embed related resources in each movie",
+	Schema:      &openapi.Schema{Type: "string", Enum: []any{"credits"}},
+}
+
+var fieldsParameter = &openapi.Parameter{
+	Name: "fields", In: "query",
+	Description: "Comma separated keys to return of each movie instead of all of them, e.g. \"id,title,year\": " + strings.Join(movieFieldSafelist, ", "),
+	Schema:      &openapi.Schema{Type: "string"},
+}
+
+var oauthCodeParameter = &openapi.Parameter{
+	Name: "code", In: "query", Required: true,
+	Description: "The authorization code from the provider",
+	Schema:      &openapi.Schema{Type: "string"},
+}
+
+var oauthStateParameter = &openapi.Parameter{
+	Name: "state", In: "query", Required: true,
+	Description: "Must match the state of the redirect to the provider, kept in the oauth_state cookie",
+	Schema:      &openapi.Schema{Type: "string"},
+}
+
+var ifMatchParameter = &openapi.Parameter{
+	Name: "If-Match", In: "header", Required: true,
+	Description: "The movie's ETag, as returned by GET /v1/movies/{id}",
+	Schema:      &openapi.Schema{Type: "string", Example: `"1"`},
+}
+
+var eventStreamParameters = []*openapi.Parameter{
+	{Name: "types", In: "query", Description: "Comma separated event types, all catalogue events by default", Schema: &openapi.Schema{Type: "string"}},
+	{Name: "Last-Event-ID", In: "header", Description: "Resume after this event, sent by EventSource when it reconnects", Schema: &openapi.Schema{Type: "string"}},
+	{Name: "last_event_id", In: "query", Description: "Resume after this event, for the first connection", Schema: &openapi.Schema{Type: "string"}},
+	{Name: "access_token", In: "query", Description: "For clients that can't set the Authorization header", Schema: &openapi.Schema{Type: "string"}},
+}
+
+func movieListParameters() []*openapi.Parameter {
+	sortValues := make([]any, len(movieSortSafelist))
+	for i, value := range movieSortSafelist {
+		sortValues[i] = value
+	}
+
+	return append(movieSearchParameters(), []*openapi.Parameter{
+		{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+		{Name: "cursor", In: "query", Description: "Keyset pagination instead of pages: empty for the first page, then the previous page's next_cursor", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+		{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order. relevance ranks by the title search", Schema: &openapi.Schema{Type: "string", Enum: sortValues}},
+		{Name: "stream", In: "query", Description: "Stream the rows as they are read, allowing page sizes up to 5000", Schema: &openapi.Schema{Type: "boolean"}},
+		includeParameter,
+		fieldsParameter,
+		runtimeFormatParameter,
+	}...)
+}
+
+/* See readMovieSearch */
+func movieSearchParameters() []*openapi.Parameter {
+	return []*openapi.Parameter{
+		{Name: "title", In: "query", Description: "Full-text search on the title, matching word prefixes and stems", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "genres", In: "query", Description: "Comma separated genres the movie must all have", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "genres_any", In: "query", Description: "Comma separated genres the movie must have at least one of", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "director", In: "query", Description: "Name of a director of the movie, case-insensitive", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "actor", In: "query", Description: "Name of an actor in the movie, case-insensitive", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "year_gte", In: "query", Description: "Earliest year, inclusive", Schema: &openapi.Schema{Type: "integer", Example: 1990}},
+		{Name: "year_lte", In: "query", Description: "Latest year, inclusive", Schema: &openapi.Schema{Type: "integer", Example: 1999}},
+		{Name: "runtime_gte", In: "query", Description: "Shortest runtime in minutes, inclusive", Schema: &openapi.Schema{Type: "integer"}},
+		{Name: "runtime_lte", In: "query", Description: "Longest runtime in minutes, inclusive", Schema: &openapi.Schema{Type: "integer", Example: 120}},
+		{Name: "created_after", In: "query", Description: "Movies added after this RFC 3339 timestamp or date", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+		{Name: "created_before", In: "query", Description: "Movies added before this RFC 3339 timestamp or date", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	}
+}
+
+/* Builds the document once, when the routes are registered, and serves it as is */
+func (app *application) openAPIHandler(routes []route) http.HandlerFunc {
+	js, err := json.Marshal(app.openAPIDocument(routes))
+	if err != nil {
+		/* Logical error in our codebase */
+		panic(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
+
+func (app *application) swaggerUIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(swaggerHTML)
+}
+
+func (app *application) openAPIDocument(routes []route) *openapi.Document {
+	gen := openapi.NewGenerator()
+
+	gen.Override(reflect.TypeFor[data.Runtime](), &openapi.Schema{
+		Description: "Runtime in minutes. Accepts 107, \"107 mins\", \"1h 47m\" or \"PT1H47M\"; written as \"107 mins\" unless runtime_format says otherwise",
+		OneOf:       []*openapi.Schema{{Type: "integer", Format: "int32"}, {Type: "string"}},
+		Example:     "107 mins",
+	})
+
+	doc := &openapi.Document{
+		OpenAPI: "3.0.3",
+		Info: openapi.Info{
+			Title:       "Greenlight API",
+			Description: "A JSON API for retrieving and managing information about movies.",
+			Version:     version,
+		},
+		Paths: make(map[string]*openapi.PathItem),
+		Components: openapi.Components{
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"bearerAuth": {
+					Type: "http", Scheme: "bearer",
+					Description: "Authentication token from POST /v1/tokens/authentication",
+				},
+				"apiKeyAuth": {
+					Type: "apiKey", In: "header", Name: "X-API-Key",
+					Description: "API key from POST /v1/me/api-keys, limited to its scopes",
+				},
+			},
+		},
+	}
+
+	errorSchema := app.openAPIErrorSchema(gen)
+
+	for _, rt := range routes {
+		path, params := openAPIPath(rt.path)
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = &openapi.PathItem{}
+		}
+
+		(*doc.Paths[path])[strings.ToLower(rt.method)] = app.openAPIOperation(gen, rt, params, errorSchema)
+	}
+
+	doc.Components.Schemas = gen.Schemas()
+
+	return doc
+}
+
+func (app *application) openAPIOperation(gen *openapi.Generator, rt route, params []*openapi.Parameter, errorSchema *openapi.Schema) *openapi.Operation {
+	op := &openapi.Operation{
+		OperationID: rt.id,
+		Summary:     rt.summary,
+		/* The resource, e.g. "movies" for /v1/movies/:id */
+		Tags:       []string{strings.Split(rt.path, "/")[2]},
+		Parameters: append(params, rt.query...),
+		Responses:  make(map[string]*openapi.Response),
+	}
+
+	status := rt.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	content := openapi.JSONContent(gen.Schema(rt.response))
+	if rt.contentType != "" {
+		content = map[string]*openapi.MediaType{rt.contentType: {Schema: gen.Schema(rt.response)}}
+	}
+
+	op.Responses[strconv.Itoa(status)] = &openapi.Response{
+		Description: http.StatusText(status),
+		Content:     content,
+	}
+
+	statuses := []int{http.StatusInternalServerError}
+
+	if rt.request != nil {
+		body := gen.Schema(rt.request)
+
+		op.RequestBody = &openapi.RequestBody{
+			Required: true,
+			Content: map[string]*openapi.MediaType{
+				"application/json":                  {Schema: body},
+				"application/x-www-form-urlencoded": {Schema: body},
+				"multipart/form-data":               {Schema: body},
+			},
+		}
+
+		if rt.patch {
+			op.RequestBody.Content["application/json-patch+json"] = &openapi.MediaType{Schema: gen.Schema([]jsonpatch.Operation{})}
+		}
+
+		statuses = append(statuses, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity)
+	}
+
+	if rt.upload != "" {
+		op.RequestBody = &openapi.RequestBody{
+			Required: true,
+			Content: map[string]*openapi.MediaType{
+				"multipart/form-data": {Schema: &openapi.Schema{
+					Type:       "object",
+					Properties: map[string]*openapi.Schema{rt.upload: {Type: "string", Format: "binary"}},
+					Required:   []string{rt.upload},
+				}},
+			},
+		}
+
+		statuses = append(statuses, http.StatusBadRequest, http.StatusUnprocessableEntity)
+	}
+
+	switch {
+	case rt.permission != "":
+		op.Description = fmt.Sprintf("Requires an activated user with the %q permission.", rt.permission)
+	case rt.role != "":
+		op.Description = fmt.Sprintf("Requires an activated user with the %q role, and no API key.", rt.role)
+	case rt.activated:
+		op.Description = "Requires an activated user."
+	}
+
+	if rt.permission != "" || rt.role != "" || rt.activated {
+		op.Security = []map[string][]string{{"bearerAuth": {}}, {"apiKeyAuth": {}}}
+
+		statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+
+	if len(params) > 0 {
+		statuses = append(statuses, http.StatusNotFound)
+	}
+
+	if app.settings().limiterEnabled || (app.config.quota.enabled && !rt.unmetered) {
+		statuses = append(statuses, http.StatusTooManyRequests)
+	}
+
+	if app.config.quota.enabled && !rt.unmetered {
+		statuses = append(statuses, http.StatusPaymentRequired)
+	}
+
+	if app.routeTimeout(rt) > 0 {
+		statuses = append(statuses, http.StatusGatewayTimeout)
+	}
+
+	for _, status := range append(statuses, rt.errors...) {
+		op.Responses[strconv.Itoa(status)] = app.openAPIErrorResponse(status, errorSchema)
+	}
+
+	return op
+}
+
+/* Converts httprouter's /v1/movies/:id to /v1/movies/{id} and returns the path parameters */
+func openAPIPath(path string) (string, []*openapi.Parameter) {
+	var params []*openapi.Parameter
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		name, found := strings.CutPrefix(segment, ":")
+		if !found {
+			continue
+		}
+
+		segments[i] = "{" + name + "}"
+
+		schema := &openapi.Schema{Type: "string"}
+		if name == "id" {
+			minimum := 1.0
+			schema = &openapi.Schema{Type: "integer", Format: "int64", Minimum: &minimum}
+		}
+
+		params = append(params, &openapi.Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+/* Errors follow -error-format, see errorResponse */
+func (app *application) openAPIErrorResponse(status int, schema *openapi.Schema) *openapi.Response {
+	response := &openapi.Response{Description: http.StatusText(status)}
+
+	if app.config.errorFormat == "problem" {
+		response.Content = map[string]*openapi.MediaType{"application/problem+json": {Schema: schema}}
+	} else {
+		response.Content = openapi.JSONContent(schema)
+	}
+
+	return response
+}
+
+func (app *application) openAPIErrorSchema(gen *openapi.Generator) *openapi.Schema {
+	if app.config.errorFormat == "problem" {
+		return gen.Schema(problemDetails{})
+	}
+
+	validationErrors := gen.Schema(map[string][]string{})
+	validationErrors.Description = "Messages keyed by field, for 422 responses"
+
+	return gen.Define("Error", &openapi.Schema{
+		Type:     "object",
+		Required: []string{"error"},
+		Properties: map[string]*openapi.Schema{
+			"error":      {OneOf: []*openapi.Schema{{Type: "string"}, validationErrors}},
+			"request_id": {Type: "string", Description: "Also sent as X-Request-ID, names the request in the logs"},
+		},
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/worker"
+)
+
+const (
+	outboxPollInterval = 2 * time.Second
+	outboxBatchSize    = 20
+	/* 30s doubled up to the 7th retry, ~1 hour after the first attempt */
+	outboxMaxAttempts = 8
+	outboxBaseDelay   = 30 * time.Second
+)
+
+/* Delivers the emails and webhook events that transactions wrote to the outbox until ctx is cancelled */
+func (app *application) runOutboxDispatcher(ctx context.Context) {
+	dispatcher := worker.NewDispatcher(app.models.Outbox, worker.DispatcherConfig{
+		PollInterval: outboxPollInterval,
+		BatchSize:    outboxBatchSize,
+		MaxAttempts:  outboxMaxAttempts,
+		BaseDelay:    outboxBaseDelay,
+		Timeout:      30 * time.Second,
+	}, app.logger)
+
+	/* SMTP can't tell a repeated message, an email is sent twice if the attempt isn't recorded */
+	dispatcher.Handle(data.OutboxEmail, func(ctx context.Context, entry *data.OutboxEntry) error {
+		var message data.EmailMessage
+
+		/* json.Number, a float64 user ID of 7 digits would be written as 1.234567e+06 */
+		dec := json.NewDecoder(bytes.NewReader(entry.Payload))
+		dec.UseNumber()
+
+		err := dec.Decode(&message)
+		if err != nil {
+			return err
+		}
+
+		return app.mailer.Send(message.Recipient, message.Template, message.Data)
+	})
+
+	/* Fans the event out to the webhook deliveries, which the webhook dispatcher sends */
+	dispatcher.Handle(data.OutboxWebhook, func(ctx context.Context, entry *data.OutboxEntry) error {
+		var event events.Event
+
+		err := json.Unmarshal(entry.Payload, &event)
+		if err != nil {
+			return err
+		}
+
+		return app.models.Webhooks.EnqueueOnce(data.WithTenant(ctx, entry.TenantID), entry.Key, event.Type, entry.Payload)
+	})
+
+	dispatcher.Run(ctx)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+func (app *application) showPersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	person, err := app.models.People.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	credits, err := app.models.People.GetCreditsForPerson(r.Context(), person.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"person": person, "credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	credits, err := app.models.People.GetCreditsForMovie(r.Context(), movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Reads ?include=, credits being the only thing movies can embed so far */
+func (app *application) readIncludeCredits(qs url.Values, v *validator.Validator) bool {
+	include := app.readCSV(qs, "include", []string{})
+
+	for _, value := range include {
+		v.CheckField(validator.In(value, "credits"), "include", validator.Message("validation.only", "credits"))
+	}
+
+	return slices.Contains(include, "credits")
+}
+
+/* Sets the credits of the movies, with one query for all of them */
+func (app *application) loadCredits(ctx context.Context, movies ...*data.Movie) error {
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	credits, err := app.models.People.GetCreditsForMovies(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	/* Movies without credits are left without the field */
+	for _, movie := range movies {
+		movie.Credits = credits[movie.ID]
+	}
+
+	return nil
+}
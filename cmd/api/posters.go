@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/storage"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+const maxPosterSize = 5 << 20
+
+/* Accepted poster types, by sniffed content type, and the extension they are stored with */
+var posterTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+/* Opens the storage backend selected by -storage */
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		baseURL := cfg.storage.baseURL
+		if baseURL == "" {
+			baseURL = "/uploads"
+		}
+		return storage.NewLocal(cfg.storage.dir, baseURL)
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.storage.s3.endpoint,
+			Region:    cfg.storage.s3.region,
+			Bucket:    cfg.storage.s3.bucket,
+			AccessKey: cfg.storage.s3.accessKey,
+			SecretKey: cfg.storage.s3.secretKey,
+			BaseURL:   cfg.storage.baseURL,
+		})
+	default:
+		return nil, fmt.Errorf("invalid -storage %q, must be local or s3", cfg.storage.backend)
+	}
+}
+
+/*
+Expects a multipart/form-data body with the image in a "poster" file field.
+The type is sniffed from the content, the client's Content-Type is ignored.
+*/
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	/* The body is capped by the route, see its maxBody */
+	file, _, err := r.FormFile("poster")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+			v.AddError("poster", validator.Message("validation.required"))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.As(err, &maxBytesError):
+			v.AddError("poster", validator.Message("validation.max_size", "5 MB"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	poster, err := io.ReadAll(io.LimitReader(file, maxPosterSize+1))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	contentType := http.DetectContentType(poster)
+
+	v.CheckField(len(poster) > 0, "poster", validator.Message("validation.not_empty"))
+	v.CheckField(len(poster) <= maxPosterSize, "poster", validator.Message("validation.max_size", "5 MB"))
+	_, ok := posterTypes[contentType]
+	v.CheckField(ok, "poster", validator.Message("validation.image_type"))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	/* A new key per upload so caches never serve the previous poster */
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	key := fmt.Sprintf("posters/%d-%s%s", movie.ID, hex.EncodeToString(suffix), posterTypes[contentType])
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	err = app.storage.Put(ctx, key, bytes.NewReader(poster), int64(len(poster)), contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previous, err := app.models.Movies.SetPoster(r.Context(), movie, key, app.storage.URL(key))
+	if err != nil {
+		app.deletePoster(key)
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	if previous != "" {
+		app.deletePoster(previous)
+	}
+
+	app.publishEvent(r.Context(), events.MovieUpdated, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Deletes a poster that is no longer referenced, failures only leave an orphan file behind */
+func (app *application) deletePoster(key string) {
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err := app.storage.Delete(ctx, key)
+		if err != nil {
+			app.logger.Error(err.Error(), "key", key)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* A month's usage as shown to clients, Quota and Remaining are left out when unlimited */
+type quotaUsage struct {
+	Requests  int64  `json:"requests" xml:"requests"`
+	Quota     *int64 `json:"quota,omitempty" xml:"quota,omitempty"`
+	Remaining *int64 `json:"remaining,omitempty" xml:"remaining,omitempty"`
+}
+
+type usageReport struct {
+	/* As "2006-01" */
+	Month    string    `json:"month" xml:"month"`
+	ResetsAt time.Time `json:"resets_at" xml:"resets_at"`
+	quotaUsage
+	/* Those of the requests made with API keys, the rest were made with tokens */
+	APIKeys []*data.APIKeyUsage `json:"api_keys" xml:"api_keys>api_key"`
+	/* Of all users of the tenant together */
+	Tenant quotaUsage `json:"tenant" xml:"tenant"`
+}
+
+type updateQuotaInput struct {
+	/* null for the -quota-user-monthly default */
+	MonthlyQuota *int64 `json:"monthly_quota"`
+}
+
+/* The usage against the quota of its own, or the default; 0 defaults are unlimited */
+func newQuotaUsage(usage data.Usage, defaultQuota int64) quotaUsage {
+	qu := quotaUsage{Requests: usage.Requests, Quota: usage.Quota}
+
+	if qu.Quota == nil && defaultQuota > 0 {
+		qu.Quota = &defaultQuota
+	}
+
+	if qu.Quota != nil {
+		remaining := max(*qu.Quota-qu.Requests, 0)
+		qu.Remaining = &remaining
+	}
+
+	return qu
+}
+
+func (qu quotaUsage) exceeded() bool {
+	return qu.Quota != nil && qu.Requests > *qu.Quota
+}
+
+/*
+Counts the requests of authenticated users with -quota-enabled, per user and
+API key and per tenant, and turns them away once a monthly quota is used up:
+with a 429 for the user's own quota, which only waits for the next month, and
+a 402 for the tenant's. Requests turned away count too. Anonymous clients only
+have the rate limits, and unmetered routes aren't counted.
+*/
+func (app *application) enforceQuota(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			next(w, r)
+			return
+		}
+
+		userUsage, tenantUsage, err := app.models.Usage.Record(r.Context(), int64(user.ID), user.APIKeyID)
+		if err != nil {
+			/* Fails open like the rate limiter, counting is no reason to turn clients away */
+			app.logError(r, err)
+			next(w, r)
+			return
+		}
+
+		own := newQuotaUsage(userUsage, app.config.quota.userMonthly)
+		tenant := newQuotaUsage(tenantUsage, app.config.quota.tenantMonthly)
+
+		reset := int(math.Ceil(time.Until(userUsage.Month.AddDate(0, 1, 0)).Seconds()))
+
+		if own.Quota != nil {
+			w.Header().Set("X-Quota-Limit", strconv.FormatInt(*own.Quota, 10))
+			w.Header().Set("X-Quota-Remaining", strconv.FormatInt(*own.Remaining, 10))
+			w.Header().Set("X-Quota-Reset", strconv.Itoa(reset))
+		}
+
+		switch {
+		case tenant.exceeded():
+			app.tenantQuotaExceededResponse(w, r)
+		case own.exceeded():
+			w.Header().Set("Retry-After", strconv.Itoa(reset))
+			app.quotaExceededResponse(w, r)
+		default:
+			next(w, r)
+		}
+	}
+}
+
+/* The usage of the user, in the tenant of the request */
+func (app *application) usageReport(r *http.Request, userID int64) (*usageReport, error) {
+	usage, keys, err := app.models.Usage.GetForUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := app.models.Usage.GetForTenant(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	return &usageReport{
+		Month:      usage.Month.Format("2006-01"),
+		ResetsAt:   usage.Month.AddDate(0, 1, 0),
+		quotaUsage: newQuotaUsage(*usage, app.config.quota.userMonthly),
+		APIKeys:    keys,
+		Tenant:     newQuotaUsage(*tenant, app.config.quota.tenantMonthly),
+	}, nil
+}
+
+/* The month's requests against the quotas, not counted itself so it works past them */
+func (app *application) showUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.quota.enabled {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	report, err := app.usageReport(r, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"usage": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Gives a user a quota of their own, or back the default; takes effect with their next request */
+func (app *application) updateUserQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input updateQuotaInput
+
+	err = app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.CheckField(input.MonthlyQuota == nil || *input.MonthlyQuota >= 0, "monthly_quota", validator.Message("validation.not_negative"))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Usage.SetUserQuota(r.Context(), id, input.MonthlyQuota)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	report, err := app.usageReport(r, id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"usage": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
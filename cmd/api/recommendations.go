@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/openapi"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+var recommendationParameters = []*openapi.Parameter{
+	{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+	{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+	runtimeFormatParameter,
+}
+
+/* Pages through the ranking, which has no sort of its own to choose */
+func (app *application) readRecommendationFilters(w http.ResponseWriter, r *http.Request) (data.Filters, bool) {
+	var f data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	f.Page = app.readInt(qs, "page", 1, v)
+	f.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	f.Sort = "-score"
+	f.SortSafelist = []string{"-score"}
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return f, false
+	}
+
+	return f, true
+}
+
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	f, ok := app.readRecommendationFilters(w, r)
+	if !ok {
+		return
+	}
+
+	/* A 404 for a missing or trashed movie rather than an empty list */
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	movies, metadata, err := app.models.Recommendations.Similar(r.Context(), movie.ID, f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := app.readRecommendationFilters(w, r)
+	if !ok {
+		return
+	}
+
+	movies, metadata, err := app.models.Recommendations.ForUser(r.Context(), int64(app.contextGetUser(r).ID), f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
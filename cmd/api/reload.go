@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"github.com/mohafarman/greenlight/internal/ratelimit"
+)
+
+/*
+The settings reloadConfig changes while the API is running. The middleware
+reads them through app.settings() rather than app.config, which keeps the
+values from startup.
+*/
+type liveSettings struct {
+	limiterEnabled bool
+	/* Anonymous clients, authenticated users and admins */
+	anonymousLimit ratelimit.Limit
+	userLimit      ratelimit.Limit
+	adminLimit     ratelimit.Limit
+
+	trustedOrigins []string
+}
+
+func newLiveSettings(cfg config) *liveSettings {
+	return &liveSettings{
+		limiterEnabled: cfg.limiter.enabled,
+		anonymousLimit: ratelimit.Limit{Rate: cfg.limiter.rps, Burst: cfg.limiter.burst},
+		userLimit:      ratelimit.Limit{Rate: cfg.limiter.userRPS, Burst: cfg.limiter.userBurst},
+		adminLimit:     ratelimit.Limit{Rate: cfg.limiter.adminRPS, Burst: cfg.limiter.adminBurst},
+		trustedOrigins: cfg.cors.trustedOrigins,
+	}
+}
+
+/* The settings of the last reload, or of startup; never modify them */
+func (app *application) settings() *liveSettings {
+	return app.live.Load()
+}
+
+/*
+Reads the configuration again from the command line, the config file and the
+environment, e.g. on SIGHUP, and applies the settings that are safe to change
+without a restart: the rate limits and whether they are enforced, the CORS
+trusted origins and the log level. Nothing changes if the configuration is
+invalid.
+*/
+func (app *application) reloadConfig() error {
+	var cfg config
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	defineFlags(fs, &cfg)
+
+	/* Defined in main, so os.Args parses again and parseConfig finds -config */
+	fs.String("config", "", "")
+	fs.Bool("print-config", false, "")
+	fs.Bool("version", false, "")
+
+	err := parseConfig(fs, os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	app.live.Store(newLiveSettings(cfg))
+	/* Valid, validateConfig checked it */
+	app.logLevel.UnmarshalText([]byte(cfg.logLevel))
+
+	app.logger.Info("configuration reloaded",
+		"limiter_enabled", cfg.limiter.enabled,
+		"cors_trusted_origins", cfg.cors.trustedOrigins,
+		"log_level", cfg.logLevel,
+	)
+
+	return nil
+}
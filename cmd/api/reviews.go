@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+type createReviewInput struct {
+	Rating int32  `json:"rating"`
+	Body   string `json:"body"`
+}
+
+/* Pointers like updateMovieInput, omitted fields are left as they are */
+type updateReviewInput struct {
+	Rating *int32  `json:"rating"`
+	Body   *string `json:"body"`
+}
+
+var reviewSortSafelist = []string{"id", "rating", "-id", "-rating"}
+
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	/* Reviews of missing movies are a 404 rather than a foreign key error */
+	_, err = app.models.Movies.Get(r.Context(), movieID)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	var input createReviewInput
+
+	err = app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review := &data.Review{
+		MovieID: movieID,
+		UserID:  int64(app.contextGetUser(r).ID),
+		Rating:  input.Rating,
+		Body:    input.Body,
+	}
+
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Insert(r.Context(), review)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v%d/movies/%d/reviews/%d", apiVersion(r), movieID, review.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var f data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	f.Page = app.readInt(qs, "page", 1, v)
+	f.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	/* Newest first by default */
+	f.Sort = app.readString(qs, "sort", "-id")
+	f.SortSafelist = reviewSortSafelist
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Movies.Get(r.Context(), movieID)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(r.Context(), movieID, f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"metadata": metadata, "reviews": reviews}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.ownReview(w, r)
+	if !ok {
+		return
+	}
+
+	var input updateReviewInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	v := validator.New()
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Update(r.Context(), review)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.ownReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Reviews.Delete(r.Context(), review.ID)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+Looks up the review in the URL and checks it was written by the user making
+the request. Writes the error response and returns false otherwise.
+*/
+func (app *application) ownReview(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	id, err := app.readNamedIDParam(r, "review_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.models.Reviews.Get(r.Context(), movieID, id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if review.UserID != int64(app.contextGetUser(r).ID) {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return review, true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* A user that doesn't exist, or is of another tenant, has no roles */
+func (app *application) listUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.writeUserRoles(w, r, id, http.StatusOK)
+}
+
+/* 201 when the role is given, 200 when the user already had it */
+func (app *application) addUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	added, err := app.models.Roles.AddForUser(r.Context(), id, httprouter.ParamsFromContext(r.Context()).ByName("role"))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+
+	app.writeUserRoles(w, r, id, status)
+}
+
+func (app *application) removeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Roles.RemoveForUser(r.Context(), id, httprouter.ParamsFromContext(r.Context()).ByName("role"))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserRoles(w, r, id, http.StatusOK)
+}
+
+/* Responds with the user's roles, as they are after a change */
+func (app *application) writeUserRoles(w http.ResponseWriter, r *http.Request, userID int64, status int) {
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, status, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,32 +3,708 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/graphql"
+	"github.com/mohafarman/greenlight/internal/openapi"
+	"github.com/mohafarman/greenlight/internal/storage"
 )
 
+/*
+An API endpoint. Besides registering the handler, the fields describe the
+endpoint for the OpenAPI document served at /v1/openapi.json.
+*/
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	/* Handler of the /v2 route when its shape differs, see versionedRoutes */
+	v2 http.HandlerFunc
+	/* Path of the /v1 route a /v2 route is cloned from, which it shares rate limits with */
+	base string
+	/* Permission code required to call the route, empty for public routes */
+	permission string
+	/* Role required to call the route, for administration rather than the catalogue */
+	role string
+	/* Needs an activated user but no particular permission */
+	activated bool
+	/* Also accepts the token as ?access_token=, see authenticateQueryToken */
+	queryToken bool
+	/* Request body limit in bytes, -max-body-bytes if not set */
+	maxBody int64
+	/* Time the handler gets, -request-timeout if not set and none for noTimeout */
+	timeout time.Duration
+	/* Not counted against the quotas, see enforceQuota */
+	unmetered bool
+
+	id      string
+	summary string
+	/* Query string parameters, path parameters are found from the path */
+	query []*openapi.Parameter
+	/* Zero value of the request body type, nil if the route reads no body */
+	request any
+	/* Also accepts a JSON Patch (RFC 6902) body */
+	patch bool
+	/* Name of the file field of a multipart/form-data body, instead of request */
+	upload string
+	/* Success status, 200 if not set, and body */
+	status   int
+	response envelope
+	/* Media type of the success body, JSON if not set */
+	contentType string
+	/* Error statuses on top of the ones implied by the other fields */
+	errors []int
+}
+
+const (
+	/* An email and a password, or a token */
+	tokenBodyBytes = 4 << 10
+	/* Room for the multipart boundaries and headers on top of an uploaded file */
+	multipartOverhead = 64 << 10
+	/* The uploads are read within the handler's time */
+	uploadTimeout = time.Minute
+	/* Room for the -enrich-provider's rate limit, see enrich.getJSON */
+	enrichTimeout = 30 * time.Second
+	/* For the streams, which end when the client goes away */
+	noTimeout = -1
+)
+
+func (app *application) apiRoutes() []route {
+	return []route{
+		{
+			method: http.MethodGet, path: "/v1/healthcheck", handler: app.healthCheckHandler,
+			id: "healthcheck", summary: "Show application status and version",
+			response: envelope{"status": "", "system_info": map[string]string{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/healthcheck/live", handler: app.livenessHandler,
+			id: "liveness", summary: "Liveness probe, the process is serving requests",
+			response: envelope{"status": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/healthcheck/ready", handler: app.readinessHandler,
+			id: "readiness", summary: "Readiness probe, checks the database and SMTP server; 503 when the database is down",
+			response: envelope{"status": "", "checks": map[string]dependencyStatus{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies", handler: app.listMoviesHandler, permission: "movies:read",
+			id: "listMovies", summary: "List movies, filtered, sorted and paginated",
+			query:    movieListParameters(),
+			response: envelope{"metadata": data.Metadata{}, "movies": []data.Movie{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/movies", handler: app.createMovieHandler, permission: "movies:write",
+			id: "createMovie", summary: "Create a movie",
+			request: createMovieInput{},
+			status:  http.StatusCreated, response: envelope{"movie": data.Movie{}, "warnings": map[string][]string{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/:id", handler: app.showMovieHandler, permission: "movies:read",
+			id: "showMovie", summary: "Show a movie",
+			query:    []*openapi.Parameter{includeParameter, fieldsParameter, runtimeFormatParameter},
+			response: envelope{"movie": data.Movie{}},
+		},
+		{
+			method: http.MethodPatch, path: "/v1/movies/:id", handler: app.updateMovieHandler, permission: "movies:write",
+			id: "updateMovie", summary: "Update some or all fields of a movie, with If-Match: * a merge body is merged into concurrent updates",
+			query:   []*openapi.Parameter{ifMatchParameter},
+			request: updateMovieInput{}, patch: true,
+			response: envelope{"movie": data.Movie{}, "warnings": map[string][]string{}},
+			errors:   []int{http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/movies/:id", handler: app.deleteMovieHandler, permission: "movies:write",
+			id: "deleteMovie", summary: "Move a movie to the trash",
+			query:    []*openapi.Parameter{ifMatchParameter},
+			response: envelope{"message": ""},
+			errors:   []int{http.StatusConflict, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		{
+			method: http.MethodPost, path: "/v1/users", handler: app.registerUserHandler,
+			id: "registerUser", summary: "Register a user and email them an activation token",
+			request: registerUserInput{},
+			status:  http.StatusAccepted, response: envelope{"user": data.User{}},
+		},
+		{
+			method: http.MethodPut, path: "/v1/users/activated", handler: app.activateUserHandler,
+			id: "activateUser", summary: "Activate a user with their activation token",
+			request:  activateUserInput{},
+			response: envelope{"user": data.User{}},
+			errors:   []int{http.StatusConflict},
+		},
+		{
+			method: http.MethodPut, path: "/v1/users/password", handler: app.updateUserPasswordHandler,
+			id: "updateUserPassword", summary: "Set a new password with a password reset token",
+			request:  updateUserPasswordInput{},
+			response: envelope{"message": ""},
+			errors:   []int{http.StatusConflict},
+		},
+		{
+			method: http.MethodPost, path: "/v1/tokens/password-reset", handler: app.createPasswordResetTokenHandler, maxBody: tokenBodyBytes,
+			id: "createPasswordResetToken", summary: "Email a password reset token to an activated user",
+			request: createPasswordResetTokenInput{},
+			status:  http.StatusAccepted, response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodPost, path: "/v1/tokens/activation", handler: app.createActivationTokenHandler, maxBody: tokenBodyBytes,
+			id: "createActivationToken", summary: "Email a new activation token to a user who isn't activated yet",
+			request: createActivationTokenInput{},
+			status:  http.StatusAccepted, response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodPost, path: "/v1/tokens/authentication", handler: app.createAuthenticationTokenHandler, maxBody: tokenBodyBytes,
+			id: "createAuthenticationToken", summary: "Exchange an email and password, plus a totp_code or recovery_code with 2FA enabled, for an authentication token",
+			request:  createAuthenticationTokenInput{},
+			response: envelope{"authentication_token": data.Token{}, "refresh_token": data.Token{}},
+			errors:   []int{http.StatusUnauthorized},
+		},
+		{
+			method: http.MethodGet, path: "/v1/auth/:provider", handler: app.oauthLoginHandler,
+			id: "oauthLogin", summary: "Sign in with an OAuth provider (google|github), redirects to the provider",
+			status: http.StatusFound,
+		},
+		{
+			method: http.MethodGet, path: "/v1/auth/:provider/callback", handler: app.oauthCallbackHandler,
+			id: "oauthCallback", summary: "Where the provider redirects back to, signs in, links or creates the user with the account's verified email",
+			query:    []*openapi.Parameter{oauthCodeParameter, oauthStateParameter},
+			response: envelope{"authentication_token": data.Token{}, "refresh_token": data.Token{}},
+			errors:   []int{http.StatusUnauthorized},
+		},
+		{
+			method: http.MethodPost, path: "/v1/tokens/refresh", handler: app.refreshTokenHandler, maxBody: tokenBodyBytes,
+			id: "refreshToken", summary: "Exchange a refresh token for new authentication and refresh tokens",
+			request:  refreshTokenInput{},
+			response: envelope{"authentication_token": data.Token{}, "refresh_token": data.Token{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/genres", handler: app.listGenresHandler, permission: "movies:read",
+			id: "listGenres", summary: "List the genres of the movies in the catalogue, with their movie counts",
+			response: envelope{"genres": []data.Genre{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/genres/:name/movies", handler: app.listGenreMoviesHandler, permission: "movies:read",
+			id: "listGenreMovies", summary: "List the movies of a genre, sorted and paginated",
+			query: []*openapi.Parameter{
+				{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+				{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+				{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order", Schema: &openapi.Schema{Type: "string", Example: "id"}},
+				runtimeFormatParameter,
+			},
+			response: envelope{"genre": data.Genre{}, "metadata": data.Metadata{}, "movies": []data.Movie{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/:id/credits", handler: app.listMovieCreditsHandler, permission: "movies:read",
+			id: "listMovieCredits", summary: "List the directors and cast of a movie",
+			response: envelope{"credits": []data.Credit{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/:id/similar", handler: app.listSimilarMoviesHandler, permission: "movies:read",
+			id: "listSimilarMovies", summary: "Movies sharing genres with a movie, the closer in year the better; best match first",
+			query:    recommendationParameters,
+			response: envelope{"metadata": data.Metadata{}, "movies": []data.Movie{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/:id/history", handler: app.listMovieHistoryHandler, permission: "movies:read",
+			id: "listMovieHistory", summary: "List the updates of a movie with their old and new values, newest first",
+			query: []*openapi.Parameter{
+				{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+				{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+			},
+			response: envelope{"metadata": data.Metadata{}, "history": []data.MovieHistoryEntry{}},
+			errors:   []int{http.StatusUnprocessableEntity},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/:id/history/:version", handler: app.showMovieHistoryHandler, permission: "movies:read",
+			id: "showMovieHistory", summary: "Show the update that gave a movie a version, with the fields it changed",
+			response: envelope{"history": data.MovieHistoryEntry{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/movies/:id/revert/:version", handler: app.revertMovieHandler, permission: "movies:write",
+			id: "revertMovie", summary: "Give a movie back the title, year, runtime and genres it had at an earlier version",
+			query:    []*openapi.Parameter{ifMatchParameter},
+			response: envelope{"movie": data.Movie{}},
+			errors:   []int{http.StatusConflict, http.StatusUnprocessableEntity, http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+		},
+		{
+			method: http.MethodGet, path: "/v1/people/:id", handler: app.showPersonHandler, permission: "movies:read",
+			id: "showPerson", summary: "Show a person and their credits, newest movie first",
+			response: envelope{"person": data.Person{}, "credits": []data.Credit{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/trash", handler: app.listTrashHandler, permission: "movies:admin",
+			id: "listTrash", summary: "List deleted movies, most recently deleted first",
+			query: []*openapi.Parameter{
+				{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+				{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+				{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order", Schema: &openapi.Schema{Type: "string", Enum: []any{"deleted_at", "id", "title", "-deleted_at", "-id", "-title"}}},
+			},
+			response: envelope{"metadata": data.Metadata{}, "movies": []data.Movie{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/movies/:id/restore", handler: app.restoreMovieHandler, permission: "movies:admin",
+			id: "restoreMovie", summary: "Move a deleted movie back to the catalogue",
+			response: envelope{"movie": data.Movie{}},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/movies/:id/purge", handler: app.purgeMovieHandler, permission: "movies:admin",
+			id: "purgeMovie", summary: "Permanently delete a movie in the trash, with its reviews and poster",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/export", handler: app.exportMoviesHandler, permission: "movies:read", timeout: noTimeout,
+			id: "exportMovies", summary: "Download every movie matching the filters as CSV",
+			query: append(movieSearchParameters(),
+				&openapi.Parameter{Name: "format", In: "query", Schema: &openapi.Schema{Type: "string", Enum: []any{"csv"}}},
+				&openapi.Parameter{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order", Schema: &openapi.Schema{Type: "string", Example: "id"}},
+			),
+			contentType: "text/csv",
+			response:    envelope{},
+			errors:      []int{http.StatusUnprocessableEntity},
+		},
+		{
+			method: http.MethodPost, path: "/v1/movies/export-jobs", handler: app.createExportJobHandler, permission: "movies:read",
+			id: "createExportJob", summary: "Export the movies matching the filters in the background, poll GET /v1/export-jobs/:id for the file",
+			query: append(movieSearchParameters(),
+				&openapi.Parameter{Name: "format", In: "query", Schema: &openapi.Schema{Type: "string", Enum: []any{"csv"}}},
+				&openapi.Parameter{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order", Schema: &openapi.Schema{Type: "string", Example: "id"}},
+			),
+			status:   http.StatusAccepted,
+			response: envelope{"export_job": data.ExportJob{}},
+			errors:   []int{http.StatusUnprocessableEntity},
+		},
+		{
+			method: http.MethodGet, path: "/v1/export-jobs/:id", handler: app.showExportJobHandler, activated: true,
+			id: "showExportJob", summary: "Show the status and progress of one of your export jobs, with the download URL once completed",
+			response: envelope{"export_job": data.ExportJob{}},
+			errors:   []int{http.StatusNotFound},
+		},
+		{
+			method: http.MethodPost, path: "/v1/movies/import", handler: app.importMoviesHandler, permission: "movies:write",
+			id: "importMovies", summary: "Create movies from a CSV file with title, year, runtime and genres columns, all or none",
+			query: []*openapi.Parameter{
+				{Name: "dry_run", In: "query", Description: "Only validate the rows, errors are keyed by line as rows[n]", Schema: &openapi.Schema{Type: "boolean"}},
+			},
+			upload: "file", maxBody: maxImportSize + multipartOverhead, timeout: uploadTimeout,
+			status: http.StatusCreated, response: envelope{"imported": 0},
+		},
+		{
+			method: http.MethodPost, path: "/v1/movies/:id/poster", handler: app.uploadPosterHandler, permission: "movies:write",
+			id: "uploadPoster", summary: "Upload a JPEG, PNG, WebP or GIF poster of up to 5 MB as the multipart field \"poster\"",
+			upload: "poster", maxBody: maxPosterSize + multipartOverhead, timeout: uploadTimeout,
+			response: envelope{"movie": data.Movie{}},
+			errors:   []int{http.StatusConflict},
+		},
+		{
+			method: http.MethodPost, path: "/v1/movies/:id/enrich", handler: app.enrichMovieHandler, permission: "movies:write", timeout: enrichTimeout,
+			id: "enrichMovie", summary: "Fill in the plot, IMDb rating, cast and, without an uploaded one, the poster from the -enrich-provider",
+			response: envelope{"movie": data.Movie{}},
+			errors:   []int{http.StatusConflict, http.StatusUnprocessableEntity, http.StatusBadGateway},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/:id/reviews", handler: app.listReviewsHandler, permission: "movies:read",
+			id: "listReviews", summary: "List the reviews of a movie",
+			query: []*openapi.Parameter{
+				{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+				{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+				{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order. Newest first by default", Schema: &openapi.Schema{Type: "string", Enum: []any{"id", "rating", "-id", "-rating"}}},
+			},
+			response: envelope{"metadata": data.Metadata{}, "reviews": []data.Review{}},
+		},
+		/* Anyone who can read the catalogue may review it, but only edit their own reviews */
+		{
+			method: http.MethodPost, path: "/v1/movies/:id/reviews", handler: app.createReviewHandler, permission: "movies:read",
+			id: "createReview", summary: "Review a movie, once per user",
+			request: createReviewInput{},
+			status:  http.StatusCreated, response: envelope{"review": data.Review{}},
+		},
+		{
+			method: http.MethodPatch, path: "/v1/movies/:id/reviews/:review_id", handler: app.updateReviewHandler, permission: "movies:read",
+			id: "updateReview", summary: "Update your review",
+			request:  updateReviewInput{},
+			response: envelope{"review": data.Review{}},
+			errors:   []int{http.StatusConflict},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/movies/:id/reviews/:review_id", handler: app.deleteReviewHandler, permission: "movies:read",
+			id: "deleteReview", summary: "Delete your review",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/recommendations", handler: app.listRecommendationsHandler, activated: true,
+			id: "listRecommendations", summary: "Movies you may like, by the genres and years of the movies on your watchlist; best match first",
+			query:    recommendationParameters,
+			response: envelope{"metadata": data.Metadata{}, "movies": []data.Movie{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/watchlist", handler: app.listWatchlistHandler, activated: true,
+			id: "listWatchlist", summary: "List the movies on your watchlist",
+			query: []*openapi.Parameter{
+				{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+				{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+				{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order. Most recently added first by default", Schema: &openapi.Schema{Type: "string", Enum: []any{"added_at", "id", "title", "year", "runtime", "-added_at", "-id", "-title", "-year", "-runtime"}}},
+			},
+			response: envelope{"metadata": data.Metadata{}, "movies": []data.Movie{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/me/watchlist", handler: app.addToWatchlistHandler, activated: true,
+			id: "addToWatchlist", summary: "Add a movie to your watchlist, 200 if it already is on it",
+			request: addToWatchlistInput{},
+			status:  http.StatusCreated, response: envelope{"movie": data.Movie{}},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/me/watchlist/:movie_id", handler: app.removeFromWatchlistHandler, activated: true,
+			id: "removeFromWatchlist", summary: "Remove a movie from your watchlist",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/searches", handler: app.listSavedSearchesHandler, activated: true,
+			id: "listSavedSearches", summary: "List your saved searches",
+			response: envelope{"saved_searches": []data.SavedSearch{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/me/searches", handler: app.createSavedSearchHandler, activated: true,
+			id: "createSavedSearch", summary: "Save a movie search, to be alerted by email or webhook when a new movie matches it",
+			request: createSavedSearchInput{},
+			status:  http.StatusCreated, response: envelope{"saved_search": data.SavedSearch{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/searches/:id", handler: app.showSavedSearchHandler, activated: true,
+			id: "showSavedSearch", summary: "Show one of your saved searches",
+			response: envelope{"saved_search": data.SavedSearch{}},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/me/searches/:id", handler: app.deleteSavedSearchHandler, activated: true,
+			id: "deleteSavedSearch", summary: "Delete one of your saved searches, ending its alerts",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/searches/:id/movies", handler: app.listSavedSearchMoviesHandler, activated: true,
+			id: "listSavedSearchMovies", summary: "List the movies matching one of your saved searches, sorted and paginated",
+			query: []*openapi.Parameter{
+				{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+				{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+				{Name: "sort", In: "query", Description: "Sort field, prefixed with - for descending order", Schema: &openapi.Schema{Type: "string", Example: "id"}},
+				runtimeFormatParameter,
+			},
+			response: envelope{"saved_search": data.SavedSearch{}, "metadata": data.Metadata{}, "movies": []data.Movie{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/usage", handler: app.showUsageHandler, activated: true, unmetered: true,
+			id: "showUsage", summary: "Show your requests this month against your monthly quota and your tenant's, with -quota-enabled",
+			response: envelope{"usage": usageReport{}},
+			errors:   []int{http.StatusNotFound},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/api-keys", handler: app.listAPIKeysHandler, activated: true,
+			id: "listAPIKeys", summary: "List your API keys, without the keys themselves",
+			response: envelope{"api_keys": []data.APIKey{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/me/api-keys", handler: app.createAPIKeyHandler, activated: true,
+			id: "createAPIKey", summary: "Create an API key limited to some of your permissions, sent as X-API-Key",
+			request: createAPIKeyInput{},
+			status:  http.StatusCreated, response: envelope{"api_key": data.APIKey{}},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/me/api-keys/:id", handler: app.deleteAPIKeyHandler, activated: true,
+			id: "deleteAPIKey", summary: "Revoke one of your API keys",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/me/sessions", handler: app.listSessionsHandler, activated: true,
+			id: "listSessions", summary: "List your active sessions, i.e. authentication tokens, and where they were last used",
+			response: envelope{"sessions": []data.Session{}},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/me/sessions", handler: app.deleteAllSessionsHandler, activated: true,
+			id: "deleteAllSessions", summary: "Revoke all your sessions and refresh tokens, signing you out everywhere",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/me/sessions/:id", handler: app.deleteSessionHandler, activated: true,
+			id: "deleteSession", summary: "Revoke one of your sessions and the refresh tokens of its login",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodPost, path: "/v1/me/2fa/enroll", handler: app.enrollTwoFactorHandler, activated: true,
+			id: "enrollTwoFactor", summary: "Start enrolling in two-factor authentication, returns the otpauth:// URI and recovery codes",
+			response: envelope{"two_factor": twoFactorEnrollment{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/me/2fa/verify", handler: app.verifyTwoFactorHandler, activated: true,
+			id: "verifyTwoFactor", summary: "Enable two-factor authentication with a code from the authenticator app",
+			request:  verifyTwoFactorInput{},
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/me/2fa", handler: app.disableTwoFactorHandler, activated: true,
+			id: "disableTwoFactor", summary: "Disable two-factor authentication, with your password and a code or recovery code",
+			request:  disableTwoFactorInput{},
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/roles", handler: app.listRolesHandler, role: data.RoleAdmin,
+			id: "listRoles", summary: "List the roles and the permissions they grant",
+			response: envelope{"roles": []data.Role{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/users/:id/roles", handler: app.listUserRolesHandler, role: data.RoleAdmin,
+			id: "listUserRoles", summary: "List a user's roles",
+			response: envelope{"roles": []string{}},
+		},
+		{
+			method: http.MethodPut, path: "/v1/users/:id/roles/:role", handler: app.addUserRoleHandler, role: data.RoleAdmin,
+			id: "addUserRole", summary: "Give a user a role, 200 if they already have it",
+			status: http.StatusCreated, response: envelope{"roles": []string{}},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/users/:id/roles/:role", handler: app.removeUserRoleHandler, role: data.RoleAdmin,
+			id: "removeUserRole", summary: "Take a role from a user",
+			response: envelope{"roles": []string{}},
+		},
+		{
+			method: http.MethodPut, path: "/v1/users/:id/quota", handler: app.updateUserQuotaHandler, role: data.RoleAdmin, unmetered: true,
+			id: "updateUserQuota", summary: "Give a user a monthly quota of their own, null for the default, and show their usage",
+			request:  updateQuotaInput{},
+			response: envelope{"usage": usageReport{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/events", handler: app.eventsHandler, permission: "movies:read", queryToken: true, timeout: noTimeout,
+			id: "streamEvents", summary: "Stream catalogue changes as Server-Sent Events, or WebSocket messages when upgraded",
+			query:       eventStreamParameters,
+			contentType: "text/event-stream",
+			response:    envelope{"type": "", "time": time.Time{}, "data": data.Movie{}},
+			errors:      []int{http.StatusUnprocessableEntity},
+		},
+		{
+			method: http.MethodGet, path: "/v1/movies/stream", handler: app.eventsHandler, permission: "movies:read", queryToken: true, timeout: noTimeout,
+			id: "streamMovieEvents", summary: "Stream movie changes, the same as GET /v1/events",
+			query:       eventStreamParameters,
+			contentType: "text/event-stream",
+			response:    envelope{"type": "", "time": time.Time{}, "data": data.Movie{}},
+			errors:      []int{http.StatusUnprocessableEntity},
+		},
+		{
+			method: http.MethodGet, path: "/v1/ws", handler: app.notificationsHandler, queryToken: true, timeout: noTimeout,
+			id: "notifications", summary: "WebSocket of the user's notifications, authenticated by ?access_token= or a first message {\"type\": \"authenticate\", \"token\": \"...\"}",
+			query: []*openapi.Parameter{
+				{Name: "access_token", In: "query", Description: "For clients that can't set the Authorization header", Schema: &openapi.Schema{Type: "string"}},
+			},
+			status:   http.StatusSwitchingProtocols,
+			response: envelope{"type": "", "time": time.Time{}, "data": map[string]any{}},
+			errors:   []int{http.StatusUnauthorized, http.StatusForbidden},
+		},
+		{
+			method: http.MethodGet, path: "/v1/admin/audit-log", handler: app.listAuditLogHandler, role: data.RoleAdmin,
+			id: "listAuditLog", summary: "List the recorded POST, PUT, PATCH and DELETE requests, newest first",
+			query: []*openapi.Parameter{
+				{Name: "user_id", In: "query", Description: "Only the requests of this user", Schema: &openapi.Schema{Type: "integer"}},
+				{Name: "resource_type", In: "query", Description: "Only the requests to this resource, the first segment of the path after /v1, e.g. movies", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "from", In: "query", Description: "Only the requests from then on, an RFC 3339 timestamp or a date", Schema: &openapi.Schema{Type: "string", Example: "2024-05-01"}},
+				{Name: "to", In: "query", Description: "Only the requests before then, an RFC 3339 timestamp or a date", Schema: &openapi.Schema{Type: "string", Example: "2024-06-01"}},
+				{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 1}},
+				{Name: "page_size", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+			},
+			response: envelope{"metadata": data.Metadata{}, "audit_log": []data.AuditEntry{}},
+			errors:   []int{http.StatusUnprocessableEntity},
+		},
+		{
+			method: http.MethodGet, path: "/v1/admin/stats", handler: app.showStatsHandler, role: data.RoleAdmin,
+			id: "showStats", summary: "Show movie, genre, registration, review and token figures of the tenant, cached for -stats-cache-ttl",
+			response: envelope{"stats": data.Stats{}},
+		},
+		{
+			method: http.MethodPost, path: "/v1/notifications/broadcast", handler: app.broadcastHandler, role: data.RoleAdmin,
+			id: "broadcastNotification", summary: "Send a message to every user connected to GET /v1/ws",
+			request: broadcastInput{},
+			status:  http.StatusAccepted, response: envelope{"connections": 0},
+		},
+		{
+			method: http.MethodPost, path: "/v1/webhooks", handler: app.createWebhookHandler, permission: "webhooks:manage",
+			id: "createWebhook", summary: "Subscribe a URL to events, the response holds its signing secret",
+			request: createWebhookInput{},
+			status:  http.StatusCreated, response: envelope{"webhook": data.Webhook{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/webhooks", handler: app.listWebhooksHandler, permission: "webhooks:manage",
+			id: "listWebhooks", summary: "List webhook subscriptions",
+			response: envelope{"webhooks": []data.Webhook{}},
+		},
+		{
+			method: http.MethodDelete, path: "/v1/webhooks/:id", handler: app.deleteWebhookHandler, permission: "webhooks:manage",
+			id: "deleteWebhook", summary: "Delete a webhook subscription and its deliveries",
+			response: envelope{"message": ""},
+		},
+		{
+			method: http.MethodGet, path: "/v1/webhooks/:id/deliveries", handler: app.listWebhookDeliveriesHandler, permission: "webhooks:manage",
+			id: "listWebhookDeliveries", summary: "Show the most recent delivery attempts of a webhook",
+			query: []*openapi.Parameter{
+				{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer", Example: 20}},
+			},
+			response: envelope{"deliveries": []data.WebhookDelivery{}},
+			errors:   []int{http.StatusUnprocessableEntity},
+		},
+		{
+			method: http.MethodPost, path: "/v1/graphql", handler: app.graphqlHandler,
+			id: "graphql", summary: "Run a GraphQL query over movies, search and the current user, or a movie mutation",
+			request:  graphql.Request{},
+			response: envelope{"data": map[string]any{}, "errors": []graphql.Error{}},
+		},
+		{
+			method: http.MethodGet, path: "/v1/graphql", handler: app.graphqlHandler,
+			id: "graphqlGet", summary: "Run a GraphQL query passed in the query string, mutations need POST",
+			query: []*openapi.Parameter{
+				{Name: "query", In: "query", Required: true, Schema: &openapi.Schema{Type: "string"}},
+				{Name: "operationName", In: "query", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "variables", In: "query", Description: "JSON object", Schema: &openapi.Schema{Type: "string"}},
+			},
+			response: envelope{"data": map[string]any{}, "errors": []graphql.Error{}},
+		},
+	}
+}
+
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
+	routes := app.versionedRoutes(app.apiRoutes())
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	/* Handlers of static segments sharing a position with a parameter, see paramSibling */
+	statics := make(map[string]map[string]http.HandlerFunc)
+	for _, rt := range routes {
+		if path, value, ok := paramSibling(routes, rt); ok {
+			key := rt.method + " " + path
+			if statics[key] == nil {
+				statics[key] = make(map[string]http.HandlerFunc)
+			}
+			statics[key][value] = app.guard(rt)
+		}
+	}
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	for _, rt := range routes {
+		if _, _, ok := paramSibling(routes, rt); ok {
+			continue
+		}
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+		handler := app.guard(rt)
+		if byValue, ok := statics[rt.method+" "+rt.path]; ok {
+			handler = dispatchParam(rt.path, byValue, handler)
+			delete(statics, rt.method+" "+rt.path)
+		}
 
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+		router.Handler(rt.method, rt.path, tagRoute(rt.path, handler))
+	}
+
+	/* Static segments with no route of their own at the parameter, e.g. POST /v1/movies/import */
+	for key, byValue := range statics {
+		method, path, _ := strings.Cut(key, " ")
+		router.Handler(method, path, tagRoute(path, dispatchParam(path, byValue, app.notFoundResponse)))
+	}
+
+	router.Handler(http.MethodGet, "/v1/openapi.json", tagRoute("/v1/openapi.json", app.openAPIHandler(routes)))
+
+	if app.config.docs.enabled {
+		router.Handler(http.MethodGet, "/docs", tagRoute("/docs", http.HandlerFunc(app.swaggerUIHandler)))
+	}
+
+	/* Files of the local storage, S3 serves its own */
+	if local, ok := app.storage.(*storage.Local); ok {
+		files := http.StripPrefix("/uploads", http.FileServer(http.Dir(local.Dir)))
+		router.Handler(http.MethodGet, "/uploads/*filepath", tagRoute("/uploads/*filepath", files))
+	}
+
+	router.Handler(http.MethodGet, "/debug/vars", tagRoute("/debug/vars", expvar.Handler()))
+
+	/* Scrapers authenticate with -metrics-token rather than a user token, so skip the API middleware */
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", app.metricsHandler)
 
 	/* After recoverPanic so any panic in rateLimiter can be handled */
-	/* Right after recoverPanic so our server don't have to do unnecessary work */
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimiter(app.authenticate(router)))))
+	/* rateLimiter after authenticate, users are limited by ID rather than IP */
+	/* compress inside metrics, which then counts the bytes actually sent */
+	mux.Handle("/", app.logRequest(app.metrics(app.compress(app.recoverPanic(app.enableCORS(router, app.resolveTenant(app.authenticate(app.rateLimiter(router)))))))))
+
+	return mux
+}
+
+/* Wraps the route's handler in the authentication it asks for, and its rate limit if it has one */
+func (app *application) guard(rt route) http.HandlerFunc {
+	handler := app.audit(rt, rt.handler)
+	switch {
+	case rt.permission != "":
+		handler = app.requirePermission(rt.permission, handler)
+	case rt.role != "":
+		handler = app.requireRole(rt.role, handler)
+	case rt.activated:
+		handler = app.requireActivatedUser(handler)
+	}
+	if rt.queryToken {
+		handler = app.authenticateQueryToken(handler)
+	}
+	if rt.maxBody > 0 {
+		handler = app.limitBody(rt.maxBody, handler)
+	}
+	handler = app.requireAcceptable(rt, handler)
+	if app.config.quota.enabled && !rt.unmetered {
+		handler = app.enforceQuota(handler)
+	}
+	/* Outermost, so the users of query tokens are limited by ID too */
+	key := rt.method + " " + rt.path
+	if rt.base != "" {
+		key = rt.method + " " + rt.base
+	}
+	if limit, ok := app.config.limiter.routes[key]; ok {
+		handler = app.rateLimitFor(key, limit, handler)
+	}
+	if timeout := app.routeTimeout(rt); timeout > 0 {
+		handler = app.requestTimeout(timeout, handler)
+	}
+
+	return handler
+}
+
+/*
+httprouter panics when a static segment shares a position with a parameter of
+another route of the same method, e.g. GET /v1/movies/export next to
+GET /v1/movies/:id. Such a route, with the static segment last, is registered
+under the parameter's path instead and picked by the parameter's value.
+Returns that path and the value.
+*/
+func paramSibling(routes []route, rt route) (string, string, bool) {
+	i := strings.LastIndex(rt.path, "/")
+	prefix, value := rt.path[:i+1], rt.path[i+1:]
+
+	if strings.HasPrefix(value, ":") || strings.HasPrefix(value, "*") {
+		return "", "", false
+	}
+
+	for _, other := range routes {
+		if other.method != rt.method || !strings.HasPrefix(other.path, prefix+":") {
+			continue
+		}
+
+		name, _, _ := strings.Cut(other.path[len(prefix):], "/")
+		return prefix + name, value, true
+	}
+
+	return "", "", false
+}
+
+/* Serves the handler registered for the value of the path's last parameter, next otherwise */
+func dispatchParam(path string, byValue map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	name := path[strings.LastIndex(path, ":")+1:]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := byValue[httprouter.ParamsFromContext(r.Context()).ByName(name)]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
 }
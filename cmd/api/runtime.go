@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/*
+Output format for movie runtimes: "mins" for "107 mins", the default of /v1,
+"minutes" for 107, the default of /v2, "iso8601" for "PT1H47M" or "human" for
+"1h 47m"
+*/
+func runtimeFormat(r *http.Request) string {
+	format := r.URL.Query().Get("runtime_format")
+	if format == "" {
+		format = r.Header.Get("X-Runtime-Format")
+	}
+
+	switch {
+	case format == "iso8601" || format == "minutes" || format == "human":
+		return format
+	case apiVersion(r) >= 2:
+		return "minutes"
+	}
+
+	return "mins"
+}
+
+/* A movie whose runtime shadows the embedded one so it is written as "PT1H47M" */
+type movieISO8601 struct {
+	XMLName xml.Name `json:"-" xml:"movie"`
+	*data.Movie
+	Runtime data.RuntimeISO8601 `json:"runtime,omitempty" xml:"runtime,omitempty"`
+}
+
+func withISO8601Runtime(movie *data.Movie) movieISO8601 {
+	return movieISO8601{Movie: movie, Runtime: data.RuntimeISO8601(movie.Runtime)}
+}
+
+/* A movie whose runtime shadows the embedded one so it is written as 107 */
+type movieMinutes struct {
+	XMLName xml.Name `json:"-" xml:"movie"`
+	*data.Movie
+	Runtime data.RuntimeMinutes `json:"runtime,omitempty" xml:"runtime,omitempty"`
+}
+
+func withMinutesRuntime(movie *data.Movie) movieMinutes {
+	return movieMinutes{Movie: movie, Runtime: data.RuntimeMinutes(movie.Runtime)}
+}
+
+/* A movie whose runtime shadows the embedded one so it is written as "1h 47m" */
+type movieHuman struct {
+	XMLName xml.Name `json:"-" xml:"movie"`
+	*data.Movie
+	Runtime data.RuntimeHuman `json:"runtime,omitempty" xml:"runtime,omitempty"`
+}
+
+func withHumanRuntime(movie *data.Movie) movieHuman {
+	return movieHuman{Movie: movie, Runtime: data.RuntimeHuman(movie.Runtime)}
+}
+
+/* The movie as written in format, for the responses that don't go through withRuntimes */
+func withRuntime(movie *data.Movie, format string) any {
+	switch format {
+	case "iso8601":
+		return withISO8601Runtime(movie)
+	case "minutes":
+		return withMinutesRuntime(movie)
+	case "human":
+		return withHumanRuntime(movie)
+	}
+
+	return movie
+}
+
+/* Returns a copy of env with every movie wrapped to write its runtime in format, env itself for "mins" */
+func withRuntimes(env envelope, format string) envelope {
+	switch format {
+	case "iso8601":
+		return convertMovies(env, withISO8601Runtime)
+	case "minutes":
+		return convertMovies(env, withMinutesRuntime)
+	case "human":
+		return convertMovies(env, withHumanRuntime)
+	}
+
+	return env
+}
+
+func convertMovies[T any](env envelope, convert func(*data.Movie) T) envelope {
+	converted := make(envelope, len(env))
+
+	for key, value := range env {
+		switch value := value.(type) {
+		case *data.Movie:
+			converted[key] = convert(value)
+		case []*data.Movie:
+			movies := make([]T, len(value))
+			for i, movie := range value {
+				movies[i] = convert(movie)
+			}
+			converted[key] = movies
+		default:
+			converted[key] = value
+		}
+	}
+
+	return converted
+}
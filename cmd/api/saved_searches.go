@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* The type of the events posted to the webhook of a saved search, which global webhooks don't get */
+const savedSearchMatched = "saved_search.matched"
+
+type createSavedSearchInput struct {
+	Name    string   `json:"name"`
+	Title   string   `json:"title"`
+	Genres  []string `json:"genres"`
+	YearGTE *int     `json:"year_gte"`
+	YearLTE *int     `json:"year_lte"`
+	/* Defaults to true */
+	NotifyEmail *bool  `json:"notify_email"`
+	WebhookURL  string `json:"webhook_url"`
+}
+
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	searches, err := app.models.SavedSearches.GetAllForUser(r.Context(), int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"saved_searches": searches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* With a webhook_url the response is the only time its signing secret is shown */
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var input createSavedSearchInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	search := &data.SavedSearch{
+		UserID:      int64(app.contextGetUser(r).ID),
+		Name:        input.Name,
+		Title:       input.Title,
+		Genres:      input.Genres,
+		YearGTE:     input.YearGTE,
+		YearLTE:     input.YearLTE,
+		NotifyEmail: input.NotifyEmail == nil || *input.NotifyEmail,
+		WebhookURL:  input.WebhookURL,
+	}
+
+	v := validator.New()
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.SavedSearches.Insert(r.Context(), search)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v%d/me/searches/%d", apiVersion(r), search.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"saved_search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	search, err := app.models.SavedSearches.Get(r.Context(), id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"saved_search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.SavedSearches.Delete(r.Context(), id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "saved search successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Runs the saved search, same as GET /v1/movies with its filters */
+func (app *application) listSavedSearchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var f data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	f.Page = app.readInt(qs, "page", 1, v)
+	f.PageSize = app.readInt(qs, "page_size", 20, v)
+	f.Sort = app.readString(qs, "sort", "id")
+	f.SortSafelist = movieSortSafelist
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	search, err := app.models.SavedSearches.Get(r.Context(), id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), search.MovieSearch(), f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"saved_search": search, "metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+Alerts the owners of the saved searches a new movie matches, by email and to
+their webhooks. A job looks them up so publishing the event stays quick; the
+alerts are jobs of their own, retried separately.
+*/
+func (app *application) alertSavedSearches(event events.Event) {
+	if event.Type != events.MovieCreated {
+		return
+	}
+
+	movie, ok := event.Data.(*data.Movie)
+	if !ok {
+		return
+	}
+
+	err := app.jobs.Enqueue("saved search alerts", func(ctx context.Context) error {
+		matches, err := app.models.SavedSearches.Matching(ctx, movie.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range matches {
+			if match.Search.NotifyEmail {
+				err = app.sendEmail(match.UserEmail, "saved_search_match.tmpl", map[string]any{
+					"userName":   match.UserName,
+					"searchName": match.Search.Name,
+					"movieID":    movie.ID,
+					"movieTitle": movie.Title,
+					"movieYear":  movie.Year,
+				})
+				if err != nil {
+					app.logger.Error(err.Error(), "saved_search_id", match.Search.ID)
+				}
+			}
+
+			if match.Search.WebhookURL != "" {
+				app.postSavedSearchAlert(match.Search, movie)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		app.logger.Error(err.Error(), "job", "saved search alerts")
+	}
+}
+
+/*
+Posts a saved_search.matched event to the search's webhook, signed with its
+secret the same way as the deliveries of global webhooks, see sendWebhook.
+*/
+func (app *application) postSavedSearchAlert(search *data.SavedSearch, movie *data.Movie) {
+	payload, err := json.Marshal(events.Event{
+		Type: savedSearchMatched,
+		Time: time.Now(),
+		Data: envelope{"saved_search": search.ID, "movie": movie},
+	})
+	if err != nil {
+		app.logger.Error(err.Error(), "saved_search_id", search.ID)
+		return
+	}
+
+	err = app.jobs.Enqueue("saved search webhook", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, search.WebhookURL, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Greenlight-Webhooks/"+version)
+		req.Header.Set("X-Greenlight-Event", savedSearchMatched)
+		req.Header.Set("X-Greenlight-Timestamp", timestamp)
+		req.Header.Set("X-Greenlight-Signature", "sha256="+signWebhook(search.WebhookSecret, timestamp, payload))
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return fmt.Errorf("saved search webhook responded %s", res.Status)
+		}
+
+		return nil
+	})
+	if err != nil {
+		app.logger.Error(err.Error(), "job", "saved search webhook")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/* Every seeded user signs in with it */
+const seedPassword = "pa55word"
+
+var (
+	seedTitleWords = [][]string{
+		{"The", "A", "Last", "Silent", "Broken", "Midnight", "Golden", "Lost", "Hidden", "Crimson", "Distant", "Final"},
+		{"Road", "River", "Empire", "Garden", "Signal", "Harbor", "Winter", "Frontier", "Kingdom", "Echo", "Island", "Machine"},
+		{"", "", "", "Returns", "Rising", "of Tomorrow", "in the Dark", "at Dawn", "Forever", "II"},
+	}
+	seedGenres     = []string{"action", "adventure", "animation", "comedy", "crime", "documentary", "drama", "fantasy", "horror", "romance", "sci-fi", "thriller", "western"}
+	seedFirstNames = []string{"Alice", "Bilal", "Chen", "Dana", "Emil", "Fatima", "Goran", "Hana", "Ivan", "Jonas", "Karin", "Leila", "Mateo", "Nora", "Omar", "Petra"}
+	seedLastNames  = []string{"Andersson", "Berg", "Costa", "Dahl", "Eriksson", "Farah", "Garcia", "Holm", "Ivanova", "Jansson", "Khan", "Lind", "Moreno", "Nilsson"}
+)
+
+/*
+Runs "seed [-movies N] [-users N] [-seed S] [-tokens FILE] [-tenant SLUG]", the
+arguments after the flags, e.g. "api -db-dsn=... seed -movies 1000". The same
+seed gives the same movies and users. Users are user1@example.com and up,
+rerunning skips those that exist; user1 is an admin, every fifth an editor, all
+of them viewers. They go to the default tenant unless -tenant names another.
+*/
+func seedCommand(models data.Models, args []string) (string, error) {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	movies := fs.Int("movies", 100, "Number of movies to create")
+	users := fs.Int("users", 10, "Number of users to create")
+	seed := fs.Uint64("seed", 1, "Seed of the generated data")
+	tokensFile := fs.String("tokens", "", "Write an authentication token for each user to this file, as email,token lines")
+	tenantSlug := fs.String("tenant", "", "Slug of the tenant to fill, see the tenants command")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return "", err
+	}
+
+	rng := rand.New(rand.NewPCG(*seed, *seed))
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	if *tenantSlug != "" {
+		tenant, err := models.Tenants.GetBySlug(ctx, *tenantSlug)
+		if err != nil {
+			return "", fmt.Errorf("tenant %q: %w", *tenantSlug, err)
+		}
+		ctx = data.WithTenant(ctx, tenant.ID)
+	}
+
+	var (
+		tokens  strings.Builder
+		created int
+	)
+
+	/* A seed that fails leaves nothing behind, it can be run again as it is */
+	err = models.WithTx(ctx, func(tx data.Models) error {
+		err := tx.Movies.InsertAll(ctx, seedMovies(rng, *movies))
+		if err != nil {
+			return err
+		}
+
+		created, err = seedUsers(ctx, tx, rng, *users, func(user *data.User) error {
+			if *tokensFile == "" {
+				return nil
+			}
+
+			/* From crypto/rand, tokens are the only thing the seed doesn't decide */
+			token, err := tx.Tokens.New(ctx, int64(user.ID), 30*24*time.Hour, data.ScopeAuthentication)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(&tokens, "%s,%s\n", user.Email, token.Plaintext)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if *tokensFile != "" {
+		err = os.WriteFile(*tokensFile, []byte(tokens.String()), 0o600)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("seeded %d movies and %d users", *movies, created), nil
+}
+
+func seedMovies(rng *rand.Rand, n int) []*data.Movie {
+	movies := make([]*data.Movie, n)
+
+	for i := range movies {
+		words := make([]string, 0, len(seedTitleWords))
+		for _, list := range seedTitleWords {
+			if word := list[rng.IntN(len(list))]; word != "" {
+				words = append(words, word)
+			}
+		}
+
+		genres := make([]string, 0, 3)
+		for _, j := range rng.Perm(len(seedGenres))[:1+rng.IntN(3)] {
+			genres = append(genres, seedGenres[j])
+		}
+
+		movies[i] = &data.Movie{
+			Title:   strings.Join(words, " "),
+			Year:    int32(1950 + rng.IntN(75)),
+			Runtime: data.Runtime(80 + rng.IntN(100)),
+			Genres:  genres,
+		}
+	}
+
+	return movies
+}
+
+/* Creates the users that don't exist yet and calls fn for every one of them, returns how many were created */
+func seedUsers(ctx context.Context, models data.Models, rng *rand.Rand, n int, fn func(*data.User) error) (int, error) {
+	/* bcrypt takes a while, every user gets the same hash */
+	var template data.User
+	err := template.Password.Set(seedPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+
+	for i := 1; i <= n; i++ {
+		user := &data.User{
+			Name:      seedFirstNames[rng.IntN(len(seedFirstNames))] + " " + seedLastNames[rng.IntN(len(seedLastNames))],
+			Email:     fmt.Sprintf("user%d@example.com", i),
+			Password:  template.Password,
+			Activated: true,
+		}
+
+		err = models.Users.Insert(ctx, user)
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			user, err = models.Users.GetByEmail(ctx, user.Email)
+			if err != nil {
+				return created, err
+			}
+		case err != nil:
+			return created, err
+		default:
+			created++
+
+			roles := []string{data.RoleViewer}
+			if i%5 == 0 {
+				roles = append(roles, data.RoleEditor)
+			}
+			if i == 1 {
+				roles = append(roles, data.RoleAdmin)
+			}
+
+			for _, role := range roles {
+				_, err = models.Roles.AddForUser(ctx, int64(user.ID), role)
+				if err != nil {
+					return created, err
+				}
+			}
+		}
+
+		err = fn(user)
+		if err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
@@ -18,10 +18,94 @@ func (app *application) serve() error {
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
 		Handler:      app.routes(),
-		/* Tell http.Server to communicate logs through our logger which implements io.Writer interface */
-		// ErrorLog: log.New(logger, "", 0),
+		/* HTTP/2 is negotiated over TLS by ALPN, ListenAndServeTLS adds "h2" */
+		TLSConfig: app.tlsConfig,
+		ErrorLog:  app.serverErrorLog("api"),
 	}
 
+	/* Ends the event streams, Shutdown() would otherwise wait for them until it times out */
+	server.RegisterOnShutdown(app.events.Close)
+	/* Closes the notification connections, which serve waits for through app.wg */
+	server.RegisterOnShutdown(app.notifications.Close)
+
+	/* gRPC for internal consumers, plain HTTP/2 (h2c) on its own port */
+	var grpcServer *http.Server
+	if app.config.grpc.port != 0 {
+		grpcServer = &http.Server{
+			Addr:        fmt.Sprintf(":%d", app.config.grpc.port),
+			ReadTimeout: 5 * time.Second,
+			IdleTimeout: 60 * time.Second,
+			Handler:     app.resolveTenant(app.grpcServer()),
+			ErrorLog:    app.serverErrorLog("grpc"),
+			Protocols:   new(http.Protocols),
+		}
+		grpcServer.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	/* Redirects plain HTTP to HTTPS, and answers the ACME challenges */
+	var redirectServer *http.Server
+	if app.tlsConfig != nil && app.config.tls.redirectPort != 0 {
+		redirectServer = &http.Server{
+			Addr:         fmt.Sprintf(":%d", app.config.tls.redirectPort),
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  60 * time.Second,
+			Handler:      app.redirectHandler(),
+			ErrorLog:     app.serverErrorLog("redirect"),
+		}
+	}
+
+	/* Stops the webhook and outbox dispatchers and the token and export cleanups on shutdown */
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	defer stopDispatcher()
+
+	app.wg.Add(4)
+	go func() {
+		defer app.wg.Done()
+		app.runWebhookDispatcher(dispatcherCtx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.runOutboxDispatcher(dispatcherCtx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.runTokenCleanup(dispatcherCtx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.runExportCleanup(dispatcherCtx)
+	}()
+
+	if app.acme != nil {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.acme.Run(dispatcherCtx, func(host string, err error) {
+				app.logger.Error(err.Error(), "acme_host", host)
+			})
+		}()
+	}
+
+	/* SIGHUP reloads the settings that can change without a restart, see reloadConfig */
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for {
+			select {
+			case <-dispatcherCtx.Done():
+				signal.Stop(hup)
+				return
+			case <-hup:
+				err := app.reloadConfig()
+				if err != nil {
+					app.logger.Error(err.Error(), "signal", "hangup")
+				}
+			}
+		}
+	}()
+
 	// Channel to receive any errors returned by graceful Shutdown()
 	shutdownError := make(chan error)
 
@@ -37,35 +121,73 @@ func (app *application) serve() error {
 		// This code will block until a signal is received.
 		s := <-quit
 
-		app.logger.Info("shutting down server", map[string]string{
-			"signal": s.String(),
-		})
+		app.logger.Info("shutting down server", "signal", s.String())
 
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
-		err := server.Shutdown(ctx)
-		if err != nil {
-			// Only send on the shutdownError channel if there's an error
-			shutdownError <- err
+		for _, srv := range []*http.Server{grpcServer, redirectServer} {
+			if srv == nil {
+				continue
+			}
+
+			err := srv.Shutdown(ctx)
+			if err != nil {
+				app.logger.Error(err.Error(), "addr", srv.Addr)
+			}
 		}
 
-		app.logger.Info("completing background tasks", map[string]string{
-			"addr": server.Addr,
-		})
+		err := server.Shutdown(ctx)
+
+		/* Even if Shutdown() timed out, let emails and the like finish before the process exits */
+		app.logger.Info("completing background tasks", "addr", server.Addr)
 
+		stopDispatcher()
 		app.wg.Wait()
-		shutdownError <- nil
+
+		/* Queued jobs get whatever is left of the 20 seconds */
+		jobsErr := app.jobs.Shutdown(ctx)
+		if jobsErr != nil {
+			app.logger.Error(jobsErr.Error(), "jobs", "not drained")
+		}
+
+		// Send the result of Shutdown(), nil if it went well
+		shutdownError <- err
 	}()
 
-	app.logger.Info("Starting server", map[string]string{
-		"addr": server.Addr,
-		"env":  app.config.env,
-	})
+	app.logger.Info("Starting server", "addr", server.Addr, "env", app.config.env, "tls", app.tlsConfig != nil)
+
+	if grpcServer != nil {
+		app.logger.Info("Starting gRPC server", "addr", grpcServer.Addr)
+
+		go func() {
+			err := grpcServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal(app.logger, err)
+			}
+		}()
+	}
+
+	if redirectServer != nil {
+		app.logger.Info("Starting HTTP redirect server", "addr", redirectServer.Addr)
+
+		go func() {
+			err := redirectServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal(app.logger, err)
+			}
+		}()
+	}
 
 	// Calling Shutdown() will cause server.ListenAndServe() to return http.ErrServerClosed,
 	// if it does then continue execution to handle graceful shutdown otherwise simply return error
-	err := server.ListenAndServe()
+	var err error
+	if app.tlsConfig != nil {
+		/* The certificates are in TLSConfig */
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
 	if err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
 			return err
@@ -79,9 +201,7 @@ func (app *application) serve() error {
 		return err
 	}
 
-	app.logger.Info("stopped server", map[string]string{
-		"addr": server.Addr,
-	})
+	app.logger.Info("stopped server", "addr", server.Addr)
 
 	return nil
 }
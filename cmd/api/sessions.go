@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/* The user's authentication tokens, JWTs aren't stored so they aren't listed */
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(r.Context(), int64(app.contextGetUser(r).ID), bearerToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Revoking the current session signs the request's client out */
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteSession(r.Context(), id, int64(app.contextGetUser(r).ID))
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Signs the user out everywhere, the current session and refresh tokens included */
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/mohafarman/greenlight/internal/cache"
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/* Stats are cached even with -cache-store=none, the queries scan whole tables */
+func openStatsCache(c cache.Cache) cache.Cache {
+	if c == nil {
+		return cache.NewMemory(100)
+	}
+	return c
+}
+
+/*
+Figures about the catalogue and its users, of the tenant of the request. They
+are computed at most once every -stats-cache-ttl per tenant, GeneratedAt tells
+how old they are; errors of the cache are ignored like the movie cache's.
+*/
+func (app *application) showStatsHandler(w http.ResponseWriter, r *http.Request) {
+	key := "stats:" + strconv.FormatInt(data.TenantID(r.Context()), 10)
+
+	var stats *data.Stats
+
+	if app.config.stats.cacheTTL > 0 {
+		value, found, err := app.statsCache.Get(r.Context(), key)
+		if err == nil && found {
+			json.Unmarshal(value, &stats)
+		}
+	}
+
+	if stats == nil {
+		var err error
+
+		stats, err = app.models.Stats.Get(r.Context())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if app.config.stats.cacheTTL > 0 {
+			if value, err := json.Marshal(stats); err == nil {
+				app.statsCache.Set(r.Context(), key, value, app.config.stats.cacheTTL)
+			}
+		}
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+/*
+Writes a JSON object whose first member is an array filled one element at a time,
+e.g. {"movies":[{...},{...}],"metadata":{...}}, so list responses can be sent
+while the rows are still being read from the database.
+*/
+type jsonArrayStream struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+/* Writes the status, headers and the opening of the object up to the array */
+func (app *application) startJSONArrayStream(w http.ResponseWriter, status int, key string, headers http.Header) (*jsonArrayStream, error) {
+	for k, v := range headers {
+		w.Header()[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	name, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.WriteString(w, "{"+string(name)+":[\n")
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonArrayStream{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+/* Appends v to the array, each element on its own line */
+func (s *jsonArrayStream) Write(v any) error {
+	if s.count > 0 {
+		_, err := io.WriteString(s.w, ",")
+		if err != nil {
+			return err
+		}
+	}
+
+	s.count++
+	return s.enc.Encode(v)
+}
+
+/* Closes the array and adds the remaining members, e.g. the metadata known only at the end */
+func (s *jsonArrayStream) Close(trailer envelope) error {
+	_, err := io.WriteString(s.w, "]")
+	if err != nil {
+		return err
+	}
+
+	for key, value := range trailer {
+		name, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(s.w, ","+string(name)+":")
+		if err != nil {
+			return err
+		}
+
+		err = s.enc.Encode(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(s.w, "}\n")
+	return err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+func TestJSONArrayStream(t *testing.T) {
+	app := &application{}
+	movies := benchmarkMovies(3)
+	metadata := data.Metadata{CurrentPage: 1, PageSize: 3, TotalRecords: 3}
+
+	for _, n := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("movies=%d", n), func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			stream, err := app.startJSONArrayStream(rr, http.StatusOK, "movies", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, movie := range movies[:n] {
+				err = stream.Write(movie)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = stream.Close(envelope{"metadata": metadata})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got struct {
+				Movies   []data.Movie  `json:"movies"`
+				Metadata data.Metadata `json:"metadata"`
+			}
+			err = json.Unmarshal(rr.Body.Bytes(), &got)
+			if err != nil {
+				t.Fatalf("invalid JSON %q: %v", rr.Body.String(), err)
+			}
+
+			if len(got.Movies) != n {
+				t.Errorf("got %d movies; want %d", len(got.Movies), n)
+			}
+			if got.Metadata != metadata {
+				t.Errorf("got metadata %+v; want %+v", got.Metadata, metadata)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q; want application/json", ct)
+			}
+		})
+	}
+}
+
+/* The buffered writeJSON against the stream, on lists the size of a large /v1/movies page */
+func BenchmarkMovieList(b *testing.B) {
+	movies := benchmarkMovies(5000)
+	metadata := data.Metadata{CurrentPage: 1, PageSize: len(movies), TotalRecords: len(movies)}
+
+	for _, n := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("buffered/movies=%d", n), func(b *testing.B) {
+			app := &application{config: config{env: "production"}}
+			env := envelope{"movies": movies[:n], "metadata": metadata}
+			w := newDiscardResponseWriter()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				err := app.writeJSON(w, http.StatusOK, env, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("streamed/movies=%d", n), func(b *testing.B) {
+			app := &application{config: config{env: "production"}}
+			w := newDiscardResponseWriter()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				stream, err := app.startJSONArrayStream(w, http.StatusOK, "movies", nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, movie := range movies[:n] {
+					err = stream.Write(movie)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				err = stream.Close(envelope{"metadata": metadata})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+/* Peak memory: the buffered response holds the whole body at once, the stream a row */
+func BenchmarkMovieListBody(b *testing.B) {
+	movies := benchmarkMovies(5000)
+
+	b.Run("buffered", func(b *testing.B) {
+		app := &application{config: config{env: "production"}}
+		env := envelope{"movies": movies}
+
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			rr := httptest.NewRecorder()
+			err := app.writeJSON(rr, http.StatusOK, env, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(rr.Body.Len()))
+		}
+	})
+
+	b.Run("streamed", func(b *testing.B) {
+		app := &application{config: config{env: "production"}}
+		var body bytes.Buffer
+
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			body.Reset()
+			w := httptest.NewRecorder()
+			w.Body = &body
+			stream, err := app.startJSONArrayStream(w, http.StatusOK, "movies", nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, movie := range movies {
+				err = stream.Write(movie)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			err = stream.Close(nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(body.Len()))
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/*
+Runs "tenants list", "tenants create SLUG NAME" or "tenants quota SLUG N|default",
+the arguments after the flags, e.g. "api -db-dsn=... tenants create acme 'Acme
+Pictures'". The quota is the tenant's monthly requests, see enforceQuota. Tenants
+are set up with the deployment rather than through the API, roles are shared
+by all of them and an admin of one would be an admin of every one.
+*/
+func tenantsCommand(models data.Models, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: tenants list|create SLUG NAME|quota SLUG N|default")
+	}
+
+	switch args[0] {
+	case "list":
+		tenants, err := models.Tenants.GetAll(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		var list strings.Builder
+		for _, tenant := range tenants {
+			fmt.Fprintf(&list, "\n%d\t%s\t%s", tenant.ID, tenant.Slug, tenant.Name)
+		}
+		return fmt.Sprintf("%d tenants%s", len(tenants), list.String()), nil
+
+	case "create":
+		if len(args) != 3 {
+			return "", fmt.Errorf("usage: tenants create SLUG NAME")
+		}
+
+		tenant := &data.Tenant{Slug: args[1], Name: args[2]}
+
+		v := validator.New()
+		if data.ValidateTenant(v, tenant); !v.Valid() {
+			return "", v.Err()
+		}
+
+		err := models.Tenants.Insert(ctx, tenant)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("created tenant %d %s", tenant.ID, tenant.Slug), nil
+
+	case "quota":
+		if len(args) != 3 {
+			return "", fmt.Errorf("usage: tenants quota SLUG N|default")
+		}
+
+		var quota *int64
+		if args[2] != "default" {
+			n, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("invalid quota %q, must be a number of requests or default", args[2])
+			}
+			quota = &n
+		}
+
+		err := models.Usage.SetTenantQuota(ctx, args[1], quota)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("set the quota of tenant %s to %s", args[1], args[2]), nil
+
+	default:
+		return "", fmt.Errorf("unknown tenants command %q, must be list, create or quota", args[0])
+	}
+}
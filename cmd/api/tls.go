@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/mohafarman/greenlight/internal/acme"
+)
+
+/*
+The TLS configuration of the API server, nil to serve plain HTTP. Certificates
+come from -tls-cert/-tls-key, or from the ACME CA for -tls-autocert-hosts, in
+which case the returned manager answers its challenges on the redirect listener.
+*/
+func openTLS(cfg config) (*tls.Config, *acme.Manager, error) {
+	manual := cfg.tls.certFile != "" || cfg.tls.keyFile != ""
+	auto := len(cfg.tls.autocertHosts) > 0
+
+	switch {
+	case !manual && !auto:
+		return nil, nil, nil
+	case manual && auto:
+		return nil, nil, errors.New("-tls-cert/-tls-key and -tls-autocert-hosts are mutually exclusive")
+	case manual && (cfg.tls.certFile == "" || cfg.tls.keyFile == ""):
+		return nil, nil, errors.New("-tls-cert and -tls-key must be set together")
+	case auto && cfg.tls.redirectPort == 0:
+		return nil, nil, errors.New("-tls-autocert-hosts needs -http-redirect-port, usually 80, for the CA to validate the hosts")
+	}
+
+	/* TLS 1.3 only, its cipher suites are all fine and aren't configurable anyway */
+	tlsConfig := &tls.Config{
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256},
+	}
+
+	if manual {
+		cert, err := tls.LoadX509KeyPair(cfg.tls.certFile, cfg.tls.keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		return tlsConfig, nil, nil
+	}
+
+	manager, err := acme.NewManager(cfg.tls.acmeDirectory, cfg.tls.autocertEmail, cfg.tls.autocertDir, cfg.tls.autocertHosts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig.GetCertificate = manager.GetCertificate
+	return tlsConfig, manager, nil
+}
+
+/* Served on -http-redirect-port, the ACME challenges go to the manager */
+func (app *application) redirectHandler() http.Handler {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if app.config.port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+		}
+
+		/* 308 rather than 301 so clients repeat a POST as a POST */
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+
+	if app.acme != nil {
+		return app.acme.HTTPHandler(redirect)
+	}
+
+	return redirect
+}
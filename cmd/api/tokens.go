@@ -9,13 +9,30 @@ import (
 	"github.com/mohafarman/greenlight/internal/validator"
 )
 
+type createAuthenticationTokenInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	/* The second step for users with 2FA enabled, either of them */
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type createActivationTokenInput struct {
+	Email string `json:"email"`
+}
+
+type refreshTokenInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type createPasswordResetTokenInput struct {
+	Email string `json:"email"`
+}
+
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var input createAuthenticationTokenInput
 
-	err := app.readJSON(w, r, &input)
+	err := app.readBody(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -33,7 +50,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	var user *data.User
 
-	user, err = app.models.Users.GetByEmail(input.Email)
+	user, err = app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -47,6 +64,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	match, err := user.Password.Match(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	if !match {
@@ -54,12 +72,217 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	token, err := app.models.Tokens.New(int64(user.ID), 24*time.Hour, "authentication")
+	err = app.checkSecondFactor(r.Context(), int64(user.ID), input.TOTPCode, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, errTwoFactorRequired):
+			app.twoFactorRequiredResponse(w, r)
+		case errors.Is(err, errInvalidTwoFactor):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeLoginTokens(w, r, user)
+}
+
+/* Ends a login, with a password or an OAuth provider, with a new refresh token and authentication token */
+func (app *application) writeLoginTokens(w http.ResponseWriter, r *http.Request, user *data.User) {
+	refreshToken, err := app.models.Tokens.NewRefresh(r.Context(), int64(user.ID), app.config.auth.refreshTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.newAuthenticationToken(r.Context(), user, refreshToken.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/*
+Exchanges a refresh token for a new authentication token and a new refresh
+token, the old one can't be used again. If it is, someone else has a copy, see
+TokenModel.Rotate.
+*/
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input refreshTokenInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.CheckField(input.RefreshToken != "", "refresh_token", validator.Message("validation.required"))
+	v.CheckField(len(input.RefreshToken) == 26, "refresh_token", validator.Message("validation.bytes", 26))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	refreshToken, err := app.models.Tokens.Rotate(r.Context(), input.RefreshToken, app.config.auth.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("refresh_token", validator.Message("validation.invalid_refresh_token"))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			/* Told apart from an invalid token in the logs only */
+			app.logError(r, err)
+			v.AddError("refresh_token", validator.Message("validation.invalid_refresh_token"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	/* Found by the new token, a user deleted since takes their tokens with them */
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeRefresh, refreshToken.Plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("refresh_token", validator.Message("validation.invalid_refresh_token"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.newAuthenticationToken(r.Context(), user, refreshToken.Family)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Sends a new activation token, for users whose welcome email was lost or expired */
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input createActivationTokenInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", validator.Message("validation.email_not_found"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", validator.Message("validation.already_activated"))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), int64(user.ID), 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.sendEmail(user.Email, "token_activation.tmpl", map[string]any{
+		"activationToken": token.Plaintext,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Emails a password reset token, valid for 45 minutes, to an activated user */
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input createPasswordResetTokenInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", validator.Message("validation.email_not_found"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		v.AddError("email", validator.Message("validation.not_activated"))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), int64(user.ID), 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.sendEmail(user.Email, "token_password_reset.tmpl", map[string]any{
+		"passwordResetToken": token.Plaintext,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
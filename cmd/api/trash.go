@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* deleted_at is when the movie was moved to the trash */
+var trashSortSafelist = []string{"deleted_at", "id", "title", "-deleted_at", "-id", "-title"}
+
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var f data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	f.Page = app.readInt(qs, "page", 1, v)
+	f.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	/* Most recently deleted first by default */
+	f.Sort = app.readString(qs, "sort", "-deleted_at")
+	f.SortSafelist = trashSortSafelist
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetDeleted(r.Context(), f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	/* Back in the catalogue, to subscribers it is as good as new */
+	app.publishEvent(r.Context(), events.MovieCreated, movie)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag(movie.Version))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Only movies in the trash can be purged, so a movie is never lost by one request */
+func (app *application) purgeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	posterKey, err := app.models.Movies.Purge(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	if posterKey != "" {
+		app.deletePoster(posterKey)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie permanently deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/totp"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* Shown by authenticator apps above the account */
+const totpIssuer = "Greenlight"
+
+var (
+	errTwoFactorRequired = errors.New("two-factor code required")
+	errInvalidTwoFactor  = errors.New("invalid two-factor code")
+)
+
+type verifyTwoFactorInput struct {
+	Code string `json:"code"`
+}
+
+type disableTwoFactorInput struct {
+	Password string `json:"password"`
+	/* Either the current code or one of the recovery codes */
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type twoFactorEnrollment struct {
+	OTPAuthURI string `json:"otpauth_uri"`
+	/* For typing in when the QR code of the URI can't be scanned */
+	Secret        string   `json:"secret"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+/* The cipher for -totp-key, nil leaves 2FA unavailable */
+func openTOTPCipher(cfg config) (*totp.Cipher, error) {
+	if cfg.auth.totpKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.auth.totpKey)
+	if err != nil {
+		return nil, errors.New("invalid -totp-key, must be base64 encoded")
+	}
+
+	return totp.NewCipher(key)
+}
+
+/*
+The second step of a login, nil when the user hasn't enabled 2FA or the code is
+right. A TOTP code is only accepted once, a recovery code is used up.
+*/
+func (app *application) checkSecondFactor(ctx context.Context, userID int64, code, recoveryCode string) error {
+	tf, err := app.models.TwoFactor.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !tf.Enabled {
+		return nil
+	}
+
+	switch {
+	case code != "":
+		if app.totpCipher == nil {
+			return errors.New("two-factor authentication is enabled for the user but -totp-key isn't set")
+		}
+
+		secret, err := app.totpCipher.Open(tf.Secret)
+		if err != nil {
+			return err
+		}
+
+		step, ok := totp.Verify(secret, code, time.Now())
+		if !ok {
+			return errInvalidTwoFactor
+		}
+
+		fresh, err := app.models.TwoFactor.UseStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errInvalidTwoFactor
+		}
+	case recoveryCode != "":
+		ok, err := app.models.TwoFactor.UseRecoveryCode(ctx, userID, recoveryCode)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidTwoFactor
+		}
+	default:
+		return errTwoFactorRequired
+	}
+
+	return nil
+}
+
+/*
+Generates a new secret and recovery codes, 2FA is only enabled once a code from
+the app is verified. Enrolling again before that replaces both.
+*/
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	/* As if the routes didn't exist without -totp-key */
+	if app.totpCipher == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sealed, err := app.totpCipher.Seal(secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.models.TwoFactor.Enroll(r.Context(), int64(user.ID), sealed)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("two_factor", validator.Message("validation.two_factor_enroll_again"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	enrollment := twoFactorEnrollment{
+		OTPAuthURI:    totp.URI(totpIssuer, user.Email, secret),
+		Secret:        totp.EncodeSecret(secret),
+		RecoveryCodes: codes,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"two_factor": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	if app.totpCipher == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input verifyTwoFactorInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID := int64(app.contextGetUser(r).ID)
+
+	tf, err := app.models.TwoFactor.Get(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case tf.Enabled:
+		v.AddError("two_factor", validator.Message("validation.two_factor_enabled"))
+	case tf.Secret == nil:
+		v.AddError("two_factor", validator.Message("validation.two_factor_not_enrolled"))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := app.totpCipher.Open(tf.Secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	step, ok := totp.Verify(secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", validator.Message("validation.invalid_code"))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TwoFactor.Enable(r.Context(), userID, step)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully enabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Takes the password and a second factor, a stolen token alone can't turn 2FA off */
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.requireUserCredentials(w, r) {
+		return
+	}
+
+	var input disableTwoFactorInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePassword(v, input.Password)
+	v.CheckGroup(validator.ExactlyOne(input.Code != "", input.RecoveryCode != ""), validator.Message("validation.exactly_one", "code, recovery_code"), "code", "recovery_code")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	/* The user of a JWT has no password hash, it is looked up again */
+	user, err := app.models.Users.GetByEmail(r.Context(), app.contextGetUser(r).Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Match(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	tf, err := app.models.TwoFactor.Get(r.Context(), int64(user.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !tf.Enabled && tf.Secret == nil {
+		v.AddError("two_factor", validator.Message("validation.two_factor_not_enabled"))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.checkSecondFactor(r.Context(), int64(user.ID), input.Code, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidTwoFactor):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.TwoFactor.Disable(r.Context(), int64(user.ID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -9,14 +9,25 @@ import (
 	"github.com/mohafarman/greenlight/internal/validator"
 )
 
+type registerUserInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type activateUserInput struct {
+	TokenPlaintext string `json:"token"`
+}
+
+type updateUserPasswordInput struct {
+	Password       string `json:"password"`
+	TokenPlaintext string `json:"token"`
+}
+
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var input registerUserInput
 
-	err := app.readJSON(w, r, &input)
+	err := app.readBody(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -41,72 +52,95 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email already exists")
-			app.failedValidationResponse(w, r, v.Errors)
-		default:
-			app.serverErrorResponse(w, r, err)
+	/* The user, their activation token and the welcome email are committed together.
+	   A duplicate email comes back as a *validator.ValidationError */
+	err = app.models.Users.Register(r.Context(), user, 3*24*time.Hour, func(token *data.Token) data.EmailMessage {
+		return data.EmailMessage{
+			Recipient: user.Email,
+			Template:  "user_welcome.tmpl",
+			Data: map[string]any{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+			},
 		}
+	})
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
 		return
 	}
 
-	/* Give movies:read permission to new user */
-	err = app.models.Permissions.AddForUser(int64(user.ID), "movies:read")
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
+}
 
-	/* After record has been inserted in db create an activation code */
-	token, err := app.models.Tokens.New(int64(user.ID), 3*24*time.Hour, data.ScopeActivation)
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input activateUserInput
+
+	err := app.readBody(w, r, &input)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.Error(err, nil)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", validator.Message("validation.invalid_activation_token"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
 		}
-	})
+		return
+	}
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	/* Also deletes the user's activation tokens and queues the user.activated webhooks */
+	err = app.models.Users.Activate(r.Context(), user)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	/* send updated info to client */
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		TokenPlaintext string `json:"token"`
-	}
+/* Sets a new password with a password reset token, signing the user out everywhere */
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input updateUserPasswordInput
 
-	err := app.readJSON(w, r, &input)
+	err := app.readBody(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	v := validator.New()
-	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+
+	data.ValidateNewPassword(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
+			v.AddError("token", validator.Message("validation.invalid_reset_token"))
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -114,27 +148,28 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user.Activated = true
-
-	err = app.models.Users.Update(user)
+	err = user.Password.Set(input.Password)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	/* If all is successfull then delete all activation tokens for the user */
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.modelErrorResponse(w, r, err)
+		return
 	}
 
-	/* send updated info to client */
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	/* The reset token is used up, and whoever had the old password is signed out */
+	for _, scope := range []string{data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh} {
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
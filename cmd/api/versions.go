@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/*
+Versions of the API. Every /v1 route is also served under /v2, through the same
+middleware, by its v2 handler or else the /v1 one. The handlers tell the
+versions apart with apiVersion, which the response helpers use for the
+per-version JSON shapes:
+  - runtimes are written as 107 rather than "107 mins", see runtimeFormat
+  - lists carry metadata even when they aren't paginated, see withDefaultMetadata
+*/
+const apiVersionContextKey = contextKey("api_version")
+
+/* 1 for requests that didn't go through a versioned route, e.g. GraphQL */
+func apiVersion(r *http.Request) int {
+	version, ok := r.Context().Value(apiVersionContextKey).(int)
+	if !ok {
+		return 1
+	}
+
+	return version
+}
+
+func withAPIVersion(version int, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), apiVersionContextKey, version)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+/* Adds the /v2 routes after the /v1 ones they are cloned from */
+func (app *application) versionedRoutes(routes []route) []route {
+	versioned := make([]route, 0, 2*len(routes))
+
+	for _, rt := range routes {
+		v1 := rt
+		v1.handler = withAPIVersion(1, app.deprecateV1(rt.handler))
+		versioned = append(versioned, v1)
+	}
+
+	for _, rt := range routes {
+		rest, ok := strings.CutPrefix(rt.path, "/v1/")
+		if !ok {
+			continue
+		}
+
+		v2 := rt
+		v2.base = rt.path
+		v2.path = "/v2/" + rest
+		v2.id = rt.id + "V2"
+		if rt.v2 != nil {
+			v2.handler = rt.v2
+		}
+		v2.handler = withAPIVersion(2, v2.handler)
+		versioned = append(versioned, v2)
+	}
+
+	return versioned
+}
+
+/*
+Announces the retirement of /v1 set by -v1-deprecated-at and -v1-sunset-at:
+a Deprecation header (RFC 9745) from the first date, a Sunset header (RFC 8594)
+as soon as the second one is set, both with a link to the /v2 route. Past the
+sunset /v1 answers 410 Gone.
+*/
+func (app *application) deprecateV1(next http.HandlerFunc) http.HandlerFunc {
+	deprecatedAt, sunsetAt := app.config.versions.v1DeprecatedAt, app.config.versions.v1SunsetAt
+
+	if deprecatedAt.IsZero() && sunsetAt.IsZero() {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+
+		if !deprecatedAt.IsZero() && !now.Before(deprecatedAt) {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+		}
+		if !sunsetAt.IsZero() {
+			w.Header().Set("Sunset", sunsetAt.UTC().Format(http.TimeFormat))
+		}
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/v1/"); ok {
+			w.Header().Add("Link", `</v2/`+rest+`>; rel="successor-version"`)
+		}
+
+		if !sunsetAt.IsZero() && !now.Before(sunsetAt) {
+			app.versionRetiredResponse(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+/*
+From /v2 on, lists come with their metadata, the first and only page of a list
+that isn't paginated. Envelopes with metadata, or with more or less than one
+list, are left alone.
+*/
+func withDefaultMetadata(env envelope) envelope {
+	if _, ok := env["metadata"]; ok {
+		return env
+	}
+
+	count, lists := 0, 0
+	for _, value := range env {
+		v := reflect.ValueOf(value)
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+			count = v.Len()
+			lists++
+		}
+	}
+
+	if lists != 1 {
+		return env
+	}
+
+	metadata := data.Metadata{}
+	if count > 0 {
+		metadata = data.Metadata{CurrentPage: 1, PageSize: count, FirstPage: 1, LastPage: 1, TotalRecords: count}
+	}
+
+	withMetadata := make(envelope, len(env)+1)
+	for key, value := range env {
+		withMetadata[key] = value
+	}
+	withMetadata["metadata"] = metadata
+
+	return withMetadata
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+type addToWatchlistInput struct {
+	MovieID int64 `json:"movie_id"`
+}
+
+/* added_at is when the movie was put on the watchlist */
+var watchlistSortSafelist = []string{"added_at", "id", "title", "year", "runtime", "-added_at", "-id", "-title", "-year", "-runtime"}
+
+/* 201 when the movie is added, 200 when it already was on the watchlist */
+func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input addToWatchlistInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.CheckField(input.MovieID > 0, "movie_id", validator.Message("validation.positive_integer"))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", validator.Message("validation.existing_movie"))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	added, err := app.models.Watchlists.Add(r.Context(), int64(app.contextGetUser(r).ID), movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+
+	err = app.writeResponse(w, r, status, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Watchlists.Remove(r.Context(), int64(app.contextGetUser(r).ID), movieID)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var f data.Filters
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	f.Page = app.readInt(qs, "page", 1, v)
+	f.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	/* Most recently added first by default */
+	f.Sort = app.readString(qs, "sort", "-added_at")
+	f.SortSafelist = watchlistSortSafelist
+
+	if data.ValidateFilters(v, f); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Watchlists.GetAll(r.Context(), int64(app.contextGetUser(r).ID), f)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+	"github.com/mohafarman/greenlight/internal/events"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+const (
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 20
+	webhookTimeout      = 10 * time.Second
+	/* Deliveries are given up after this many attempts, about 4 hours with the backoff below */
+	webhookMaxAttempts = 10
+)
+
+type createWebhookInput struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	/* Signs the deliveries, a random one is generated when left out */
+	Secret *string `json:"secret"`
+}
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input createWebhookInput
+
+	err := app.readBody(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:        input.URL,
+		EventTypes: input.EventTypes,
+	}
+
+	v := validator.New()
+	if input.Secret != nil {
+		webhook.Secret = *input.Secret
+		data.ValidateWebhookSecret(v, webhook.Secret)
+	}
+
+	if data.ValidateWebhook(v, webhook, events.Types); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(r.Context(), webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v%d/webhooks/%d", apiVersion(r), webhook.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(r.Context(), id)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* The most recent delivery attempts of a webhook, ?limit= up to 100 */
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	limit := app.readInt(r.URL.Query(), "limit", 20, v)
+	v.CheckField(validator.Between(limit, 1, 100), "limit", validator.Message("validation.between", 1, 100))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, err := app.models.Webhooks.GetDeliveries(r.Context(), id, limit)
+	if err != nil {
+		app.modelErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+/* Queues a delivery for every webhook of the event's tenant subscribed to its type */
+func (app *application) enqueueWebhooks(event events.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		app.logger.Error(err.Error(), "event_type", event.Type)
+		return
+	}
+
+	err = app.models.Webhooks.Enqueue(data.WithTenant(context.Background(), event.Tenant), event.Type, payload)
+	if err != nil {
+		app.logger.Error(err.Error(), "event_type", event.Type)
+	}
+}
+
+/* Polls for due deliveries until ctx is cancelled */
+func (app *application) runWebhookDispatcher(ctx context.Context) {
+	client := &http.Client{Timeout: webhookTimeout}
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		/* Lease long enough to cover a whole batch of timed out requests */
+		deliveries, err := app.models.Webhooks.ClaimDue(ctx, webhookBatchSize, webhookBatchSize*webhookTimeout+time.Minute)
+		if err != nil {
+			app.logger.Error(err.Error())
+			continue
+		}
+
+		for _, delivery := range deliveries {
+			app.deliverWebhook(ctx, client, delivery)
+		}
+	}
+}
+
+func (app *application) deliverWebhook(ctx context.Context, client *http.Client, delivery *data.WebhookDelivery) {
+	now := time.Now()
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = nil
+	delivery.LastError = nil
+
+	status, err := app.sendWebhook(client, delivery, now)
+
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		delivery.Status = data.DeliverySucceeded
+	case delivery.Attempts >= webhookMaxAttempts:
+		delivery.Status = data.DeliveryFailed
+	default:
+		delivery.NextAttemptAt = now.Add(webhookBackoff(delivery.Attempts))
+	}
+
+	if status != 0 {
+		delivery.ResponseStatus = &status
+	}
+
+	if err != nil {
+		message := err.Error()
+		delivery.LastError = &message
+	}
+
+	err = app.models.Webhooks.RecordAttempt(ctx, delivery)
+	if err != nil {
+		app.logger.Error(err.Error(), "delivery_id", delivery.ID)
+	}
+}
+
+/*
+Posts the payload, signed with the webhook's secret. Receivers verify it by
+computing HMAC-SHA256 over "<X-Greenlight-Timestamp>.<body>" and comparing it
+with X-Greenlight-Signature, and reject stale timestamps to prevent replays.
+*/
+func (app *application) sendWebhook(client *http.Client, delivery *data.WebhookDelivery, now time.Time) (int, error) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhooks/"+version)
+	req.Header.Set("X-Greenlight-Event", delivery.EventType)
+	req.Header.Set("X-Greenlight-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Greenlight-Timestamp", timestamp)
+	req.Header.Set("X-Greenlight-Signature", "sha256="+signWebhook(delivery.Secret, timestamp, delivery.Payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	/* Drain a little so the connection can be reused */
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	return res.StatusCode, nil
+}
+
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/* 30s, 1m, 2m, 4m ... capped at 6 hours */
+func webhookBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second << (attempts - 1)
+	if backoff > 6*time.Hour || backoff <= 0 {
+		return 6 * time.Hour
+	}
+
+	return backoff
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+/*
+encoding/xml can't marshal maps, so the envelope writes itself as a <response>
+element with one child per key (in sorted order for stable output). Nested maps
+and slices are expanded the same way, struct values use their own xml tags.
+*/
+func (env envelope) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "response"}
+	return encodeXMLMap(e, start, reflect.ValueOf(map[string]any(env)))
+}
+
+/* Wraps a value returned without an envelope, e.g. a movie becomes <movie>, a list <response> */
+type xmlRoot struct {
+	value any
+}
+
+func (root xmlRoot) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	v := reflect.ValueOf(root.value)
+
+	name := "response"
+	if k := reflect.Indirect(v).Kind(); k != reflect.Slice && k != reflect.Array && k != reflect.Map {
+		name = xmlItemName(v)
+	}
+
+	return encodeXMLValue(e, name, v)
+}
+
+func encodeXMLValue(e *xml.Encoder, name string, v reflect.Value) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+
+		/* Types with their own MarshalXML (e.g. data.Runtime) are left to encoding/xml */
+		if v.Type().Implements(xmlMarshalerType) {
+			break
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		return encodeXMLMap(e, start, v)
+
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return e.EncodeElement(v.Interface(), start)
+		}
+
+		err := e.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+
+		for i := range v.Len() {
+			err = encodeXMLValue(e, xmlItemName(v.Index(i)), v.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+
+		return e.EncodeToken(start.End())
+
+	default:
+		return e.EncodeElement(v.Interface(), start)
+	}
+}
+
+func encodeXMLMap(e *xml.Encoder, start xml.StartElement, v reflect.Value) error {
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		err = encodeXMLValue(e, key, v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())))
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+/* Slice elements are named after their type, e.g. []*data.Movie becomes <movie> items */
+func xmlItemName(v reflect.Value) string {
+	t := v.Type()
+	if t.Kind() == reflect.Interface && !v.IsNil() {
+		t = v.Elem().Type()
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	/* An XMLName tag wins over the type name */
+	if t.Kind() == reflect.Struct {
+		if field, ok := t.FieldByName("XMLName"); ok && field.Tag.Get("xml") != "" {
+			name, _, _ := strings.Cut(field.Tag.Get("xml"), ",")
+			return name
+		}
+	}
+
+	if t.Name() == "" || t.Kind() == reflect.String {
+		return "item"
+	}
+
+	return strings.ToLower(t.Name())
+}
+
+var xmlMarshalerType = reflect.TypeFor[xml.Marshaler]()
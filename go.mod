@@ -6,7 +6,6 @@ require (
 	github.com/go-mail/mail v2.3.1+incompatible
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
-	github.com/tomasen/realip v0.0.0-20180522021738-f0c99a92ddce
 	golang.org/x/crypto v0.40.0
 	golang.org/x/time v0.12.0
 )
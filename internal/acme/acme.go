@@ -0,0 +1,470 @@
+/*
+Package acme obtains TLS certificates from an ACME (RFC 8555) certificate
+authority such as Let's Encrypt. Only what the API needs: an ES256 account key,
+http-01 challenges and single-name certificates; no revocation, key rollover or
+external account binding.
+*/
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+/* How often the state of an authorization or order is checked while the CA works on it, shortened by the tests */
+var pollInterval = 2 * time.Second
+
+var ErrUnsupportedChallenge = errors.New("acme: the CA offered no http-01 challenge")
+
+/* An RFC 7807 problem document returned by the CA */
+type Error struct {
+	Status int
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("acme: %d %s: %s", e.Status, e.Type, e.Detail)
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *Error   `json:"error"`
+}
+
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	Error  *Error `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Challenges []challenge `json:"challenges"`
+}
+
+/*
+Client talks to the CA for one account, which is registered with the first
+certificate it obtains. Safe for concurrent use.
+*/
+type Client struct {
+	DirectoryURL string
+	/* P-256 account key */
+	Key *ecdsa.PrivateKey
+	/* Email address the CA sends expiry notices to, optional */
+	Email string
+	/* defaultHTTPClient if nil */
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	dir   *directory
+	kid   string
+	nonce string
+}
+
+/*
+Obtains a certificate for the CSR of a single domain and returns the DER
+encoded chain, leaf first. publish is called with the token and the key
+authorization the CA fetches from http://<domain>/.well-known/acme-challenge/<token>
+to validate the domain, unpublish once it has.
+*/
+func (c *Client) Obtain(ctx context.Context, domain string, csr []byte, publish func(token, keyAuth string), unpublish func(token string)) ([][]byte, error) {
+	err := c.register(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var o order
+
+	identifiers := map[string]any{"identifiers": []map[string]string{{"type": "dns", "value": domain}}}
+	orderURL, err := c.postJSON(ctx, c.dir.NewOrder, identifiers, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, authzURL := range o.Authorizations {
+		err := c.authorize(ctx, authzURL, publish, unpublish)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = c.postJSON(ctx, o.Finalize, map[string]string{"csr": encode(csr)}, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	for o.Status != "valid" {
+		if o.Status == "invalid" {
+			return nil, orderError(&o)
+		}
+
+		err := sleep(ctx, pollInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = c.postJSON(ctx, orderURL, nil, &o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := c.post(ctx, o.Certificate, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, body = pem.Decode(body)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("acme: the CA returned no certificate")
+	}
+
+	return chain, nil
+}
+
+/* Answers the http-01 challenge of an authorization that isn't valid yet and waits for the CA to check it */
+func (c *Client) authorize(ctx context.Context, authzURL string, publish func(token, keyAuth string), unpublish func(token string)) error {
+	var authz authorization
+
+	_, err := c.postJSON(ctx, authzURL, nil, &authz)
+	if err != nil {
+		return err
+	}
+
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return ErrUnsupportedChallenge
+	}
+
+	thumbprint, err := jwkThumbprint(&c.Key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	publish(chal.Token, chal.Token+"."+thumbprint)
+	defer unpublish(chal.Token)
+
+	/* An empty object tells the CA the response is ready */
+	_, err = c.postJSON(ctx, chal.URL, struct{}{}, chal)
+	if err != nil {
+		return err
+	}
+
+	for {
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "invalid", "deactivated", "expired", "revoked":
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return ch.Error
+				}
+			}
+			return fmt.Errorf("acme: authorization is %s", authz.Status)
+		}
+
+		err := sleep(ctx, pollInterval)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.postJSON(ctx, authzURL, nil, &authz)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/* Fetches the directory and creates the account, or finds it if the key already has one */
+func (c *Client) register(ctx context.Context) error {
+	c.mu.Lock()
+	registered := c.kid != ""
+	c.mu.Unlock()
+
+	if registered {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DirectoryURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var dir directory
+	err = json.NewDecoder(res.Body).Decode(&dir)
+	if err != nil {
+		return fmt.Errorf("acme: directory: %w", err)
+	}
+
+	c.mu.Lock()
+	c.dir = &dir
+	c.mu.Unlock()
+
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if c.Email != "" {
+		account["contact"] = []string{"mailto:" + c.Email}
+	}
+
+	kid, err := c.postJSON(ctx, dir.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+
+	return nil
+}
+
+/* POSTs payload and decodes the response into v if not nil, returns the Location header */
+func (c *Client) postJSON(ctx context.Context, url string, payload any, v any) (string, error) {
+	res, err := c.post(ctx, url, payload)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if v != nil {
+		err = json.NewDecoder(res.Body).Decode(v)
+		if err != nil {
+			return "", fmt.Errorf("acme: %s: %w", url, err)
+		}
+	}
+
+	return res.Header.Get("Location"), nil
+}
+
+/*
+Sends a JWS signed request, a POST-as-GET for a nil payload. A request
+rejected for its nonce is sent once more with the fresh one.
+*/
+func (c *Client) post(ctx context.Context, url string, payload any) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := c.sign(ctx, url, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+
+		res, err := c.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.nonce = res.Header.Get("Replay-Nonce")
+		c.mu.Unlock()
+
+		if res.StatusCode < 400 {
+			return res, nil
+		}
+
+		problem := &Error{Status: res.StatusCode}
+		json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(problem)
+		res.Body.Close()
+
+		if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+			continue
+		}
+
+		return nil, problem
+	}
+}
+
+/* The flattened JWS JSON serialization of payload, with the account's kid once it has one */
+func (c *Client) sign(ctx context.Context, url string, payload any) ([]byte, error) {
+	nonce, err := c.takeNonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	kid := c.kid
+	c.mu.Unlock()
+
+	protected := map[string]any{"alg": "ES256", "nonce": nonce, "url": url}
+	if kid != "" {
+		protected["kid"] = kid
+	} else {
+		protected["jwk"] = jwk(&c.Key.PublicKey)
+	}
+
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	/* POST-as-GET has an empty payload rather than an encoded null */
+	var body string
+	if payload != nil {
+		js, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = encode(js)
+	}
+
+	input := encode(header) + "." + body
+	digest := sha256.Sum256([]byte(input))
+
+	r, s, err := ecdsa.Sign(rand.Reader, c.Key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	/* JWS wants the fixed size r || s rather than the ASN.1 signature */
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": encode(header),
+		"payload":   body,
+		"signature": encode(signature),
+	})
+}
+
+/* The nonce of the last response, or a new one from the CA */
+func (c *Client) takeNonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	nonce, newNonce := c.nonce, c.dir.NewNonce
+	c.nonce = ""
+	c.mu.Unlock()
+
+	if nonce != "" {
+		return nonce, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, newNonce, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	nonce = res.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: the CA returned no nonce")
+	}
+
+	return nonce, nil
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+func jwk(key *ecdsa.PublicKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": encode(x), "y": encode(y)}
+}
+
+/* RFC 7638: the hash of the required members in lexicographic order, which encoding/json uses for maps */
+func jwkThumbprint(key *ecdsa.PublicKey) (string, error) {
+	js, err := json.Marshal(jwk(key))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+	return encode(sum[:]), nil
+}
+
+func orderError(o *order) error {
+	if o.Error != nil {
+		return o.Error
+	}
+	return errors.New("acme: order is invalid")
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,549 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	pollInterval = time.Millisecond
+}
+
+/*
+A certificate authority speaking just enough RFC 8555 for Client: it checks
+the JWS signature, nonce and url of every POST, validates the http-01 challenge
+through fetchKeyAuth and issues certificates from its own CA key.
+*/
+type stubCA struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	/* Returns what the client serves at /.well-known/acme-challenge/<token> */
+	fetchKeyAuth func(token string) (string, bool)
+	/* The types of challenge offered, http-01 if empty */
+	challengeTypes []string
+	/* How many requests with a valid nonce are rejected with badNonce anyway */
+	badNonces int
+	/* Lifetime of the issued certificates, 90 days if zero */
+	validity time.Duration
+
+	mu         sync.Mutex
+	nonces     map[string]bool
+	nonceSeq   int
+	accountKey *ecdsa.PublicKey
+	accounts   int
+	orders     int
+	domain     string
+	authz      string
+	chalError  *Error
+	order      string
+	issued     int
+	serial     int64
+	chainPEM   []byte
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+}
+
+const stubToken = "stub-token"
+
+func newStubCA(t *testing.T) *stubCA {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Stub CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &stubCA{t: t, nonces: make(map[string]bool), caKey: caKey, caCert: caCert, serial: 1}
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.serveHTTP))
+	t.Cleanup(ca.srv.Close)
+
+	return ca
+}
+
+func (ca *stubCA) directoryURL() string {
+	return ca.srv.URL + "/directory"
+}
+
+func (ca *stubCA) newNonce() string {
+	ca.nonceSeq++
+	nonce := fmt.Sprintf("nonce-%d", ca.nonceSeq)
+	ca.nonces[nonce] = true
+	return nonce
+}
+
+func (ca *stubCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	w.Header().Set("Replay-Nonce", ca.newNonce())
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/directory":
+		json.NewEncoder(w).Encode(directory{
+			NewNonce:   ca.srv.URL + "/new-nonce",
+			NewAccount: ca.srv.URL + "/new-account",
+			NewOrder:   ca.srv.URL + "/new-order",
+		})
+		return
+	case r.Method == http.MethodHead && r.URL.Path == "/new-nonce":
+		return
+	case r.Method != http.MethodPost:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+		ca.t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		return
+	}
+
+	payload, problem := ca.verifyJWS(r)
+	if problem != nil {
+		ca.problem(w, problem)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/new-account":
+		ca.accounts++
+		w.Header().Set("Location", ca.srv.URL+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+
+	case "/new-order":
+		var req struct {
+			Identifiers []struct {
+				Type  string `json:"type"`
+				Value string `json:"value"`
+			} `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		if len(req.Identifiers) != 1 || req.Identifiers[0].Type != "dns" {
+			ca.problem(w, &Error{Status: http.StatusBadRequest, Type: "urn:ietf:params:acme:error:malformed"})
+			return
+		}
+
+		ca.orders++
+		ca.domain = req.Identifiers[0].Value
+		ca.authz = "pending"
+		ca.chalError = nil
+		ca.order = "pending"
+
+		w.Header().Set("Location", ca.srv.URL+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ca.orderJSON())
+
+	case "/authz/1":
+		json.NewEncoder(w).Encode(ca.authzJSON())
+
+	case "/challenge/1":
+		if string(payload) != "{}" {
+			ca.t.Errorf("got challenge payload %q; want {}", payload)
+		}
+
+		keyAuth, ok := ca.fetchKeyAuth(stubToken)
+		thumbprint, _ := jwkThumbprint(ca.accountKey)
+		if ok && keyAuth == stubToken+"."+thumbprint {
+			ca.authz = "valid"
+		} else {
+			ca.authz = "invalid"
+			ca.chalError = &Error{Type: "urn:ietf:params:acme:error:unauthorized", Detail: fmt.Sprintf("got key authorization %q", keyAuth)}
+		}
+		json.NewEncoder(w).Encode(challenge{Type: "http-01", URL: ca.srv.URL + "/challenge/1", Token: stubToken, Status: "processing"})
+
+	case "/finalize/1":
+		if ca.authz != "valid" {
+			ca.problem(w, &Error{Status: http.StatusForbidden, Type: "urn:ietf:params:acme:error:orderNotReady"})
+			return
+		}
+
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || !slices.Equal(csr.DNSNames, []string{ca.domain}) {
+			ca.problem(w, &Error{Status: http.StatusBadRequest, Type: "urn:ietf:params:acme:error:badCSR"})
+			return
+		}
+
+		ca.issueFor(csr.PublicKey)
+		/* Ready on the next poll */
+		ca.order = "processing"
+		json.NewEncoder(w).Encode(ca.orderJSON())
+
+	case "/order/1":
+		if ca.order == "processing" {
+			ca.order = "valid"
+		}
+		json.NewEncoder(w).Encode(ca.orderJSON())
+
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.chainPEM)
+
+	default:
+		ca.problem(w, &Error{Status: http.StatusNotFound, Type: "urn:ietf:params:acme:error:malformed", Detail: r.URL.Path})
+	}
+}
+
+/* Checks the flattened JWS of a POST and returns its payload */
+func (ca *stubCA) verifyJWS(r *http.Request) ([]byte, *Error) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		ca.t.Errorf("got Content-Type %q; want application/jose+json", ct)
+	}
+
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&jws)
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Type: "urn:ietf:params:acme:error:malformed", Detail: err.Error()}
+	}
+
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var header struct {
+		Alg   string            `json:"alg"`
+		Nonce string            `json:"nonce"`
+		URL   string            `json:"url"`
+		Kid   string            `json:"kid"`
+		JWK   map[string]string `json:"jwk"`
+	}
+	json.Unmarshal(headerJSON, &header)
+
+	if header.Alg != "ES256" {
+		ca.t.Errorf("got alg %q; want ES256", header.Alg)
+	}
+	if header.URL != ca.srv.URL+r.URL.Path {
+		ca.t.Errorf("got url %q; want %q", header.URL, ca.srv.URL+r.URL.Path)
+	}
+
+	if !ca.nonces[header.Nonce] {
+		return nil, &Error{Status: http.StatusBadRequest, Type: "urn:ietf:params:acme:error:badNonce", Detail: "unknown nonce"}
+	}
+	delete(ca.nonces, header.Nonce)
+	if ca.badNonces > 0 {
+		ca.badNonces--
+		return nil, &Error{Status: http.StatusBadRequest, Type: "urn:ietf:params:acme:error:badNonce", Detail: "try again"}
+	}
+
+	/* A new account is signed with its key, everything after with the account's kid */
+	key := ca.accountKey
+	switch {
+	case r.URL.Path == "/new-account":
+		if header.JWK == nil || header.Kid != "" {
+			ca.t.Errorf("new-account must carry a jwk and no kid, got %s", headerJSON)
+		}
+		x, _ := base64.RawURLEncoding.DecodeString(header.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK["y"])
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		ca.accountKey = key
+	case header.Kid != ca.srv.URL+"/account/1" || header.JWK != nil:
+		ca.t.Errorf("got kid %q and jwk %v; want the account's kid only", header.Kid, header.JWK)
+		return nil, &Error{Status: http.StatusUnauthorized, Type: "urn:ietf:params:acme:error:accountDoesNotExist"}
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(signature) != 64 || key == nil ||
+		!ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return nil, &Error{Status: http.StatusBadRequest, Type: "urn:ietf:params:acme:error:malformed", Detail: "bad signature"}
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, nil
+}
+
+func (ca *stubCA) problem(w http.ResponseWriter, problem *Error) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func (ca *stubCA) orderJSON() order {
+	o := order{
+		Status:         ca.order,
+		Authorizations: []string{ca.srv.URL + "/authz/1"},
+		Finalize:       ca.srv.URL + "/finalize/1",
+	}
+	if ca.order == "valid" {
+		o.Certificate = ca.srv.URL + "/cert/1"
+	}
+	return o
+}
+
+func (ca *stubCA) authzJSON() authorization {
+	types := ca.challengeTypes
+	if len(types) == 0 {
+		types = []string{"http-01"}
+	}
+
+	authz := authorization{Status: ca.authz}
+	for _, typ := range types {
+		chal := challenge{Type: typ, URL: ca.srv.URL + "/challenge/1", Token: stubToken, Status: "pending"}
+		if typ == "http-01" {
+			chal.Error = ca.chalError
+		}
+		authz.Challenges = append(authz.Challenges, chal)
+	}
+	return authz
+}
+
+/* Set by issueFor, the leaf followed by the CA certificate */
+func (ca *stubCA) issueFor(pub any) {
+	ca.chainPEM = ca.issue(pub, ca.domain, time.Now().Add(-time.Hour), ca.lifetime())
+	ca.issued++
+}
+
+func (ca *stubCA) lifetime() time.Duration {
+	if ca.validity == 0 {
+		return 90 * 24 * time.Hour
+	}
+	return ca.validity
+}
+
+func (ca *stubCA) issue(pub any, domain string, notBefore time.Time, lifetime time.Duration) []byte {
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.caCert, pub, ca.caKey)
+	if err != nil {
+		/* Runs in the server's goroutine, where Fatal isn't allowed */
+		ca.t.Error(err)
+		return nil
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...)
+}
+
+/* A client of ca with a new account key and the publish and unpublish callbacks of a map */
+func newTestClient(t *testing.T, ca *stubCA) (*Client, func(token, keyAuth string), func(token string)) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	published := make(map[string]string)
+
+	ca.fetchKeyAuth = func(token string) (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		keyAuth, ok := published[token]
+		return keyAuth, ok
+	}
+
+	publish := func(token, keyAuth string) {
+		mu.Lock()
+		defer mu.Unlock()
+		published[token] = keyAuth
+	}
+	unpublish := func(token string) {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(published, token)
+	}
+
+	return &Client{DirectoryURL: ca.directoryURL(), Key: key, Email: "admin@example.com"}, publish, unpublish
+}
+
+func newCSR(t *testing.T, domain string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{domain}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestObtain(t *testing.T) {
+	ca := newStubCA(t)
+	client, publish, unpublish := newTestClient(t, ca)
+
+	var published, unpublished []string
+	chain, err := client.Obtain(context.Background(), "example.com", newCSR(t, "example.com"),
+		func(token, keyAuth string) {
+			published = append(published, token)
+			publish(token, keyAuth)
+		},
+		func(token string) {
+			unpublished = append(unpublished, token)
+			unpublish(token)
+		})
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+
+	if len(chain) != 2 {
+		t.Fatalf("got a chain of %d certificates; want 2", len(chain))
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(leaf.DNSNames, []string{"example.com"}) {
+		t.Errorf("got DNS names %v; want [example.com]", leaf.DNSNames)
+	}
+	if err := leaf.CheckSignatureFrom(ca.caCert); err != nil {
+		t.Errorf("leaf not signed by the CA: %v", err)
+	}
+
+	if !slices.Equal(published, []string{stubToken}) || !slices.Equal(unpublished, []string{stubToken}) {
+		t.Errorf("got published %v and unpublished %v; want the token once each", published, unpublished)
+	}
+}
+
+func TestObtainRegistersOnce(t *testing.T) {
+	ca := newStubCA(t)
+	client, publish, unpublish := newTestClient(t, ca)
+
+	for range 2 {
+		_, err := client.Obtain(context.Background(), "example.com", newCSR(t, "example.com"), publish, unpublish)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ca.accounts != 1 || ca.issued != 2 {
+		t.Errorf("got %d accounts and %d certificates; want 1 and 2", ca.accounts, ca.issued)
+	}
+}
+
+func TestObtainRetriesBadNonce(t *testing.T) {
+	ca := newStubCA(t)
+	ca.badNonces = 1
+	client, publish, unpublish := newTestClient(t, ca)
+
+	_, err := client.Obtain(context.Background(), "example.com", newCSR(t, "example.com"), publish, unpublish)
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+}
+
+/* The nonce is retried once only, a CA that keeps rejecting it isn't looped on */
+func TestObtainBadNonceTwice(t *testing.T) {
+	ca := newStubCA(t)
+	ca.badNonces = 2
+	client, publish, unpublish := newTestClient(t, ca)
+
+	_, err := client.Obtain(context.Background(), "example.com", newCSR(t, "example.com"), publish, unpublish)
+
+	var problem *Error
+	if !errors.As(err, &problem) || problem.Type != "urn:ietf:params:acme:error:badNonce" {
+		t.Fatalf("got error %v; want a badNonce problem", err)
+	}
+}
+
+func TestObtainInvalidChallenge(t *testing.T) {
+	ca := newStubCA(t)
+	client, _, unpublish := newTestClient(t, ca)
+
+	/* Never published, the CA finds nothing at the challenge URL */
+	_, err := client.Obtain(context.Background(), "example.com", newCSR(t, "example.com"), func(string, string) {}, unpublish)
+
+	var problem *Error
+	if !errors.As(err, &problem) || problem.Type != "urn:ietf:params:acme:error:unauthorized" {
+		t.Fatalf("got error %v; want an unauthorized problem", err)
+	}
+	if ca.issued != 0 {
+		t.Errorf("got %d certificates issued; want 0", ca.issued)
+	}
+}
+
+func TestObtainUnsupportedChallenge(t *testing.T) {
+	ca := newStubCA(t)
+	ca.challengeTypes = []string{"dns-01", "tls-alpn-01"}
+	client, publish, unpublish := newTestClient(t, ca)
+
+	_, err := client.Obtain(context.Background(), "example.com", newCSR(t, "example.com"), publish, unpublish)
+	if !errors.Is(err, ErrUnsupportedChallenge) {
+		t.Fatalf("got error %v; want %v", err, ErrUnsupportedChallenge)
+	}
+}
+
+func TestObtainCancelled(t *testing.T) {
+	ca := newStubCA(t)
+	client, publish, unpublish := newTestClient(t, ca)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.Obtain(ctx, "example.com", newCSR(t, "example.com"), publish, unpublish)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	/* The example key of RFC 7638 is RSA, this one is checked against its definition instead */
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	canonical := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, encode(x), encode(y))
+	sum := sha256.Sum256([]byte(canonical))
+
+	got, err := jwkThumbprint(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := encode(sum[:]); got != want {
+		t.Errorf("got thumbprint %q; want %q", got, want)
+	}
+}
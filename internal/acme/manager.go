@@ -0,0 +1,358 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const challengePath = "/.well-known/acme-challenge/"
+
+/* Time after a failed order before the next handshake for the host tries again */
+const retryAfter = time.Minute
+
+var ErrHostNotAllowed = errors.New("acme: host not allowed")
+
+type failure struct {
+	err error
+	at  time.Time
+}
+
+/*
+Manager provides the certificates of a fixed list of hosts to a tls.Config,
+obtaining them from the CA on first use and renewing them from Run. Certificates
+and the account key are kept in Dir so restarts don't hit the CA's rate limits.
+*/
+type Manager struct {
+	client *Client
+	hosts  []string
+	dir    string
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+	/* Closed when the certificate being obtained for the host is there, see certificate */
+	pending map[string]chan struct{}
+	failed  map[string]failure
+
+	challengesMu sync.RWMutex
+	challenges   map[string]string
+}
+
+/* Creates dir and the account key in it if they don't exist yet */
+func NewManager(directoryURL, email, dir string, hosts []string) (*Manager, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := loadAccountKey(filepath.Join(dir, "acme_account.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	lower := make([]string, len(hosts))
+	for i, host := range hosts {
+		lower[i] = strings.ToLower(host)
+	}
+
+	return &Manager{
+		client:     &Client{DirectoryURL: directoryURL, Key: key, Email: email},
+		hosts:      lower,
+		dir:        dir,
+		certs:      make(map[string]*tls.Certificate),
+		pending:    make(map[string]chan struct{}),
+		failed:     make(map[string]failure),
+		challenges: make(map[string]string),
+	}, nil
+}
+
+/* For tls.Config.GetCertificate; the first handshake for a host waits until its certificate is issued */
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if !slices.Contains(m.hosts, host) {
+		return nil, fmt.Errorf("%w: %q", ErrHostNotAllowed, host)
+	}
+
+	/* Only set for the ClientHelloInfo of an actual handshake */
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return m.certificate(ctx, host)
+}
+
+/*
+Serves the challenge responses under /.well-known/acme-challenge/, which the
+CA fetches over plain HTTP on port 80, and passes anything else to fallback.
+*/
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.URL.Path, challengePath)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		m.challengesMu.RLock()
+		keyAuth, ok := m.challenges[token]
+		m.challengesMu.RUnlock()
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
+
+/*
+Obtains the missing certificates, then renews the ones about to expire twice a
+day until ctx is cancelled. Errors are passed to logError and retried on the
+next round.
+*/
+func (m *Manager) Run(ctx context.Context, logError func(host string, err error)) {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		for _, host := range m.hosts {
+			_, err := m.certificate(ctx, host)
+			if err != nil && ctx.Err() == nil {
+				logError(host, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+Returns the host's certificate from memory or Dir, obtaining a new one when
+there is none or it is due for renewal. Only one is obtained at a time per host,
+the other callers wait for it. The order goes on when ctx is cancelled, a
+handshake that gave up still leaves the certificate for the next one.
+*/
+func (m *Manager) certificate(ctx context.Context, host string) (*tls.Certificate, error) {
+	for {
+		m.mu.Lock()
+
+		cert, ok := m.certs[host]
+		if !ok {
+			cert, _ = loadCertificate(m.certPath(host))
+			if cert != nil {
+				m.certs[host] = cert
+			}
+		}
+
+		if cert != nil && !renewalDue(cert) {
+			m.mu.Unlock()
+			return cert, nil
+		}
+
+		if wait, ok := m.pending[host]; ok {
+			m.mu.Unlock()
+
+			/* A certificate that is still valid is served while the new one is obtained */
+			if cert != nil && time.Now().Before(cert.Leaf.NotAfter) {
+				return cert, nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-wait:
+			}
+			continue
+		}
+
+		/* Handshakes for a host the CA just refused mustn't run into its rate limits */
+		if f, ok := m.failed[host]; ok && time.Since(f.at) < retryAfter {
+			m.mu.Unlock()
+			if cert != nil && time.Now().Before(cert.Leaf.NotAfter) {
+				return cert, nil
+			}
+			return nil, f.err
+		}
+
+		done := make(chan struct{})
+		m.pending[host] = done
+		m.mu.Unlock()
+
+		result := make(chan error, 1)
+		go func() {
+			defer close(done)
+
+			obtainCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+
+			result <- m.obtain(obtainCtx, host)
+		}()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case err := <-result:
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+/* Orders a certificate for host with a new key and stores it in memory and Dir */
+func (m *Manager) obtain(ctx context.Context, host string) (err error) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, host)
+		if err != nil {
+			m.failed[host] = failure{err: err, at: time.Now()}
+		} else {
+			delete(m.failed, host)
+		}
+		m.mu.Unlock()
+	}()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: host},
+		DNSNames: []string{host},
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	chain, err := m.client.Obtain(ctx, host, csr, m.publish, m.unpublish)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, der := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	pemData := buf.Bytes()
+
+	cert, err := parseCertificate(pemData)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(m.certPath(host), pemData, 0o600)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.certs[host] = cert
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Manager) publish(token, keyAuth string) {
+	m.challengesMu.Lock()
+	defer m.challengesMu.Unlock()
+
+	m.challenges[token] = keyAuth
+}
+
+func (m *Manager) unpublish(token string) {
+	m.challengesMu.Lock()
+	defer m.challengesMu.Unlock()
+
+	delete(m.challenges, token)
+}
+
+func (m *Manager) certPath(host string) string {
+	return filepath.Join(m.dir, host+".pem")
+}
+
+/* In the last third of its validity, 30 days before expiry for the 90 day certificates of Let's Encrypt */
+func renewalDue(cert *tls.Certificate) bool {
+	lifetime := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore)
+	return time.Now().After(cert.Leaf.NotAfter.Add(-lifetime / 3))
+}
+
+/* The key followed by the chain, as written by obtain */
+func loadCertificate(path string) (*tls.Certificate, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCertificate(pemData)
+}
+
+func parseCertificate(pemData []byte) (*tls.Certificate, error) {
+	/* X509KeyPair skips the blocks that aren't certificates, and the other way round */
+	cert, err := tls.X509KeyPair(pemData, pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+func loadAccountKey(path string) (*ecdsa.PrivateKey, error) {
+	pemData, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(pemData)
+		if block == nil {
+			return nil, fmt.Errorf("acme: %s is not a PEM file", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
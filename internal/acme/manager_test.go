@@ -0,0 +1,231 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/* A manager of the hosts on ca, which validates the challenges through the manager's HTTPHandler */
+func newTestManager(t *testing.T, ca *stubCA, dir string, hosts ...string) *Manager {
+	t.Helper()
+
+	m, err := NewManager(ca.directoryURL(), "admin@example.com", dir, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca.fetchKeyAuth = func(token string) (string, bool) {
+		rr := httptest.NewRecorder()
+		m.HTTPHandler(http.NotFoundHandler()).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, challengePath+token, nil))
+		body, _ := io.ReadAll(rr.Body)
+		return string(body), rr.Code == http.StatusOK
+	}
+
+	return m
+}
+
+func getCertificate(m *Manager, host string) (*tls.Certificate, error) {
+	return m.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+}
+
+func TestManagerGetCertificate(t *testing.T) {
+	ca := newStubCA(t)
+	dir := t.TempDir()
+	m := newTestManager(t, ca, dir, "Example.com")
+
+	cert, err := getCertificate(m, "example.com.")
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.DNSNames[0] != "example.com" {
+		t.Fatalf("got a certificate for %v; want example.com", cert.Leaf)
+	}
+
+	again, err := getCertificate(m, "EXAMPLE.COM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != cert || ca.issued != 1 {
+		t.Errorf("got %d certificates issued; want the first one reused", ca.issued)
+	}
+
+	/* A restart finds the certificate and the account key in dir */
+	_, err = os.Stat(filepath.Join(dir, "example.com.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := newTestManager(t, ca, dir, "example.com")
+	loaded, err := getCertificate(restarted, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 || ca.issued != 1 {
+		t.Errorf("got serial %v and %d issued; want the stored certificate", loaded.Leaf.SerialNumber, ca.issued)
+	}
+	if !restarted.client.Key.Equal(m.client.Key) {
+		t.Error("the account key wasn't reused after a restart")
+	}
+}
+
+func TestManagerHostNotAllowed(t *testing.T) {
+	ca := newStubCA(t)
+	m := newTestManager(t, ca, t.TempDir(), "example.com")
+
+	for _, host := range []string{"other.com", "sub.example.com", ""} {
+		_, err := getCertificate(m, host)
+		if !errors.Is(err, ErrHostNotAllowed) {
+			t.Errorf("got error %v for %q; want %v", err, host, ErrHostNotAllowed)
+		}
+	}
+
+	if ca.orders != 0 {
+		t.Errorf("got %d orders; want 0", ca.orders)
+	}
+}
+
+func TestManagerRenewal(t *testing.T) {
+	ca := newStubCA(t)
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		renewed   bool
+	}{
+		{"fresh", time.Now().Add(-24 * time.Hour), false},
+		{"in the last third", time.Now().Add(-70 * 24 * time.Hour), true},
+		{"expired", time.Now().Add(-100 * 24 * time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca.mu.Lock()
+			chain := ca.issue(&key.PublicKey, "example.com", tt.notBefore, 90*24*time.Hour)
+			ca.mu.Unlock()
+
+			stored := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), chain...)
+			err := os.WriteFile(filepath.Join(dir, "example.com.pem"), stored, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			issued := ca.issued
+			m := newTestManager(t, ca, dir, "example.com")
+
+			cert, err := getCertificate(m, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			renewed := ca.issued > issued
+			if renewed != tt.renewed || renewalDue(cert) {
+				t.Errorf("got renewed %t, due %t; want renewed %t and not due", renewed, renewalDue(cert), tt.renewed)
+			}
+		})
+	}
+}
+
+func TestManagerFailureBackoff(t *testing.T) {
+	ca := newStubCA(t)
+	ca.challengeTypes = []string{"dns-01"}
+	m := newTestManager(t, ca, t.TempDir(), "example.com")
+
+	for range 3 {
+		_, err := getCertificate(m, "example.com")
+		if !errors.Is(err, ErrUnsupportedChallenge) {
+			t.Fatalf("got error %v; want %v", err, ErrUnsupportedChallenge)
+		}
+	}
+
+	/* Handshakes within retryAfter get the stored error instead of new orders */
+	if ca.orders != 1 {
+		t.Errorf("got %d orders; want 1", ca.orders)
+	}
+}
+
+func TestManagerHandshakeCancelled(t *testing.T) {
+	ca := newStubCA(t)
+	m := newTestManager(t, ca, t.TempDir(), "example.com")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := m.certificate(ctx, "example.com")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v; want %v", err, context.Canceled)
+	}
+
+	/* The order carries on without the handshake, the next one gets its certificate */
+	_, err = m.certificate(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.orders != 1 {
+		t.Errorf("got %d orders; want 1", ca.orders)
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	m, err := NewManager("http://ca.invalid/directory", "", t.TempDir(), []string{"example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.publish("token", "token.thumbprint")
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{challengePath + "token", http.StatusOK, "token.thumbprint"},
+		{challengePath + "unknown", http.StatusNotFound, ""},
+		{"/v1/healthcheck", http.StatusTeapot, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			m.HTTPHandler(fallback).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rr.Code != tt.code {
+				t.Errorf("got status %d; want %d", rr.Code, tt.code)
+			}
+			if tt.body != "" && rr.Body.String() != tt.body {
+				t.Errorf("got body %q; want %q", rr.Body.String(), tt.body)
+			}
+		})
+	}
+
+	m.unpublish("token")
+	rr := httptest.NewRecorder()
+	m.HTTPHandler(fallback).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, challengePath+"token", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %d after unpublish; want %d", rr.Code, http.StatusNotFound)
+	}
+}
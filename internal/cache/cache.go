@@ -0,0 +1,105 @@
+/*
+Package cache keeps values for a while under string keys, so hot reads can skip
+the database. Memory keeps them in one process, Redis shares them between the
+API's instances. Values are bytes, callers choose how to encode them.
+*/
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Cache interface {
+	/* found is false when key is missing or expired */
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+	/* A zero ttl keeps the value until it is deleted */
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+type entry struct {
+	value []byte
+	/* Zero for no expiry */
+	expires time.Time
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+/* Values of one process, at most maxEntries of them, see Set */
+type Memory struct {
+	mu         sync.Mutex
+	entries    map[string]entry
+	maxEntries int
+}
+
+/* Expired entries are removed a minute at a time */
+func NewMemory(maxEntries int) *Memory {
+	m := &Memory{entries: make(map[string]entry), maxEntries: maxEntries}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			now := time.Now()
+
+			m.mu.Lock()
+			for key, e := range m.entries {
+				if e.expired(now) {
+					delete(m.entries, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}()
+
+	return m
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, found := m.entries[key]
+	if !found || e.expired(time.Now()) {
+		return nil, false, nil
+	}
+
+	return e.value, true, nil
+}
+
+/* A full cache makes room by evicting a random entry, map iteration order is random */
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	e := entry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, found := m.entries[key]; !found && len(m.entries) >= m.maxEntries {
+		for evict := range m.entries {
+			delete(m.entries, evict)
+			break
+		}
+	}
+
+	m.entries[key] = e
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/redis"
+)
+
+/* Values shared by every instance using the same Redis, keys are prefixed with prefix */
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := c.client.Do(ctx, "GET", c.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch reply := reply.(type) {
+	case nil:
+		return nil, false, nil
+	case string:
+		return []byte(reply), true, nil
+	default:
+		return nil, false, fmt.Errorf("cache: unexpected reply %v", reply)
+	}
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", c.prefix + key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+
+	_, err := c.client.Do(ctx, args...)
+	return err
+}
+
+func (c *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := []string{"DEL"}
+	for _, key := range keys {
+		args = append(args, c.prefix+key)
+	}
+
+	_, err := c.client.Do(ctx, args...)
+	return err
+}
@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/* Tells API keys apart from tokens, in headers and in leaked secrets scans */
+const apiKeyPrefix = "gl_"
+
+/*
+A long-lived key for scripts and other machine clients. It acts for its user
+with at most the permissions in Scopes.
+*/
+type APIKey struct {
+	ID         int64      `json:"id" xml:"id"`
+	CreatedAt  time.Time  `json:"created_at" xml:"created_at"`
+	Name       string     `json:"name" xml:"name"`
+	Prefix     string     `json:"prefix" xml:"prefix"`
+	Scopes     []string   `json:"scopes" xml:"scopes>scope"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" xml:"last_used_at,omitempty"`
+	/* Only set when the key is created, it can't be shown again */
+	Plaintext string `json:"key,omitempty" xml:"key,omitempty"`
+	Hash      []byte `json:"-" xml:"-"`
+	UserID    int64  `json:"-" xml:"-"`
+}
+
+type APIKeyModel struct {
+	DB DBTX
+}
+
+/* permitted are the permissions of the user, a key can't have more */
+func ValidateAPIKey(v *validator.Validator, key *APIKey, permitted Permissions) {
+	v.CheckField(validator.NotBlank(key.Name), "name", validator.Message("validation.required"))
+	v.CheckField(validator.MaxChars(key.Name, 64), "name", validator.Message("validation.max_chars", 64))
+
+	v.CheckField(len(key.Scopes) > 0, "scopes", validator.Message("validation.no_permissions"))
+	v.CheckField(validator.Unique(key.Scopes), "scopes", validator.Message("validation.unique"))
+	held := true
+	for _, scope := range key.Scopes {
+		held = held && permitted.Include(scope)
+	}
+	v.CheckField(held, "scopes", validator.Message("validation.held_permissions"))
+
+	if key.ExpiresAt != nil {
+		v.CheckField(validator.DateBetween(*key.ExpiresAt, time.Now(), time.Time{}), "expires_at", validator.Message("validation.future"))
+	}
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.CheckField(strings.HasPrefix(plaintext, apiKeyPrefix), "key", validator.Message("validation.api_key"))
+	v.CheckField(len(plaintext) == len(apiKeyPrefix)+32, "key", validator.Message("validation.bytes", len(apiKeyPrefix)+32))
+}
+
+/* Generates the key and stores its hash, key.Plaintext is set for the one response showing it */
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = apiKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:len(apiKeyPrefix)+6]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+/* Newest first, expired keys included so they can be told apart from revoked ones */
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, name, prefix, scopes, expires_at, last_used_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		key.UserID = userID
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+/* Only the user's own keys, another user's key is as not found as a missing one */
+func (m APIKeyModel) Delete(ctx context.Context, id, userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+/*
+Looks up the user of an unexpired key, with User.Scopes set to the key's
+scopes, and records that the key was used.
+*/
+func (m APIKeyModel) GetUserForKey(ctx context.Context, plaintext string) (*User, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		WITH key AS (
+			UPDATE api_keys
+			SET last_used_at = NOW()
+			WHERE hash = $1 AND (expires_at IS NULL OR expires_at > NOW())
+			RETURNING id, user_id, scopes
+		)
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, key.scopes, key.id
+		FROM users
+		INNER JOIN key ON users.id = key.user_id
+		WHERE users.tenant_id = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], TenantID(ctx)).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		pq.Array(&user.Scopes),
+		&user.APIKeyID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	/* Never nil for a key, nil means unrestricted */
+	if user.Scopes == nil {
+		user.Scopes = []string{}
+	}
+
+	return &user, nil
+}
+
+/* Whether the user, if restricted by an API key, may use a permission */
+func (u *User) InScope(code string) bool {
+	return u.Scopes == nil || slices.Contains(u.Scopes, code)
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type AuditEntry struct {
+	ID        int64     `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	/* nil for anonymous requests, e.g. registrations */
+	UserID *int64 `json:"user_id,omitempty" xml:"user_id,omitempty"`
+	Method string `json:"method" xml:"method"`
+	/* The route's pattern, e.g. "/v1/movies/:id" */
+	Route        string  `json:"route" xml:"route"`
+	Path         string  `json:"path" xml:"path"`
+	ResourceType string  `json:"resource_type" xml:"resource_type"`
+	ResourceID   *string `json:"resource_id,omitempty" xml:"resource_id,omitempty"`
+	Status       int     `json:"status" xml:"status"`
+	IP           string  `json:"ip" xml:"ip"`
+	RequestID    string  `json:"request_id" xml:"request_id"`
+	/* {"field": {"before": ..., "after": ...}}, only from handlers that record their change */
+	Changes json.RawMessage `json:"changes,omitempty" xml:"-"`
+}
+
+/* Filters of AuditLogModel.GetAll, zero values match everything */
+type AuditSearch struct {
+	UserID       int64
+	ResourceType string
+	/* From is inclusive, To exclusive */
+	From *time.Time
+	To   *time.Time
+}
+
+type AuditLogModel struct {
+	DB DBTX
+}
+
+/* Recorded in the tenant of ctx */
+func (m AuditLogModel) Insert(ctx context.Context, entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (user_id, method, route, path, resource_type, resource_id, status, ip, request_id, changes, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at`
+
+	/* A nil json.RawMessage would be sent as an empty string, which isn't JSON */
+	var changes any
+	if entry.Changes != nil {
+		changes = []byte(entry.Changes)
+	}
+
+	args := []any{entry.UserID, entry.Method, entry.Route, entry.Path, entry.ResourceType, entry.ResourceID, entry.Status, entry.IP, entry.RequestID, changes, TenantID(ctx)}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+/* The entries of the tenant in ctx, newest first, paginated with f.Page and f.PageSize */
+func (m AuditLogModel) GetAll(ctx context.Context, search AuditSearch, f Filters) ([]*AuditEntry, Metadata, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	where("tenant_id = $%d", TenantID(ctx))
+
+	if search.UserID != 0 {
+		where("user_id = $%d", search.UserID)
+	}
+	if search.ResourceType != "" {
+		where("resource_type = $%d", search.ResourceType)
+	}
+	if search.From != nil {
+		where("created_at >= $%d", *search.From)
+	}
+	if search.To != nil {
+		where("created_at < $%d", *search.To)
+	}
+
+	query := `
+		SELECT count(*) OVER(), id, created_at, user_id, method, route, path, resource_type, resource_id, status, ip, request_id, changes
+		FROM audit_log
+		WHERE ` + strings.Join(conditions, " AND ")
+
+	args = append(args, f.limit(), f.offset())
+	query += fmt.Sprintf(`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var (
+			entry   AuditEntry
+			changes []byte
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.UserID,
+			&entry.Method,
+			&entry.Route,
+			&entry.Path,
+			&entry.ResourceType,
+			&entry.ResourceID,
+			&entry.Status,
+			&entry.IP,
+			&entry.RequestID,
+			&changes,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if changes != nil {
+			entry.Changes = changes
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
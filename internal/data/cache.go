@@ -0,0 +1,227 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/cache"
+)
+
+/*
+Movies and pages of movies are cached for a while by MovieModel.Get and GetAll,
+when the model has a cache. A movie's key is dropped when it is written. Pages
+are keyed by a hash of their search and filters, which can't all be found to be
+dropped, so their keys start with a generation that every write replaces.
+
+Errors of the cache are ignored, a read falls back to the database and a
+value that couldn't be dropped is stale until its TTL at most.
+*/
+type movieCache struct {
+	cache cache.Cache
+	ttl   time.Duration
+	/* In a transaction of Models.WithTx, see cacheWrites */
+	writes *cacheWrites
+}
+
+/*
+The keys a transaction wrote, dropped once it commits: dropped right away a
+read could cache the old values again before then. A transaction neither reads
+the cache nor fills it, its reads see its own writes which may be rolled back.
+*/
+type cacheWrites struct {
+	keys  []string
+	lists bool
+}
+
+/* Drops what a committed transaction wrote */
+func (c movieCache) drop(ctx context.Context, writes *cacheWrites) {
+	if c.cache == nil {
+		return
+	}
+
+	for _, key := range writes.keys {
+		c.cache.Delete(ctx, key)
+	}
+
+	if writes.lists {
+		c.newGeneration(ctx)
+	}
+}
+
+const movieListGenerationKey = "movies:generation"
+
+/* Movie IDs are unique across tenants, the tenant keeps one from reading another's movie */
+func movieCacheKey(ctx context.Context, id int64) string {
+	return "movie:" + strconv.FormatInt(TenantID(ctx), 10) + ":" + strconv.FormatInt(id, 10)
+}
+
+/* gob rather than JSON, the JSON of a movie leaves out CreatedAt and posterKey */
+type cachedMovie struct {
+	Movie     *Movie
+	PosterKey string
+}
+
+type cachedMovieList struct {
+	Movies   []*Movie
+	Metadata Metadata
+}
+
+func (c movieCache) get(ctx context.Context, id int64) (*Movie, bool) {
+	if c.cache == nil || c.writes != nil {
+		return nil, false
+	}
+
+	var cached cachedMovie
+	if !c.decode(ctx, movieCacheKey(ctx, id), &cached) {
+		return nil, false
+	}
+
+	cached.Movie.posterKey = cached.PosterKey
+	return cached.Movie, true
+}
+
+func (c movieCache) set(ctx context.Context, movie *Movie) {
+	if c.cache == nil || c.writes != nil {
+		return
+	}
+
+	c.encode(ctx, movieCacheKey(ctx, movie.ID), cachedMovie{Movie: movie, PosterKey: movie.posterKey})
+}
+
+/* The key of a page of movies, "" when there is no cache */
+func (c movieCache) listKey(ctx context.Context, search MovieSearch, f Filters) string {
+	if c.cache == nil || c.writes != nil {
+		return ""
+	}
+
+	generation, found, err := c.cache.Get(ctx, movieListGenerationKey)
+	if err != nil {
+		return ""
+	}
+
+	if !found {
+		generation, err = c.newGeneration(ctx)
+		if err != nil {
+			return ""
+		}
+	}
+
+	/* The safelist doesn't change the rows, only whether the sort was valid */
+	js, err := json.Marshal(struct {
+		Tenant      int64
+		Search      MovieSearch
+		Page        int
+		PageSize    int
+		Sort        string
+		MaxPageSize int
+		Cursor      *Cursor
+	}{TenantID(ctx), search, f.Page, f.PageSize, f.Sort, f.MaxPageSize, f.Cursor})
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(js)
+	return "movies:" + string(generation) + ":" + hex.EncodeToString(sum[:])
+}
+
+func (c movieCache) getList(ctx context.Context, key string) ([]*Movie, Metadata, bool) {
+	if key == "" {
+		return nil, Metadata{}, false
+	}
+
+	var cached cachedMovieList
+	if !c.decode(ctx, key, &cached) {
+		return nil, Metadata{}, false
+	}
+
+	/* gob leaves an empty page nil, handlers write it as [] */
+	if cached.Movies == nil {
+		cached.Movies = []*Movie{}
+	}
+
+	return cached.Movies, cached.Metadata, true
+}
+
+func (c movieCache) setList(ctx context.Context, key string, movies []*Movie, metadata Metadata) {
+	if key == "" {
+		return
+	}
+
+	c.encode(ctx, key, cachedMovieList{Movies: movies, Metadata: metadata})
+}
+
+/* Drops the movie and every page, see invalidateLists */
+func (c movieCache) invalidate(ctx context.Context, id int64) {
+	if c.cache == nil {
+		return
+	}
+
+	if c.writes != nil {
+		c.writes.keys = append(c.writes.keys, movieCacheKey(ctx, id))
+		c.writes.lists = true
+		return
+	}
+
+	c.cache.Delete(ctx, movieCacheKey(ctx, id))
+	c.newGeneration(ctx)
+}
+
+/* Drops every page with a new generation, for new movies that no page has yet */
+func (c movieCache) invalidateLists(ctx context.Context) {
+	if c.cache == nil {
+		return
+	}
+
+	if c.writes != nil {
+		c.writes.lists = true
+		return
+	}
+
+	c.newGeneration(ctx)
+}
+
+/* Random, so instances starting a generation at the same time don't pick the same one */
+func (c movieCache) newGeneration(ctx context.Context) ([]byte, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	generation := []byte(hex.EncodeToString(b))
+
+	/* Pages of an older generation expire with their TTL */
+	err = c.cache.Set(ctx, movieListGenerationKey, generation, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return generation, nil
+}
+
+func (c movieCache) decode(ctx context.Context, key string, dst any) bool {
+	value, found, err := c.cache.Get(ctx, key)
+	if err != nil || !found {
+		return false
+	}
+
+	return gob.NewDecoder(bytes.NewReader(value)).Decode(dst) == nil
+}
+
+func (c movieCache) encode(ctx context.Context, key string, value any) {
+	var buf bytes.Buffer
+
+	err := gob.NewEncoder(&buf).Encode(value)
+	if err != nil {
+		return
+	}
+
+	c.cache.Set(ctx, key, buf.Bytes(), c.ttl)
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	ExportQueued    = "queued"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+)
+
+/*
+An export of the movies matching a search, written to a file in the background.
+The file can be downloaded from DownloadURL once the job is completed, until
+ExpiresAt when the job and its file are deleted.
+*/
+type ExportJob struct {
+	ID        int64     `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	Format    string    `json:"format" xml:"format"`
+	/* queued, running, completed or failed */
+	Status string `json:"status" xml:"status"`
+	/* Known once the job is running */
+	RowsTotal   *int64     `json:"rows_total,omitempty" xml:"rows_total,omitempty"`
+	RowsDone    int64      `json:"rows_done" xml:"rows_done"`
+	DownloadURL string     `json:"download_url,omitempty" xml:"download_url,omitempty"`
+	FileSize    int64      `json:"file_size,omitempty" xml:"file_size,omitempty"`
+	Error       string     `json:"error,omitempty" xml:"error,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" xml:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at" xml:"expires_at"`
+
+	TenantID int64       `json:"-" xml:"-"`
+	UserID   int64       `json:"-" xml:"-"`
+	Search   MovieSearch `json:"-" xml:"-"`
+	Sort     string      `json:"-" xml:"-"`
+	FileKey  string      `json:"-" xml:"-"`
+}
+
+type ExportJobModel struct {
+	DB DBTX
+}
+
+/* Queues the job in the tenant of ctx, it expires ttl from now */
+func (m ExportJobModel) Insert(ctx context.Context, job *ExportJob, ttl time.Duration) error {
+	query := `
+		INSERT INTO export_jobs (tenant_id, user_id, format, search, sort, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW() + $6 * interval '1 second')
+		RETURNING id, created_at, status, expires_at`
+
+	search, err := json.Marshal(job.Search)
+	if err != nil {
+		return err
+	}
+
+	job.TenantID = TenantID(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{job.TenantID, job.UserID, job.Format, search, job.Sort, int64(ttl.Seconds())}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&job.ID, &job.CreatedAt, &job.Status, &job.ExpiresAt)
+}
+
+/* The user's job, ErrRecordNotFound for someone else's and expired ones */
+func (m ExportJobModel) GetForUser(ctx context.Context, id, userID int64) (*ExportJob, error) {
+	query := `
+		SELECT id, created_at, tenant_id, user_id, format, search, sort, status, rows_total, rows_done,
+			file_key, file_url, file_size, error, completed_at, expires_at
+		FROM export_jobs
+		WHERE id = $1 AND user_id = $2 AND expires_at > NOW()`
+
+	var job ExportJob
+	var search []byte
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.TenantID,
+		&job.UserID,
+		&job.Format,
+		&search,
+		&job.Sort,
+		&job.Status,
+		&job.RowsTotal,
+		&job.RowsDone,
+		&job.FileKey,
+		&job.DownloadURL,
+		&job.FileSize,
+		&job.Error,
+		&job.CompletedAt,
+		&job.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(search, &job.Search)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+/* Marks the job running with the number of rows it is going to write */
+func (m ExportJobModel) Start(ctx context.Context, id, rowsTotal int64) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'running', rows_total = $2, rows_done = 0
+		WHERE id = $1`
+
+	return m.exec(ctx, query, id, rowsTotal)
+}
+
+func (m ExportJobModel) SetProgress(ctx context.Context, id, rowsDone int64) error {
+	query := `
+		UPDATE export_jobs
+		SET rows_done = $2
+		WHERE id = $1`
+
+	return m.exec(ctx, query, id, rowsDone)
+}
+
+/* Records the stored file of the job */
+func (m ExportJobModel) Complete(ctx context.Context, job *ExportJob) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'completed', rows_done = $2, file_key = $3, file_url = $4, file_size = $5, completed_at = NOW()
+		WHERE id = $1`
+
+	return m.exec(ctx, query, job.ID, job.RowsDone, job.FileKey, job.DownloadURL, job.FileSize)
+}
+
+/* message is shown to the user, it shouldn't tell more than they need */
+func (m ExportJobModel) Fail(ctx context.Context, id int64, message string) error {
+	query := `
+		UPDATE export_jobs
+		SET status = 'failed', error = $2, completed_at = NOW()
+		WHERE id = $1`
+
+	return m.exec(ctx, query, id, message)
+}
+
+/* Deletes the expired jobs, returning the storage keys of their files to be deleted too */
+func (m ExportJobModel) DeleteExpired(ctx context.Context) ([]string, error) {
+	query := `
+		DELETE FROM export_jobs
+		WHERE expires_at <= NOW()
+		RETURNING file_key`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var key string
+
+		err := rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+/* Runs an update of the job, ErrRecordNotFound when it was deleted meanwhile */
+func (m ExportJobModel) exec(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
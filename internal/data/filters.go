@@ -1,7 +1,11 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/mohafarman/greenlight/internal/validator"
@@ -12,14 +16,84 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	/* PageSize must be less than this, 100 when left at zero */
+	MaxPageSize int
+	/* Keyset pagination after this position instead of Page, nil for page mode */
+	Cursor *Cursor
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int `json:"current_page,omitempty" xml:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty" xml:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty" xml:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty" xml:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty" xml:"total_records,omitempty"`
+	/* Cursor of the following page in cursor mode, empty on the last page */
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+/*
+Cursor is the position of the last row of a page: its value in the sort column
+and its ID, which breaks ties. Clients only see it encoded, so its layout can
+change without breaking them. The zero Cursor starts at the first page.
+*/
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+/* Decodes a cursor returned in Metadata.NextCursor, "" decodes to the first page */
+func DecodeCursor(s string) (*Cursor, error) {
+	var cursor Cursor
+
+	if s == "" {
+		return &cursor, nil
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(js, &cursor)
+	if err != nil || cursor.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+/*
+Whether the cursor's value can be compared with column: the integer columns
+take an integer in their range, text any value but NUL, which Postgres rejects.
+Cursors are only encoded for the columns of Movie.sortValue.
+*/
+func (c Cursor) valueFits(column string) bool {
+	var err error
+
+	switch column {
+	case "id":
+		_, err = strconv.ParseInt(c.Value, 10, 64)
+	case "year", "runtime":
+		_, err = strconv.ParseInt(c.Value, 10, 32)
+	default:
+		return !strings.ContainsRune(c.Value, 0)
+	}
+
+	return err == nil
+}
+
+/* Whether this is the first page, with no position to continue from */
+func (c Cursor) first() bool {
+	return c.ID == 0
 }
 
 func (f Filters) sortColumn() string {
@@ -40,6 +114,15 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+/* Comparison selecting the rows after the cursor in the sort direction */
+func (f Filters) cursorOperator() string {
+	if f.sortDirection() == "DESC" {
+		return "<"
+	}
+
+	return ">"
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -63,10 +146,24 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.CheckField(f.Page > 0, "page", "must be greater than zero")
-	v.CheckField(f.Page <= 10_000_000, "page", "must be less than 10 million")
-	v.CheckField(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.CheckField(f.PageSize < 100, "page_size", "must be less than 100")
+	v.CheckField(validator.Min(f.Page, 1), "page", validator.Message("validation.greater_than_zero"))
+	v.CheckField(validator.Max(f.Page, 10_000_000), "page", validator.Message("validation.max", 10_000_000))
+	v.CheckField(validator.Min(f.PageSize, 1), "page_size", validator.Message("validation.greater_than_zero"))
+	maxPageSize := f.MaxPageSize
+	if maxPageSize == 0 {
+		maxPageSize = 100
+	}
+	v.CheckField(validator.Max(f.PageSize, maxPageSize-1), "page_size", validator.Message("validation.less_than", maxPageSize))
+
+	v.CheckField(validator.In(f.Sort, f.SortSafelist...), "sort", validator.Message("validation.invalid_sort"))
 
-	v.CheckField(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	/* The position is a value of the sort column, it can't carry over to another sort */
+	if f.Cursor != nil && !f.Cursor.first() {
+		v.CheckField(f.Cursor.Sort == f.Sort, "cursor", validator.Message("validation.cursor_sort"))
+
+		/* The value is compared with the column in SQL, one of another type would fail the query */
+		if f.Cursor.Sort == f.Sort && validator.In(f.Sort, f.SortSafelist...) {
+			v.CheckField(f.Cursor.valueFits(f.sortColumn()), "cursor", validator.Message("validation.cursor"))
+		}
+	}
 }
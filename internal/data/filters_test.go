@@ -0,0 +1,167 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+var testSortSafelist = []string{"id", "title", "year", "-id", "-title", "-year"}
+
+func TestValidateFiltersSort(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  string
+		valid bool
+	}{
+		{"ascending", "title", true},
+		{"descending", "-year", true},
+		{"empty", "", false},
+		{"unknown column", "password_hash", false},
+		{"sql injection", "id; DROP TABLE movies", false},
+		{"injected direction", "id DESC", false},
+		{"comment", "id--", false},
+		{"subquery", "(SELECT 1)", false},
+		{"double minus", "--id", false},
+		{"case differs", "Title", false},
+		{"trailing space", "title ", false},
+		{"quoted", `"title"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafelist: testSortSafelist})
+
+			if v.Valid() != tt.valid {
+				t.Errorf("got valid %t; want %t (errors %v)", v.Valid(), tt.valid, v.Errors)
+			}
+			if !tt.valid && len(v.Errors["sort"]) == 0 {
+				t.Errorf("got no sort error; want one (errors %v)", v.Errors)
+			}
+		})
+	}
+}
+
+func TestSortColumn(t *testing.T) {
+	tests := []struct {
+		sort      string
+		column    string
+		direction string
+	}{
+		{"id", "id", "ASC"},
+		{"-title", "title", "DESC"},
+		{"year", "year", "ASC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			f := Filters{Sort: tt.sort, SortSafelist: testSortSafelist}
+
+			if got := f.sortColumn(); got != tt.column {
+				t.Errorf("got column %q; want %q", got, tt.column)
+			}
+			if got := f.sortDirection(); got != tt.direction {
+				t.Errorf("got direction %q; want %q", got, tt.direction)
+			}
+		})
+	}
+}
+
+/* A sort that skipped validation must never reach the query */
+func TestSortColumnUnsafe(t *testing.T) {
+	tests := []string{
+		"",
+		"password_hash",
+		"id; DROP TABLE movies",
+		"-id DESC, (SELECT pg_sleep(10))",
+		"--title",
+	}
+
+	for _, sort := range tests {
+		t.Run(sort, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("sortColumn(%q) didn't panic", sort)
+				}
+			}()
+
+			f := Filters{Sort: sort, SortSafelist: testSortSafelist}
+			f.sortColumn()
+		})
+	}
+}
+
+/* A tampered cursor is a validation error rather than a failed query */
+func TestValidateFiltersCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		sort   string
+		cursor Cursor
+		valid  bool
+	}{
+		{"first page", "year", Cursor{}, true},
+		{"id", "id", Cursor{Sort: "id", Value: "42", ID: 42}, true},
+		{"year", "-year", Cursor{Sort: "-year", Value: "1999", ID: 7}, true},
+		{"title", "title", Cursor{Sort: "title", Value: "Moana'; DROP TABLE movies --", ID: 7}, true},
+		{"other sort", "year", Cursor{Sort: "title", Value: "Moana", ID: 7}, false},
+		{"text for year", "year", Cursor{Sort: "year", Value: "abc", ID: 7}, false},
+		{"empty for year", "year", Cursor{Sort: "year", Value: "", ID: 7}, false},
+		{"decimal for year", "year", Cursor{Sort: "year", Value: "1999.5", ID: 7}, false},
+		{"year out of range", "year", Cursor{Sort: "year", Value: "9999999999", ID: 7}, false},
+		{"text for id", "-id", Cursor{Sort: "-id", Value: "1 OR 1=1", ID: 7}, false},
+		{"NUL in title", "title", Cursor{Sort: "title", Value: "Moa\x00na", ID: 7}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			/* As the client sends it, the first page as an empty cursor */
+			encoded := ""
+			if tt.cursor != (Cursor{}) {
+				encoded = tt.cursor.Encode()
+			}
+
+			cursor, err := DecodeCursor(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := validator.New()
+			ValidateFilters(v, Filters{Page: 1, PageSize: 20, Sort: tt.sort, SortSafelist: testSortSafelist, Cursor: cursor})
+
+			if v.Valid() != tt.valid {
+				t.Errorf("got valid %t; want %t (errors %v)", v.Valid(), tt.valid, v.Errors)
+			}
+			if !tt.valid && len(v.Errors["cursor"]) == 0 {
+				t.Errorf("got no cursor error; want one (errors %v)", v.Errors)
+			}
+		})
+	}
+}
+
+func TestValidateFiltersPageSize(t *testing.T) {
+	tests := []struct {
+		pageSize    int
+		maxPageSize int
+		valid       bool
+	}{
+		{1, 0, true},
+		{99, 0, true},
+		{100, 0, false},
+		{0, 0, false},
+		{4999, 5000, true},
+		{5000, 5000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d of %d", tt.pageSize, tt.maxPageSize), func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, Filters{Page: 1, PageSize: tt.pageSize, MaxPageSize: tt.maxPageSize, Sort: "id", SortSafelist: testSortSafelist})
+
+			if v.Valid() != tt.valid {
+				t.Errorf("got valid %t; want %t (errors %v)", v.Valid(), tt.valid, v.Errors)
+			}
+		})
+	}
+}
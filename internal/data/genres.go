@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Genre struct {
+	ID   int64  `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	/* Movies of the genre in the tenant's catalogue, those in the trash aren't counted */
+	MovieCount int `json:"movie_count" xml:"movie_count"`
+}
+
+type GenreModel struct {
+	DB DBTX
+}
+
+/*
+The genres of the movies in the catalogue, by name. Genres are created along
+with the first movie that has them, those left without movies aren't listed.
+*/
+func (m GenreModel) GetAll(ctx context.Context) ([]*Genre, error) {
+	query := `
+		SELECT genres.id, genres.name, count(*)
+		FROM genres
+		INNER JOIN movies_genres ON movies_genres.genre_id = genres.id
+		INNER JOIN movies ON movies.id = movies_genres.movie_id
+		WHERE movies.tenant_id = $1 AND movies.deleted_at IS NULL
+		GROUP BY genres.id
+		ORDER BY genres.name`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, TenantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.ID, &genre.Name, &genre.MovieCount)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+/* ErrRecordNotFound unless a movie in the catalogue has the genre, like GetAll */
+func (m GenreModel) Get(ctx context.Context, name string) (*Genre, error) {
+	query := `
+		SELECT genres.id, genres.name, count(*)
+		FROM genres
+		INNER JOIN movies_genres ON movies_genres.genre_id = genres.id
+		INNER JOIN movies ON movies.id = movies_genres.movie_id
+		WHERE genres.name = $1 AND movies.tenant_id = $2 AND movies.deleted_at IS NULL
+		GROUP BY genres.id`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, name, TenantID(ctx)).Scan(&genre.ID, &genre.Name, &genre.MovieCount)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
@@ -0,0 +1,230 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+/* The fields of a movie that Update changes and the history keeps */
+type MovieValues struct {
+	Title   string   `json:"title" xml:"title"`
+	Year    int32    `json:"year" xml:"year"`
+	Runtime Runtime  `json:"runtime" xml:"runtime"`
+	Genres  []string `json:"genres" xml:"genres>genre"`
+}
+
+func movieValues(movie *Movie) MovieValues {
+	return MovieValues{Title: movie.Title, Year: movie.Year, Runtime: movie.Runtime, Genres: movie.Genres}
+}
+
+/* Sets the fields of movie to values */
+func (values MovieValues) Apply(movie *Movie) {
+	movie.Title = values.Title
+	movie.Year = values.Year
+	movie.Runtime = values.Runtime
+	movie.Genres = values.Genres
+}
+
+/* The names of the fields that differ between values and other */
+func (values MovieValues) Diff(other MovieValues) []string {
+	changed := []string{}
+
+	if values.Title != other.Title {
+		changed = append(changed, "title")
+	}
+	if values.Year != other.Year {
+		changed = append(changed, "year")
+	}
+	if values.Runtime != other.Runtime {
+		changed = append(changed, "runtime")
+	}
+	if !slices.Equal(values.Genres, other.Genres) {
+		changed = append(changed, "genres")
+	}
+
+	return changed
+}
+
+/*
+An update of a movie, Version is the version it gave the movie. Posters,
+enrichment and reviews, through the average rating, change the version as well
+but aren't recorded, so versions can be missing from the history.
+*/
+type MovieHistoryEntry struct {
+	Version  int32     `json:"version" xml:"version"`
+	EditedAt time.Time `json:"edited_at" xml:"edited_at"`
+	/* Nil once the editor is deleted */
+	EditorID *int64      `json:"editor_id,omitempty" xml:"editor_id,omitempty"`
+	Old      MovieValues `json:"old" xml:"old"`
+	New      MovieValues `json:"new" xml:"new"`
+	/* The fields that differ between Old and New */
+	Changed []string `json:"changed" xml:"changed>field"`
+}
+
+type MovieHistoryModel struct {
+	DB DBTX
+}
+
+/* Written by MovieModel.Update in its transaction, editorID 0 for none */
+func insertMovieHistory(ctx context.Context, tx DBTX, movieID int64, version int32, editorID int64, old, new MovieValues) error {
+	query := `
+		INSERT INTO movies_history (movie_id, version, editor_id, old_values, new_values)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5)`
+
+	oldJSON, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+
+	newJSON, err := json.Marshal(new)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, movieID, version, editorID, oldJSON, newJSON)
+	return err
+}
+
+/* The updates of the movie, newest first */
+func (m MovieHistoryModel) GetAll(ctx context.Context, movieID int64, f Filters) ([]*MovieHistoryEntry, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), movies_history.version, edited_at, editor_id, old_values, new_values
+		FROM movies_history
+		INNER JOIN movies ON movies.id = movies_history.movie_id
+		WHERE movie_id = $1 AND movies.tenant_id = $2
+		ORDER BY movies_history.version DESC
+		LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, TenantID(ctx), f.limit(), f.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*MovieHistoryEntry{}
+
+	for rows.Next() {
+		entry, err := scanMovieHistoryEntry(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+/* The update that gave the movie version, ErrRecordNotFound if none did */
+func (m MovieHistoryModel) Get(ctx context.Context, movieID int64, version int32) (*MovieHistoryEntry, error) {
+	query := `
+		SELECT 1, movies_history.version, edited_at, editor_id, old_values, new_values
+		FROM movies_history
+		INNER JOIN movies ON movies.id = movies_history.movie_id
+		WHERE movie_id = $1 AND movies_history.version = $2 AND movies.tenant_id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	entry, err := scanMovieHistoryEntry(m.DB.QueryRowContext(ctx, query, movieID, version, TenantID(ctx)), &count)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+/*
+The values the movie had at version: what the last update up to it set, or
+before any, what the first update after it replaced. ErrRecordNotFound when
+the movie has no history, its values never changed.
+*/
+func (m MovieHistoryModel) ValuesAt(ctx context.Context, movieID int64, version int32) (*MovieValues, error) {
+	query := `
+		SELECT movie_values FROM (
+			(SELECT new_values AS movie_values, 0 AS preference
+			FROM movies_history
+			WHERE movie_id = $1 AND version <= $2
+			ORDER BY version DESC
+			LIMIT 1)
+			UNION ALL
+			(SELECT old_values, 1
+			FROM movies_history
+			WHERE movie_id = $1 AND version > $2
+			ORDER BY version ASC
+			LIMIT 1)
+		) AS candidates
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = $1 AND tenant_id = $3)
+		ORDER BY preference
+		LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var js []byte
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version, TenantID(ctx)).Scan(&js)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var values MovieValues
+
+	err = json.Unmarshal(js, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &values, nil
+}
+
+/* Scans a row of GetAll or Get, the first column being the total count */
+func scanMovieHistoryEntry(row interface{ Scan(...any) error }, totalRecords *int) (*MovieHistoryEntry, error) {
+	var (
+		entry        MovieHistoryEntry
+		oldJS, newJS []byte
+	)
+
+	err := row.Scan(totalRecords, &entry.Version, &entry.EditedAt, &entry.EditorID, &oldJS, &newJS)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(oldJS, &entry.Old)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(newJS, &entry.New)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Changed = entry.Old.Diff(entry.New)
+
+	return &entry, nil
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+/* An account at an OAuth provider, signing in with it signs in as the user */
+type Identity struct {
+	Provider string
+	Subject  string
+	UserID   int64
+	Email    string
+}
+
+type IdentityModel struct {
+	DB DBTX
+}
+
+/* The user the account is linked to, ErrRecordNotFound if it isn't yet */
+func (m IdentityModel) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	query := `
+		SELECT user_id
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2 AND tenant_id = $3`
+
+	var userID int64
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject, TenantID(ctx)).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (m IdentityModel) Insert(ctx context.Context, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email, tenant_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant_id, provider, subject) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, identity.Provider, identity.Subject, identity.UserID, identity.Email, TenantID(ctx))
+	return err
+}
@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/cache"
 )
 
 var (
@@ -10,19 +14,70 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+/*
+A *sql.DB or a transaction, for queries run on their own or as part of one. The
+models of Models.WithTx have its transaction, the others the database.
+*/
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 // Models struct to wrap all other models.
 // A single "container" which will hold all database models
+//
+// The models take the caller's context, e.g. the request's, so a client going
+// away or a request timing out cancels its queries; each query gets 3 seconds
+// at most.
 type Models struct {
 	Movies      MovieModel
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionsModel
+	Roles       RoleModel
+	Webhooks    WebhookModel
+	Reviews     ReviewModel
+	Watchlists  WatchlistModel
+	Genres      GenreModel
+	People      PersonModel
+	APIKeys     APIKeyModel
+	TwoFactor   TwoFactorModel
+	Identities  IdentityModel
+	Outbox      OutboxModel
+	AuditLog    AuditLogModel
+	/* The users' own searches, see SavedSearchModel.Matching */
+	SavedSearches SavedSearchModel
+	/* RecommendationModel unless replaced */
+	Recommendations Recommender
+	/* The catalogues the others are scoped to, see WithTenant */
+	Tenants TenantModel
+	Usage   UsageModel
+	Stats   StatsModel
+	/* Exports run by the job queue, see runExportJob */
+	ExportJobs ExportJobModel
+	/* Written by MovieModel.Update */
+	MovieHistory MovieHistoryModel
+
+	/* For WithTx */
+	db     DBTX
+	movies movieCache
 }
 
-func NewModels(db *sql.DB) Models {
+/*
+Movie reads go to replica, which may be nil, and are cached in c for ttl; c may
+be nil to read them from the database every time.
+*/
+func NewModels(db *sql.DB, replica *Replica, c cache.Cache, ttl time.Duration) Models {
+	return newModels(db, replica, movieCache{cache: c, ttl: ttl})
+}
+
+func newModels(db DBTX, replica *Replica, movies movieCache) Models {
 	return Models{
 		Movies: MovieModel{
-			DB: db,
+			DB:      db,
+			Replica: replica,
+			cache:   movies,
 		},
 		Users: UserModel{
 			DB: db,
@@ -33,5 +88,111 @@ func NewModels(db *sql.DB) Models {
 		Permissions: PermissionsModel{
 			DB: db,
 		},
+		Roles: RoleModel{
+			DB: db,
+		},
+		Webhooks: WebhookModel{
+			DB: db,
+		},
+		Reviews: ReviewModel{
+			DB:    db,
+			cache: movies,
+		},
+		Watchlists: WatchlistModel{
+			DB: db,
+		},
+		Genres: GenreModel{
+			DB: db,
+		},
+		People: PersonModel{
+			DB: db,
+		},
+		APIKeys: APIKeyModel{
+			DB: db,
+		},
+		TwoFactor: TwoFactorModel{
+			DB: db,
+		},
+		Identities: IdentityModel{
+			DB: db,
+		},
+		Outbox: OutboxModel{
+			DB: db,
+		},
+		AuditLog: AuditLogModel{
+			DB: db,
+		},
+		SavedSearches: SavedSearchModel{
+			DB: db,
+		},
+		Recommendations: RecommendationModel{
+			DB: db,
+		},
+		Tenants: TenantModel{
+			DB: db,
+		},
+		Usage: UsageModel{
+			DB: db,
+		},
+		Stats: StatsModel{
+			DB:      db,
+			Replica: replica,
+		},
+		ExportJobs: ExportJobModel{
+			DB: db,
+		},
+		MovieHistory: MovieHistoryModel{
+			DB: db,
+		},
+		db:     db,
+		movies: movies,
+	}
+}
+
+/*
+WithTx runs fn with models whose queries all go to one transaction, committed
+when fn returns nil and rolled back when it returns an error, so the writes of
+several models, e.g. a user with their roles and tokens, are made all together
+or not at all. The transactions of the models themselves become savepoints of
+it, a model failing in fn only undoes its own writes and fn can carry on.
+
+Reads in fn see its writes: they skip the replica and the cache, and the movies
+fn writes are dropped from the cache once the transaction commits. WithTx of
+the models fn gets nests like the models' transactions do.
+*/
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	tx, err := beginTx(ctx, m.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	movies := m.movies
+	if movies.writes == nil {
+		movies.writes = &cacheWrites{}
+	}
+
+	models := newModels(tx, nil, movies)
+
+	/* A replacement has no transaction to take part in, it is kept as it is */
+	if _, ok := m.Recommendations.(RecommendationModel); !ok {
+		models.Recommendations = m.Recommendations
 	}
+
+	err = fn(models)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	/* Nested, the outermost transaction drops them once it commits */
+	if m.movies.writes == nil {
+		m.movies.drop(ctx, movies.writes)
+	}
+
+	return nil
 }
@@ -5,64 +5,272 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"github.com/mohafarman/greenlight/internal/validator"
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID        int64     `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"-" xml:"-"`
+	/* The validate tags are checked by ValidateMovie */
+	Title   string   `json:"title" xml:"title" validate:"required,max=100"`
+	Year    int32    `json:"year,omitempty" xml:"year,omitempty" validate:"required,min=1888"`
+	Runtime Runtime  `json:"runtime,omitempty" xml:"runtime,omitempty" validate:"required,min=1"`
+	Genres  []string `json:"genres,omitempty" xml:"genres>genre,omitempty" validate:"required,max=5,unique,dive,required"`
+	/* Mean of the reviews' ratings, nil until the first review */
+	AverageRating *float64 `json:"average_rating,omitempty" xml:"average_rating,omitempty"`
+	PosterURL     string   `json:"poster_url,omitempty" xml:"poster_url,omitempty"`
+	/* Looked up at a metadata service and only loaded by Get, see SetEnrichment */
+	Plot       string     `json:"plot,omitempty" xml:"plot,omitempty"`
+	IMDbID     string     `json:"imdb_id,omitempty" xml:"imdb_id,omitempty"`
+	IMDbRating *float64   `json:"imdb_rating,omitempty" xml:"imdb_rating,omitempty"`
+	Cast       []string   `json:"cast,omitempty" xml:"cast>name,omitempty"`
+	EnrichedAt *time.Time `json:"enriched_at,omitempty" xml:"enriched_at,omitempty"`
+	/* Only loaded on request, see PersonModel.GetCreditsForMovies */
+	Credits []*Credit `json:"credits,omitempty" xml:"credits>credit,omitempty"`
+	/* Set for movies in the trash only, see GetDeleted */
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+	Version   int32      `json:"version" xml:"version"`
+
+	/* Storage key of the poster, set through SetPoster only */
+	posterKey string
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	/* The primary, for writes and reads that must see them */
+	DB DBTX
+	/* Get, GetAll and the streams read from it, see Replica */
+	Replica *Replica
+
+	/* Get and GetAll read through it when it has a cache, see movieCache */
+	cache movieCache
+}
+
+/*
+The genres of the movie in the enclosing query, in the order they were given,
+so queries read Movie.Genres as when it was a text[] column of movies.
+*/
+const movieGenres = `ARRAY(
+			SELECT genres.name
+			FROM movies_genres
+			INNER JOIN genres ON genres.id = movies_genres.genre_id
+			WHERE movies_genres.movie_id = movies.id
+			ORDER BY movies_genres.position)`
+
+/* What a movie listing is narrowed down to, the zero value matches every movie */
+type MovieSearch struct {
+	/* Full-text search on the title, see titleSearchQuery */
+	Title string
+	/* The movie must have all of them */
+	Genres []string
+	/* The movie must have at least one of them */
+	GenresAny []string
+	/* Names of people credited with the movie, compared case-insensitively */
+	Director string
+	Actor    string
+	/* Inclusive bounds, nil for none */
+	YearGTE    *int
+	YearLTE    *int
+	RuntimeGTE *int
+	RuntimeLTE *int
+	/* Exclusive bounds on when the movie was added, nil for none */
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
 }
 
-func (m *MovieModel) Insert(movie *Movie) error {
+/* Credits of the movie in the enclosing query, for further conditions */
+const movieCreditedAs = `
+			SELECT 1
+			FROM credits
+			INNER JOIN people ON people.id = credits.person_id
+			WHERE credits.movie_id = movies.id`
+
+/* The movies in the tenant's catalogue matching the search */
+func (s MovieSearch) where(ctx context.Context) *whereClause {
+	where := &whereClause{}
+
+	where.add("tenant_id = " + where.arg(TenantID(ctx)))
+
+	if s.Title != "" {
+		where.add("title_search @@ to_tsquery('english', " + where.arg(titleSearchQuery(s.Title)) + ")")
+	}
+
+	if len(s.Genres) > 0 {
+		where.add(movieGenres + " @> " + where.arg(pq.Array(s.Genres)))
+	}
+
+	if len(s.GenresAny) > 0 {
+		where.add(movieGenres + " && " + where.arg(pq.Array(s.GenresAny)))
+	}
+
+	if s.Director != "" {
+		where.add("EXISTS (" + movieCreditedAs + " AND credits.role = 'director' AND lower(people.name) = lower(" + where.arg(s.Director) + "))")
+	}
+
+	if s.Actor != "" {
+		where.add("EXISTS (" + movieCreditedAs + " AND credits.role = 'actor' AND lower(people.name) = lower(" + where.arg(s.Actor) + "))")
+	}
+
+	if s.YearGTE != nil {
+		where.add("year >= " + where.arg(*s.YearGTE))
+	}
+
+	if s.YearLTE != nil {
+		where.add("year <= " + where.arg(*s.YearLTE))
+	}
+
+	if s.RuntimeGTE != nil {
+		where.add("runtime >= " + where.arg(*s.RuntimeGTE))
+	}
+
+	if s.RuntimeLTE != nil {
+		where.add("runtime <= " + where.arg(*s.RuntimeLTE))
+	}
+
+	if s.CreatedAfter != nil {
+		where.add("created_at > " + where.arg(*s.CreatedAfter))
+	}
+
+	if s.CreatedBefore != nil {
+		where.add("created_at < " + where.arg(*s.CreatedBefore))
+	}
+
+	where.add("deleted_at IS NULL")
+
+	return where
+}
+
+/* Whether the search can't match anything, e.g. a title of only punctuation */
+func (s MovieSearch) empty() bool {
+	return s.Title != "" && titleSearchQuery(s.Title) == ""
+}
+
+func (m *MovieModel) Insert(ctx context.Context, movie *Movie) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidateLists(ctx)
+
+	return nil
+}
+
+func insertMovie(ctx context.Context, tx DBTX, movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
+		INSERT INTO movies (title, year, runtime, tenant_id)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id, created_at, version
 		`
 
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, TenantID(ctx)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	return setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+}
+
+/* Replaces the genres of a movie, creating the genres no movie had before */
+func setMovieGenres(ctx context.Context, tx DBTX, movieID int64, genres []string) error {
+	query := `
+		INSERT INTO genres (name)
+		SELECT unnest($1::text[])
+		ON CONFLICT (name) DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(genres))
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM movies_genres
+		WHERE movie_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, movieID)
+	if err != nil {
+		return err
+	}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	query = `
+		INSERT INTO movies_genres (movie_id, genre_id, position)
+		SELECT $1, genres.id, given.position
+		FROM unnest($2::text[]) WITH ORDINALITY AS given(name, position)
+		INNER JOIN genres ON genres.name = given.name`
+
+	_, err = tx.ExecContext(ctx, query, movieID, pq.Array(genres))
+	return err
 }
 
-func (m *MovieModel) Get(id int64) (*Movie, error) {
+func (m *MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	/* Context w/ 3-second timeout */
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if cached, found := m.cache.get(ctx, id); found {
+		return cached, nil
+	}
+
+	movie, err := m.load(ctx, id, m.Replica)
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache.set(ctx, movie)
+
+	return movie, nil
+}
+
+/* Reads the movie from replica, from the primary when it is nil */
+func (m *MovieModel) load(ctx context.Context, id int64, replica *Replica) (*Movie, error) {
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT id, created_at, title, year, runtime, ` + movieGenres + `, average_rating, poster_key, poster_url,
+			plot, imdb_id, imdb_rating, top_cast, enriched_at, version
 		FROM movies
-		WHERE id = $1;`
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL;`
 
 	var movie Movie
 
-	/* Context w/ 3-second timeout */
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := replica.queryRow(ctx, m.DB, query, []any{id, TenantID(ctx)},
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
+		&movie.AverageRating,
+		&movie.posterKey,
+		&movie.PosterURL,
+		&movie.Plot,
+		&movie.IMDbID,
+		&movie.IMDbRating,
+		pq.Array(&movie.Cast),
+		&movie.EnrichedAt,
 		&movie.Version)
 
 	/* Scan may return sql.ErrNoRows */
@@ -79,31 +287,179 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 }
 
 /* Filter parameters as arguments */
-func (m *MovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie, Metadata, error) {
+func (m *MovieModel) GetAll(ctx context.Context, search MovieSearch, f Filters) ([]*Movie, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	key := m.cache.listKey(ctx, search, f)
+	if movies, metadata, found := m.cache.getList(ctx, key); found {
+		return movies, metadata, nil
+	}
+
+	movies := []*Movie{}
+
+	metadata, err := m.Stream(ctx, search, f, func(movie *Movie) error {
+		movies = append(movies, movie)
+		return nil
+	})
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	m.cache.setList(ctx, key, movies, metadata)
+
+	return movies, metadata, nil
+}
+
+/* Bulk reads and writes go through the whole catalogue and get longer than the usual 3 seconds */
+const bulkTimeout = time.Minute
+
+/* Inserts all movies in one transaction, none of them are if one fails */
+func (m *MovieModel) InsertAll(ctx context.Context, movies []*Movie) error {
+	ctx, cancel := context.WithTimeout(ctx, bulkTimeout)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, movie := range movies {
+		err = insertMovie(ctx, tx, movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidateLists(ctx)
+
+	return nil
+}
+
+/* The number of movies StreamAll would hand over for the search */
+func (m *MovieModel) Count(ctx context.Context, search MovieSearch) (int64, error) {
+	if search.empty() {
+		return 0, nil
+	}
+
+	where := search.where(ctx)
+
+	query := fmt.Sprintf(`
+		SELECT count(*)
+		FROM movies
+		WHERE %s`,
+		where)
+
+	ctx, cancel := context.WithTimeout(ctx, bulkTimeout)
+	defer cancel()
+
+	var count int64
+
+	err := m.Replica.queryRow(ctx, m.DB, query, where.args, &count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+/*
+StreamAll hands every movie matching the search to fn in f's sort order,
+without paging, for exports. An error from fn stops the iteration.
+*/
+func (m *MovieModel) StreamAll(ctx context.Context, search MovieSearch, f Filters, fn func(*Movie) error) error {
+	if search.empty() {
+		return nil
+	}
+
+	where := search.where(ctx)
+
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, year, runtime, `+movieGenres+`, average_rating, poster_key, poster_url, version
+		FROM movies
+		WHERE %s
+		ORDER BY %s`,
+		where, movieOrderBy(f, search, where))
+
+	ctx, cancel := context.WithTimeout(ctx, bulkTimeout)
+	defer cancel()
+
+	rows, err := m.Replica.query(ctx, m.DB, query, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.posterKey,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+/*
+Stream runs the same query as GetAll but hands every movie to fn as soon as it is
+scanned instead of collecting them, so large results never sit in memory at once.
+An error from fn stops the iteration and is returned as is.
+*/
+func (m *MovieModel) Stream(ctx context.Context, search MovieSearch, f Filters, fn func(*Movie) error) (Metadata, error) {
+	if f.Cursor != nil {
+		return m.streamAfter(ctx, search, f, fn)
+	}
+
+	if search.empty() {
+		return Metadata{}, nil
+	}
+
+	where := search.where(ctx)
+
 	/* INFO: count(*) OVER() allows us to get metadata from the query */
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, `+movieGenres+`, average_rating, poster_key, poster_url, version
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`,
-		f.sortColumn(), f.sortDirection())
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		where, movieOrderBy(f, search, where), where.arg(f.limit()), where.arg(f.offset()))
 
 	/* Context w/ 3-second timeout */
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), f.limit(), f.offset()}
-
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.Replica.query(ctx, m.DB, query, where.args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return Metadata{}, err
 	}
 	defer rows.Close()
 
 	totalRecords := 0
-	movies := []*Movie{}
 
 	for rows.Next() {
 		var movie Movie
@@ -116,30 +472,188 @@ func (m *MovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.posterKey,
+			&movie.PosterURL,
 			&movie.Version,
 		)
 
 		if err != nil {
-			return nil, Metadata{}, err
+			return Metadata{}, err
 		}
 
-		movies = append(movies, &movie)
+		err = fn(&movie)
+		if err != nil {
+			return Metadata{}, err
+		}
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, f.Page, f.PageSize)
+	/* INFO: A page past the end has no rows to carry count(*) OVER(), count separately so pagers still get the total */
+	if totalRecords == 0 && f.Page > 1 {
+		where := search.where(ctx)
 
-	return movies, metadata, nil
+		query := `
+			SELECT count(*)
+			FROM movies
+			WHERE ` + where.String()
+
+		err = m.Replica.queryRow(ctx, m.DB, query, where.args, &totalRecords)
+		if err != nil {
+			return Metadata{}, err
+		}
+	}
+
+	return calculateMetadata(totalRecords, f.Page, f.PageSize), nil
 }
 
-func (m *MovieModel) Update(movie *Movie) error {
+/*
+Keyset pagination: seeks past the cursor on (sort column, id) instead of
+counting rows to skip, so every page is as fast as the first. There is no
+total count, one extra row is fetched to know whether another page follows.
+*/
+func (m *MovieModel) streamAfter(ctx context.Context, search MovieSearch, f Filters, fn func(*Movie) error) (Metadata, error) {
+	if search.empty() {
+		return Metadata{PageSize: f.PageSize}, nil
+	}
+
+	column, direction := f.sortColumn(), f.sortDirection()
+
+	where := search.where(ctx)
+
+	if !f.Cursor.first() {
+		/* INFO: The value is sent as text, Postgres casts it to the column's type */
+		where.add(fmt.Sprintf("(%s, id) %s (%s, %s)", column, f.cursorOperator(), where.arg(f.Cursor.Value), where.arg(f.Cursor.ID)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, year, runtime, `+movieGenres+`, average_rating, poster_key, poster_url, version
+		FROM movies
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT %s`,
+		where, column, direction, direction, where.arg(f.limit()+1))
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.Replica.query(ctx, m.DB, query, where.args...)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer rows.Close()
+
+	metadata := Metadata{PageSize: f.PageSize}
+
+	var last *Movie
+	count := 0
+
+	for rows.Next() {
+		count++
+
+		/* The extra row only tells that there is a next page */
+		if count > f.limit() {
+			metadata.NextCursor = Cursor{Sort: f.Sort, Value: last.sortValue(column), ID: last.ID}.Encode()
+			break
+		}
+
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.posterKey,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+
+		if err != nil {
+			return Metadata{}, err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return Metadata{}, err
+		}
+
+		last = &movie
+	}
+
+	if err = rows.Err(); err != nil {
+		return Metadata{}, err
+	}
+
+	return metadata, nil
+}
+
+/*
+Turns free text into a prefix tsquery, e.g. "dark knig" into "dark:* & knig:*",
+so partially typed words match too and to_tsquery stems them like the titles.
+Only letters and digits are kept, anything else could be tsquery syntax.
+*/
+func titleSearchQuery(title string) string {
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+/* ORDER BY clause for the filters, the id keeps pages stable on ties */
+func movieOrderBy(f Filters, search MovieSearch, where *whereClause) string {
+	/* Best match first */
+	if f.sortColumn() == "relevance" {
+		return "ts_rank(title_search, to_tsquery('english', " + where.arg(titleSearchQuery(search.Title)) + ")) DESC, id ASC"
+	}
+
+	return fmt.Sprintf("%s %s, id ASC", f.sortColumn(), f.sortDirection())
+}
+
+/* The movie's value in a sort column, as text for a Cursor */
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	}
+
+	/* Logical error in our codebase, a sort column without a cursor value */
+	panic("no cursor value for sort column: " + column)
+}
+
+/*
+Updates the title, year, runtime and genres of the movie at movie.Version and
+records the change in its history, with editorID as the editor or 0 for none.
+*/
+func (m *MovieModel) Update(ctx context.Context, movie *Movie, editorID int64) error {
+	/* Locks the row, no other update can slip in between and the history is complete */
+	oldQuery := `
+		SELECT title, year, runtime, ` + movieGenres + `
+		FROM movies
+		WHERE id = $1 AND version = $2 AND tenant_id = $3 AND deleted_at IS NULL
+		FOR UPDATE`
+
 	query := `
 		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET title = $1, year = $2, runtime = $3, version = version + 1
+		WHERE id = $4 AND version = $5 AND tenant_id = $6 AND deleted_at IS NULL
 		RETURNING version
 		`
 
@@ -147,17 +661,153 @@ func (m *MovieModel) Update(movie *Movie) error {
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
-		pq.Array(movie.Genres),
 		movie.ID,
-		movie.Version}
+		movie.Version,
+		TenantID(ctx)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old MovieValues
+
 	/* If no matching row could be found either the row does not exist
 	   or the version has changed. I.e. optimistic locking based on version
 	   to prevent data race conditions */
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx, oldQuery, movie.ID, movie.Version, TenantID(ctx)).Scan(
+		&old.Title, &old.Year, &old.Runtime, pq.Array(&old.Genres))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	err = insertMovieHistory(ctx, tx, movie.ID, movie.Version, editorID, old, movieValues(movie))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.cache.invalidate(ctx, movie.ID)
+
+	return nil
+}
+
+/*
+UpdateWithRetry reads the movie, applies mutate and updates it like Update,
+over again on an edit conflict, see RetryOnConflict. The reads after a conflict
+skip the cache and the replica, which may not have the conflicting write yet.
+An error from mutate, e.g. a *validator.ValidationError, is returned as it is.
+*/
+func (m *MovieModel) UpdateWithRetry(ctx context.Context, id, editorID int64, attempts int, mutate func(movie *Movie) error) (*Movie, error) {
+	var movie *Movie
+
+	err := RetryOnConflict(ctx, attempts, func(attempt int) error {
+		var err error
+
+		if attempt == 0 {
+			movie, err = m.Get(ctx, id)
+		} else {
+			movie, err = m.load(ctx, id, nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = mutate(movie)
+		if err != nil {
+			return err
+		}
+
+		return m.Update(ctx, movie, editorID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return movie, nil
+}
+
+/*
+Replaces the movie's poster, with the same optimistic locking as Update, and
+returns the storage key of the previous poster so it can be deleted.
+*/
+func (m *MovieModel) SetPoster(ctx context.Context, movie *Movie, key, url string) (string, error) {
+	query := `
+		UPDATE movies
+		SET poster_key = $1, poster_url = $2, version = version + 1
+		WHERE id = $3 AND version = $4 AND tenant_id = $5 AND deleted_at IS NULL
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, url, movie.ID, movie.Version, TenantID(ctx)).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrEditConflict
+		default:
+			return "", err
+		}
+	}
+
+	m.cache.invalidate(ctx, movie.ID)
+
+	previous := movie.posterKey
+	movie.posterKey, movie.PosterURL = key, url
+
+	return previous, nil
+}
+
+/*
+Stores what was looked up about the movie at the given version, ErrEditConflict
+if it has changed since. The poster URL is only taken while no poster was
+uploaded, see SetPoster; movie.PosterURL is the one kept either way.
+*/
+func (m *MovieModel) SetEnrichment(ctx context.Context, movie *Movie, posterURL string) error {
+	query := `
+		UPDATE movies
+		SET plot = $1, imdb_id = $2, imdb_rating = $3, top_cast = $4, enriched_at = NOW(),
+			poster_url = CASE WHEN poster_key = '' AND $5 <> '' THEN $5 ELSE poster_url END,
+			version = version + 1
+		WHERE id = $6 AND version = $7 AND tenant_id = $8 AND deleted_at IS NULL
+		RETURNING poster_url, enriched_at, version`
+
+	/* A nil slice would be NULL */
+	cast := movie.Cast
+	if cast == nil {
+		cast = []string{}
+	}
+
+	args := []any{movie.Plot, movie.IMDbID, movie.IMDbRating, pq.Array(cast), posterURL, movie.ID, movie.Version, TenantID(ctx)}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.PosterURL, &movie.EnrichedAt, &movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -167,22 +817,29 @@ func (m *MovieModel) Update(movie *Movie) error {
 		}
 	}
 
+	m.cache.invalidate(ctx, movie.ID)
+
 	return nil
 }
 
-func (m *MovieModel) Delete(id int64) error {
+/*
+Moves the movie at the given version to the trash, ErrEditConflict if it has
+changed since. The version is bumped so pending updates of it fail too.
+*/
+func (m *MovieModel) Delete(ctx context.Context, id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM movies
-		WHERE id = $1`
+		UPDATE movies
+		SET deleted_at = now(), version = version + 1
+		WHERE id = $1 AND version = $2 AND tenant_id = $3 AND deleted_at IS NULL`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	res, err := m.DB.ExecContext(ctx, query, id)
+	res, err := m.DB.ExecContext(ctx, query, id, version, TenantID(ctx))
 	if err != nil {
 		return err
 	}
@@ -192,27 +849,157 @@ func (m *MovieModel) Delete(id int64) error {
 		return err
 	}
 
-	/* INFO: If no rows are affected that means nothing was deleted */
+	/* INFO: The movie was read just before, so no rows affected means it was changed or deleted meanwhile */
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
 
+	m.cache.invalidate(ctx, id)
+
 	return nil
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.CheckField(validator.NotBlank(movie.Title), "title", "must be provided")
-	v.CheckField(validator.MaxChars(movie.Title, 100), "title", "must not be longer than 100 characters")
+/* The movies in the trash, paginated like GetAll */
+func (m *MovieModel) GetDeleted(ctx context.Context, f Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, `+movieGenres+`, average_rating, poster_key, poster_url, deleted_at, version
+		FROM movies
+		WHERE tenant_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`,
+		f.sortColumn(), f.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, TenantID(ctx), f.limit(), f.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.posterKey,
+			&movie.PosterURL,
+			&movie.DeletedAt,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
 
-	v.CheckField(validator.NotEmpty(movie.Year), "year", "must be provided")
-	v.CheckField(movie.Year >= 1888, "year", "must be greatar than 1888")
-	v.CheckField(validator.NotFuture(movie.Year), "year", "must not be in the future")
+/* Takes the movie out of the trash, ErrRecordNotFound if it isn't in it */
+func (m *MovieModel) Restore(ctx context.Context, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NOT NULL
+		RETURNING id, created_at, title, year, runtime, ` + movieGenres + `, average_rating, poster_key, poster_url, version`
+
+	var movie Movie
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, TenantID(ctx)).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.AverageRating,
+		&movie.posterKey,
+		&movie.PosterURL,
+		&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	m.cache.invalidate(ctx, id)
+
+	return &movie, nil
+}
+
+/*
+Deletes a movie in the trash for good, with its reviews and watchlist entries.
+Returns the storage key of its poster, ErrRecordNotFound if it isn't in the trash.
+*/
+func (m *MovieModel) Purge(ctx context.Context, id int64) (string, error) {
+	if id < 1 {
+		return "", ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movies
+		WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NOT NULL
+		RETURNING poster_key`
+
+	var posterKey string
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, TenantID(ctx)).Scan(&posterKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return posterKey, nil
+}
+
+/* The bounds of a search, keyed by their query parameters */
+func ValidateMovieSearch(v *validator.Validator, s MovieSearch) {
+	v.CheckGroup(validator.ValidRange(s.YearGTE, s.YearLTE), validator.Message("validation.invalid_range", "year_gte", "year_lte"), "year_gte", "year_lte")
+	v.CheckGroup(validator.ValidRange(s.RuntimeGTE, s.RuntimeLTE), validator.Message("validation.invalid_range", "runtime_gte", "runtime_lte"), "runtime_gte", "runtime_lte")
+	v.CheckField(s.RuntimeGTE == nil || validator.Min(*s.RuntimeGTE, 0), "runtime_gte", validator.Message("validation.not_negative"))
+	v.CheckField(s.RuntimeLTE == nil || validator.Min(*s.RuntimeLTE, 0), "runtime_lte", validator.Message("validation.not_negative"))
+	v.CheckField(validator.ValidDateRange(s.CreatedAfter, s.CreatedBefore), "created_after", validator.Message("validation.before", "created_before"))
+}
+
+func ValidateMovie(v *validator.Validator, movie *Movie) {
+	validator.ValidateStruct(v, movie)
 
-	v.CheckField(validator.NotEmpty(movie.Runtime), "runtime", "must be provided")
-	v.CheckField(validator.NotNegative(movie.Runtime), "runtime", "must be a positive integer")
+	v.CheckField(validator.NotFuture(movie.Year), "year", validator.Message("validation.not_future"))
 
-	v.CheckField(movie.Genres != nil, "genres", "must be provided")
-	v.CheckField(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.CheckField(len(movie.Genres) <= 5, "genres", "must contain at max 5 genres")
-	v.CheckField(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	/* Likely hours or seconds sent for minutes, but a few films are that long */
+	v.CheckWarning(validator.Max(movie.Runtime, 300), "runtime", validator.Message("validation.long_runtime"))
 }
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/events"
+)
+
+/* Kinds of outbox entries */
+const (
+	OutboxEmail   = "email"
+	OutboxWebhook = "webhook"
+)
+
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxDead      = "dead"
+)
+
+/*
+OutboxEntry is an email or webhook event written in the same transaction as the
+change it is about, so it is sent if and only if the change is committed. The
+dispatcher delivers it at least once: an entry delivered right before a crash
+is delivered again, handlers use Key to tell.
+*/
+type OutboxEntry struct {
+	ID       int64
+	Kind     string
+	Key      string
+	Payload  json.RawMessage
+	Attempts int
+	/* Of the transaction that wrote it, webhook events only go to its webhooks */
+	TenantID int64
+}
+
+/* The payload of an OutboxEmail entry, the arguments of mailer.Send */
+type EmailMessage struct {
+	Recipient string         `json:"recipient"`
+	Template  string         `json:"template"`
+	Data      map[string]any `json:"data"`
+}
+
+type OutboxModel struct {
+	DB DBTX
+}
+
+/* Writing an entry with the key of one that exists does nothing, the entry is of the tenant in ctx */
+func insertOutbox(ctx context.Context, tx DBTX, kind, key string, payload any) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox (kind, idempotency_key, payload, tenant_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (idempotency_key) DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, kind, key, js, TenantID(ctx))
+	return err
+}
+
+/* A webhook event, with the same JSON as the events published on the bus */
+func insertOutboxEvent(ctx context.Context, tx DBTX, key, eventType string, data any) error {
+	event := events.Event{Type: eventType, Time: time.Now().UTC(), Data: data}
+	return insertOutbox(ctx, tx, OutboxWebhook, key, event)
+}
+
+/*
+Claims up to limit entries that are due and pushes their next attempt back by
+lease, so they are only claimed again if the attempt is never recorded.
+*/
+func (m OutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEntry, error) {
+	query := `
+		UPDATE outbox
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, kind, idempotency_key, payload, attempts, tenant_id`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*OutboxEntry{}
+
+	for rows.Next() {
+		var entry OutboxEntry
+
+		err := rows.Scan(&entry.ID, &entry.Kind, &entry.Key, &entry.Payload, &entry.Attempts, &entry.TenantID)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+/*
+Records an attempt at the entry: delivered when err is nil, otherwise pending
+until next or dead when next is zero. The payload of an entry that is done with
+is cleared, emails carry activation tokens.
+*/
+func (m OutboxModel) RecordAttempt(ctx context.Context, id int64, next time.Time, err error) error {
+	status := OutboxDelivered
+	var lastError *string
+
+	if err != nil {
+		message := err.Error()
+		lastError = &message
+
+		status = OutboxDead
+		if !next.IsZero() {
+			status = OutboxPending
+		}
+	}
+
+	query := `
+		UPDATE outbox
+		SET status = $2, attempts = attempts + 1, next_attempt_at = COALESCE($3, next_attempt_at), last_error = $4,
+			payload = CASE WHEN $2 = 'pending' THEN payload ELSE '{}' END
+		WHERE id = $1`
+
+	var nextAttemptAt *time.Time
+	if !next.IsZero() {
+		nextAttemptAt = &next
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, id, status, nextAttemptAt, lastError)
+	return err
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	RoleDirector = "director"
+	RoleActor    = "actor"
+)
+
+type Person struct {
+	ID        int64     `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"-" xml:"-"`
+	Name      string    `json:"name" xml:"name"`
+	Version   int32     `json:"version" xml:"version"`
+}
+
+/*
+A person's part in a movie. Credits of a movie name the person, credits of a
+person name the movie, the other side is left out.
+*/
+type Credit struct {
+	MovieID    int64  `json:"movie_id" xml:"movie_id"`
+	MovieTitle string `json:"movie_title,omitempty" xml:"movie_title,omitempty"`
+	MovieYear  int32  `json:"movie_year,omitempty" xml:"movie_year,omitempty"`
+	PersonID   int64  `json:"person_id" xml:"person_id"`
+	Name       string `json:"name,omitempty" xml:"name,omitempty"`
+	Role       string `json:"role" xml:"role"`
+	/* The character played, for actors */
+	Character string `json:"character,omitempty" xml:"character,omitempty"`
+}
+
+type PersonModel struct {
+	DB DBTX
+}
+
+func (m PersonModel) Get(ctx context.Context, id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, version
+		FROM people
+		WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&person.ID, &person.CreatedAt, &person.Name, &person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+/* The person's credits in movies of the catalogue, newest movie first */
+func (m PersonModel) GetCreditsForPerson(ctx context.Context, personID int64) ([]*Credit, error) {
+	query := `
+		SELECT credits.movie_id, movies.title, movies.year, credits.person_id, credits.role, credits.character
+		FROM credits
+		INNER JOIN movies ON movies.id = credits.movie_id
+		WHERE credits.person_id = $1 AND movies.tenant_id = $2 AND movies.deleted_at IS NULL
+		ORDER BY movies.year DESC, movies.id, credits.role`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID, TenantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.MovieID,
+			&credit.MovieTitle,
+			&credit.MovieYear,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+/* The credits of a movie, directors first */
+func (m PersonModel) GetCreditsForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	credits, err := m.GetCreditsForMovies(ctx, []int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+
+	if credits[movieID] == nil {
+		return []*Credit{}, nil
+	}
+
+	return credits[movieID], nil
+}
+
+/* The credits of each movie, directors first, in one query */
+func (m PersonModel) GetCreditsForMovies(ctx context.Context, movieIDs []int64) (map[int64][]*Credit, error) {
+	query := `
+		SELECT credits.movie_id, credits.person_id, people.name, credits.role, credits.character
+		FROM credits
+		INNER JOIN people ON people.id = credits.person_id
+		WHERE credits.movie_id = ANY($1)
+		ORDER BY credits.movie_id, credits.role = 'actor', credits.position, people.name`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]*Credit)
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits[credit.MovieID] = append(credits[credit.MovieID], &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
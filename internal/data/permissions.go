@@ -2,11 +2,8 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"slices"
 	"time"
-
-	"github.com/lib/pq"
 )
 
 /* holds permission codes */
@@ -17,19 +14,20 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionsModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+/* The union of the permissions of the user's roles */
+func (m PermissionsModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
-		SELECT permissions.code
+		SELECT DISTINCT permissions.code
 		FROM permissions
-		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-		INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1;`
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1`
 
 	/* Context w/ 3-second timeout */
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
@@ -57,15 +55,3 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 
 	return permissions, nil
 }
-
-func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
-	query := `
-		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
-}
@@ -0,0 +1,40 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+The WHERE clause of a query built from optional filters, so only the filters
+that were set end up in it. Values never go into the SQL, arg numbers them as
+placeholders: conditions are written as e.g.
+
+	where.add("year >= " + where.arg(year))
+
+LIMIT, OFFSET and any other values of the query take their placeholders from
+arg too, after the conditions, so the numbering carries on.
+*/
+type whereClause struct {
+	conditions []string
+	args       []any
+}
+
+/* A placeholder for val, its $n among the query's arguments */
+func (w *whereClause) arg(val any) string {
+	w.args = append(w.args, val)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+/* ANDs cond to the clause, its values must be placeholders from arg */
+func (w *whereClause) add(cond string) {
+	w.conditions = append(w.conditions, cond)
+}
+
+/* The conditions joined by AND, for after WHERE; TRUE without any */
+func (w *whereClause) String() string {
+	if len(w.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(w.conditions, "\n\t\tAND ")
+}
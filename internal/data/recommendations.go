@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+Recommender ranks the movies related to a movie or to a user's taste, best
+first, paginated like MovieModel.GetAll; the movie itself and the ones on the
+user's watchlist are left out. RecommendationModel scores them in SQL, a
+learned model can take its place in Models without the handlers noticing.
+*/
+type Recommender interface {
+	Similar(ctx context.Context, movieID int64, f Filters) ([]*Movie, Metadata, error)
+	ForUser(ctx context.Context, userID int64, f Filters) ([]*Movie, Metadata, error)
+}
+
+/*
+Scores a movie one point for every genre it shares, plus up to one point the
+closer its year is, nothing from ten years apart on. Movies sharing no genre
+aren't related at all.
+*/
+type RecommendationModel struct {
+	DB DBTX
+}
+
+func (m RecommendationModel) Similar(ctx context.Context, movieID int64, f Filters) ([]*Movie, Metadata, error) {
+	query := `
+		WITH shared AS (
+			SELECT other.movie_id, count(*) AS points
+			FROM movies_genres AS own
+			INNER JOIN movies_genres AS other ON other.genre_id = own.genre_id AND other.movie_id <> own.movie_id
+			WHERE own.movie_id = $1
+			GROUP BY other.movie_id
+		)
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, ` + movieGenres + `, average_rating, poster_key, poster_url, version
+		FROM shared
+		INNER JOIN movies ON movies.id = shared.movie_id
+		WHERE movies.tenant_id = $4 AND movies.deleted_at IS NULL
+		ORDER BY shared.points + greatest(0, 1 - abs(movies.year - (SELECT target.year FROM movies AS target WHERE target.id = $1)) / 10.0) DESC, id ASC
+		LIMIT $2 OFFSET $3`
+
+	return m.query(ctx, query, f, movieID)
+}
+
+/*
+The user's taste is the genres of the movies on their watchlist, each weighted
+by how many of them have it, and their average year. Without a watchlist there
+is nothing to go by and nothing is recommended.
+*/
+func (m RecommendationModel) ForUser(ctx context.Context, userID int64, f Filters) ([]*Movie, Metadata, error) {
+	query := `
+		WITH watched AS (
+			SELECT movie_id
+			FROM users_movies
+			INNER JOIN movies ON movies.id = users_movies.movie_id
+			WHERE users_movies.user_id = $1 AND movies.deleted_at IS NULL
+		), taste AS (
+			SELECT movies_genres.genre_id, count(*)::float / (SELECT count(*) FROM watched) AS weight
+			FROM watched
+			INNER JOIN movies_genres ON movies_genres.movie_id = watched.movie_id
+			GROUP BY movies_genres.genre_id
+		), shared AS (
+			SELECT movies_genres.movie_id, sum(taste.weight) AS points
+			FROM taste
+			INNER JOIN movies_genres ON movies_genres.genre_id = taste.genre_id
+			WHERE movies_genres.movie_id NOT IN (SELECT movie_id FROM watched)
+			GROUP BY movies_genres.movie_id
+		)
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, ` + movieGenres + `, average_rating, poster_key, poster_url, version
+		FROM shared
+		INNER JOIN movies ON movies.id = shared.movie_id
+		WHERE movies.tenant_id = $4 AND movies.deleted_at IS NULL
+		ORDER BY shared.points + greatest(0, 1 - abs(movies.year - (
+			SELECT avg(watched_movies.year) FROM watched INNER JOIN movies AS watched_movies ON watched_movies.id = watched.movie_id
+		)) / 10.0) DESC, id ASC
+		LIMIT $2 OFFSET $3`
+
+	return m.query(ctx, query, f, userID)
+}
+
+/* Runs a query with the ID as $1, the page as $2 and $3 and the tenant as $4 */
+func (m RecommendationModel) query(ctx context.Context, query string, f Filters, id int64) ([]*Movie, Metadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, f.limit(), f.offset(), TenantID(ctx))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.posterKey,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
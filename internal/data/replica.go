@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+Replica is a read-only copy of the primary database, for reads that can be a
+little behind it. While it can't be reached reads go to the primary: a query
+failing to reach it marks it down and a ping every 5 seconds brings it back.
+A nil Replica reads from the primary.
+*/
+type Replica struct {
+	db   *sql.DB
+	down atomic.Bool
+}
+
+func NewReplica(db *sql.DB) *Replica {
+	r := &Replica{db: db}
+
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			err := db.PingContext(ctx)
+			cancel()
+
+			r.down.Store(err != nil)
+
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	return r
+}
+
+/* A transaction reads from itself, the replica doesn't have its writes */
+func (r *Replica) pick(primary DBTX) DBTX {
+	if _, ok := primary.(*sql.DB); r == nil || !ok || r.down.Load() {
+		return primary
+	}
+	return r.db
+}
+
+/*
+Whether a read from db should be tried again on the primary. Errors from
+Postgres itself would be the same there, and an expired context leaves no time.
+*/
+func (r *Replica) failover(ctx context.Context, db DBTX, err error) bool {
+	if r == nil || db != r.db || err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return false
+	}
+
+	r.down.Store(true)
+	return true
+}
+
+func (r *Replica) query(ctx context.Context, primary DBTX, query string, args ...any) (*sql.Rows, error) {
+	db := r.pick(primary)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if r.failover(ctx, db, err) {
+		return primary.QueryContext(ctx, query, args...)
+	}
+
+	return rows, err
+}
+
+/* QueryRowContext and Scan into dest */
+func (r *Replica) queryRow(ctx context.Context, primary DBTX, query string, args []any, dest ...any) error {
+	db := r.pick(primary)
+
+	err := db.QueryRowContext(ctx, query, args...).Scan(dest...)
+	if r.failover(ctx, db, err) {
+		return primary.QueryRowContext(ctx, query, args...).Scan(dest...)
+	}
+
+	return err
+}
@@ -0,0 +1,42 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+/* The delay before the second attempt of RetryOnConflict, doubled for every one after that */
+const conflictRetryDelay = 10 * time.Millisecond
+
+/*
+RetryOnConflict calls update until it doesn't fail with ErrEditConflict, at most
+attempts times, waiting a random part of a growing delay in between so writers
+that conflicted don't meet again. update must read the record again and apply
+its change to what it read, that's how it takes in the write it conflicted with;
+only changes that are safe to merge that way should be retried.
+*/
+func RetryOnConflict(ctx context.Context, attempts int, update func(attempt int) error) error {
+	var err error
+
+	for attempt := range max(attempts, 1) {
+		if attempt > 0 {
+			/* INFO: Full jitter, a random delay between 0 and the backoff */
+			delay := rand.N(conflictRetryDelay << (attempt - 1))
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		err = update(attempt)
+		if !errors.Is(err, ErrEditConflict) {
+			return err
+		}
+	}
+
+	return err
+}
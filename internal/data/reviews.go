@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+type Review struct {
+	ID        int64     `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	MovieID   int64     `json:"movie_id" xml:"movie_id"`
+	UserID    int64     `json:"user_id" xml:"user_id"`
+	Rating    int32     `json:"rating" xml:"rating" validate:"min=1,max=5"`
+	Body      string    `json:"body,omitempty" xml:"body,omitempty" validate:"max=2000"`
+	Version   int32     `json:"version" xml:"version"`
+}
+
+type ReviewModel struct {
+	DB DBTX
+
+	/* Movies' average ratings change with their reviews */
+	cache movieCache
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	validator.ValidateStruct(v, review)
+}
+
+/* movies.average_rating is kept up to date by a trigger on the reviews table */
+func (m ReviewModel) Insert(ctx context.Context, review *Review) error {
+	query := `
+		INSERT INTO reviews (movie_id, user_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return validator.NewFieldError(ErrDuplicateReview, "movie_id", validator.Message("validation.duplicate_review"))
+		default:
+			return err
+		}
+	}
+
+	m.cache.invalidate(ctx, review.MovieID)
+
+	return nil
+}
+
+/* A review of the movie, ErrRecordNotFound if it belongs to another movie or tenant */
+func (m ReviewModel) Get(ctx context.Context, movieID, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE id = $1 AND movie_id = $2
+		AND movie_id IN (SELECT movies.id FROM movies WHERE movies.tenant_id = $3)`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID, TenantID(ctx)).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+func (m ReviewModel) GetAllForMovie(ctx context.Context, movieID int64, f Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE movie_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`,
+		f.sortColumn(), f.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, f.limit(), f.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
+
+/* The latest reviews of each movie, at most limit per movie, in one query */
+func (m ReviewModel) GetLatestForMovies(ctx context.Context, movieIDs []int64, limit int) (map[int64][]*Review, error) {
+	query := `
+		SELECT id, created_at, movie_id, user_id, rating, body, version
+		FROM (
+			SELECT *, row_number() OVER (PARTITION BY movie_id ORDER BY id DESC) AS n
+			FROM reviews
+			WHERE movie_id = ANY($1)
+		) latest
+		WHERE n <= $2
+		ORDER BY movie_id, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := make(map[int64][]*Review)
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews[review.MovieID] = append(reviews[review.MovieID], &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+func (m ReviewModel) Update(ctx context.Context, review *Review) error {
+	query := `
+		UPDATE reviews
+		SET rating = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{review.Rating, review.Body, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	m.cache.invalidate(ctx, review.MovieID)
+
+	return nil
+}
+
+func (m ReviewModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1
+		RETURNING movie_id`
+
+	var movieID int64
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	m.cache.invalidate(ctx, movieID)
+
+	return nil
+}
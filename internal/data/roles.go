@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	/* Given to every user at registration */
+	RoleViewer = "viewer"
+	/* Reads and edits the catalogue */
+	RoleEditor = "editor"
+	/* Assigns roles to users */
+	RoleAdmin = "admin"
+)
+
+type Role struct {
+	ID          int64       `json:"id" xml:"id"`
+	Name        string      `json:"name" xml:"name"`
+	Description string      `json:"description" xml:"description"`
+	Permissions Permissions `json:"permissions" xml:"permissions>permission"`
+}
+
+type RoleModel struct {
+	DB DBTX
+}
+
+/* Every role with its permissions, by name */
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT roles.id, roles.name, roles.description,
+			ARRAY(
+				SELECT permissions.code
+				FROM permissions
+				INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+				WHERE roles_permissions.role_id = roles.id
+				ORDER BY permissions.code
+			)
+		FROM roles
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+/* Names of the user's roles, none for users of other tenants */
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT roles.name
+		FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		INNER JOIN users ON users.id = users_roles.user_id
+		WHERE users_roles.user_id = $1 AND users.tenant_id = $2
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, TenantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+/*
+Gives the user the role, false if they already had it. ErrRecordNotFound if
+either the user or the role doesn't exist, users of other tenants don't.
+*/
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, role string) (bool, error) {
+	query := `
+		WITH role AS (
+			SELECT roles.id FROM roles WHERE roles.name = $2
+		), usr AS (
+			SELECT users.id FROM users WHERE users.id = $1 AND users.tenant_id = $3
+		), added AS (
+			INSERT INTO users_roles (user_id, role_id)
+			SELECT usr.id, role.id FROM usr, role
+			ON CONFLICT DO NOTHING
+			RETURNING user_id
+		)
+		SELECT (SELECT COUNT(*) FROM usr, role), (SELECT COUNT(*) FROM added)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var found, added int
+
+	err := m.DB.QueryRowContext(ctx, query, userID, role, TenantID(ctx)).Scan(&found, &added)
+	if err != nil {
+		return false, err
+	}
+
+	if found == 0 {
+		return false, ErrRecordNotFound
+	}
+
+	return added == 1, nil
+}
+
+/* ErrRecordNotFound if the user doesn't have the role, users of other tenants don't */
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, role string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles, users
+		WHERE users_roles.role_id = roles.id AND users.id = users_roles.user_id
+		AND users_roles.user_id = $1 AND roles.name = $2 AND users.tenant_id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, role, TenantID(ctx))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+/* Whether the user has the role */
+func (m RoleModel) UserHas(ctx context.Context, userID int64, role string) (bool, error) {
+	roles, err := m.GetAllForUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(roles, role), nil
+}
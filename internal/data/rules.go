@@ -0,0 +1,25 @@
+package data
+
+import (
+	"regexp"
+
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+var (
+	IMDbIDRX = regexp.MustCompile(`^tt\d{7,8}$`)
+	SlugRX   = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+)
+
+/* Domain rules shared by movie, review and user inputs through `validate` tags */
+func init() {
+	validator.RegisterRule("imdb_id", validator.Message("validation.imdb_id"), func(value any, _ string) bool {
+		s, ok := value.(string)
+		return ok && validator.Matches(s, IMDbIDRX)
+	})
+
+	validator.RegisterRule("slug", validator.Message("validation.slug"), func(value any, _ string) bool {
+		s, ok := value.(string)
+		return ok && validator.Matches(s, SlugRX)
+	})
+}
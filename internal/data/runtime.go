@@ -1,8 +1,10 @@
 package data
 
 import (
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -20,24 +22,164 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
+/* Written as <runtime unit="mins">107</runtime> in XML responses */
+func (r Runtime) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "unit"}, Value: "mins"})
+
+	return e.EncodeElement(int32(r), start)
+}
+
+/* Accepts a bare number of minutes (107) or a string in any format ParseRuntime understands */
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	/* Bare integer, e.g. 107 */
+	if i, err := strconv.ParseInt(string(jsonValue), 10, 32); err == nil {
+		*r = Runtime(i)
+		return nil
+	}
+
 	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
+	runtime, err := ParseRuntime(unquotedJSONValue)
+	if err != nil {
+		return err
+	}
 
-	if len(parts) < 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+	*r = runtime
+	return nil
+}
+
+/* Parses "107 mins", "107", "1h 47m" or an ISO 8601 duration of hours and minutes ("PT1H47M") */
+func ParseRuntime(s string) (Runtime, error) {
+	if strings.HasPrefix(s, "PT") {
+		return parseISO8601Runtime(s)
+	}
+
+	if strings.HasSuffix(s, "h") || strings.HasSuffix(s, "m") {
+		return parseHumanRuntime(s)
+	}
+
+	parts := strings.Split(s, " ")
+
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "mins") {
+		return 0, ErrInvalidRuntimeFormat
 	}
 
 	/* Parse into int32 */
 	i, err := strconv.ParseInt(parts[0], 10, 32)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, ErrInvalidRuntimeFormat
 	}
 
-	*r = Runtime(i)
-	return nil
+	return Runtime(i), nil
+}
+
+func parseISO8601Runtime(s string) (Runtime, error) {
+	rest := strings.TrimPrefix(s, "PT")
+	if rest == "" {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	var minutes int64
+
+	if hours, after, found := strings.Cut(rest, "H"); found {
+		h, err := strconv.ParseInt(hours, 10, 32)
+		if err != nil || h < 0 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		minutes, rest = h*60, after
+	}
+
+	if rest != "" {
+		mins, found := strings.CutSuffix(rest, "M")
+		if !found {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		m, err := strconv.ParseInt(mins, 10, 32)
+		if err != nil || m < 0 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		minutes += m
+	}
+
+	if minutes > math.MaxInt32 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return Runtime(minutes), nil
+}
+
+/* "1h 47m", "2h" or "47m", as written by Human */
+func parseHumanRuntime(s string) (Runtime, error) {
+	/* The ISO 8601 parser does the rest once it looks like one, "1h 47m" being "PT1H47M" */
+	iso := "PT" + strings.ToUpper(strings.Replace(s, "h ", "h", 1))
+	if strings.ContainsAny(iso, " +-") {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return parseISO8601Runtime(iso)
+}
+
+/* Formats the runtime as an ISO 8601 duration, e.g. "PT1H47M" */
+func (r Runtime) ISO8601() string {
+	hours, minutes := r/60, r%60
+
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("PT%dM", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("PT%dH", hours)
+	default:
+		return fmt.Sprintf("PT%dH%dM", hours, minutes)
+	}
+}
+
+/* Formats the runtime for people to read, e.g. "1h 47m" */
+func (r Runtime) Human() string {
+	hours, minutes := r/60, r%60
+
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+}
+
+/* A Runtime that is written as an ISO 8601 duration instead of "107 mins" */
+type RuntimeISO8601 Runtime
+
+func (r RuntimeISO8601) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(Runtime(r).ISO8601())), nil
+}
+
+func (r RuntimeISO8601) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(Runtime(r).ISO8601(), start)
+}
+
+/* A Runtime that is written as a number of minutes, e.g. 107, instead of "107 mins" */
+type RuntimeMinutes Runtime
+
+func (r RuntimeMinutes) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(r), 10), nil
+}
+
+func (r RuntimeMinutes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return Runtime(r).MarshalXML(e, start)
+}
+
+/* A Runtime that is written as "1h 47m" instead of "107 mins" */
+type RuntimeHuman Runtime
+
+func (r RuntimeHuman) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(Runtime(r).Human())), nil
+}
+
+func (r RuntimeHuman) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(Runtime(r).Human(), start)
 }
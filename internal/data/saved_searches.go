@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+var ErrDuplicateSavedSearch = errors.New("duplicate saved search")
+
+/*
+A movie search a user saved under a name, to be alerted when a new movie
+matches it: by email, to WebhookURL or both.
+*/
+type SavedSearch struct {
+	ID        int64     `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	Name      string    `json:"name" xml:"name"`
+	/* The filters, as the parameters of GET /v1/movies */
+	Title   string   `json:"title,omitempty" xml:"title,omitempty"`
+	Genres  []string `json:"genres,omitempty" xml:"genres>genre,omitempty"`
+	YearGTE *int     `json:"year_gte,omitempty" xml:"year_gte,omitempty"`
+	YearLTE *int     `json:"year_lte,omitempty" xml:"year_lte,omitempty"`
+
+	NotifyEmail bool   `json:"notify_email" xml:"notify_email"`
+	WebhookURL  string `json:"webhook_url,omitempty" xml:"webhook_url,omitempty"`
+	/* Only shown once, when the search is created with a webhook */
+	WebhookSecret string `json:"webhook_secret,omitempty" xml:"webhook_secret,omitempty"`
+	UserID        int64  `json:"-" xml:"-"`
+}
+
+/* The movie search it stands for */
+func (s *SavedSearch) MovieSearch() MovieSearch {
+	return MovieSearch{Title: s.Title, Genres: s.Genres, YearGTE: s.YearGTE, YearLTE: s.YearLTE}
+}
+
+/* A saved search a new movie matches, with whom to alert */
+type SavedSearchMatch struct {
+	Search    *SavedSearch
+	UserName  string
+	UserEmail string
+}
+
+type SavedSearchModel struct {
+	DB DBTX
+}
+
+func ValidateSavedSearch(v *validator.Validator, search *SavedSearch) {
+	v.CheckField(validator.NotBlank(search.Name), "name", validator.Message("validation.required"))
+	v.CheckField(validator.MaxChars(search.Name, 64), "name", validator.Message("validation.max_chars", 64))
+
+	/* A search without filters would match every movie */
+	v.CheckGroup(search.Title != "" || len(search.Genres) > 0 || search.YearGTE != nil || search.YearLTE != nil,
+		validator.Message("validation.any_required", "title, genres, year_gte, year_lte"), "title", "genres", "year_gte", "year_lte")
+	v.CheckField(search.Title == "" || titleSearchQuery(search.Title) != "", "title", validator.Message("validation.alphanumeric"))
+	v.CheckField(validator.MaxChars(search.Title, 100), "title", validator.Message("validation.max_chars", 100))
+
+	v.CheckField(validator.Max(len(search.Genres), 5), "genres", validator.Message("validation.max_genres", 5))
+	v.CheckField(validator.Unique(search.Genres), "genres", validator.Message("validation.unique"))
+	v.CheckGroup(validator.ValidRange(search.YearGTE, search.YearLTE), validator.Message("validation.invalid_range", "year_gte", "year_lte"), "year_gte", "year_lte")
+
+	v.CheckField(validator.RequiredIf(search.NotifyEmail, search.WebhookURL == ""), "notify_email", validator.Message("validation.required_unless", "webhook_url"))
+	if search.WebhookURL != "" {
+		v.CheckField(validator.IsURL(search.WebhookURL), "webhook_url", validator.Message("validation.url"))
+	}
+}
+
+/* With a webhook, generates its signing secret, returned to the client only in the create response */
+func (m SavedSearchModel) Insert(ctx context.Context, search *SavedSearch) error {
+	if search.WebhookURL != "" {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return err
+		}
+		search.WebhookSecret = hex.EncodeToString(secret)
+	}
+
+	if search.Genres == nil {
+		search.Genres = []string{}
+	}
+
+	query := `
+		INSERT INTO saved_searches (user_id, name, title, title_query, genres, year_gte, year_lte, notify_email, webhook_url, webhook_secret)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at`
+
+	args := []any{
+		search.UserID,
+		search.Name,
+		search.Title,
+		titleSearchQuery(search.Title),
+		pq.Array(search.Genres),
+		search.YearGTE,
+		search.YearLTE,
+		search.NotifyEmail,
+		search.WebhookURL,
+		search.WebhookSecret,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&search.ID, &search.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "saved_searches_user_id_name_key"`:
+			return validator.NewFieldError(ErrDuplicateSavedSearch, "name", validator.Message("validation.duplicate_saved_search"))
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+const savedSearchColumns = `saved_searches.id, saved_searches.created_at, saved_searches.name, saved_searches.title,
+			saved_searches.genres, saved_searches.year_gte, saved_searches.year_lte, saved_searches.notify_email,
+			saved_searches.webhook_url, saved_searches.user_id`
+
+/* The scan destinations of savedSearchColumns */
+func (s *SavedSearch) dests() []any {
+	return []any{&s.ID, &s.CreatedAt, &s.Name, &s.Title, pq.Array(&s.Genres), &s.YearGTE, &s.YearLTE, &s.NotifyEmail, &s.WebhookURL, &s.UserID}
+}
+
+/* Newest first */
+func (m SavedSearchModel) GetAllForUser(ctx context.Context, userID int64) ([]*SavedSearch, error) {
+	query := `
+		SELECT ` + savedSearchColumns + `
+		FROM saved_searches
+		WHERE user_id = $1
+		ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []*SavedSearch{}
+
+	for rows.Next() {
+		var search SavedSearch
+
+		err := rows.Scan(search.dests()...)
+		if err != nil {
+			return nil, err
+		}
+
+		searches = append(searches, &search)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+/* Only the user's own searches, another user's search is as not found as a missing one */
+func (m SavedSearchModel) Get(ctx context.Context, id, userID int64) (*SavedSearch, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + savedSearchColumns + `
+		FROM saved_searches
+		WHERE id = $1 AND user_id = $2`
+
+	var search SavedSearch
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(search.dests()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &search, nil
+}
+
+func (m SavedSearchModel) Delete(ctx context.Context, id, userID int64) error {
+	query := `
+		DELETE FROM saved_searches
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+/*
+The saved searches of activated users of the movie's tenant that the movie
+matches, with the secret of their webhooks. The filters are compared in one query over all searches,
+like MovieSearch.where compares them for one.
+*/
+func (m SavedSearchModel) Matching(ctx context.Context, movieID int64) ([]*SavedSearchMatch, error) {
+	query := `
+		SELECT ` + savedSearchColumns + `, saved_searches.webhook_secret, users.name, users.email
+		FROM saved_searches
+		INNER JOIN users ON users.id = saved_searches.user_id
+		INNER JOIN movies ON movies.id = $1 AND movies.tenant_id = users.tenant_id
+		WHERE users.activated AND movies.deleted_at IS NULL
+		AND (saved_searches.title_query = '' OR movies.title_search @@ to_tsquery('english', saved_searches.title_query))
+		AND (saved_searches.genres = '{}' OR ` + movieGenres + ` @> saved_searches.genres)
+		AND (saved_searches.year_gte IS NULL OR movies.year >= saved_searches.year_gte)
+		AND (saved_searches.year_lte IS NULL OR movies.year <= saved_searches.year_lte)
+		ORDER BY saved_searches.id`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []*SavedSearchMatch
+
+	for rows.Next() {
+		match := SavedSearchMatch{Search: &SavedSearch{}}
+
+		err := rows.Scan(append(match.Search.dests(), &match.Search.WebhookSecret, &match.UserName, &match.UserEmail)...)
+		if err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, &match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
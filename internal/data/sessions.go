@@ -0,0 +1,130 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"time"
+)
+
+/* Longer user agents are cut, they are only shown to tell sessions apart */
+const maxUserAgentLength = 256
+
+/* An authentication token, as shown to its user; the token itself never is */
+type Session struct {
+	ID                int64      `json:"id" xml:"id"`
+	CreatedAt         time.Time  `json:"created_at" xml:"created_at"`
+	Expiry            time.Time  `json:"expiry" xml:"expiry"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty" xml:"last_used_at,omitempty"`
+	LastUsedIP        string     `json:"last_used_ip,omitempty" xml:"last_used_ip,omitempty"`
+	LastUsedUserAgent string     `json:"last_used_user_agent,omitempty" xml:"last_used_user_agent,omitempty"`
+	/* The session of the request listing them */
+	Current bool `json:"current" xml:"current"`
+}
+
+/*
+Records that an authentication token was used. At most once a minute from the
+same address, so the row isn't written on every request.
+*/
+func (m TokenModel) Touch(ctx context.Context, tokenPlaintext, ip, userAgent string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW(), last_used_ip = $2, last_used_user_agent = $3
+		WHERE hash = $1 AND scope = $4
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip IS DISTINCT FROM $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash[:], ip, userAgent, ScopeAuthentication)
+	return err
+}
+
+/* The user's unexpired authentication tokens, most recently created first */
+func (m TokenModel) GetSessionsForUser(ctx context.Context, userID int64, currentPlaintext string) ([]*Session, error) {
+	current := sha256.Sum256([]byte(currentPlaintext))
+
+	query := `
+		SELECT id, hash, created_at, expiry, last_used_at, COALESCE(last_used_ip, ''), COALESCE(last_used_user_agent, '')
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND expiry > NOW()
+		ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var (
+			session Session
+			hash    []byte
+		)
+
+		err := rows.Scan(
+			&session.ID,
+			&hash,
+			&session.CreatedAt,
+			&session.Expiry,
+			&session.LastUsedAt,
+			&session.LastUsedIP,
+			&session.LastUsedUserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		session.Current = bytes.Equal(hash, current[:])
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+/*
+Revokes one of the user's sessions, and the refresh tokens of the login it came
+from so it can't be renewed.
+*/
+func (m TokenModel) DeleteSession(ctx context.Context, id, userID int64) error {
+	query := `
+		WITH session AS (
+			DELETE FROM tokens
+			WHERE id = $1 AND user_id = $2 AND scope = $3
+			RETURNING family
+		), refresh AS (
+			DELETE FROM tokens
+			WHERE scope = $4 AND family IN (SELECT family FROM session WHERE family IS NOT NULL)
+		)
+		SELECT COUNT(*) FROM session`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var deleted int
+	err := m.DB.QueryRowContext(ctx, query, id, userID, ScopeAuthentication, ScopeRefresh).Scan(&deleted)
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
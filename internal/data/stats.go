@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+/* Figures about the catalogue and its users, of the tenant in ctx */
+type Stats struct {
+	GeneratedAt time.Time `json:"generated_at" xml:"generated_at"`
+	/* Leaves out movies in the trash */
+	TotalMovies   int64          `json:"total_movies" xml:"total_movies"`
+	MoviesByGenre []*GenreCount  `json:"movies_by_genre" xml:"movies_by_genre>genre"`
+	UsersByDay    []*DayCount    `json:"users_by_day" xml:"users_by_day>day"`
+	MostReviewed  []*ReviewCount `json:"most_reviewed" xml:"most_reviewed>movie"`
+	/* Unexpired tokens of any scope, JWTs aren't stored and aren't counted */
+	ActiveTokens int64 `json:"active_tokens" xml:"active_tokens"`
+}
+
+type GenreCount struct {
+	Genre  string `json:"genre" xml:"genre"`
+	Movies int64  `json:"movies" xml:"movies"`
+}
+
+type DayCount struct {
+	/* As 2006-01-02, in UTC */
+	Date  string `json:"date" xml:"date"`
+	Users int64  `json:"users" xml:"users"`
+}
+
+type ReviewCount struct {
+	MovieID       int64    `json:"movie_id" xml:"movie_id"`
+	Title         string   `json:"title" xml:"title"`
+	Reviews       int64    `json:"reviews" xml:"reviews"`
+	AverageRating *float64 `json:"average_rating,omitempty" xml:"average_rating,omitempty"`
+}
+
+/* How many days UsersByDay goes back, today included */
+const statsDays = 30
+
+/* How many movies MostReviewed lists */
+const statsMostReviewed = 10
+
+/* Reads from the replica, the figures don't need to be the latest */
+type StatsModel struct {
+	DB      DBTX
+	Replica *Replica
+}
+
+func (m StatsModel) Get(ctx context.Context) (*Stats, error) {
+	stats := &Stats{GeneratedAt: time.Now().UTC()}
+	tenantID := TenantID(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT
+			(SELECT count(*) FROM movies WHERE tenant_id = $1 AND deleted_at IS NULL),
+			(SELECT count(*) FROM tokens INNER JOIN users ON users.id = tokens.user_id WHERE users.tenant_id = $1 AND tokens.expiry > NOW())`
+
+	err := m.Replica.queryRow(ctx, m.DB, query, []any{tenantID}, &stats.TotalMovies, &stats.ActiveTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Genres without movies of the tenant are left out, genres are shared by the tenants */
+	query = `
+		SELECT genres.name, count(*)
+		FROM movies_genres
+		INNER JOIN genres ON genres.id = movies_genres.genre_id
+		INNER JOIN movies ON movies.id = movies_genres.movie_id
+		WHERE movies.tenant_id = $1 AND movies.deleted_at IS NULL
+		GROUP BY genres.name
+		ORDER BY count(*) DESC, genres.name`
+
+	stats.MoviesByGenre = []*GenreCount{}
+	err = m.scan(ctx, query, []any{tenantID}, func(rows *sql.Rows) error {
+		var genre GenreCount
+		if err := rows.Scan(&genre.Genre, &genre.Movies); err != nil {
+			return err
+		}
+		stats.MoviesByGenre = append(stats.MoviesByGenre, &genre)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	/* INFO: generate_series fills in the days nobody registered on, oldest first */
+	query = `
+		SELECT to_char(days.day, 'YYYY-MM-DD'), count(users.id)
+		FROM generate_series(
+			(NOW() AT TIME ZONE 'UTC')::date - ($2::int - 1),
+			(NOW() AT TIME ZONE 'UTC')::date,
+			interval '1 day') AS days(day)
+		LEFT JOIN users ON (users.created_at AT TIME ZONE 'UTC')::date = days.day AND users.tenant_id = $1
+		GROUP BY days.day
+		ORDER BY days.day`
+
+	stats.UsersByDay = []*DayCount{}
+	err = m.scan(ctx, query, []any{tenantID, statsDays}, func(rows *sql.Rows) error {
+		var day DayCount
+		if err := rows.Scan(&day.Date, &day.Users); err != nil {
+			return err
+		}
+		stats.UsersByDay = append(stats.UsersByDay, &day)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT movies.id, movies.title, count(*), movies.average_rating
+		FROM reviews
+		INNER JOIN movies ON movies.id = reviews.movie_id
+		WHERE movies.tenant_id = $1 AND movies.deleted_at IS NULL
+		GROUP BY movies.id
+		ORDER BY count(*) DESC, movies.id
+		LIMIT $2`
+
+	stats.MostReviewed = []*ReviewCount{}
+	err = m.scan(ctx, query, []any{tenantID, statsMostReviewed}, func(rows *sql.Rows) error {
+		var movie ReviewCount
+		if err := rows.Scan(&movie.MovieID, &movie.Title, &movie.Reviews, &movie.AverageRating); err != nil {
+			return err
+		}
+		stats.MostReviewed = append(stats.MostReviewed, &movie)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+/* Runs query on the replica and calls fn for every row */
+func (m StatsModel) scan(ctx context.Context, query string, args []any, fn func(rows *sql.Rows) error) error {
+	rows, err := m.Replica.query(ctx, m.DB, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := fn(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
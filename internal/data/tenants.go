@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+var ErrDuplicateTenant = errors.New("duplicate tenant")
+
+/*
+A catalogue of its own, with its own users: one deployment serves every
+tenant, each only sees its movies and users. Genres, people, roles and
+webhooks are shared by all of them.
+*/
+type Tenant struct {
+	ID        int64     `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	/* Its subdomain or X-Tenant header value */
+	Slug    string `json:"slug" xml:"slug"`
+	Name    string `json:"name" xml:"name" validate:"required,max=100"`
+	Version int32  `json:"version" xml:"version"`
+}
+
+/* The tenant of everything created before tenancy, and of every request without it */
+const DefaultTenantID int64 = 1
+
+type tenantContextKey struct{}
+
+/* The models scope their queries to the tenant in ctx */
+func WithTenant(ctx context.Context, tenantID int64) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+/* The tenant set by WithTenant, DefaultTenantID without one */
+func TenantID(ctx context.Context) int64 {
+	if id, ok := ctx.Value(tenantContextKey{}).(int64); ok {
+		return id
+	}
+	return DefaultTenantID
+}
+
+/* A DNS label, so every slug can be a subdomain */
+var tenantSlugRX = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+/* Subdomains the deployment itself answers on */
+var reservedTenantSlugs = []string{"www", "api", "admin", "mail"}
+
+func ValidateTenant(v *validator.Validator, tenant *Tenant) {
+	v.CheckField(validator.Matches(tenant.Slug, tenantSlugRX), "slug", validator.Message("validation.tenant_slug"))
+	v.CheckField(validator.NotIn(tenant.Slug, reservedTenantSlugs...), "slug", validator.Message("validation.reserved"))
+	validator.ValidateStruct(v, tenant)
+}
+
+type TenantModel struct {
+	DB DBTX
+}
+
+func (m TenantModel) Insert(ctx context.Context, tenant *Tenant) error {
+	query := `
+		INSERT INTO tenants (slug, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tenant.Slug, tenant.Name).Scan(&tenant.ID, &tenant.CreatedAt, &tenant.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "tenants_slug_key"`:
+			return validator.NewFieldError(ErrDuplicateTenant, "slug", validator.Message("validation.duplicate_tenant"))
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m TenantModel) GetBySlug(ctx context.Context, slug string) (*Tenant, error) {
+	query := `
+		SELECT id, created_at, slug, name, version
+		FROM tenants
+		WHERE slug = $1`
+
+	var tenant Tenant
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&tenant.ID, &tenant.CreatedAt, &tenant.Slug, &tenant.Name, &tenant.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tenant, nil
+}
+
+/* Oldest first, the default tenant leading */
+func (m TenantModel) GetAll(ctx context.Context) ([]*Tenant, error) {
+	query := `
+		SELECT id, created_at, slug, name, version
+		FROM tenants
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []*Tenant{}
+
+	for rows.Next() {
+		var tenant Tenant
+
+		err := rows.Scan(&tenant.ID, &tenant.CreatedAt, &tenant.Slug, &tenant.Name, &tenant.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		tenants = append(tenants, &tenant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/mohafarman/greenlight/internal/validator"
@@ -14,23 +15,30 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
 )
 
+/* A rotated refresh token was presented again, i.e. it was most likely stolen */
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext string    `json:"token" xml:"token"`
+	Hash      []byte    `json:"-" xml:"-"`
+	UserID    int64     `json:"-" xml:"-"`
+	Expiry    time.Time `json:"expiry" xml:"expiry"`
+	Scope     string    `json:"-" xml:"-"`
+	/* Refresh tokens only, the hash of the first token of the login */
+	Family []byte `json:"-" xml:"-"`
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.CheckField(tokenPlaintext != "", "token", "must be provided")
-	v.CheckField(len(tokenPlaintext) == 26, "token", "must be 26 bytes")
+	v.CheckField(tokenPlaintext != "", "token", validator.Message("validation.required"))
+	v.CheckField(len(tokenPlaintext) == 26, "token", validator.Message("validation.bytes", 26))
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -60,38 +68,177 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 }
 
 /* Shortcut for generating and inserting a token in db */
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+func insertToken(ctx context.Context, db DBTX, token *Token) error {
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, family)
+		VALUES ($1, $2, $3, $4, $5)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+/* An authentication token of a login, revoking its session revokes the login's refresh tokens */
+func (m TokenModel) NewInFamily(ctx context.Context, userID int64, ttl time.Duration, family []byte) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+/* Starts a family of refresh tokens, at a login */
+func (m TokenModel) NewRefresh(ctx context.Context, userID int64, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = token.Hash
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+/*
+Exchanges a refresh token for a new one of the same family, valid for ttl from
+now. The old token is kept, marked used, until it expires: if it is presented
+again the whole family is revoked, along with the user's authentication tokens
+since they can't be told apart by login, and ErrRefreshTokenReused returned.
+ErrRecordNotFound for a token of a user of another tenant than ctx's.
+*/
+func (m TokenModel) Rotate(ctx context.Context, tokenPlaintext string, ttl time.Duration) (*Token, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		userID int64
+		family []byte
+		expiry time.Time
+		usedAt sql.NullTime
+	)
+
+	/*
+		FOR UPDATE so two concurrent exchanges of the same token can't both succeed.
+		A token of another tenant's user isn't found and stays unused, presented
+		where it belongs it still works
+	*/
+	query := `
+		SELECT tokens.user_id, tokens.family, tokens.expiry, tokens.used_at
+		FROM tokens
+		INNER JOIN users ON users.id = tokens.user_id
+		WHERE tokens.hash = $1 AND tokens.scope = $2 AND users.tenant_id = $3
+		FOR UPDATE OF tokens`
+
+	err = tx.QueryRowContext(ctx, query, hash[:], ScopeRefresh, TenantID(ctx)).Scan(&userID, &family, &expiry, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	if !expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	if usedAt.Valid {
+		query = `
+			DELETE FROM tokens
+			WHERE family = $1 OR (user_id = $2 AND scope = $3)`
+
+		_, err = tx.ExecContext(ctx, query, family, userID, ScopeAuthentication)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE hash = $1`, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(userID, ttl, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+
+	query = `
+		INSERT INTO tokens (hash, user_id, expiry, scope, family)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, tx.Commit()
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+/* Removes the tokens past their expiry, of every scope, returning how many */
+func (m TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
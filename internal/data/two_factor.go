@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+const recoveryCodeCount = 10
+
+/* A user's TOTP settings, the secret as stored, i.e. encrypted */
+type TwoFactor struct {
+	UserID   int64
+	Secret   []byte
+	Enabled  bool
+	LastStep int64
+}
+
+type TwoFactorModel struct {
+	DB DBTX
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.CheckField(code != "", "code", validator.Message("validation.required"))
+	v.CheckField(len(code) == 6, "code", validator.Message("validation.digits", 6))
+}
+
+func (m TwoFactorModel) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT totp_secret, totp_enabled, totp_last_step
+		FROM users
+		WHERE id = $1`
+
+	tf := TwoFactor{UserID: userID}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&tf.Secret, &tf.Enabled, &tf.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tf, nil
+}
+
+/*
+Stores a new, not yet enabled, secret and replaces the recovery codes,
+returning them. ErrEditConflict if 2FA is already enabled.
+*/
+func (m TwoFactorModel) Enroll(ctx context.Context, userID int64, secret []byte) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET totp_secret = $2, totp_last_step = 0
+		WHERE id = $1 AND NOT totp_enabled`
+
+	result, err := tx.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO totp_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+/* Enables 2FA once the user proved their app has the secret with the code of step */
+func (m TwoFactorModel) Enable(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE users
+		SET totp_enabled = true, totp_last_step = $2
+		WHERE id = $1 AND totp_secret IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, step)
+	return err
+}
+
+/* Records the step of a used code, false if it or a later one was used already */
+func (m TwoFactorModel) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `
+		UPDATE users
+		SET totp_last_step = $2
+		WHERE id = $1 AND totp_last_step < $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected == 1, err
+}
+
+/* Uses up a recovery code, false if it isn't one of the user's */
+func (m TwoFactorModel) UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	query := `
+		DELETE FROM totp_recovery_codes
+		WHERE user_id = $1 AND hash = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected == 1, err
+}
+
+func (m TwoFactorModel) Disable(ctx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0
+		WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/* Ten characters in two groups, e.g. "k3vq7-mx2pa", easy to read out and type */
+func generateRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+/* Case and the dash don't matter when the code is typed back */
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
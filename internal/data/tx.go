@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+/*
+A transaction of a model or of Models.WithTx. Begun on a transaction rather
+than the database it is a savepoint of that one: Commit releases it and
+Rollback rolls back to it, leaving the outer transaction to be committed or
+rolled back by its owner.
+*/
+type modelTx struct {
+	*sql.Tx
+	savepoint bool
+	done      bool
+	/* Not cancelled, a savepoint has to be rolled back after a timeout too */
+	ctx context.Context
+}
+
+/* Postgres rolls back to the latest savepoint of a name, nested ones can share it */
+const savepointName = "model_tx"
+
+func beginTx(ctx context.Context, db DBTX) (*modelTx, error) {
+	var outer *sql.Tx
+
+	switch db := db.(type) {
+	case *sql.DB:
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &modelTx{Tx: tx}, nil
+	case *modelTx:
+		outer = db.Tx
+	case *sql.Tx:
+		outer = db
+	default:
+		return nil, fmt.Errorf("data: can't begin a transaction on %T", db)
+	}
+
+	_, err := outer.ExecContext(ctx, "SAVEPOINT "+savepointName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &modelTx{Tx: outer, savepoint: true, ctx: context.WithoutCancel(ctx)}, nil
+}
+
+func (tx *modelTx) Commit() error {
+	if !tx.savepoint {
+		return tx.Tx.Commit()
+	}
+
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+
+	_, err := tx.Tx.ExecContext(tx.ctx, "RELEASE SAVEPOINT "+savepointName)
+	return err
+}
+
+/* Like sql.Tx, Rollback after Commit does nothing and returns sql.ErrTxDone */
+func (tx *modelTx) Rollback() error {
+	if !tx.savepoint {
+		return tx.Tx.Rollback()
+	}
+
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+
+	_, err := tx.Tx.ExecContext(tx.ctx, "ROLLBACK TO SAVEPOINT "+savepointName)
+	if err != nil {
+		return err
+	}
+
+	/* Left in place it would be the one an outer savepoint's Rollback goes back to */
+	_, err = tx.Tx.ExecContext(tx.ctx, "RELEASE SAVEPOINT "+savepointName)
+	return err
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+/*
+The requests of a user or tenant in a month. Quota is its own monthly quota,
+nil for the deployment's default, see enforceQuota.
+*/
+type Usage struct {
+	Month    time.Time
+	Requests int64
+	Quota    *int64
+}
+
+/* The requests a user made with one of their API keys */
+type APIKeyUsage struct {
+	APIKeyID int64 `json:"api_key_id" xml:"api_key_id"`
+	Requests int64 `json:"requests" xml:"requests"`
+}
+
+type UsageModel struct {
+	DB DBTX
+}
+
+/* The first day of t's month, in UTC, which is when quotas start over */
+func UsageMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+/*
+Counts a request of the user, made with the API key or 0 for a token, and of
+the tenant in ctx, returning both their usage of the month with it.
+*/
+func (m UsageModel) Record(ctx context.Context, userID, apiKeyID int64) (user, tenant Usage, err error) {
+	/* INFO: The SELECT doesn't see the rows the CTEs write, the user's other keys are summed and the written count added */
+	query := `
+		WITH user_count AS (
+			INSERT INTO usage (user_id, api_key_id, month, requests)
+			VALUES ($1, $2, $4, 1)
+			ON CONFLICT (user_id, month, api_key_id) DO UPDATE SET requests = usage.requests + 1
+			RETURNING requests
+		), tenant_count AS (
+			INSERT INTO tenant_usage (tenant_id, month, requests)
+			VALUES ($3, $4, 1)
+			ON CONFLICT (tenant_id, month) DO UPDATE SET requests = tenant_usage.requests + 1
+			RETURNING requests
+		)
+		SELECT
+			(SELECT coalesce(sum(requests), 0) FROM usage WHERE user_id = $1 AND month = $4 AND api_key_id <> $2)
+				+ (SELECT requests FROM user_count),
+			(SELECT monthly_quota FROM users WHERE id = $1),
+			(SELECT requests FROM tenant_count),
+			(SELECT monthly_quota FROM tenants WHERE id = $3)`
+
+	month := UsageMonth(time.Now())
+	user.Month, tenant.Month = month, month
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, userID, apiKeyID, TenantID(ctx), month).Scan(
+		&user.Requests, &user.Quota, &tenant.Requests, &tenant.Quota)
+	if err != nil {
+		return Usage{}, Usage{}, err
+	}
+
+	return user, tenant, nil
+}
+
+/*
+The user's usage of the current month, with the requests of each API key they
+used, most used first. ErrRecordNotFound for users of other tenants.
+*/
+func (m UsageModel) GetForUser(ctx context.Context, userID int64) (*Usage, []*APIKeyUsage, error) {
+	query := `
+		SELECT monthly_quota
+		FROM users
+		WHERE id = $1 AND tenant_id = $2`
+
+	usage := Usage{Month: UsageMonth(time.Now())}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, TenantID(ctx)).Scan(&usage.Quota)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	query = `
+		SELECT api_key_id, requests
+		FROM usage
+		WHERE user_id = $1 AND month = $2
+		ORDER BY requests DESC, api_key_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, usage.Month)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKeyUsage{}
+
+	for rows.Next() {
+		var key APIKeyUsage
+
+		err := rows.Scan(&key.APIKeyID, &key.Requests)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		usage.Requests += key.Requests
+
+		if key.APIKeyID != 0 {
+			keys = append(keys, &key)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return &usage, keys, nil
+}
+
+/* The usage of the tenant in ctx in the current month */
+func (m UsageModel) GetForTenant(ctx context.Context) (*Usage, error) {
+	query := `
+		SELECT coalesce(tenant_usage.requests, 0), tenants.monthly_quota
+		FROM tenants
+		LEFT JOIN tenant_usage ON tenant_usage.tenant_id = tenants.id AND tenant_usage.month = $2
+		WHERE tenants.id = $1`
+
+	usage := Usage{Month: UsageMonth(time.Now())}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, TenantID(ctx), usage.Month).Scan(&usage.Requests, &usage.Quota)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &usage, nil
+}
+
+/* Gives the user a quota of their own, nil for the default; ErrRecordNotFound for users of other tenants */
+func (m UsageModel) SetUserQuota(ctx context.Context, userID int64, quota *int64) error {
+	query := `
+		UPDATE users
+		SET monthly_quota = $1
+		WHERE id = $2 AND tenant_id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.exec(ctx, query, quota, userID, TenantID(ctx))
+}
+
+/* Gives the tenant a quota of its own, nil for the default */
+func (m UsageModel) SetTenantQuota(ctx context.Context, slug string, quota *int64) error {
+	query := `
+		UPDATE tenants
+		SET monthly_quota = $1, version = version + 1
+		WHERE slug = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.exec(ctx, query, quota, slug)
+}
+
+/* Runs an update of one row, ErrRecordNotFound if there is none */
+func (m UsageModel) exec(ctx context.Context, query string, args ...any) error {
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -5,8 +5,11 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
+	"unicode"
 
+	"github.com/mohafarman/greenlight/internal/events"
 	"github.com/mohafarman/greenlight/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -19,17 +22,21 @@ var (
 
 // "-" prevents output to JSON when converting
 type User struct {
-	ID        int       `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID        int       `json:"id" xml:"id"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	Name      string    `json:"name" xml:"name"`
+	Email     string    `json:"email" xml:"email"`
+	Password  password  `json:"-" xml:"-"`
+	Activated bool      `json:"activated" xml:"activated"`
+	Version   int       `json:"-" xml:"-"`
+	/* Set when authenticated with an API key, the permissions the key is limited to */
+	Scopes []string `json:"-" xml:"-"`
+	/* And the key's ID, for its usage */
+	APIKeyID int64 `json:"-" xml:"-"`
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 type password struct {
@@ -63,25 +70,44 @@ func (p *password) Match(plaintextPassword string) (bool, error) {
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.CheckField(email != "", "email", "must be provided")
-	v.CheckField(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.CheckField(email != "", "email", validator.Message("validation.required"))
+	v.CheckField(validator.Matches(email, validator.EmailRX), "email", validator.Message("validation.email"))
 }
 
 // len returns bytes, if you want characters use runes
 func ValidatePassword(v *validator.Validator, password string) {
-	v.CheckField(password != "", "password", "must be provided")
-	v.CheckField(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.CheckField(len(password) < 72, "password", "must be less than 72 bytes long")
+	v.CheckField(password != "", "password", validator.Message("validation.required"))
+	v.CheckField(validator.Min(len(password), 8), "password", validator.Message("validation.min_bytes", 8))
+	v.CheckField(validator.Max(len(password), 72), "password", validator.Message("validation.max_bytes", 72))
+}
+
+/*
+For passwords being set, ValidatePassword is enough for ones being checked.
+Length matters most, this only rules out the weakest of the allowed lengths.
+*/
+func ValidateNewPassword(v *validator.Validator, password string) {
+	ValidatePassword(v, password)
+
+	var letter, other bool
+	for _, c := range password {
+		if unicode.IsLetter(c) {
+			letter = true
+		} else {
+			other = true
+		}
+	}
+
+	v.CheckField(letter && other, "password", validator.Message("validation.password_mix"))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.CheckField(validator.NotBlank(user.Name), "name", "must be provided")
-	v.CheckField(validator.MaxChars(user.Name, 32), "name", "must be provided")
+	v.CheckField(validator.NotBlank(user.Name), "name", validator.Message("validation.required"))
+	v.CheckField(validator.MaxChars(user.Name, 32), "name", validator.Message("validation.max_chars", 32))
 
 	ValidateEmail(v, user.Email)
 
 	if user.Password.plaintext != nil {
-		ValidatePassword(v, *user.Password.plaintext)
+		ValidateNewPassword(v, *user.Password.plaintext)
 	}
 
 	if user.Password.hash == nil {
@@ -94,24 +120,28 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+func insertUser(ctx context.Context, db DBTX, user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash, activated, tenant_id)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, version
 		`
 
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, TenantID(ctx)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_tenant_id_email_key"`:
+			return validator.NewFieldError(ErrDuplicateEmail, "email", validator.Message("validation.duplicate_email"))
 		default:
 			return err
 		}
@@ -120,19 +150,67 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+/*
+Inserts a new user with the viewer role and an activation token valid for
+activationTTL, and queues the welcome email that welcome writes with the token
+in the outbox; all of it or none of it.
+*/
+func (m UserModel) Register(ctx context.Context, user *User, activationTTL time.Duration, welcome func(token *Token) EmailMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	/* New users can read the catalogue, i.e. movies:read */
+	query := `
+		INSERT INTO users_roles (user_id, role_id)
+		SELECT $1, roles.id FROM roles WHERE roles.name = $2`
+
+	_, err = tx.ExecContext(ctx, query, user.ID, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	token, err := generateToken(int64(user.ID), activationTTL, ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	err = insertToken(ctx, tx, token)
+	if err != nil {
+		return err
+	}
+
+	err = insertOutbox(ctx, tx, OutboxEmail, fmt.Sprintf("user.welcome:%d", user.ID), welcome(token))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
-		WHERE email = $1;`
+		WHERE email = $1 AND tenant_id = $2;`
 
 	var user User
 
 	/* Context w/ 3-second timeout */
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+	err := m.DB.QueryRowContext(ctx, query, email, TenantID(ctx)).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
@@ -154,7 +232,46 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1 AND tenant_id = $2;`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, TenantID(ctx)).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) Update(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return updateUser(ctx, m.DB, user)
+}
+
+func updateUser(ctx context.Context, db DBTX, user *User) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -170,17 +287,14 @@ func (m UserModel) Update(user *User) error {
 		user.ID,
 		user.Version}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	/* If no matching row could be found either the row does not exist
 	   or the version has changed. I.e. optimistic locking based on version
 	   to prevent data race conditions */
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_tenant_id_email_key"`:
+			return validator.NewFieldError(ErrDuplicateEmail, "email", validator.Message("validation.duplicate_email"))
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -191,7 +305,45 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+/*
+Marks the user activated, deletes their activation tokens and queues the
+user.activated webhook event in the outbox, in one transaction.
+*/
+func (m UserModel) Activate(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	user.Activated = true
+
+	err = updateUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2`
+
+	_, err = tx.ExecContext(ctx, query, ScopeActivation, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertOutboxEvent(ctx, tx, fmt.Sprintf("user.activated:%d", user.ID), events.UserActivated, user)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
@@ -201,14 +353,15 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
 		AND tokens.scope = $2
-		AND tokens.expiry > $3`
+		AND tokens.expiry > $3
+		AND users.tenant_id = $4`
 
-	args := []any{tokenHash[:], tokenScope, time.Now()}
+	args := []any{tokenHash[:], tokenScope, time.Now(), TenantID(ctx)}
 
 	var user User
 
 	/* Context w/ 3-second timeout */
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
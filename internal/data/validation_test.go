@@ -0,0 +1,55 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+/*
+Every message of the Validate functions is a catalogue key, so a response is
+in one language: a literal message comes out the same in Swedish as in English.
+*/
+func TestValidationMessagesTranslated(t *testing.T) {
+	v := validator.New()
+
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	yearGTE, yearLTE := 2020, 2010
+
+	ValidateEmail(v, "not an email")
+	ValidateNewPassword(v, "short")
+	ValidateNewPassword(v, strings.Repeat("a", 73))
+	ValidateFilters(v, Filters{Page: 20_000_000, PageSize: 1000, Sort: "password_hash", SortSafelist: testSortSafelist})
+	ValidateTokenPlaintext(v, "short")
+	ValidateTOTPCode(v, "1")
+	ValidateAPIKey(v, &APIKey{Name: "key", Scopes: []string{"movies:write"}, ExpiresAt: &past}, Permissions{"movies:read"})
+	ValidateAPIKey(v, &APIKey{Name: "key"}, nil)
+	ValidateAPIKeyPlaintext(v, "nope")
+	ValidateTenant(v, &Tenant{Slug: "Not A Slug", Name: "Tenant"})
+	ValidateTenant(v, &Tenant{Slug: "www", Name: "Tenant"})
+	ValidateWebhook(v, &Webhook{URL: "ftp://example.com", EventTypes: []string{"nope"}}, []string{"movie.created"})
+	ValidateWebhook(v, &Webhook{URL: "https://example.com", EventTypes: []string{}}, nil)
+	ValidateSavedSearch(v, &SavedSearch{Name: "search", Title: "!!!", YearGTE: &yearGTE, YearLTE: &yearLTE, WebhookURL: "example.com"})
+	ValidateMovieSearch(v, MovieSearch{CreatedAfter: &now, CreatedBefore: &past})
+
+	if len(v.Errors) == 0 {
+		t.Fatal("got no errors")
+	}
+
+	en := validator.Localize(v.Errors, "en")
+	sv := validator.Localize(v.Errors, "sv")
+
+	for key, messages := range en {
+		for i, message := range messages {
+			if strings.HasPrefix(message, "validation.") {
+				t.Errorf("%s: got the key %q; want it in the catalogue", key, message)
+			}
+			if sv[key][i] == message {
+				t.Errorf("%s: got %q in both English and Swedish; want a message key", key, message)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type WatchlistModel struct {
+	DB DBTX
+}
+
+/* Adds the movie to the user's watchlist, false if it already was on it */
+func (m WatchlistModel) Add(ctx context.Context, userID, movieID int64) (bool, error) {
+	query := `
+		INSERT INTO users_movies (user_id, movie_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (m WatchlistModel) Remove(ctx context.Context, userID, movieID int64) error {
+	query := `
+		DELETE FROM users_movies
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+/* Those of users who have the movie on their watchlist */
+func (m WatchlistModel) UsersWatching(ctx context.Context, movieID int64, users []int64) ([]int64, error) {
+	query := `
+		SELECT user_id
+		FROM users_movies
+		WHERE movie_id = $1 AND user_id = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, pq.Array(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watching := []int64{}
+
+	for rows.Next() {
+		var userID int64
+
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+
+		watching = append(watching, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return watching, nil
+}
+
+/* The movies on the user's watchlist, paginated like MovieModel.GetAll */
+func (m WatchlistModel) GetAll(ctx context.Context, userID int64, f Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, `+movieGenres+`, average_rating, poster_key, poster_url, version
+		FROM movies
+		INNER JOIN users_movies ON users_movies.movie_id = movies.id
+		WHERE users_movies.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`,
+		f.sortColumn(), f.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, f.limit(), f.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.AverageRating,
+			&movie.posterKey,
+			&movie.PosterURL,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, f.Page, f.PageSize), nil
+}
@@ -0,0 +1,324 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mohafarman/greenlight/internal/validator"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID         int64     `json:"id" xml:"id"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at"`
+	URL        string    `json:"url" xml:"url"`
+	EventTypes []string  `json:"event_types" xml:"event_types>event_type"`
+	/* Only shown once, when the webhook is created */
+	Secret  string `json:"secret,omitempty" xml:"secret,omitempty"`
+	Version int32  `json:"version" xml:"version"`
+}
+
+type WebhookDelivery struct {
+	ID             int64      `json:"id" xml:"id"`
+	WebhookID      int64      `json:"webhook_id" xml:"webhook_id"`
+	CreatedAt      time.Time  `json:"created_at" xml:"created_at"`
+	EventType      string     `json:"event_type" xml:"event_type"`
+	Payload        []byte     `json:"-" xml:"-"`
+	Status         string     `json:"status" xml:"status"`
+	Attempts       int        `json:"attempts" xml:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" xml:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty" xml:"last_attempt_at,omitempty"`
+	ResponseStatus *int       `json:"response_status,omitempty" xml:"response_status,omitempty"`
+	LastError      *string    `json:"last_error,omitempty" xml:"last_error,omitempty"`
+
+	/* Joined from the webhook when claimed for delivery */
+	URL    string `json:"-" xml:"-"`
+	Secret string `json:"-" xml:"-"`
+}
+
+type WebhookModel struct {
+	DB DBTX
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook, eventTypes []string) {
+	v.CheckField(validator.NotBlank(webhook.URL), "url", validator.Message("validation.required"))
+	if webhook.URL != "" {
+		v.CheckField(validator.IsURL(webhook.URL), "url", validator.Message("validation.url"))
+	}
+
+	v.CheckField(webhook.EventTypes != nil, "event_types", validator.Message("validation.required"))
+	v.CheckField(validator.Min(len(webhook.EventTypes), 1), "event_types", validator.Message("validation.no_event_types"))
+	v.CheckField(validator.Unique(webhook.EventTypes), "event_types", validator.Message("validation.unique"))
+
+	validator.Each(v, "event_types", webhook.EventTypes, func(v *validator.Validator, eventType string) {
+		v.CheckField(validator.In(eventType, eventTypes...), "", validator.Message("validation.event_type"))
+	})
+}
+
+/* Long enough to not be guessed, the generated ones are 64 characters */
+func ValidateWebhookSecret(v *validator.Validator, secret string) {
+	v.CheckField(validator.MinChars(secret, 32), "secret", validator.Message("validation.min_chars", 32))
+	v.CheckField(validator.MaxChars(secret, 128), "secret", validator.Message("validation.max_chars", 128))
+}
+
+/*
+Generates the signing secret unless the client chose one, it is returned to the
+client only in the create response
+*/
+func (m WebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	query := `
+		INSERT INTO webhooks (url, event_types, secret, tenant_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, webhook.URL, pq.Array(webhook.EventTypes), webhook.Secret, TenantID(ctx)).
+		Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+func (m WebhookModel) GetAll(ctx context.Context) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, event_types, version
+		FROM webhooks
+		WHERE tenant_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, TenantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(&webhook.ID, &webhook.CreatedAt, &webhook.URL, pq.Array(&webhook.EventTypes), &webhook.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1 AND tenant_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, TenantID(ctx))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+/* Queues a delivery of payload to every webhook of the tenant in ctx subscribed to eventType */
+func (m WebhookModel) Enqueue(ctx context.Context, eventType string, payload []byte) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+		SELECT id, $1, $2
+		FROM webhooks
+		WHERE $1 = ANY(event_types) AND tenant_id = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, eventType, payload, TenantID(ctx))
+	return err
+}
+
+/*
+Enqueue for an event of the outbox, key being the entry's: an entry delivered
+again queues no second delivery to the webhooks that already have it.
+*/
+func (m WebhookModel) EnqueueOnce(ctx context.Context, key, eventType string, payload []byte) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, payload, outbox_key)
+		SELECT id, $1, $2, $3
+		FROM webhooks
+		WHERE $1 = ANY(event_types) AND tenant_id = $4
+		ON CONFLICT (webhook_id, outbox_key) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, eventType, payload, key, TenantID(ctx))
+	return err
+}
+
+/*
+Claims up to limit deliveries that are due and pushes their next attempt back by
+lease, so another dispatcher (or this one after a crash) only picks them up again
+if the attempt is never recorded.
+*/
+func (m WebhookModel) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM webhooks w
+		WHERE w.id = d.webhook_id
+		AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING d.id, d.webhook_id, d.created_at, d.event_type, d.payload, d.attempts, w.url, w.secret`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+
+	for rows.Next() {
+		delivery := WebhookDelivery{Status: DeliveryPending}
+
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.CreatedAt, &delivery.EventType,
+			&delivery.Payload, &delivery.Attempts, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+/* Saves the outcome of an attempt: status, attempts, next attempt and response */
+func (m WebhookModel) RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = $4,
+			response_status = $5, last_error = $6
+		WHERE id = $7`
+
+	args := []any{
+		delivery.Status,
+		delivery.Attempts,
+		delivery.NextAttemptAt,
+		delivery.LastAttemptAt,
+		delivery.ResponseStatus,
+		delivery.LastError,
+		delivery.ID,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+/* Most recent deliveries first; ErrRecordNotFound if the webhook doesn't exist in the tenant of ctx */
+func (m WebhookModel) GetDeliveries(ctx context.Context, webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	if webhookID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = $1 AND tenant_id = $2)`, webhookID, TenantID(ctx)).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, webhook_id, created_at, event_type, status, attempts,
+			next_attempt_at, last_attempt_at, response_status, last_error
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.CreatedAt, &delivery.EventType, &delivery.Status,
+			&delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastAttemptAt, &delivery.ResponseStatus, &delivery.LastError)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,86 @@
+/*
+Package enrich looks up what the catalogue doesn't keep itself about a movie,
+its plot, poster, IMDb rating and cast, at a metadata service. Provider is the
+extension point; TMDB and OMDb talk to The Movie Database and the Open Movie
+Database, Cached keeps their answers so the same movie isn't looked up twice.
+*/
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/cache"
+)
+
+/* No movie with the title and year at the provider */
+var ErrNotFound = errors.New("enrich: movie not found")
+
+/* Empty fields are the ones the provider doesn't know */
+type Metadata struct {
+	Plot      string `json:"plot"`
+	PosterURL string `json:"poster_url"`
+	IMDbID    string `json:"imdb_id"`
+	/* Out of 10, nil without votes */
+	IMDbRating *float64 `json:"imdb_rating"`
+	/* Top billed first */
+	Cast []string `json:"cast"`
+}
+
+type Provider interface {
+	/* The movie best matching the title and year, ErrNotFound without one; a zero year matches any */
+	Lookup(ctx context.Context, title string, year int32) (*Metadata, error)
+}
+
+/* At most this many of the cast are kept */
+const maxCast = 10
+
+/*
+Cached answers lookups from c for ttl after the provider answered them, not
+found included. Errors of the cache are ignored, it's only there to spare the
+provider's rate limit.
+*/
+type Cached struct {
+	provider Provider
+	name     string
+	c        cache.Cache
+	ttl      time.Duration
+}
+
+/* name tells the providers' answers apart in a cache they share */
+func NewCached(provider Provider, name string, c cache.Cache, ttl time.Duration) *Cached {
+	return &Cached{provider: provider, name: name, c: c, ttl: ttl}
+}
+
+func (c *Cached) Lookup(ctx context.Context, title string, year int32) (*Metadata, error) {
+	key := fmt.Sprintf("enrich:%s:%d:%s", c.name, year, strings.ToLower(strings.TrimSpace(title)))
+
+	if value, found, err := c.c.Get(ctx, key); err == nil && found {
+		/* null for a movie the provider doesn't have */
+		var md *Metadata
+		if json.Unmarshal(value, &md) == nil {
+			if md == nil {
+				return nil, ErrNotFound
+			}
+			return md, nil
+		}
+	}
+
+	md, err := c.provider.Lookup(ctx, title, year)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	if value, err := json.Marshal(md); err == nil {
+		c.c.Set(ctx, key, value, c.ttl)
+	}
+
+	if md == nil {
+		return nil, ErrNotFound
+	}
+	return md, nil
+}
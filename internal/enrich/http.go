@@ -0,0 +1,100 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	/* Tries of a request that is rate limited or fails on the provider's side */
+	maxAttempts = 4
+	/* Doubled after every try unless the provider says how long to wait */
+	firstBackoff = 500 * time.Millisecond
+	/* Longer waits give up, the client of the API is waiting too */
+	maxWait = 10 * time.Second
+)
+
+/*
+Gets endpoint and decodes the JSON response into v. 429s and 5xxs are retried
+after the Retry-After of the response, or a backoff without one; ErrNotFound
+for a 404.
+*/
+func getJSON(ctx context.Context, client *http.Client, endpoint string, v any) error {
+	wait := firstBackoff
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+
+		res, err := client.Do(req)
+		if err != nil {
+			/* A *url.Error would quote the URL, and the API key in it */
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return fmt.Errorf("enrich: %s: %w", req.URL.Host, err)
+		}
+
+		retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		if !retry {
+			defer res.Body.Close()
+
+			switch {
+			case res.StatusCode == http.StatusNotFound:
+				return ErrNotFound
+			case res.StatusCode >= 300:
+				msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+				return fmt.Errorf("enrich: %s responded %s: %s", req.URL.Host, res.Status, bytes.TrimSpace(msg))
+			}
+
+			return json.NewDecoder(res.Body).Decode(v)
+		}
+
+		res.Body.Close()
+
+		if d, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+			wait = d
+		}
+
+		if attempt == maxAttempts || wait > maxWait {
+			return fmt.Errorf("enrich: %s responded %s, gave up after %d attempts", req.URL.Host, res.Status, attempt)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		wait *= 2
+	}
+}
+
+/* Retry-After in seconds or as an HTTP date */
+func retryAfter(val string) (time.Duration, bool) {
+	if val == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(val); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(val); err == nil {
+		return max(time.Until(t), 0), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,87 @@
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+OMDb looks movies up at the Open Movie Database by exact title. It only names
+the leading actors, usually three or four.
+*/
+type OMDb struct {
+	apiKey  string
+	baseURL string
+
+	client *http.Client
+}
+
+func NewOMDb(apiKey string) *OMDb {
+	return &OMDb{
+		apiKey:  apiKey,
+		baseURL: "https://www.omdbapi.com/",
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (o *OMDb) Lookup(ctx context.Context, title string, year int32) (*Metadata, error) {
+	query := url.Values{"apikey": {o.apiKey}, "t": {title}, "type": {"movie"}, "plot": {"short"}}
+	if year != 0 {
+		query.Set("y", strconv.Itoa(int(year)))
+	}
+
+	/* Every value is a string, "N/A" for the ones it doesn't know */
+	var movie struct {
+		Response   string `json:"Response"`
+		Error      string `json:"Error"`
+		Plot       string `json:"Plot"`
+		Poster     string `json:"Poster"`
+		IMDbID     string `json:"imdbID"`
+		IMDbRating string `json:"imdbRating"`
+		Actors     string `json:"Actors"`
+	}
+
+	err := getJSON(ctx, o.client, o.baseURL+"?"+query.Encode(), &movie)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Errors are answered with a 200 too */
+	if movie.Response != "True" {
+		if movie.Error == "Movie not found!" {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("enrich: omdb: %s", movie.Error)
+	}
+
+	md := &Metadata{
+		Plot:      known(movie.Plot),
+		PosterURL: known(movie.Poster),
+		IMDbID:    known(movie.IMDbID),
+	}
+
+	if rating, err := strconv.ParseFloat(movie.IMDbRating, 64); err == nil {
+		md.IMDbRating = &rating
+	}
+
+	if actors := known(movie.Actors); actors != "" {
+		for _, actor := range strings.Split(actors, ",") {
+			md.Cast = append(md.Cast, strings.TrimSpace(actor))
+		}
+		md.Cast = md.Cast[:min(len(md.Cast), maxCast)]
+	}
+
+	return md, nil
+}
+
+func known(val string) string {
+	if val == "N/A" {
+		return ""
+	}
+	return val
+}
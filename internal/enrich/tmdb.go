@@ -0,0 +1,91 @@
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*
+TMDB searches The Movie Database with a v3 API key. It has no IMDb rating, only
+the IMDb ID, the rating is left nil.
+*/
+type TMDB struct {
+	apiKey  string
+	baseURL string
+	/* Posters are linked at this size, see https://developer.themoviedb.org/docs/image-basics */
+	imageURL string
+
+	client *http.Client
+}
+
+func NewTMDB(apiKey string) *TMDB {
+	return &TMDB{
+		apiKey:   apiKey,
+		baseURL:  "https://api.themoviedb.org/3",
+		imageURL: "https://image.tmdb.org/t/p/w500",
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (t *TMDB) Lookup(ctx context.Context, title string, year int32) (*Metadata, error) {
+	query := url.Values{"api_key": {t.apiKey}, "query": {title}}
+	if year != 0 {
+		query.Set("year", strconv.Itoa(int(year)))
+	}
+
+	var search struct {
+		Results []struct {
+			ID int64 `json:"id"`
+		} `json:"results"`
+	}
+
+	err := getJSON(ctx, t.client, t.baseURL+"/search/movie?"+query.Encode(), &search)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Ranked by relevance, the first result is the best match */
+	if len(search.Results) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var movie struct {
+		Overview    string `json:"overview"`
+		PosterPath  string `json:"poster_path"`
+		ExternalIDs struct {
+			IMDbID string `json:"imdb_id"`
+		} `json:"external_ids"`
+		Credits struct {
+			Cast []struct {
+				Name string `json:"name"`
+			} `json:"cast"`
+		} `json:"credits"`
+	}
+
+	query = url.Values{"api_key": {t.apiKey}, "append_to_response": {"credits,external_ids"}}
+
+	err = getJSON(ctx, t.client, fmt.Sprintf("%s/movie/%d?%s", t.baseURL, search.Results[0].ID, query.Encode()), &movie)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{
+		Plot:   movie.Overview,
+		IMDbID: movie.ExternalIDs.IMDbID,
+	}
+
+	if movie.PosterPath != "" {
+		md.PosterURL = t.imageURL + movie.PosterPath
+	}
+
+	/* In billing order */
+	for _, member := range movie.Credits.Cast[:min(len(movie.Credits.Cast), maxCast)] {
+		md.Cast = append(md.Cast, member.Name)
+	}
+
+	return md, nil
+}
@@ -0,0 +1,124 @@
+/*
+Package errreport sends the errors and panics of the API to an error tracker,
+with the stack trace and the request they happened in. Reporter is the
+extension point; Sentry speaks the protocol of Sentry and compatible services
+such as GlitchTip.
+*/
+package errreport
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+type Reporter interface {
+	/* Sends the event, or drops it when it isn't sampled; errors are returned for the log */
+	Report(ctx context.Context, event *Event) error
+}
+
+type Event struct {
+	Time time.Time
+	/* "panic" or the Go type of the error, e.g. *pq.Error */
+	Type    string
+	Message string
+	/* Innermost call first, see Stacktrace */
+	Stack []Frame
+
+	/* The request that failed, nil for background work */
+	Request   *http.Request
+	RequestID string
+	/* 0 for anonymous requests */
+	UserID int
+}
+
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+/* The stack of the caller of Stacktrace, without the skip frames above that */
+func Stacktrace(skip int) []Frame {
+	pc := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pc)
+
+	var stack []Frame
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		stack = append(stack, Frame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		if !more {
+			return stack
+		}
+	}
+}
+
+/* Headers that carry credentials, never reported */
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+/* Query parameters whose names contain one of these are reported as [Filtered] */
+var sensitiveParams = []string{"password", "token", "secret", "key", "code"}
+
+const filtered = "[Filtered]"
+
+/* The request's headers without credentials */
+func scrubHeaders(header http.Header) map[string]string {
+	scrubbed := make(map[string]string, len(header))
+
+	for name, values := range header {
+		scrubbed[name] = strings.Join(values, ", ")
+		for _, sensitive := range sensitiveHeaders {
+			if strings.EqualFold(name, sensitive) {
+				scrubbed[name] = filtered
+			}
+		}
+	}
+
+	return scrubbed
+}
+
+/* The query string with passwords, tokens and the like filtered out */
+func scrubQuery(query url.Values) string {
+	scrubbed := make(url.Values, len(query))
+
+	for name, values := range query {
+		scrubbed[name] = values
+		for _, sensitive := range sensitiveParams {
+			if strings.Contains(strings.ToLower(name), sensitive) {
+				scrubbed[name] = []string{filtered}
+			}
+		}
+	}
+
+	return scrubbed.Encode()
+}
+
+/* Passwords in connection strings, e.g. in the errors of a database that went away */
+var (
+	urlPassword      = regexp.MustCompile(`(://[^:/@\s]+:)[^@\s]+@`)
+	keyValuePassword = regexp.MustCompile(`(?i)((?:password|secret|token)\s*[=:]\s*)\S+`)
+)
+
+func scrubMessage(msg string) string {
+	msg = urlPassword.ReplaceAllString(msg, "${1}"+filtered+"@")
+	return keyValuePassword.ReplaceAllString(msg, "${1}"+filtered)
+}
+
+/* Frames of packages outside the main module, i.e. the standard library and dependencies, are marked as such */
+var mainModule = func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return info.Main.Path
+}()
+
+func inApp(function string) bool {
+	return mainModule != "" && strings.HasPrefix(function, mainModule+"/")
+}
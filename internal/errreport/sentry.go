@@ -0,0 +1,199 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Sentry sends events to the envelope endpoint of the project of a DSN such as
+https://<key>@o0.ingest.sentry.io/<project>, keeping SampleRate of them.
+*/
+type Sentry struct {
+	endpoint string
+	auth     string
+	cfg      SentryConfig
+
+	client *http.Client
+}
+
+type SentryConfig struct {
+	DSN string
+	/* Fraction of the events that are sent, between 0 and 1 */
+	SampleRate  float64
+	Environment string
+	Release     string
+}
+
+func NewSentry(cfg SentryConfig) (*Sentry, error) {
+	dsn, err := url.Parse(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("errreport: invalid DSN: %w", err)
+	}
+
+	/* The project ID is the last segment, a DSN can have a path before it, e.g. behind a proxy */
+	i := strings.LastIndex(dsn.Path, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("errreport: invalid DSN %q, must be like https://<key>@<host>/<project>", dsn.Redacted())
+	}
+	prefix, projectID := dsn.Path[:i], dsn.Path[i+1:]
+
+	if dsn.Scheme != "http" && dsn.Scheme != "https" || dsn.Host == "" || dsn.User.Username() == "" || projectID == "" {
+		return nil, fmt.Errorf("errreport: invalid DSN %q, must be like https://<key>@<host>/<project>", dsn.Redacted())
+	}
+
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("errreport: invalid sample rate %v, must be between 0 and 1", cfg.SampleRate)
+	}
+
+	return &Sentry{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/envelope/", dsn.Scheme, dsn.Host, prefix, projectID),
+		auth:     "Sentry sentry_version=7, sentry_client=greenlight/1.0, sentry_key=" + dsn.User.Username(),
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *Sentry) Report(ctx context.Context, event *Event) error {
+	if mathrand.Float64() >= s.cfg.SampleRate {
+		return nil
+	}
+
+	eventID := make([]byte, 16)
+	rand.Read(eventID)
+	id := hex.EncodeToString(eventID)
+
+	payload, err := json.Marshal(s.payload(id, event))
+	if err != nil {
+		return err
+	}
+
+	/* An envelope: its header, then the item header and the event, one per line */
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `{"event_id":%q,"sent_at":%q}`+"\n", id, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`+"\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("errreport: sentry responded %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+/* The event in Sentry's format, see https://develop.sentry.dev/sdk/data-model/event-payloads/ */
+func (s *Sentry) payload(id string, event *Event) map[string]any {
+	/* Sentry wants the outermost call first */
+	frames := make([]sentryFrame, 0, len(event.Stack))
+	for _, frame := range slices.Backward(event.Stack) {
+		frames = append(frames, sentryFrame{
+			Function: frame.Function,
+			Filename: shortFile(frame.File),
+			AbsPath:  frame.File,
+			Lineno:   frame.Line,
+			InApp:    inApp(frame.Function),
+		})
+	}
+
+	level := "error"
+	if event.Type == "panic" {
+		level = "fatal"
+	}
+
+	payload := map[string]any{
+		"event_id":    id,
+		"timestamp":   event.Time.UTC().Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       level,
+		"environment": s.cfg.Environment,
+		"release":     s.cfg.Release,
+		"exception": map[string]any{
+			"values": []map[string]any{{
+				"type":       event.Type,
+				"value":      scrubMessage(event.Message),
+				"stacktrace": map[string]any{"frames": frames},
+			}},
+		},
+	}
+
+	if r := event.Request; r != nil {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		request := sentryRequest{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + r.URL.Path,
+			QueryString: scrubQuery(r.URL.Query()),
+			Headers:     scrubHeaders(r.Header),
+		}
+
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			request.Env = map[string]string{"REMOTE_ADDR": host}
+		}
+
+		payload["request"] = request
+	}
+
+	if event.UserID != 0 {
+		payload["user"] = map[string]string{"id": strconv.Itoa(event.UserID)}
+	}
+
+	if event.RequestID != "" {
+		payload["tags"] = map[string]string{"request_id": event.RequestID}
+	}
+
+	return payload
+}
+
+/* The file and its directory, e.g. api/errors.go, which is what Sentry shows */
+func shortFile(file string) string {
+	dir, name := path.Split(file)
+	return path.Join(path.Base(dir), name)
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+/* Event types published by the API */
+const (
+	MovieCreated  = "movie.created"
+	MovieUpdated  = "movie.updated"
+	MovieDeleted  = "movie.deleted"
+	UserActivated = "user.activated"
+)
+
+/* All event types, in the order they are documented */
+var Types = []string{MovieCreated, MovieUpdated, MovieDeleted, UserActivated}
+
+type Event struct {
+	/* Increasing, for resuming event streams; left out of the JSON, which webhooks also get */
+	ID   uint64    `json:"-"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+	/* Whose catalogue or user it is about, event streams only get those of their own tenant */
+	Tenant int64 `json:"-"`
+}
+
+/*
+Bus fans events out to in-process subscribers. Handlers run synchronously in
+the publishing goroutine, so they should hand slow work off (to the database
+or a channel) rather than do it inline.
+*/
+type Bus struct {
+	mu       sync.Mutex
+	nextID   int
+	handlers map[int]func(Event)
+
+	/* The last event's ID before the first one and now, and the most recent events, oldest first */
+	firstEventID uint64
+	lastEventID  uint64
+	history      []Event
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+/* Events kept for subscribers resuming with SubscribeSince */
+const historySize = 256
+
+func NewBus() *Bus {
+	/* From the clock, so IDs of an earlier run of the API come before those of this one */
+	id := uint64(time.Now().UnixMicro())
+
+	return &Bus{
+		handlers:     make(map[int]func(Event)),
+		firstEventID: id,
+		lastEventID:  id,
+		done:         make(chan struct{}),
+	}
+}
+
+/*
+Closes Done, telling long-lived subscribers such as event streams to finish on
+shutdown. Events published afterwards still reach the subscribers left, so
+requests completing during shutdown still queue their webhooks.
+*/
+func (b *Bus) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+func (b *Bus) Done() <-chan struct{} {
+	return b.done
+}
+
+/* Returns a function that removes the subscription */
+func (b *Bus) Subscribe(handler func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.subscribe(handler)
+}
+
+/*
+Subscribe, first returning the events published after the one with ID lastID
+that handler won't get. complete is false when some of them are no longer kept,
+or lastID is not one of this bus, e.g. of an earlier run or another instance of
+the API.
+*/
+func (b *Bus) SubscribeSince(lastID uint64, handler func(Event)) (missed []Event, complete bool, unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = lastID >= b.firstEventID && lastID <= b.lastEventID
+
+	i := len(b.history)
+	for i > 0 && b.history[i-1].ID > lastID {
+		i--
+	}
+
+	/* The event after lastID was dropped from the history */
+	if i == 0 && len(b.history) == historySize && b.history[0].ID > lastID+1 {
+		complete = false
+	}
+
+	if complete {
+		missed = slices.Clone(b.history[i:])
+	}
+
+	return missed, complete, b.subscribe(handler)
+}
+
+/* b.mu must be held */
+func (b *Bus) subscribe(handler func(Event)) (unsubscribe func()) {
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.handlers, id)
+	}
+}
+
+func (b *Bus) Publish(tenant int64, eventType string, data any) {
+	/* Numbered and copied under the same lock as SubscribeSince, so a subscriber gets each event once */
+	b.mu.Lock()
+
+	b.lastEventID++
+	event := Event{ID: b.lastEventID, Type: eventType, Time: time.Now().UTC(), Data: data, Tenant: tenant}
+
+	if len(b.history) == historySize {
+		b.history = slices.Delete(b.history, 0, 1)
+	}
+	b.history = append(b.history, event)
+
+	/* Copy so handlers can unsubscribe without deadlocking */
+	handlers := make([]func(Event), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
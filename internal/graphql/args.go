@@ -0,0 +1,81 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+/* Field arguments after variables have been substituted */
+type Args map[string]any
+
+/* Whether the argument was given, tells an omitted argument from one set to its fallback */
+func (a Args) Has(name string) bool {
+	v, ok := a[name]
+	return ok && v != nil
+}
+
+func (a Args) String(name, fallback string) (string, error) {
+	v, ok := a[name]
+	if !ok || v == nil {
+		return fallback, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %q must be a string", name)
+	}
+
+	return s, nil
+}
+
+/* Accepts literals as well as variables decoded from JSON (float64 or json.Number) */
+func (a Args) Int(name string, fallback int) (int, error) {
+	v, ok := a[name]
+	if !ok || v == nil {
+		return fallback, nil
+	}
+
+	switch n := v.(type) {
+	case int64:
+		return int(n), nil
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n), nil
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return int(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("argument %q must be an integer", name)
+}
+
+/* A single string is accepted for a list, as GraphQL input coercion allows */
+func (a Args) Strings(name string) ([]string, error) {
+	v, ok := a[name]
+	if !ok || v == nil {
+		return nil, nil
+	}
+
+	if s, ok := v.(string); ok {
+		return []string{s}, nil
+	}
+
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("argument %q must be a list of strings", name)
+	}
+
+	values := make([]string, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %q must be a list of strings", name)
+		}
+		values[i] = s
+	}
+
+	return values, nil
+}
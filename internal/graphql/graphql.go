@@ -0,0 +1,507 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+/*
+A small GraphQL executor for queries and mutations. The schema is plain Go: objects
+list their fields and each field resolves from its parent value. Fields can be
+resolved in batches, for every parent in a list at once, which avoids the N+1
+queries a per-parent resolver would make (the job of a dataloader elsewhere).
+
+Supported: queries with variables, aliases, arguments, named and inline
+fragments, @skip/@include and __typename. The top-level fields of a mutation
+are resolved one after another, in the order they are selected. Not supported:
+subscriptions and introspection.
+*/
+
+type Schema struct {
+	Query *Object
+	/* Nil for a schema without mutations */
+	Mutation *Object
+}
+
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+type Field struct {
+	/* Object the field resolves to, a single value or a slice of them; nil for scalars */
+	Type *Object
+	/* Names of the accepted arguments */
+	Args []string
+
+	/* Resolve is called once per parent, without it the parent's field with the same json name is used */
+	Resolve func(ctx context.Context, source any, args Args) (any, error)
+	/* ResolveBatch is called once for all parents and returns a value per parent */
+	ResolveBatch func(ctx context.Context, sources []any, args Args) ([]any, error)
+}
+
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+type Error struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: "syntax error: " + err.Error()}}}
+	}
+
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	var root *Object
+	switch op.kind {
+	case "query":
+		root = s.Query
+	case "mutation":
+		root = s.Mutation
+	}
+	if root == nil {
+		return &Response{Errors: []*Error{{Message: op.kind + " operations are not supported"}}}
+	}
+
+	vars, err := coerceVariables(op, req.Variables)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	e := &executor{ctx: ctx, doc: doc, vars: vars}
+
+	results := e.executeSelection(root, []any{nil}, op.selection, [][]any{{}})
+
+	return &Response{Data: results[0], Errors: e.errors}
+}
+
+func (doc *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, fmt.Errorf("operationName is required when the document has several operations")
+		}
+		return doc.operations[0], nil
+	}
+
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func coerceVariables(op *operation, provided map[string]any) (map[string]any, error) {
+	vars := make(map[string]any, len(op.variables))
+
+	for _, def := range op.variables {
+		v, ok := provided[def.name]
+		switch {
+		case ok:
+			vars[def.name] = v
+		case def.defaultValue != nil:
+			vars[def.name] = def.defaultValue
+		case def.nonNull:
+			return nil, fmt.Errorf("variable $%s is required", def.name)
+		}
+	}
+
+	return vars, nil
+}
+
+type executor struct {
+	ctx    context.Context
+	doc    *document
+	vars   map[string]any
+	errors []*Error
+}
+
+func (e *executor) addError(path []any, format string, args ...any) {
+	e.errors = append(e.errors, &Error{Message: fmt.Sprintf(format, args...), Path: path})
+}
+
+/* A field as selected in the query, with any duplicates merged */
+type collectedField struct {
+	alias     string
+	name      string
+	arguments map[string]value
+	selection []selection
+}
+
+/* Runs the selection set against every source and returns one result per source */
+func (e *executor) executeSelection(obj *Object, sources []any, sels []selection, paths [][]any) []*result {
+	results := make([]*result, len(sources))
+	for i := range results {
+		results[i] = &result{}
+	}
+
+	for _, cf := range e.collectFields(obj, sels, nil) {
+		if cf.name == "__typename" {
+			for _, res := range results {
+				res.set(cf.alias, obj.Name)
+			}
+			continue
+		}
+
+		fieldPaths := make([][]any, len(sources))
+		for i := range sources {
+			fieldPaths[i] = appendPath(paths[i], cf.alias)
+		}
+
+		field, ok := obj.Fields[cf.name]
+		if !ok {
+			e.addError(nil, "cannot query field %q on type %q", cf.name, obj.Name)
+			continue
+		}
+
+		values := e.resolve(field, cf, sources, fieldPaths)
+
+		if field.Type == nil {
+			if len(cf.selection) > 0 {
+				e.addError(nil, "field %q of type %q must not have a selection", cf.name, obj.Name)
+			}
+			for i, res := range results {
+				res.set(cf.alias, values[i])
+			}
+			continue
+		}
+
+		if len(cf.selection) == 0 {
+			e.addError(nil, "field %q of type %q must have a selection of subfields", cf.name, obj.Name)
+			continue
+		}
+
+		e.completeObjects(field.Type, cf, values, fieldPaths, results)
+	}
+
+	return results
+}
+
+/*
+Flattens the objects (or lists of objects) resolved for every source so their
+own selection runs once over all of them, then puts the results back in place.
+*/
+func (e *executor) completeObjects(obj *Object, cf collectedField, values []any, paths [][]any, results []*result) {
+	var children []any
+	var childPaths [][]any
+
+	type slot struct {
+		list  bool
+		start int
+		count int
+	}
+	slots := make([]slot, len(values))
+
+	for i, v := range values {
+		rv := reflect.ValueOf(v)
+
+		switch {
+		case isNil(v):
+			slots[i] = slot{count: -1}
+		case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+			slots[i] = slot{list: true, start: len(children), count: rv.Len()}
+			for j := range rv.Len() {
+				children = append(children, rv.Index(j).Interface())
+				childPaths = append(childPaths, appendPath(paths[i], j))
+			}
+		default:
+			slots[i] = slot{start: len(children), count: 1}
+			children = append(children, v)
+			childPaths = append(childPaths, paths[i])
+		}
+	}
+
+	childResults := e.executeSelection(obj, children, cf.selection, childPaths)
+
+	for i, s := range slots {
+		switch {
+		case s.count == -1:
+			results[i].set(cf.alias, nil)
+		case s.list:
+			list := make([]*result, s.count)
+			copy(list, childResults[s.start:s.start+s.count])
+			results[i].set(cf.alias, list)
+		default:
+			results[i].set(cf.alias, childResults[s.start])
+		}
+	}
+}
+
+/* Resolves the field for every source, a failed resolve becomes a null and an error */
+func (e *executor) resolve(field *Field, cf collectedField, sources []any, paths [][]any) []any {
+	values := make([]any, len(sources))
+	if len(sources) == 0 {
+		return values
+	}
+
+	args, err := e.arguments(field, cf)
+	if err != nil {
+		for _, path := range paths {
+			e.addError(path, "%s", err)
+		}
+		return values
+	}
+
+	if field.ResolveBatch != nil {
+		resolved, err := field.ResolveBatch(e.ctx, sources, args)
+		if err == nil && len(resolved) != len(sources) {
+			err = fmt.Errorf("batch resolver for %q returned %d values for %d sources", cf.name, len(resolved), len(sources))
+		}
+		if err != nil {
+			for _, path := range paths {
+				e.addError(path, "%s", err)
+			}
+			return values
+		}
+		return resolved
+	}
+
+	for i, source := range sources {
+		if field.Resolve == nil {
+			values[i] = defaultResolve(source, cf.name)
+			continue
+		}
+
+		values[i], err = field.Resolve(e.ctx, source, args)
+		if err != nil {
+			e.addError(paths[i], "%s", err)
+			values[i] = nil
+		}
+	}
+
+	return values
+}
+
+func (e *executor) arguments(field *Field, cf collectedField) (Args, error) {
+	args := make(Args, len(cf.arguments))
+
+	for name, v := range cf.arguments {
+		if !slices.Contains(field.Args, name) {
+			return nil, fmt.Errorf("unknown argument %q on field %q", name, cf.name)
+		}
+
+		args[name] = e.value(v)
+	}
+
+	return args, nil
+}
+
+/* Substitutes variables in v */
+func (e *executor) value(v value) any {
+	switch v := v.(type) {
+	case variableRef:
+		return e.vars[string(v)]
+	case enumValue:
+		return string(v)
+	case []value:
+		list := make([]any, len(v))
+		for i, item := range v {
+			list[i] = e.value(item)
+		}
+		return list
+	case map[string]value:
+		object := make(map[string]any, len(v))
+		for k, item := range v {
+			object[k] = e.value(item)
+		}
+		return object
+	default:
+		return v
+	}
+}
+
+func (e *executor) collectFields(obj *Object, sels []selection, visited map[string]bool) []collectedField {
+	var fields []collectedField
+
+	for _, sel := range sels {
+		if !e.included(sel.directives) {
+			continue
+		}
+
+		var nested []selection
+
+		switch {
+		case sel.spread != "":
+			if visited[sel.spread] {
+				continue
+			}
+			frag, ok := e.doc.fragments[sel.spread]
+			if !ok {
+				e.addError(nil, "unknown fragment %q", sel.spread)
+				continue
+			}
+			if frag.typeCondition != obj.Name {
+				continue
+			}
+			if visited == nil {
+				visited = make(map[string]bool)
+			}
+			visited[sel.spread] = true
+			nested = frag.selection
+
+		case sel.inline:
+			if sel.typeCondition != "" && sel.typeCondition != obj.Name {
+				continue
+			}
+			nested = sel.selection
+
+		default:
+			fields = mergeField(fields, collectedField{alias: sel.alias, name: sel.name, arguments: sel.arguments, selection: sel.selection})
+			continue
+		}
+
+		for _, cf := range e.collectFields(obj, nested, visited) {
+			fields = mergeField(fields, cf)
+		}
+	}
+
+	return fields
+}
+
+/* The same response key selected twice resolves once with both selections */
+func mergeField(fields []collectedField, cf collectedField) []collectedField {
+	for i := range fields {
+		if fields[i].alias == cf.alias {
+			fields[i].selection = append(fields[i].selection, cf.selection...)
+			return fields
+		}
+	}
+
+	return append(fields, cf)
+}
+
+/* Applies @skip(if:) and @include(if:) */
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		condition, _ := e.value(d.arguments["if"]).(bool)
+
+		switch d.name {
+		case "skip":
+			if condition {
+				return false
+			}
+		case "include":
+			if !condition {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+/* Reads the struct field whose json name is name, or the map entry with that key */
+func defaultResolve(source any, name string) any {
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		v := rv.MapIndex(reflect.ValueOf(name))
+		if !v.IsValid() {
+			return nil
+		}
+		return v.Interface()
+
+	case reflect.Struct:
+		for i := range rv.NumField() {
+			field := rv.Type().Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if field.IsExported() && (tag == name || (tag == "" && field.Name == name)) {
+				return rv.Field(i).Interface()
+			}
+		}
+	}
+
+	return nil
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+
+	return false
+}
+
+func appendPath(path []any, key any) []any {
+	return append(path[:len(path):len(path)], key)
+}
+
+/* Response object that keeps keys in the order they were selected, as the spec requires */
+type result struct {
+	keys   []string
+	values map[string]any
+}
+
+func (r *result) set(key string, v any) {
+	if r.values == nil {
+		r.values = make(map[string]any)
+	}
+
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+
+	r.values[key] = v
+}
+
+func (r *result) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+
+		v, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
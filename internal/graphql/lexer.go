@@ -0,0 +1,146 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+/* Commas are insignificant in GraphQL and are skipped with whitespace and comments */
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunct, value: "...", pos: start}, nil
+
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunct, value: string(c), pos: start}, nil
+
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], pos: start}, nil
+
+	case c == '-' || isDigit(c):
+		return l.number()
+
+	case c == '"':
+		return l.string()
+	}
+
+	return token{}, fmt.Errorf("unexpected character %q at position %d", c, start)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokenInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case isDigit(c):
+		case c == '.' || c == 'e' || c == 'E':
+			kind = tokenFloat
+		case (c == '+' || c == '-') && kind == tokenFloat:
+		default:
+			goto done
+		}
+		l.pos++
+	}
+
+done:
+	value := l.src[start:l.pos]
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return token{}, fmt.Errorf("invalid number %q at position %d", value, start)
+	}
+
+	return token{kind: kind, value: value, pos: start}, nil
+}
+
+/* Block strings (""") are not supported, only regular quoted strings */
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	l.pos++
+
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '\n':
+			return token{}, fmt.Errorf("unterminated string at position %d", start)
+		case '"':
+			l.pos++
+
+			/* GraphQL escapes are a subset of JSON's, which strconv.Unquote understands */
+			value, err := strconv.Unquote(l.src[start:l.pos])
+			if err != nil {
+				return token{}, fmt.Errorf("invalid string at position %d", start)
+			}
+			return token{kind: tokenString, value: value, pos: start}, nil
+		}
+		l.pos++
+	}
+
+	return token{}, fmt.Errorf("unterminated string at position %d", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,506 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/* Parsed query document */
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind      string
+	name      string
+	variables []*variableDefinition
+	selection []selection
+}
+
+type variableDefinition struct {
+	name         string
+	nonNull      bool
+	defaultValue value
+}
+
+type fragment struct {
+	typeCondition string
+	selection     []selection
+}
+
+/* A field, a fragment spread (...Name) or an inline fragment (... on Type { }) */
+type selection struct {
+	alias      string
+	name       string
+	arguments  map[string]value
+	directives []*directive
+	selection  []selection
+
+	spread        string
+	inline        bool
+	typeCondition string
+}
+
+type directive struct {
+	name      string
+	arguments map[string]value
+}
+
+/* Literal values keep their Go form, variables and enums are wrapped */
+type value any
+
+type variableRef string
+
+type enumValue string
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parse(query string) (*document, error) {
+	p := &parser{lex: &lexer{src: query}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{fragments: make(map[string]*fragment)}
+
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			selection, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selection: selection})
+
+		case p.tok.kind == tokenName && p.tok.value == "fragment":
+			name, frag, err := p.fragmentDefinition()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments[name] = frag
+
+		case p.tok.kind == tokenName:
+			op, err := p.operationDefinition()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("document does not contain an operation")
+	}
+
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokenPunct && p.tok.value == punct
+}
+
+/* Consumes punct if it is next, reporting whether it was */
+func (p *parser) skip(punct string) (bool, error) {
+	if !p.peek(punct) {
+		return false, nil
+	}
+
+	return true, p.advance()
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		return fmt.Errorf("expected %q at position %d", punct, p.tok.pos)
+	}
+
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", fmt.Errorf("expected a name at position %d", p.tok.pos)
+	}
+
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of document")
+	}
+
+	return fmt.Errorf("unexpected %q at position %d", p.tok.value, p.tok.pos)
+}
+
+func (p *parser) operationDefinition() (*operation, error) {
+	kind, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if kind != "query" && kind != "mutation" && kind != "subscription" {
+		return nil, fmt.Errorf("unknown operation type %q", kind)
+	}
+
+	op := &operation{kind: kind}
+
+	if p.tok.kind == tokenName {
+		op.name, _ = p.name()
+	}
+
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+
+	op.selection, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+/* $name: Type = default, only nullability of the type is kept */
+func (p *parser) variableDefinition() (*variableDefinition, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	def := &variableDefinition{name: name}
+
+	def.nonNull, err = p.typeReference()
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		def.defaultValue, err = p.value(true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return def, nil
+}
+
+func (p *parser) typeReference() (bool, error) {
+	if ok, err := p.skip("["); err != nil {
+		return false, err
+	} else if ok {
+		if _, err := p.typeReference(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+
+	return p.skip("!")
+}
+
+func (p *parser) fragmentDefinition() (string, *fragment, error) {
+	/* The "fragment" keyword */
+	if err := p.advance(); err != nil {
+		return "", nil, err
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if on, _ := p.name(); on != "on" {
+		return "", nil, fmt.Errorf("expected \"on\" in fragment %q", name)
+	}
+
+	frag := &fragment{}
+
+	frag.typeCondition, err = p.name()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := p.directives(); err != nil {
+		return "", nil, err
+	}
+
+	frag.selection, err = p.selectionSet()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return name, frag, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var selections []selection
+
+	for !p.peek("}") {
+		if p.tok.kind == tokenEOF {
+			return nil, p.unexpected()
+		}
+
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	var sel selection
+
+	if ok, err := p.skip("..."); err != nil {
+		return sel, err
+	} else if ok {
+		return p.fragmentSelection()
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return sel, err
+	}
+
+	sel.name, sel.alias = name, name
+
+	if ok, err := p.skip(":"); err != nil {
+		return sel, err
+	} else if ok {
+		sel.name, err = p.name()
+		if err != nil {
+			return sel, err
+		}
+	}
+
+	if p.peek("(") {
+		sel.arguments, err = p.arguments()
+		if err != nil {
+			return sel, err
+		}
+	}
+
+	sel.directives, err = p.directives()
+	if err != nil {
+		return sel, err
+	}
+
+	if p.peek("{") {
+		sel.selection, err = p.selectionSet()
+		if err != nil {
+			return sel, err
+		}
+	}
+
+	return sel, nil
+}
+
+/* After "...": either a named spread or an inline fragment */
+func (p *parser) fragmentSelection() (selection, error) {
+	var sel selection
+	var err error
+
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		sel.spread, _ = p.name()
+		sel.directives, err = p.directives()
+		return sel, err
+	}
+
+	sel.inline = true
+
+	if p.tok.kind == tokenName {
+		/* "on" */
+		p.advance()
+		sel.typeCondition, err = p.name()
+		if err != nil {
+			return sel, err
+		}
+	}
+
+	sel.directives, err = p.directives()
+	if err != nil {
+		return sel, err
+	}
+
+	sel.selection, err = p.selectionSet()
+	return sel, err
+}
+
+func (p *parser) arguments() (map[string]value, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	args := make(map[string]value)
+
+	for !p.peek(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		args[name], err = p.value(false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var directives []*directive
+
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		d := &directive{name: name}
+		if p.peek("(") {
+			d.arguments, err = p.arguments()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		directives = append(directives, d)
+	}
+
+	return directives, nil
+}
+
+/* Variables are not allowed in constant values such as variable defaults */
+func (p *parser) value(constant bool) (value, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokenInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q at position %d", tok.value, tok.pos)
+		}
+		return n, p.advance()
+
+	case tokenFloat:
+		f, _ := strconv.ParseFloat(tok.value, 64)
+		return f, p.advance()
+
+	case tokenString:
+		return tok.value, p.advance()
+
+	case tokenName:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		switch tok.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return enumValue(tok.value), nil
+	}
+
+	switch {
+	case p.peek("$") && !constant:
+		p.advance()
+		name, err := p.name()
+		return variableRef(name), err
+
+	case p.peek("["):
+		p.advance()
+		list := []value{}
+		for !p.peek("]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+
+	case p.peek("{"):
+		p.advance()
+		object := map[string]value{}
+		for !p.peek("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			object[name], err = p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	}
+
+	return nil, p.unexpected()
+}
@@ -0,0 +1,339 @@
+package greenlightpb
+
+import (
+	"github.com/mohafarman/greenlight/internal/protowire"
+)
+
+/*
+Messages of proto/greenlight/v1/greenlight.proto. Field numbers must match the
+.proto file; new fields are added to both.
+*/
+
+const (
+	CatalogueGetMovie     = "/greenlight.v1.Catalogue/GetMovie"
+	CatalogueListMovies   = "/greenlight.v1.Catalogue/ListMovies"
+	CatalogueCreateMovie  = "/greenlight.v1.Catalogue/CreateMovie"
+	CatalogueUpdateMovie  = "/greenlight.v1.Catalogue/UpdateMovie"
+	CatalogueDeleteMovie  = "/greenlight.v1.Catalogue/DeleteMovie"
+	TokensIntrospectToken = "/greenlight.v1.Tokens/IntrospectToken"
+)
+
+type Movie struct {
+	ID             int64
+	Title          string
+	Year           int32
+	RuntimeMinutes int32
+	Genres         []string
+	Version        int32
+}
+
+func (m *Movie) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendInt(b, 1, m.ID)
+	b = protowire.AppendString(b, 2, m.Title)
+	b = protowire.AppendInt(b, 3, int64(m.Year))
+	b = protowire.AppendInt(b, 4, int64(m.RuntimeMinutes))
+	b = protowire.AppendStrings(b, 5, m.Genres)
+	b = protowire.AppendInt(b, 6, int64(m.Version))
+	return b
+}
+
+func (m *Movie) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Int()
+		case 2:
+			m.Title = d.String()
+		case 3:
+			m.Year = int32(d.Int())
+		case 4:
+			m.RuntimeMinutes = int32(d.Int())
+		case 5:
+			m.Genres = append(m.Genres, d.String())
+		case 6:
+			m.Version = int32(d.Int())
+		}
+	}
+	return d.Err()
+}
+
+type GetMovieRequest struct {
+	ID int64
+}
+
+func (m *GetMovieRequest) MarshalProto() []byte {
+	return protowire.AppendInt(nil, 1, m.ID)
+}
+
+func (m *GetMovieRequest) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		if d.Num() == 1 {
+			m.ID = d.Int()
+		}
+	}
+	return d.Err()
+}
+
+type ListMoviesRequest struct {
+	Title    string
+	Genres   []string
+	Page     int32
+	PageSize int32
+	Sort     string
+}
+
+func (m *ListMoviesRequest) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendString(b, 1, m.Title)
+	b = protowire.AppendStrings(b, 2, m.Genres)
+	b = protowire.AppendInt(b, 3, int64(m.Page))
+	b = protowire.AppendInt(b, 4, int64(m.PageSize))
+	b = protowire.AppendString(b, 5, m.Sort)
+	return b
+}
+
+func (m *ListMoviesRequest) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Title = d.String()
+		case 2:
+			m.Genres = append(m.Genres, d.String())
+		case 3:
+			m.Page = int32(d.Int())
+		case 4:
+			m.PageSize = int32(d.Int())
+		case 5:
+			m.Sort = d.String()
+		}
+	}
+	return d.Err()
+}
+
+type Metadata struct {
+	CurrentPage  int32
+	PageSize     int32
+	FirstPage    int32
+	LastPage     int32
+	TotalRecords int32
+}
+
+func (m *Metadata) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendInt(b, 1, int64(m.CurrentPage))
+	b = protowire.AppendInt(b, 2, int64(m.PageSize))
+	b = protowire.AppendInt(b, 3, int64(m.FirstPage))
+	b = protowire.AppendInt(b, 4, int64(m.LastPage))
+	b = protowire.AppendInt(b, 5, int64(m.TotalRecords))
+	return b
+}
+
+func (m *Metadata) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.CurrentPage = int32(d.Int())
+		case 2:
+			m.PageSize = int32(d.Int())
+		case 3:
+			m.FirstPage = int32(d.Int())
+		case 4:
+			m.LastPage = int32(d.Int())
+		case 5:
+			m.TotalRecords = int32(d.Int())
+		}
+	}
+	return d.Err()
+}
+
+type ListMoviesResponse struct {
+	Movies   []*Movie
+	Metadata *Metadata
+}
+
+func (m *ListMoviesResponse) MarshalProto() []byte {
+	var b []byte
+	for _, movie := range m.Movies {
+		b = protowire.AppendMessage(b, 1, movie.MarshalProto())
+	}
+	if m.Metadata != nil {
+		b = protowire.AppendMessage(b, 2, m.Metadata.MarshalProto())
+	}
+	return b
+}
+
+func (m *ListMoviesResponse) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			movie := &Movie{}
+			if err := movie.UnmarshalProto(d.Bytes()); err != nil {
+				return err
+			}
+			m.Movies = append(m.Movies, movie)
+		case 2:
+			m.Metadata = &Metadata{}
+			if err := m.Metadata.UnmarshalProto(d.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Err()
+}
+
+type CreateMovieRequest struct {
+	Movie *Movie
+}
+
+func (m *CreateMovieRequest) MarshalProto() []byte {
+	if m.Movie == nil {
+		return nil
+	}
+	return protowire.AppendMessage(nil, 1, m.Movie.MarshalProto())
+}
+
+func (m *CreateMovieRequest) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		if d.Num() == 1 {
+			m.Movie = &Movie{}
+			if err := m.Movie.UnmarshalProto(d.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Err()
+}
+
+type UpdateMovieRequest struct {
+	ID         int64
+	Movie      *Movie
+	UpdateMask []string
+}
+
+func (m *UpdateMovieRequest) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendInt(b, 1, m.ID)
+	if m.Movie != nil {
+		b = protowire.AppendMessage(b, 2, m.Movie.MarshalProto())
+	}
+	b = protowire.AppendStrings(b, 3, m.UpdateMask)
+	return b
+}
+
+func (m *UpdateMovieRequest) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Int()
+		case 2:
+			m.Movie = &Movie{}
+			if err := m.Movie.UnmarshalProto(d.Bytes()); err != nil {
+				return err
+			}
+		case 3:
+			m.UpdateMask = append(m.UpdateMask, d.String())
+		}
+	}
+	return d.Err()
+}
+
+type DeleteMovieRequest struct {
+	ID      int64
+	Version int32
+}
+
+func (m *DeleteMovieRequest) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendInt(b, 1, m.ID)
+	b = protowire.AppendInt(b, 2, int64(m.Version))
+	return b
+}
+
+func (m *DeleteMovieRequest) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Int()
+		case 2:
+			m.Version = int32(d.Int())
+		}
+	}
+	return d.Err()
+}
+
+type DeleteMovieResponse struct{}
+
+func (m *DeleteMovieResponse) MarshalProto() []byte {
+	return nil
+}
+
+func (m *DeleteMovieResponse) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+	}
+	return d.Err()
+}
+
+type IntrospectTokenRequest struct {
+	Token string
+}
+
+func (m *IntrospectTokenRequest) MarshalProto() []byte {
+	return protowire.AppendString(nil, 1, m.Token)
+}
+
+func (m *IntrospectTokenRequest) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		if d.Num() == 1 {
+			m.Token = d.String()
+		}
+	}
+	return d.Err()
+}
+
+type IntrospectTokenResponse struct {
+	Active      bool
+	UserID      int64
+	Email       string
+	Activated   bool
+	Permissions []string
+}
+
+func (m *IntrospectTokenResponse) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendBool(b, 1, m.Active)
+	b = protowire.AppendInt(b, 2, m.UserID)
+	b = protowire.AppendString(b, 3, m.Email)
+	b = protowire.AppendBool(b, 4, m.Activated)
+	b = protowire.AppendStrings(b, 5, m.Permissions)
+	return b
+}
+
+func (m *IntrospectTokenResponse) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Active = d.Bool()
+		case 2:
+			m.UserID = d.Int()
+		case 3:
+			m.Email = d.String()
+		case 4:
+			m.Activated = d.Bool()
+		case 5:
+			m.Permissions = append(m.Permissions, d.String())
+		}
+	}
+	return d.Err()
+}
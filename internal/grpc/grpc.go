@@ -0,0 +1,266 @@
+package grpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+A unary-only gRPC server on top of net/http's HTTP/2 support. Requests are
+routed on their path, /package.Service/Method, and every response carries
+its status in the grpc-status and grpc-message trailers.
+*/
+
+/* Largest request message accepted, the same default as grpc-go */
+const MaxMessageSize = 4 << 20
+
+type Message interface {
+	MarshalProto() []byte
+	UnmarshalProto(b []byte) error
+}
+
+type UnaryHandler func(ctx context.Context, req Message) (Message, error)
+
+/*
+Interceptors wrap every call in the order they were added, the first one
+outermost, the way middleware wraps the HTTP router.
+*/
+type UnaryInterceptor func(ctx context.Context, info *MethodInfo, req Message, next UnaryHandler) (Message, error)
+
+type MethodInfo struct {
+	/* e.g. /greenlight.v1.Catalogue/GetMovie */
+	FullMethod string
+}
+
+type method struct {
+	newRequest func() Message
+	handler    UnaryHandler
+}
+
+type Server struct {
+	methods      map[string]*method
+	interceptors []UnaryInterceptor
+}
+
+func NewServer(interceptors ...UnaryInterceptor) *Server {
+	return &Server{methods: make(map[string]*method), interceptors: interceptors}
+}
+
+func (s *Server) Handle(fullMethod string, newRequest func() Message, handler UnaryHandler) {
+	if _, exists := s.methods[fullMethod]; exists {
+		panic("grpc: method registered twice: " + fullMethod)
+	}
+
+	s.methods[fullMethod] = &method{newRequest: newRequest, handler: handler}
+}
+
+type metadataKey struct{}
+
+/* Request headers, where gRPC clients send their metadata */
+func IncomingMetadata(ctx context.Context) http.Header {
+	md, _ := ctx.Value(metadataKey{}).(http.Header)
+	return md
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+
+	res, err := s.call(r)
+	if err != nil {
+		/* Nothing written yet, so a "Trailers-Only" response with the status in the headers */
+		setStatus(w.Header(), "", err)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = writeMessage(w, res)
+	setStatus(w.Header(), http.TrailerPrefix, err)
+}
+
+func setStatus(h http.Header, prefix string, err error) {
+	st := Convert(err)
+
+	h.Set(prefix+"Grpc-Status", strconv.Itoa(int(st.Code)))
+	if st.Message != "" {
+		h.Set(prefix+"Grpc-Message", encodeGrpcMessage(st.Message))
+	}
+}
+
+func (s *Server) call(r *http.Request) (Message, error) {
+	m, ok := s.methods[r.URL.Path]
+	if !ok {
+		return nil, Errorf(Unimplemented, "unknown method %s", r.URL.Path)
+	}
+
+	req := m.newRequest()
+
+	err := readMessage(r.Body, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(r.Context(), metadataKey{}, r.Header)
+
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		d, err := parseTimeout(timeout)
+		if err != nil {
+			return nil, Errorf(InvalidArgument, "invalid grpc-timeout %q", timeout)
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	info := &MethodInfo{FullMethod: r.URL.Path}
+
+	handler := m.handler
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.interceptors[i], handler
+		handler = func(ctx context.Context, req Message) (Message, error) {
+			return interceptor(ctx, info, req, next)
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+/* Messages are framed as a compressed flag byte and a 4 byte big-endian length */
+func readMessage(body io.Reader, msg Message) error {
+	var header [5]byte
+
+	_, err := io.ReadFull(body, header[:])
+	if err != nil {
+		return Errorf(InvalidArgument, "reading message: %v", err)
+	}
+
+	if header[0] != 0 {
+		return Errorf(Unimplemented, "compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MaxMessageSize {
+		return Errorf(ResourceExhausted, "message larger than %d bytes", MaxMessageSize)
+	}
+
+	b := make([]byte, length)
+
+	_, err = io.ReadFull(body, b)
+	if err != nil {
+		return Errorf(InvalidArgument, "reading message: %v", err)
+	}
+
+	err = msg.UnmarshalProto(b)
+	if err != nil {
+		return Errorf(InvalidArgument, "decoding message: %v", err)
+	}
+
+	return nil
+}
+
+func writeMessage(w http.ResponseWriter, msg Message) error {
+	b := msg.MarshalProto()
+
+	frame := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	frame = append(frame, b...)
+
+	_, err := w.Write(frame)
+	if err != nil {
+		/* The client has gone, the status won't reach it either */
+		return Errorf(Unavailable, "writing response: %v", err)
+	}
+
+	return nil
+}
+
+/* A positive integer of at most 8 digits followed by a unit, e.g. "100m" for 100ms */
+func parseTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, errors.New("invalid timeout")
+	}
+
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid timeout")
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, errors.New("invalid timeout unit")
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+/* grpc-message is percent-encoded, see the gRPC over HTTP/2 spec */
+func encodeGrpcMessage(msg string) string {
+	return strings.ReplaceAll(url.PathEscape(msg), "%20", " ")
+}
+
+type Code uint32
+
+const (
+	OK                Code = 0
+	Canceled          Code = 1
+	Unknown           Code = 2
+	InvalidArgument   Code = 3
+	DeadlineExceeded  Code = 4
+	NotFound          Code = 5
+	PermissionDenied  Code = 7
+	ResourceExhausted Code = 8
+	Aborted           Code = 10
+	Unimplemented     Code = 12
+	Internal          Code = 13
+	Unavailable       Code = 14
+	Unauthenticated   Code = 16
+)
+
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("grpc: code %d: %s", s.Code, s.Message)
+}
+
+func Errorf(code Code, format string, args ...any) error {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+/* Errors that are not a *Status are reported as Unknown, context errors by their code */
+func Convert(err error) *Status {
+	var st *Status
+
+	switch {
+	case err == nil:
+		return &Status{Code: OK}
+	case errors.As(err, &st):
+		return st
+	case errors.Is(err, context.Canceled):
+		return &Status{Code: Canceled, Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Status{Code: DeadlineExceeded, Message: err.Error()}
+	default:
+		return &Status{Code: Unknown, Message: err.Error()}
+	}
+}
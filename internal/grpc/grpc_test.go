@@ -0,0 +1,306 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/protowire"
+)
+
+/* A message with an id and a name, fields 1 and 2 */
+type testMessage struct {
+	ID   int64
+	Name string
+}
+
+func (m *testMessage) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendInt(b, 1, m.ID)
+	b = protowire.AppendString(b, 2, m.Name)
+	return b
+}
+
+func (m *testMessage) UnmarshalProto(b []byte) error {
+	d := protowire.NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Int()
+		case 2:
+			m.Name = d.String()
+		}
+	}
+	return d.Err()
+}
+
+func frame(compressed byte, b []byte) []byte {
+	f := make([]byte, 5, 5+len(b))
+	f[0] = compressed
+	binary.BigEndian.PutUint32(f[1:], uint32(len(b)))
+	return append(f, b...)
+}
+
+func TestMessageFraming(t *testing.T) {
+	for _, msg := range []*testMessage{{}, {ID: 1}, {ID: -5, Name: "Moana"}, {Name: strings.Repeat("x", 70000)}} {
+		rr := httptest.NewRecorder()
+
+		err := writeMessage(rr, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := rr.Body.Bytes()
+		if !bytes.Equal(body, frame(0, msg.MarshalProto())) {
+			t.Errorf("got frame %x; want the flag, the length and the message", body[:min(len(body), 16)])
+		}
+
+		var got testMessage
+		err = readMessage(bytes.NewReader(body), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != *msg {
+			t.Errorf("got %+v; want %+v", got, *msg)
+		}
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	oversized := make([]byte, 5)
+	binary.BigEndian.PutUint32(oversized[1:], MaxMessageSize+1)
+
+	tests := []struct {
+		name string
+		body []byte
+		code Code
+	}{
+		{"empty", nil, InvalidArgument},
+		{"short header", []byte{0, 0, 0}, InvalidArgument},
+		{"compressed", frame(1, nil), Unimplemented},
+		{"too large", oversized, ResourceExhausted},
+		{"truncated message", frame(0, []byte{0x08, 0x01})[:6], InvalidArgument},
+		{"malformed message", frame(0, []byte{0x08}), InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readMessage(bytes.NewReader(tt.body), &testMessage{})
+			if got := Convert(err).Code; got != tt.code {
+				t.Errorf("got code %d (%v); want %d", got, err, tt.code)
+			}
+		})
+	}
+}
+
+func newTestServer(interceptors ...UnaryInterceptor) *Server {
+	s := NewServer(interceptors...)
+
+	s.Handle("/test.Service/Echo",
+		func() Message { return &testMessage{} },
+		func(ctx context.Context, req Message) (Message, error) {
+			m := req.(*testMessage)
+			if _, ok := ctx.Deadline(); ok {
+				m.Name += " (deadline)"
+			}
+			if v := IncomingMetadata(ctx).Get("X-Test"); v != "" {
+				m.Name += " " + v
+			}
+			return m, nil
+		})
+	s.Handle("/test.Service/Fail",
+		func() Message { return &testMessage{} },
+		func(ctx context.Context, req Message) (Message, error) {
+			return nil, Errorf(NotFound, "movie %d not found", req.(*testMessage).ID)
+		})
+
+	return s
+}
+
+func call(s *Server, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestServerUnaryCall(t *testing.T) {
+	s := newTestServer()
+	req := &testMessage{ID: 42, Name: "echo"}
+
+	rr := call(s, "/test.Service/Echo", frame(0, req.MarshalProto()), map[string]string{"X-Test": "meta", "Grpc-Timeout": "5S"})
+
+	var res testMessage
+	err := readMessage(rr.Body, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != 42 || res.Name != "echo (deadline) meta" {
+		t.Errorf("got %+v; want the request with its deadline and metadata", res)
+	}
+
+	trailers := rr.Result().Trailer
+	if got := trailers.Get("Grpc-Status"); got != "0" {
+		t.Errorf("got grpc-status trailer %q; want 0", got)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/grpc" {
+		t.Errorf("got Content-Type %q; want application/grpc", ct)
+	}
+}
+
+/* Errors before the response message are sent "Trailers-Only", in the headers */
+func TestServerErrors(t *testing.T) {
+	s := newTestServer()
+	valid := frame(0, (&testMessage{ID: 7}).MarshalProto())
+
+	tests := []struct {
+		name    string
+		path    string
+		body    []byte
+		headers map[string]string
+		code    Code
+		message string
+	}{
+		{"handler error", "/test.Service/Fail", valid, nil, NotFound, "movie 7 not found"},
+		{"unknown method", "/test.Service/Nope", valid, nil, Unimplemented, "unknown method /test.Service/Nope"},
+		{"bad timeout", "/test.Service/Echo", valid, map[string]string{"Grpc-Timeout": "soon"}, InvalidArgument, `invalid grpc-timeout "soon"`},
+		{"compressed", "/test.Service/Echo", frame(1, nil), nil, Unimplemented, "compressed messages are not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := call(s, tt.path, tt.body, tt.headers)
+
+			if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+				t.Errorf("got status %d and %d bytes; want 200 and no body", rr.Code, rr.Body.Len())
+			}
+			if got := rr.Header().Get("Grpc-Status"); got != fmt.Sprint(tt.code) {
+				t.Errorf("got grpc-status %q; want %d", got, tt.code)
+			}
+			if got := rr.Header().Get("Grpc-Message"); got != encodeGrpcMessage(tt.message) {
+				t.Errorf("got grpc-message %q; want %q", got, encodeGrpcMessage(tt.message))
+			}
+		})
+	}
+}
+
+func TestServerRejectsNonGRPC(t *testing.T) {
+	s := newTestServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/test.Service/Echo", nil)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	var order []string
+
+	record := func(name string) UnaryInterceptor {
+		return func(ctx context.Context, info *MethodInfo, req Message, next UnaryHandler) (Message, error) {
+			if info.FullMethod != "/test.Service/Echo" {
+				t.Errorf("got method %q", info.FullMethod)
+			}
+			order = append(order, name+" in")
+			res, err := next(ctx, req)
+			order = append(order, name+" out")
+			return res, err
+		}
+	}
+
+	s := newTestServer(record("first"), record("second"))
+	call(s, "/test.Service/Echo", frame(0, nil), nil)
+
+	want := []string{"first in", "second in", "second out", "first out"}
+	if !slices.Equal(order, want) {
+		t.Errorf("got %v; want %v", order, want)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+		ok   bool
+	}{
+		{"1H", time.Hour, true},
+		{"2M", 2 * time.Minute, true},
+		{"3S", 3 * time.Second, true},
+		{"100m", 100 * time.Millisecond, true},
+		{"5u", 5 * time.Microsecond, true},
+		{"99999999n", 99999999 * time.Nanosecond, true},
+		{"0S", 0, true},
+		{"", 0, false},
+		{"S", 0, false},
+		{"100", 0, false},
+		{"123456789S", 0, false},
+		{"-1S", 0, false},
+		{"1x", 0, false},
+		{"1.5S", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseTimeout(tt.s)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("got %v, %v; want %v and ok %t", got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		err  error
+		code Code
+	}{
+		{nil, OK},
+		{Errorf(NotFound, "gone"), NotFound},
+		{fmt.Errorf("wrapped: %w", Errorf(Aborted, "conflict")), Aborted},
+		{context.Canceled, Canceled},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), DeadlineExceeded},
+		{errors.New("boom"), Unknown},
+		{io.ErrUnexpectedEOF, Unknown},
+	}
+
+	for _, tt := range tests {
+		if got := Convert(tt.err).Code; got != tt.code {
+			t.Errorf("Convert(%v) = %d; want %d", tt.err, got, tt.code)
+		}
+	}
+}
+
+func TestEncodeGrpcMessage(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{"movie 7 not found", "movie 7 not found"},
+		{"100% done", "100%25 done"},
+		{"line\nbreak", "line%0Abreak"},
+		{"åäö", "%C3%A5%C3%A4%C3%B6"},
+	}
+
+	for _, tt := range tests {
+		if got := encodeGrpcMessage(tt.msg); got != tt.want {
+			t.Errorf("encodeGrpcMessage(%q) = %q; want %q", tt.msg, got, tt.want)
+		}
+	}
+}
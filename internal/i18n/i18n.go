@@ -0,0 +1,110 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//go:embed "locales"
+var localesFS embed.FS
+
+/* Used when the client accepts none of the available locales */
+const DefaultLocale = "en"
+
+/* locale -> message key -> message (a fmt format string) */
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	entries, err := localesFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]map[string]string, len(entries))
+
+	for _, entry := range entries {
+		content, err := localesFS.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		var messages map[string]string
+		err = json.Unmarshal(content, &messages)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalogue %s: %s", entry.Name(), err))
+		}
+
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+
+	return catalogs
+}
+
+/* Returns the available locales, sorted */
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+
+	return locales
+}
+
+/*
+Translate formats the message for key in locale with args, falling back to the
+default locale and finally to the key itself so a missing translation is visible
+but never fatal.
+*/
+func Translate(locale, key string, args ...any) string {
+	message, ok := catalogs[locale][key]
+	if !ok {
+		message, ok = catalogs[DefaultLocale][key]
+		if !ok {
+			message = key
+		}
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+
+	return fmt.Sprintf(message, args...)
+}
+
+/*
+MatchLocale picks the best available locale for an Accept-Language header value,
+e.g. "sv-SE,sv;q=0.9,en;q=0.8". Region subtags fall back to their language.
+*/
+func MatchLocale(acceptLanguage string) string {
+	best, bestQ := DefaultLocale, 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+
+		if q <= bestQ {
+			continue
+		}
+
+		language, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := catalogs[language]; ok {
+			best, bestQ = language, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,270 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Returned (wrapped) when a "test" operation doesn't match the document
+	ErrTestFailed = errors.New("test operation failed")
+	// Returned (wrapped) for malformed operations or paths that can't be applied
+	ErrInvalidPatch = errors.New("invalid patch")
+)
+
+/* A single RFC 6902 operation */
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+/*
+Apply runs the operations in order against the JSON document doc and returns the
+patched document. It is all or nothing: an error leaves no partial result.
+*/
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	var root any
+
+	err := json.Unmarshal(doc, &root)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		root, err = apply(root, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func apply(root any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+		}
+
+		var value any
+		err = json.Unmarshal(op.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			root, err = remove(root, path)
+			if err != nil {
+				return nil, err
+			}
+			return add(root, path, value)
+		default:
+			current, err := get(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, ErrTestFailed
+			}
+			return root, nil
+		}
+
+	case "remove":
+		return remove(root, path)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalidPatch)
+			}
+
+			root, err = remove(root, from)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return add(root, path, value)
+
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+/* Splits a JSON Pointer (RFC 6901) into its unescaped reference tokens */
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			value, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q not found", ErrInvalidPatch, token)
+			}
+			node = value
+
+		case []any:
+			i, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+
+		default:
+			return nil, fmt.Errorf("%w: cannot traverse into %q", ErrInvalidPatch, token)
+		}
+	}
+
+	return node, nil
+}
+
+/* Returns the (possibly new) root after adding value at path */
+func add(root any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+		return root, nil
+
+	case []any:
+		i := len(p)
+		if last != "-" {
+			i, err = arrayIndex(last, len(p))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = value
+
+		/* The slice header changed, so store it back in its parent */
+		return setChild(root, path[:len(path)-1], p)
+
+	default:
+		return nil, fmt.Errorf("%w: cannot add to %q", ErrInvalidPatch, last)
+	}
+}
+
+func remove(root any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+
+	switch p := parent.(type) {
+	case map[string]any:
+		if _, ok := p[last]; !ok {
+			return nil, fmt.Errorf("%w: member %q not found", ErrInvalidPatch, last)
+		}
+		delete(p, last)
+		return root, nil
+
+	case []any:
+		i, err := arrayIndex(last, len(p)-1)
+		if err != nil {
+			return nil, err
+		}
+
+		p = append(p[:i], p[i+1:]...)
+		return setChild(root, path[:len(path)-1], p)
+
+	default:
+		return nil, fmt.Errorf("%w: cannot remove %q", ErrInvalidPatch, last)
+	}
+}
+
+/* Replaces the value at path with child, used after an array was resized */
+func setChild(root any, path []string, child any) (any, error) {
+	if len(path) == 0 {
+		return child, nil
+	}
+
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = child
+	case []any:
+		i, err := arrayIndex(last, len(p)-1)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = child
+	}
+
+	return root, nil
+}
+
+/* Parses an array index token, which must be within [0, max] */
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+
+	return i, nil
+}
@@ -0,0 +1,234 @@
+/*
+Package jwt signs and verifies JSON Web Tokens (RFC 7519) in the JWS compact
+serialization, with HS256 or RS256. Only what the API needs: no encrypted
+tokens, no key sets and no other algorithms.
+*/
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token has expired")
+	ErrInvalidClaims    = errors.New("jwt: invalid issuer, audience or validity period")
+)
+
+/* Leeway for clocks that are slightly off when checking exp and nbf */
+const leeway = 30 * time.Second
+
+/* The registered claims the API uses, plus a few of its own about the user */
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+
+	Name      string `json:"name,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Activated bool   `json:"activated"`
+	/* The ID of the user's tenant, tokens are only valid with it */
+	Tenant int64 `json:"tenant,omitempty"`
+}
+
+/* "aud" is either a string or an array of strings, always written as a string here */
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+/* Signs and verifies tokens with one key, tokens of any other algorithm are rejected */
+type Codec struct {
+	alg     string
+	secret  []byte
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+}
+
+/* A secret shorter than the hash output would weaken HS256 */
+func NewHS256(secret []byte) (*Codec, error) {
+	if len(secret) < sha256.Size {
+		return nil, fmt.Errorf("jwt: HS256 secret must be at least %d bytes", sha256.Size)
+	}
+
+	return &Codec{alg: "HS256", secret: secret}, nil
+}
+
+/* Takes a PEM encoded RSA private key, PKCS #1 or PKCS #8 */
+func NewRS256(privateKeyPEM []byte) (*Codec, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("jwt: no PEM encoded key found")
+	}
+
+	var key *rsa.PrivateKey
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwt: RS256 key must be an RSA key")
+		}
+		key = rsaKey
+	default:
+		return nil, fmt.Errorf("jwt: unsupported PEM block %q", block.Type)
+	}
+
+	if key.N.BitLen() < 2048 {
+		return nil, errors.New("jwt: RS256 key must be at least 2048 bits")
+	}
+
+	return &Codec{alg: "RS256", private: key, public: &key.PublicKey}, nil
+}
+
+/* Tells JWTs from the API's opaque tokens, which have no dots */
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (c *Codec) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Alg: c.alg, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(h) + "." + encode(p)
+
+	signature, err := c.sign(signingInput)
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encode(signature), nil
+}
+
+/*
+Checks the signature, then that the token is valid at now and was issued by
+issuer for audience. The claims are only returned for a valid token.
+*/
+func (c *Codec) Verify(token, issuer, audience string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+
+	/* The algorithm is the codec's, never the token's (think "alg": "none") */
+	if h.Alg != c.alg {
+		return nil, ErrInvalidSignature
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if !c.verify(parts[0]+"."+parts[1], signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return nil, ErrExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrInvalidClaims
+	}
+
+	if claims.Issuer != issuer || !slices.Contains(claims.Audience, audience) {
+		return nil, ErrInvalidClaims
+	}
+
+	return &claims, nil
+}
+
+func (c *Codec) sign(signingInput string) ([]byte, error) {
+	if c.alg == "HS256" {
+		mac := hmac.New(sha256.New, c.secret)
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil), nil
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	return rsa.SignPKCS1v15(nil, c.private, crypto.SHA256, digest[:])
+}
+
+func (c *Codec) verify(signingInput string, signature []byte) bool {
+	if c.alg == "HS256" {
+		expected, _ := c.sign(signingInput)
+		return hmac.Equal(signature, expected)
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	return rsa.VerifyPKCS1v15(c.public, crypto.SHA256, digest[:], signature) == nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJSON(part string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
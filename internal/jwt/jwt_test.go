@@ -0,0 +1,315 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "greenlight"
+	testAudience = "greenlight-api"
+)
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func testClaims() Claims {
+	return Claims{
+		Subject:   "42",
+		Issuer:    testIssuer,
+		Audience:  Audience{testAudience},
+		ExpiresAt: testNow.Add(time.Hour).Unix(),
+		IssuedAt:  testNow.Unix(),
+		Email:     "alice@example.com",
+		Activated: true,
+		Tenant:    7,
+	}
+}
+
+func newHS256(t *testing.T) *Codec {
+	t.Helper()
+
+	c, err := NewHS256([]byte(strings.Repeat("k", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newRS256(t *testing.T) (*Codec, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	c, err := NewRS256(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, key
+}
+
+/* A token of the given header and claims, signed with HMAC-SHA256 under secret */
+func forge(t *testing.T, h header, claims Claims, secret []byte) string {
+	t.Helper()
+
+	hb, _ := json.Marshal(h)
+	cb, _ := json.Marshal(claims)
+	signingInput := encode(hb) + "." + encode(cb)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+
+	return signingInput + "." + encode(mac.Sum(nil))
+}
+
+func TestRoundTrip(t *testing.T) {
+	rs, _ := newRS256(t)
+
+	for name, c := range map[string]*Codec{"HS256": newHS256(t), "RS256": rs} {
+		t.Run(name, func(t *testing.T) {
+			want := testClaims()
+
+			token, err := c.Sign(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !LooksLikeJWT(token) {
+				t.Errorf("token %q doesn't look like a JWT", token)
+			}
+
+			got, err := c.Verify(token, testIssuer, testAudience, testNow)
+			if err != nil {
+				t.Fatalf("got error %v; want nil", err)
+			}
+
+			if got.Subject != want.Subject || got.Email != want.Email || got.Tenant != want.Tenant || !got.Activated {
+				t.Errorf("got claims %+v; want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewHS256ShortSecret(t *testing.T) {
+	_, err := NewHS256([]byte("too short"))
+	if err == nil {
+		t.Error("got nil error for a short secret")
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	hs := newHS256(t)
+	rs, key := newRS256(t)
+	publicKey := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+
+	claims := testClaims()
+	hsToken, err := hs.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := func(alg string) string {
+		hb, _ := json.Marshal(header{Alg: alg, Typ: "JWT"})
+		cb, _ := json.Marshal(claims)
+		return encode(hb) + "." + encode(cb) + "."
+	}
+
+	tests := []struct {
+		name  string
+		codec *Codec
+		token string
+	}{
+		{"none to HS256", hs, unsigned("none")},
+		{"none to RS256", rs, unsigned("none")},
+		{"lowercase none", hs, unsigned("None")},
+		{"empty alg", hs, unsigned("")},
+		/* The classic attack: HMAC with the RSA public key as the secret */
+		{"HS256 with the public key to RS256", rs, forge(t, header{Alg: "HS256", Typ: "JWT"}, claims, publicKey)},
+		{"HS256 token to RS256", rs, hsToken},
+		{"HS512 to HS256", hs, forge(t, header{Alg: "HS512", Typ: "JWT"}, claims, []byte(strings.Repeat("k", 32)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.codec.Verify(tt.token, testIssuer, testAudience, testNow)
+			if !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("got error %v; want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestTampered(t *testing.T) {
+	c := newHS256(t)
+
+	token, err := c.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	other := testClaims()
+	other.Subject = "1"
+	otherPayload, _ := json.Marshal(other)
+
+	wrongKey, err := NewHS256([]byte(strings.Repeat("x", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKeyToken, err := wrongKey.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := []byte(parts[2])
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"payload swapped", parts[0] + "." + encode(otherPayload) + "." + parts[2], ErrInvalidSignature},
+		{"signature changed", parts[0] + "." + parts[1] + "." + string(flipped), ErrInvalidSignature},
+		{"signature removed", parts[0] + "." + parts[1] + ".", ErrInvalidSignature},
+		{"signed with another key", wrongKeyToken, ErrInvalidSignature},
+		{"signature not base64", parts[0] + "." + parts[1] + ".!!!", ErrMalformed},
+		{"header not JSON", encode([]byte("{")) + "." + parts[1] + "." + parts[2], ErrMalformed},
+		{"two parts", parts[0] + "." + parts[1], ErrMalformed},
+		{"four parts", token + ".x", ErrMalformed},
+		{"empty", "", ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.Verify(tt.token, testIssuer, testAudience, testNow)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidityPeriod(t *testing.T) {
+	c := newHS256(t)
+
+	exp := testNow.Add(time.Hour)
+	nbf := testNow.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		exp  int64
+		nbf  int64
+		now  time.Time
+		want error
+	}{
+		{"valid", exp.Unix(), nbf.Unix(), testNow, nil},
+		{"no nbf", exp.Unix(), 0, testNow, nil},
+		{"no exp", 0, 0, testNow, ErrExpired},
+		{"at exp", exp.Unix(), 0, exp, nil},
+		{"expired within leeway", exp.Unix(), 0, exp.Add(leeway), nil},
+		{"expired past leeway", exp.Unix(), 0, exp.Add(leeway + time.Second), ErrExpired},
+		{"at nbf", exp.Unix(), nbf.Unix(), nbf, nil},
+		{"before nbf within leeway", exp.Unix(), nbf.Unix(), nbf.Add(-leeway), nil},
+		{"before nbf past leeway", exp.Unix(), nbf.Unix(), nbf.Add(-leeway - time.Second), ErrInvalidClaims},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims()
+			claims.ExpiresAt = tt.exp
+			claims.NotBefore = tt.nbf
+
+			token, err := c.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.Verify(token, testIssuer, testAudience, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssuerAudience(t *testing.T) {
+	c := newHS256(t)
+
+	tests := []struct {
+		name     string
+		issuer   string
+		audience Audience
+		want     error
+	}{
+		{"match", testIssuer, Audience{testAudience}, nil},
+		{"one of several audiences", testIssuer, Audience{"other", testAudience}, nil},
+		{"wrong issuer", "someone-else", Audience{testAudience}, ErrInvalidClaims},
+		{"empty issuer", "", Audience{testAudience}, ErrInvalidClaims},
+		{"wrong audience", testIssuer, Audience{"other"}, ErrInvalidClaims},
+		{"no audience", testIssuer, nil, ErrInvalidClaims},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims()
+			claims.Issuer = tt.issuer
+			claims.Audience = tt.audience
+
+			token, err := c.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.Verify(token, testIssuer, testAudience, testNow)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudienceJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want Audience
+	}{
+		{`"api"`, Audience{"api"}},
+		{`["api","web"]`, Audience{"api", "web"}},
+	}
+
+	for _, tt := range tests {
+		var got Audience
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("got %q; want %q", got, tt.want)
+		}
+
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.json {
+			t.Errorf("got %s; want %s", b, tt.json)
+		}
+	}
+}
@@ -2,8 +2,13 @@ package mailer
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"embed"
+	"fmt"
 	"html/template"
+	"net"
+	"net/textproto"
 	"time"
 
 	"github.com/go-mail/mail"
@@ -58,16 +63,43 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
 
-	for range 3 {
-		/* returns "dial tcp: i/o timeout" if there's a timeout */
-		err = m.dialer.DialAndSend(msg)
-		if nil == err {
-			return nil
-		}
+	/* returns "dial tcp: i/o timeout" if there's a timeout */
+	/* INFO: No retries here, the job queue retries a failed email with backoff */
+	return m.dialer.DialAndSend(msg)
+}
+
+/*
+Checks that the SMTP server accepts connections by waiting for its 220
+greeting, without authenticating or sending anything.
+*/
+func (m Mailer) Ping(ctx context.Context) error {
+	addr := net.JoinHostPort(m.dialer.Host, fmt.Sprint(m.dialer.Port))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
 
-		/* If sending didn't work retry after sleeping */
-		time.Sleep(500 * time.Millisecond)
+	/* Port 465 speaks TLS from the start, the greeting comes after the handshake */
+	if m.dialer.SSL {
+		conn = tls.Client(conn, &tls.Config{ServerName: m.dialer.Host})
 	}
 
+	text := textproto.NewConn(conn)
+
+	_, _, err = text.ReadResponse(220)
+	if err != nil {
+		return err
+	}
+
+	/* Be polite, the answer doesn't matter */
+	text.PrintfLine("QUIT")
+
 	return nil
 }
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+)
+
+/* Counters partitioned by label values, e.g. requests by route and status */
+type CounterVec struct {
+	desc
+
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{metricName: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+		labels: make(map[string][]string),
+	}
+	r.register(c)
+	return c
+}
+
+/* Adds delta, which must not be negative, to the series of the label values */
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if len(labelValues) != len(c.desc.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values", c.metricName, len(c.desc.labels)))
+	}
+
+	k := key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.labels[k]; !ok {
+		c.labels[k] = labelValues
+	}
+	c.values[k] += delta
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelSet(c.labels[k]), formatFloat(c.values[k]))
+	}
+}
+
+/* A counter or gauge read from elsewhere when scraped, e.g. sql.DBStats */
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{metricName: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{metricName: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", f.metricName, formatFloat(f.fn()))
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+/* A value that goes up and down, e.g. requests in flight */
+type Gauge struct {
+	desc
+	bits atomic.Uint64
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{metricName: name, help: help, kind: "gauge"}}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.Value()))
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+)
+
+/* Observations counted in buckets, partitioned by label values like CounterVec */
+type HistogramVec struct {
+	desc
+	/* Upper bounds, ascending, +Inf is implied */
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	labels []string
+	/* counts[i] is the number of observations <= buckets[i], not cumulative yet */
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	h := &HistogramVec{
+		desc:    desc{metricName: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.desc.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values", h.metricName, len(h.desc.labels)))
+	}
+
+	k := key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+
+	/* Values above the last bound only count towards +Inf, i.e. count */
+	if i, _ := slices.BinarySearch(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelSet(s.labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelSet(s.labels, "le", formatFloat(math.Inf(1))), s.count)
+
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labelSet(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labelSet(s.labels), s.count)
+	}
+}
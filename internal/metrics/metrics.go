@@ -0,0 +1,136 @@
+/*
+Package metrics keeps counters, gauges and histograms and writes them in the
+Prometheus text exposition format, see
+https://prometheus.io/docs/instrumenting/exposition_formats/
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Buckets of request durations in seconds, the Prometheus client defaults */
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+/* A metric family, writes its HELP, TYPE and samples */
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+/* Panics on a name registered twice, like expvar, it is a programming error */
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[m.name()] {
+		panic("metrics: duplicate metric " + m.name())
+	}
+
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+/* Writes every metric, in the order they were registered */
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d desc) name() string {
+	return d.metricName
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, helpEscaper.Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.kind)
+}
+
+/* Formats {a="x",b="y"}, with extra appended as a last label if not empty */
+func (d desc) labelSet(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+
+	for i, label := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, label, labelEscaper.Replace(values[i]))
+	}
+
+	if len(extra) == 2 {
+		if len(d.labels) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[0], labelEscaper.Replace(extra[1]))
+	}
+
+	b.WriteByte('}')
+	return b.String()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+/* The only escapes the format has in label values */
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+/* Series keys in a stable order, so scrapes are easy to diff */
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
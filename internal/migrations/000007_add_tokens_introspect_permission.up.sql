@@ -0,0 +1,4 @@
+-- Lets internal services introspect authentication tokens over gRPC
+INSERT INTO permissions (code)
+VALUES
+    ('tokens:introspect');
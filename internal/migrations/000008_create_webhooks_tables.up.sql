@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    url text NOT NULL,
+    event_types text[] NOT NULL,
+    secret text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    -- pending, succeeded or failed
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_attempt_at timestamp(0) with time zone,
+    response_status integer,
+    last_error text
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id DESC);
+
+INSERT INTO permissions (code)
+VALUES
+    ('webhooks:manage');
@@ -0,0 +1,4 @@
+-- Let cursor pagination seek on (sort column, id) instead of sorting the table
+CREATE INDEX IF NOT EXISTS movies_title_id_idx ON movies (title, id);
+CREATE INDEX IF NOT EXISTS movies_year_id_idx ON movies (year, id);
+CREATE INDEX IF NOT EXISTS movies_runtime_id_idx ON movies (runtime, id);
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple', title));
+DROP INDEX IF EXISTS movies_title_search_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS title_search;
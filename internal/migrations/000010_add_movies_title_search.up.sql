@@ -0,0 +1,7 @@
+-- English stemming so "knights" finds "Knight", kept up to date by Postgres
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS title_search tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', title)) STORED;
+
+-- Serves title_search @@ to_tsquery(...), the old index on the simple config is unused now
+CREATE INDEX IF NOT EXISTS movies_title_search_idx ON movies USING GIN (title_search);
+DROP INDEX IF EXISTS movies_title_idx;
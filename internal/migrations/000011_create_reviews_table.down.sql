@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS reviews;
+DROP FUNCTION IF EXISTS update_movie_average_rating();
+ALTER TABLE movies DROP COLUMN IF EXISTS average_rating;
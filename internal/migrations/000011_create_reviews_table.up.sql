@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    -- One review per user and movie
+    UNIQUE (movie_id, user_id)
+);
+
+-- NULL until the movie has a review
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating numeric(3, 2);
+
+-- Keeps movies.average_rating in sync whatever writes to reviews
+CREATE OR REPLACE FUNCTION update_movie_average_rating() RETURNS trigger AS $$
+DECLARE
+    review reviews;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        review := OLD;
+    ELSE
+        review := NEW;
+    END IF;
+
+    UPDATE movies
+    SET average_rating = (SELECT avg(rating) FROM reviews WHERE movie_id = review.movie_id)
+    WHERE id = review.movie_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reviews_update_movie_average_rating
+AFTER INSERT OR DELETE OR UPDATE OF rating ON reviews
+FOR EACH ROW EXECUTE FUNCTION update_movie_average_rating();
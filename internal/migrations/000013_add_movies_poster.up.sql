@@ -0,0 +1,3 @@
+-- The storage key, to delete the file on replacement, and its public URL
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_key text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_url text NOT NULL DEFAULT '';
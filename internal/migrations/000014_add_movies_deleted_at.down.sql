@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'movies:admin';
+-- Without the column deleted movies would be back in the catalogue
+DELETE FROM movies WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS movies_deleted_at_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,10 @@
+-- Deleted movies stay in the table until purged, NULL for movies in the catalogue
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- Serves the trash, which is small next to the catalogue
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Lets admins list, restore and purge deleted movies
+INSERT INTO permissions (code)
+VALUES
+    ('movies:admin');
@@ -0,0 +1,15 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS genres text[] NOT NULL DEFAULT '{}';
+
+UPDATE movies SET genres = ARRAY(
+    SELECT genres.name
+    FROM movies_genres
+    INNER JOIN genres ON genres.id = movies_genres.genre_id
+    WHERE movies_genres.movie_id = movies.id
+    ORDER BY movies_genres.position);
+
+ALTER TABLE movies ALTER COLUMN genres DROP DEFAULT;
+ALTER TABLE movies ADD CONSTRAINT movies_length_check CHECK (array_length(genres, 1) BETWEEN 1 AND 5);
+CREATE INDEX IF NOT EXISTS movies_genres_idx ON movies USING GIN (genres);
+
+DROP TABLE IF EXISTS movies_genres;
+DROP TABLE IF EXISTS genres;
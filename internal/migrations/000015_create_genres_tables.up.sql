@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE
+);
+
+-- position keeps a movie's genres in the order they were given
+CREATE TABLE IF NOT EXISTS movies_genres (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+-- Serves the movies of a genre, the primary key serves the genres of a movie
+CREATE INDEX IF NOT EXISTS movies_genres_genre_id_idx ON movies_genres (genre_id);
+
+INSERT INTO genres (name)
+SELECT DISTINCT unnest(genres) FROM movies
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO movies_genres (movie_id, genre_id, position)
+SELECT movies.id, genres.id, given.position
+FROM movies
+CROSS JOIN unnest(movies.genres) WITH ORDINALITY AS given(name, position)
+INNER JOIN genres ON genres.name = given.name
+ON CONFLICT (movie_id, genre_id) DO NOTHING;
+
+DROP INDEX IF EXISTS movies_genres_idx;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_length_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS genres;
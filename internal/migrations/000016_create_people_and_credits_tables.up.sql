@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Serves ?director= and ?actor=, which compare names case-insensitively
+CREATE INDEX IF NOT EXISTS people_name_idx ON people (lower(name));
+
+-- A person can direct and act in the same movie, position orders the credits of a movie
+CREATE TABLE IF NOT EXISTS credits (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('director', 'actor')),
+    character text NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (movie_id, person_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS credits_person_id_idx ON credits (person_id);
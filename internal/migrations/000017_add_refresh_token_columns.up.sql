@@ -0,0 +1,6 @@
+-- Refresh tokens live in tokens with the refresh scope. Every token rotated from
+-- the same login shares a family, used_at marks the ones already exchanged.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bytea;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family) WHERE family IS NOT NULL;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    -- The start of the key, shown so users can tell their keys apart
+    prefix text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    -- Permission codes the key is limited to
+    scopes text[] NOT NULL,
+    expires_at timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
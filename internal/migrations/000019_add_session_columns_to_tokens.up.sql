@@ -0,0 +1,9 @@
+-- Authentication tokens are listed as sessions, by id, with when and from where
+-- they were last used
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS id bigserial UNIQUE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_ip text;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS last_used_user_agent text;
+
+CREATE INDEX IF NOT EXISTS tokens_user_id_scope_idx ON tokens (user_id, scope);
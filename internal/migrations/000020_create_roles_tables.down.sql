@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+-- Users keep the permissions of their roles
+INSERT INTO users_permissions
+SELECT DISTINCT users_roles.user_id, roles_permissions.permission_id
+FROM users_roles
+INNER JOIN roles_permissions ON roles_permissions.role_id = users_roles.role_id;
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,56 @@
+-- Users get permissions through roles rather than one by one
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    description text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS users_roles_role_id_idx ON users_roles (role_id);
+
+INSERT INTO roles (name, description)
+VALUES
+    ('viewer', 'Reads the catalogue'),
+    ('editor', 'Reads and edits the catalogue'),
+    ('curator', 'Manages the trash of deleted movies'),
+    ('integrator', 'Manages webhooks'),
+    ('service', 'Internal services introspecting tokens'),
+    ('admin', 'Every permission, and assigns roles to users');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles
+INNER JOIN permissions ON (roles.name, permissions.code) IN (
+    ('viewer', 'movies:read'),
+    ('editor', 'movies:read'),
+    ('editor', 'movies:write'),
+    ('curator', 'movies:admin'),
+    ('integrator', 'webhooks:manage'),
+    ('service', 'tokens:introspect')
+) OR roles.name = 'admin';
+
+-- Every permission a user had becomes the role granting it, editors also gain movies:read
+INSERT INTO users_roles
+SELECT DISTINCT users_permissions.user_id, roles.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+INNER JOIN roles ON (roles.name, permissions.code) IN (
+    ('viewer', 'movies:read'),
+    ('editor', 'movies:write'),
+    ('curator', 'movies:admin'),
+    ('integrator', 'webhooks:manage'),
+    ('service', 'tokens:introspect')
+);
+
+DROP TABLE IF EXISTS users_permissions;
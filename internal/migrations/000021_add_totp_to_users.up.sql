@@ -0,0 +1,12 @@
+-- The TOTP secret is encrypted with -totp-key, it is set but not enabled until a
+-- first code is verified. last_step refuses codes that were already used.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret bytea;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled bool NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+
+-- Single use codes for when the authenticator is lost
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
@@ -0,0 +1,11 @@
+-- Accounts at OAuth providers linked to users, subject is the provider's ID of the account
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider text NOT NULL,
+    subject text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    email citext NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
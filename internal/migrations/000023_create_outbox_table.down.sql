@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS webhook_deliveries_outbox_key_idx;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS outbox_key;
+
+DROP TABLE IF EXISTS outbox;
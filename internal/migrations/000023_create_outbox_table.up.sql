@@ -0,0 +1,22 @@
+-- Emails and webhook events written in the same transaction as the change they
+-- are about, and delivered from there by the outbox dispatcher
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- email or webhook
+    kind text NOT NULL,
+    -- Writing the same entry twice keeps the first one
+    idempotency_key text NOT NULL UNIQUE,
+    payload jsonb NOT NULL,
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- pending, delivered or dead
+    status text NOT NULL DEFAULT 'pending',
+    last_error text
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at) WHERE status = 'pending';
+
+-- A webhook event of the outbox delivered again after a crash queues no second delivery
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS outbox_key text;
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_outbox_key_idx ON webhook_deliveries (webhook_id, outbox_key);
@@ -0,0 +1,20 @@
+-- Mutating requests. user_id has no foreign key, the record of a deleted user is kept
+CREATE TABLE IF NOT EXISTS audit_log (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint,
+    method text NOT NULL,
+    route text NOT NULL,
+    path text NOT NULL,
+    resource_type text NOT NULL,
+    resource_id text,
+    status integer NOT NULL,
+    ip text NOT NULL,
+    request_id text NOT NULL,
+    -- {"field": {"before": ..., "after": ...}} for the handlers that record a change
+    changes jsonb
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_log_user_id_idx ON audit_log (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_log_resource_type_idx ON audit_log (resource_type, created_at DESC);
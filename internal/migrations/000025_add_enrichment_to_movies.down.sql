@@ -0,0 +1,5 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS enriched_at;
+ALTER TABLE movies DROP COLUMN IF EXISTS top_cast;
+ALTER TABLE movies DROP COLUMN IF EXISTS imdb_rating;
+ALTER TABLE movies DROP COLUMN IF EXISTS imdb_id;
+ALTER TABLE movies DROP COLUMN IF EXISTS plot;
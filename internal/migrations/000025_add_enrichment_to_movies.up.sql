@@ -0,0 +1,6 @@
+-- Looked up at the -enrich-provider, see SetEnrichment. top_cast because cast is a keyword
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS plot text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_id text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS imdb_rating numeric(3, 1);
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS top_cast text[] NOT NULL DEFAULT '{}';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS enriched_at timestamp(0) with time zone;
@@ -0,0 +1,18 @@
+-- Movie searches users are alerted about, see SavedSearchModel.Matching.
+-- title_query is the tsquery of title, empty when there is no title to search
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    title text NOT NULL DEFAULT '',
+    title_query text NOT NULL DEFAULT '',
+    genres text[] NOT NULL DEFAULT '{}',
+    year_gte integer,
+    year_lte integer,
+    notify_email bool NOT NULL DEFAULT true,
+    webhook_url text NOT NULL DEFAULT '',
+    -- Signs the webhook's requests like those of the webhooks table
+    webhook_secret text NOT NULL DEFAULT '',
+    UNIQUE (user_id, name)
+);
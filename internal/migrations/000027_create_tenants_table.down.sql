@@ -0,0 +1,9 @@
+ALTER TABLE user_identities DROP CONSTRAINT IF EXISTS user_identities_pkey;
+ALTER TABLE user_identities ADD PRIMARY KEY (provider, subject);
+ALTER TABLE user_identities DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_tenant_id_email_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS movies_tenant_id_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS tenant_id;
+DROP TABLE IF EXISTS tenants;
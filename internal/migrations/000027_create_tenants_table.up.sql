@@ -0,0 +1,26 @@
+-- The isolated catalogues a deployment serves, see TenantModel. Movies and users
+-- made before tenancy belong to the default tenant, genres, people and roles stay shared
+CREATE TABLE IF NOT EXISTS tenants (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug text UNIQUE NOT NULL,
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'Default') ON CONFLICT DO NOTHING;
+SELECT setval('tenants_id_seq', (SELECT max(id) FROM tenants));
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants;
+CREATE INDEX IF NOT EXISTS movies_tenant_id_idx ON movies (tenant_id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants;
+
+-- The same email can sign up with every tenant
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_tenant_id_email_key UNIQUE (tenant_id, email);
+
+-- An OAuth account can be linked to a user of every tenant
+ALTER TABLE user_identities ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants;
+ALTER TABLE user_identities DROP CONSTRAINT IF EXISTS user_identities_pkey;
+ALTER TABLE user_identities ADD PRIMARY KEY (tenant_id, provider, subject);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS tenant_usage;
+DROP TABLE IF EXISTS usage;
+ALTER TABLE tenants DROP COLUMN IF EXISTS monthly_quota;
+ALTER TABLE users DROP COLUMN IF EXISTS monthly_quota;
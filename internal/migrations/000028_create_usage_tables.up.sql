@@ -0,0 +1,20 @@
+-- Monthly request quotas of users and tenants, NULL for the -quota-* defaults
+ALTER TABLE users ADD COLUMN IF NOT EXISTS monthly_quota bigint;
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS monthly_quota bigint;
+
+-- Requests counted by enforceQuota for each month, api_key_id is 0 for those
+-- made with tokens. Not a foreign key, a revoked key's requests still count
+CREATE TABLE IF NOT EXISTS usage (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    api_key_id bigint NOT NULL DEFAULT 0,
+    month date NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, month, api_key_id)
+);
+
+CREATE TABLE IF NOT EXISTS tenant_usage (
+    tenant_id bigint NOT NULL REFERENCES tenants ON DELETE CASCADE,
+    month date NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, month)
+);
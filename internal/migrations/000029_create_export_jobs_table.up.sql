@@ -0,0 +1,22 @@
+-- Exports run in the background by POST /v1/movies/export-jobs. search holds
+-- the data.MovieSearch of the filters, file_key the result in the storage
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    tenant_id bigint NOT NULL REFERENCES tenants ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    format text NOT NULL,
+    search jsonb NOT NULL,
+    sort text NOT NULL,
+    status text NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    rows_total bigint,
+    rows_done bigint NOT NULL DEFAULT 0,
+    file_key text NOT NULL DEFAULT '',
+    file_url text NOT NULL DEFAULT '',
+    file_size bigint NOT NULL DEFAULT 0,
+    error text NOT NULL DEFAULT '',
+    completed_at timestamp(0) with time zone,
+    expires_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS export_jobs_expires_at_idx ON export_jobs (expires_at);
@@ -0,0 +1,12 @@
+-- Every update of a movie's title, year, runtime and genres, written by
+-- MovieModel.Update. version is the one the update gave the movie
+CREATE TABLE IF NOT EXISTS movies_history (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    edited_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- NULL once the editor is deleted
+    editor_id bigint REFERENCES users ON DELETE SET NULL,
+    old_values jsonb NOT NULL,
+    new_values jsonb NOT NULL,
+    PRIMARY KEY (movie_id, version)
+);
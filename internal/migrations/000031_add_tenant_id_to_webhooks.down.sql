@@ -0,0 +1,3 @@
+ALTER TABLE outbox DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS webhooks_tenant_id_idx;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS tenant_id;
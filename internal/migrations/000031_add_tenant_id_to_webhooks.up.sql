@@ -0,0 +1,6 @@
+-- Webhooks belong to a tenant and only get its events. Outbox entries carry the
+-- tenant of the change they are about, for the webhook events among them
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants;
+CREATE INDEX IF NOT EXISTS webhooks_tenant_id_idx ON webhooks (tenant_id);
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants;
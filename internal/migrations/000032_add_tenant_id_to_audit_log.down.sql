@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS audit_log_tenant_id_idx;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,3 @@
+-- Each tenant's admins only see the requests made to their tenant
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS tenant_id bigint NOT NULL DEFAULT 1 REFERENCES tenants;
+CREATE INDEX IF NOT EXISTS audit_log_tenant_id_idx ON audit_log (tenant_id, created_at DESC);
@@ -0,0 +1,17 @@
+CREATE OR REPLACE FUNCTION update_movie_average_rating() RETURNS trigger AS $$
+DECLARE
+    review reviews;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        review := OLD;
+    ELSE
+        review := NEW;
+    END IF;
+
+    UPDATE movies
+    SET average_rating = (SELECT avg(rating) FROM reviews WHERE movie_id = review.movie_id)
+    WHERE id = review.movie_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,20 @@
+-- A review changes the movie's average_rating, so it gives the movie a new
+-- version like any other change; the version is its ETag
+CREATE OR REPLACE FUNCTION update_movie_average_rating() RETURNS trigger AS $$
+DECLARE
+    review reviews;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        review := OLD;
+    ELSE
+        review := NEW;
+    END IF;
+
+    UPDATE movies
+    SET average_rating = (SELECT avg(rating) FROM reviews WHERE movie_id = review.movie_id),
+        version = version + 1
+    WHERE id = review.movie_id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
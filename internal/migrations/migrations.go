@@ -0,0 +1,264 @@
+/*
+Package migrations embeds the database migrations in the binary and applies
+them. They are the NNNNNN_name.up.sql and NNNNNN_name.down.sql files of this
+directory, created with "migrate create -seq -ext .sql".
+
+The applied version is kept in a schema_migrations table laid out like the one
+of golang-migrate, so databases migrated with its CLI carry on from where they
+are. Like golang-migrate, a migration runs as one multi-statement query, not in
+a transaction of its own, and the version is marked dirty while it runs: a
+migration that fails halfway must be cleaned up by hand before trying again.
+*/
+package migrations
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+var ErrDirty = errors.New("migrations: the database is dirty, a migration failed halfway and must be fixed by hand")
+
+/* Any value, as long as nothing else takes the same advisory lock */
+const lockID = 7_452_137_301
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+/* The embedded migrations, oldest first */
+func All() ([]*Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: unexpected file %s", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		sql, err := fs.ReadFile(files, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	all := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		all = append(all, m)
+	}
+
+	slices.SortFunc(all, func(a, b *Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return all, nil
+}
+
+/* The applied version, 0 when none is, and whether its migration failed halfway */
+func Version(ctx context.Context, db *sql.DB) (int64, bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	err = ensureTable(ctx, conn)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version(ctx, conn)
+}
+
+/* Applies the migrations after the current version, returns how many were */
+func Up(ctx context.Context, db *sql.DB) (int, error) {
+	all, err := All()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+
+	err = locked(ctx, db, func(conn *sql.Conn, current int64) error {
+		for _, m := range all {
+			if m.Version <= current {
+				continue
+			}
+
+			err := run(ctx, conn, m.Version, m.Up, m.Version)
+			if err != nil {
+				return fmt.Errorf("migrations: %06d_%s up: %w", m.Version, m.Name, err)
+			}
+
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+/* Reverts the last steps migrations, or fewer if not as many are applied; returns how many were */
+func Down(ctx context.Context, db *sql.DB, steps int) (int, error) {
+	all, err := All()
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+
+	err = locked(ctx, db, func(conn *sql.Conn, current int64) error {
+		for i := len(all) - 1; i >= 0 && reverted < steps; i-- {
+			m := all[i]
+			if m.Version > current {
+				continue
+			}
+
+			previous := int64(0)
+			if i > 0 {
+				previous = all[i-1].Version
+			}
+
+			err := run(ctx, conn, m.Version, m.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migrations: %06d_%s down: %w", m.Version, m.Name, err)
+			}
+
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+/*
+Runs fn on a connection holding a session advisory lock, so instances
+starting at the same time don't apply the same migrations twice.
+*/
+func locked(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn, current int64) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	err = ensureTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return ErrDirty
+	}
+
+	return fn(conn, current)
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	return err
+}
+
+func version(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var (
+		v     int64
+		dirty bool
+	)
+
+	err := conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return v, dirty, err
+}
+
+/* Marks version dirty, runs query and records to as the clean version */
+func run(ctx context.Context, conn *sql.Conn, version int64, query string, to int64) error {
+	err := setVersion(ctx, conn, version, true)
+	if err != nil {
+		return err
+	}
+
+	/* Without arguments lib/pq sends the file as a simple query, which can hold several statements */
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return setVersion(ctx, conn, to, false)
+}
+
+/* Version 0 leaves the table empty, as golang-migrate does with nothing applied */
+func setVersion(ctx context.Context, conn *sql.Conn, version int64, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `TRUNCATE schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if version > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, dirty)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,367 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+/*
+A database/sql driver standing in for Postgres: it keeps schema_migrations in
+memory, records the migrations run and fails the one set in failOn, which is
+all the runner needs to be tested without a database.
+*/
+type fakeDB struct {
+	mu       sync.Mutex
+	table    bool
+	rows     []fakeRow
+	ran      []string
+	failOn   string
+	locks    int
+	maxLocks int
+}
+
+type fakeRow struct {
+	version int64
+	dirty   bool
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("migrationsfake", fakeDriver{})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+
+	return &fakeConn{db: fakeDBs[name]}, nil
+}
+
+func openFake(t *testing.T) (*sql.DB, *fakeDB) {
+	t.Helper()
+
+	fake := &fakeDB{}
+
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = fake
+	fakeDBsMu.Unlock()
+
+	db, err := sql.Open("migrationsfake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, fake
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake: prepared statements aren't supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+/* setVersion's transaction is applied as it goes, it only ever commits after its statements succeed */
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_advisory_lock("):
+		db.locks++
+		db.maxLocks = max(db.maxLocks, db.locks)
+	case strings.HasPrefix(query, "SELECT pg_advisory_unlock("):
+		db.locks--
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		db.table = true
+	case query == "TRUNCATE schema_migrations":
+		db.rows = nil
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		db.rows = append(db.rows, fakeRow{version: args[0].Value.(int64), dirty: args[1].Value.(bool)})
+	default:
+		if query == db.failOn {
+			return nil, errors.New("fake: syntax error")
+		}
+		db.ran = append(db.ran, query)
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !strings.HasPrefix(query, "SELECT version, dirty FROM schema_migrations") {
+		return nil, fmt.Errorf("fake: unexpected query %q", query)
+	}
+	if !db.table {
+		return nil, errors.New(`fake: relation "schema_migrations" does not exist`)
+	}
+
+	return &fakeRows{rows: append([]fakeRow(nil), db.rows...)}, nil
+}
+
+type fakeRows struct {
+	rows []fakeRow
+}
+
+func (r *fakeRows) Columns() []string { return []string{"version", "dirty"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	dest[0], dest[1] = r.rows[0].version, r.rows[0].dirty
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func (db *fakeDB) state() (rows []fakeRow, ran []string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return append([]fakeRow(nil), db.rows...), append([]string(nil), db.ran...)
+}
+
+func mustAll(t *testing.T) []*Migration {
+	t.Helper()
+
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < 3 {
+		t.Fatalf("got %d migrations; the tests need at least 3", len(all))
+	}
+	return all
+}
+
+func TestAll(t *testing.T) {
+	all := mustAll(t)
+
+	for i, m := range all {
+		if m.Version != int64(i+1) {
+			t.Errorf("got version %d at %d; want versions 1 to %d without gaps", m.Version, i, len(all))
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("%06d_%s: got an empty up or down migration", m.Version, m.Name)
+		}
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	db, fake := openFake(t)
+	all := mustAll(t)
+	last := all[len(all)-1].Version
+
+	applied, err := Up(ctx, db)
+	if err != nil || applied != len(all) {
+		t.Fatalf("got %d applied, error %v; want %d and nil", applied, err, len(all))
+	}
+
+	rows, ran := fake.state()
+	if len(rows) != 1 || rows[0] != (fakeRow{version: last}) {
+		t.Fatalf("got schema_migrations %v; want the clean version %d", rows, last)
+	}
+	for i, m := range all {
+		if ran[i] != m.Up {
+			t.Fatalf("migration %d run out of order", m.Version)
+		}
+	}
+
+	/* Nothing left to apply */
+	applied, err = Up(ctx, db)
+	if err != nil || applied != 0 {
+		t.Fatalf("got %d applied, error %v; want 0 and nil", applied, err)
+	}
+
+	reverted, err := Down(ctx, db, 2)
+	if err != nil || reverted != 2 {
+		t.Fatalf("got %d reverted, error %v; want 2 and nil", reverted, err)
+	}
+
+	rows, ran = fake.state()
+	if want := all[len(all)-3].Version; rows[0] != (fakeRow{version: want}) {
+		t.Errorf("got schema_migrations %v; want the clean version %d", rows, want)
+	}
+	if ran[len(ran)-2] != all[len(all)-1].Down || ran[len(ran)-1] != all[len(all)-2].Down {
+		t.Error("the down migrations didn't run newest first")
+	}
+
+	version, dirty, err := Version(ctx, db)
+	if err != nil || dirty || version != all[len(all)-3].Version {
+		t.Errorf("got version %d, dirty %t, error %v; want %d, false and nil", version, dirty, err, all[len(all)-3].Version)
+	}
+
+	/* More steps than applied reverts everything, leaving the table empty like golang-migrate */
+	reverted, err = Down(ctx, db, len(all)+10)
+	if err != nil || reverted != len(all)-2 {
+		t.Fatalf("got %d reverted, error %v; want %d and nil", reverted, err, len(all)-2)
+	}
+
+	rows, _ = fake.state()
+	if len(rows) != 0 {
+		t.Errorf("got schema_migrations %v; want no rows", rows)
+	}
+
+	if fake.locks != 0 || fake.maxLocks != 1 {
+		t.Errorf("got %d locks held, at most %d; want every lock released", fake.locks, fake.maxLocks)
+	}
+}
+
+/* A database migrated with golang-migrate's CLI carries on from its version */
+func TestUpFromExistingVersion(t *testing.T) {
+	ctx := context.Background()
+	db, fake := openFake(t)
+	all := mustAll(t)
+
+	fake.table = true
+	fake.rows = []fakeRow{{version: all[1].Version}}
+
+	applied, err := Up(ctx, db)
+	if err != nil || applied != len(all)-2 {
+		t.Fatalf("got %d applied, error %v; want %d and nil", applied, err, len(all)-2)
+	}
+
+	_, ran := fake.state()
+	if ran[0] != all[2].Up {
+		t.Errorf("got the first migration run %.40q; want %06d", ran[0], all[2].Version)
+	}
+}
+
+func TestDirty(t *testing.T) {
+	ctx := context.Background()
+	db, fake := openFake(t)
+	all := mustAll(t)
+
+	failing := all[2]
+	fake.failOn = failing.Up
+
+	applied, err := Up(ctx, db)
+	if err == nil || applied != 2 {
+		t.Fatalf("got %d applied, error %v; want 2 and an error", applied, err)
+	}
+	if want := fmt.Sprintf("%06d_%s up", failing.Version, failing.Name); !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %q; want it to name %s", err, want)
+	}
+
+	/* The failed migration's version is left dirty */
+	version, dirty, err := Version(ctx, db)
+	if err != nil || !dirty || version != failing.Version {
+		t.Fatalf("got version %d, dirty %t, error %v; want %d, true and nil", version, dirty, err, failing.Version)
+	}
+
+	_, ranBefore := fake.state()
+
+	/* Nothing runs on a dirty database, in either direction, until it is fixed by hand */
+	fake.failOn = ""
+
+	applied, err = Up(ctx, db)
+	if !errors.Is(err, ErrDirty) || applied != 0 {
+		t.Errorf("got %d applied, error %v; want 0 and %v", applied, err, ErrDirty)
+	}
+
+	reverted, err := Down(ctx, db, 1)
+	if !errors.Is(err, ErrDirty) || reverted != 0 {
+		t.Errorf("got %d reverted, error %v; want 0 and %v", reverted, err, ErrDirty)
+	}
+
+	_, ranAfter := fake.state()
+	if len(ranAfter) != len(ranBefore) {
+		t.Errorf("got %d migrations run on a dirty database; want none", len(ranAfter)-len(ranBefore))
+	}
+
+	/* Fixed by hand, as with "migrate force": the failed migration is retried */
+	fake.rows = []fakeRow{{version: all[1].Version}}
+
+	applied, err = Up(ctx, db)
+	if err != nil || applied != len(all)-2 {
+		t.Fatalf("got %d applied, error %v; want %d and nil", applied, err, len(all)-2)
+	}
+
+	if fake.locks != 0 {
+		t.Errorf("got %d locks still held", fake.locks)
+	}
+}
+
+/*
+The whole set against a real database, which checks the SQL of every up and
+down migration. Only runs with GREENLIGHT_TEST_DB_DSN set, to an empty
+database: it is migrated down to nothing at the end.
+*/
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN not set")
+	}
+
+	ctx := context.Background()
+	all := mustAll(t)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	version, dirty, err := Version(ctx, db)
+	if err != nil || dirty || version != 0 {
+		t.Fatalf("got version %d, dirty %t, error %v; want an empty database", version, dirty, err)
+	}
+
+	_, err = Up(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* Every down migration has to undo its up one for the second round to apply */
+	for range 2 {
+		reverted, err := Down(ctx, db, len(all))
+		if err != nil || reverted != len(all) {
+			t.Fatalf("got %d reverted, error %v; want %d and nil", reverted, err, len(all))
+		}
+
+		applied, err := Up(ctx, db)
+		if err != nil || applied != len(all) {
+			t.Fatalf("got %d applied, error %v; want %d and nil", applied, err, len(all))
+		}
+	}
+
+	_, err = Down(ctx, db, len(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,156 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+)
+
+/*
+Marshal encodes v as MessagePack. v is first run through encoding/json so struct
+tags and custom MarshalJSON methods (e.g. data.Runtime) shape the output exactly
+like the JSON responses do, only in a more compact binary form.
+*/
+func Marshal(v any) ([]byte, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	/* Keep integers as integers instead of float64 */
+	dec.UseNumber()
+
+	var generic any
+	err = dec.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	err = encode(buf, generic)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			encodeInt(buf, i)
+			return nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+
+	case string:
+		encodeStringHeader(buf, len(v))
+		buf.WriteString(v)
+
+	case []any:
+		encodeContainerHeader(buf, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			err := encode(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+
+	case map[string]any:
+		encodeContainerHeader(buf, len(v), 0x80, 0xde, 0xdf)
+
+		/* Sorted keys keep the output deterministic */
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			encodeStringHeader(buf, len(key))
+			buf.WriteString(key)
+
+			err := encode(buf, v[key])
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+
+	return nil
+}
+
+func encodeInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+func encodeStringHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 31:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+/* Writes an array or map header using the fix, 16 or 32 bit form */
+func encodeContainerHeader(buf *bytes.Buffer, n int, fix, code16, code32 byte) {
+	switch {
+	case n <= 15:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
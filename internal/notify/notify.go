@@ -0,0 +1,137 @@
+/*
+Package notify delivers notifications to the connections of a user, e.g. their
+WebSocket connections. The registry is in-process: a user only gets the
+notifications sent by the instance of the API they are connected to.
+*/
+package notify
+
+import (
+	"sync"
+	"time"
+)
+
+/* Notification types */
+const (
+	WatchlistMovieUpdated = "watchlist.movie_updated"
+	WatchlistMovieDeleted = "watchlist.movie_deleted"
+	AdminBroadcast        = "admin.broadcast"
+)
+
+type Notification struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+/*
+Hub keeps the connections of each user. Like events.Bus the send functions run
+in the notifying goroutine, so they should only queue the notification.
+*/
+type Hub struct {
+	mu     sync.RWMutex
+	nextID int
+	users  map[int64]map[int]connection
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+/* A connection and the tenant it was opened with, broadcasts only go to their own tenant */
+type connection struct {
+	tenant int64
+	send   func(Notification)
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		users: make(map[int64]map[int]connection),
+		done:  make(chan struct{}),
+	}
+}
+
+/* Closes Done, telling the connections to finish on shutdown */
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+/* Adds a connection of the user in tenant, send gets their notifications until unregister is called */
+func (h *Hub) Register(tenant, userID int64, send func(Notification)) (unregister func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.nextID
+	h.nextID++
+
+	if h.users[userID] == nil {
+		h.users[userID] = make(map[int]connection)
+	}
+	h.users[userID][id] = connection{tenant: tenant, send: send}
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.users[userID], id)
+		if len(h.users[userID]) == 0 {
+			delete(h.users, userID)
+		}
+	}
+}
+
+/* The users with at least one connection */
+func (h *Hub) Users() []int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := make([]int64, 0, len(h.users))
+	for userID := range h.users {
+		users = append(users, userID)
+	}
+
+	return users
+}
+
+/* Sends to every connection of the user, returns how many there are */
+func (h *Hub) Notify(userID int64, notificationType string, data any) int {
+	notification := Notification{Type: notificationType, Time: time.Now().UTC(), Data: data}
+
+	h.mu.RLock()
+	sends := make([]func(Notification), 0, len(h.users[userID]))
+	for _, conn := range h.users[userID] {
+		sends = append(sends, conn.send)
+	}
+	h.mu.RUnlock()
+
+	return deliver(sends, notification)
+}
+
+/* Sends to every connection of tenant, returns how many there are */
+func (h *Hub) Broadcast(tenant int64, notificationType string, data any) int {
+	notification := Notification{Type: notificationType, Time: time.Now().UTC(), Data: data}
+
+	h.mu.RLock()
+	var sends []func(Notification)
+	for _, conns := range h.users {
+		for _, conn := range conns {
+			if conn.tenant == tenant {
+				sends = append(sends, conn.send)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	return deliver(sends, notification)
+}
+
+/* Called on a copy, so connections can unregister without deadlocking */
+func deliver(sends []func(Notification), notification Notification) int {
+	for _, send := range sends {
+		send(notification)
+	}
+
+	return len(sends)
+}
@@ -0,0 +1,248 @@
+/*
+Package oauth signs users in with an OAuth 2.0 provider using the
+authorization-code flow with PKCE (RFC 7636), and fetches who they are from the
+provider's user info endpoint. Google and GitHub are supported.
+*/
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoVerifiedEmail = errors.New("oauth: the account has no verified email address")
+
+/* Who signed in, as told by the provider */
+type Identity struct {
+	/* The provider's stable ID of the account, emails can change */
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type Provider struct {
+	Name string
+
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	authURL      string
+	tokenURL     string
+	scopes       []string
+
+	client   *http.Client
+	identity func(ctx context.Context, p *Provider, accessToken string) (*Identity, error)
+}
+
+func Google(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "google",
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		tokenURL:     "https://oauth2.googleapis.com/token",
+		scopes:       []string{"openid", "email", "profile"},
+		client:       &http.Client{Timeout: 10 * time.Second},
+		identity:     googleIdentity,
+	}
+}
+
+func GitHub(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "github",
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		authURL:      "https://github.com/login/oauth/authorize",
+		tokenURL:     "https://github.com/login/oauth/access_token",
+		scopes:       []string{"read:user", "user:email"},
+		client:       &http.Client{Timeout: 10 * time.Second},
+		identity:     githubIdentity,
+	}
+}
+
+/* A random value for the state parameter or the PKCE code verifier */
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+/* Where the user is sent to sign in, the provider redirects back with a code and state */
+func (p *Provider) AuthCodeURL(state, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.clientID)
+	values.Set("redirect_uri", p.redirectURL)
+	values.Set("scope", strings.Join(p.scopes, " "))
+	values.Set("state", state)
+	values.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	values.Set("code_challenge_method", "S256")
+
+	return p.authURL + "?" + values.Encode()
+}
+
+/* Trades the code from the redirect for an access token */
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", p.redirectURL)
+	values.Set("client_id", p.clientID)
+	values.Set("client_secret", p.clientSecret)
+	values.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	/* GitHub reports errors with a 200 and the error field */
+	err = p.do(req, &token)
+	if err == nil && token.Error != "" {
+		err = fmt.Errorf("oauth: %s: %s %s", p.Name, token.Error, token.ErrorDescription)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("oauth: %s: no access token in the response", p.Name)
+	}
+
+	return token.AccessToken, nil
+}
+
+/* The signed in account, ErrNoVerifiedEmail unless the provider verified its email */
+func (p *Provider) Identity(ctx context.Context, accessToken string) (*Identity, error) {
+	identity, err := p.identity(ctx, p, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrNoVerifiedEmail
+	}
+
+	return identity, nil
+}
+
+func (p *Provider) get(ctx context.Context, url, accessToken string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	return p.do(req, dst)
+}
+
+func (p *Provider) do(req *http.Request, dst any) error {
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth: %s: %s returned %d: %s", p.Name, req.URL.Path, res.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, dst)
+}
+
+func googleIdentity(ctx context.Context, p *Provider, accessToken string) (*Identity, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	err := p.get(ctx, "https://openidconnect.googleapis.com/v1/userinfo", accessToken, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Subject:       info.Sub,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Name:          info.Name,
+	}, nil
+}
+
+/* The profile's email may be hidden, the primary one comes from /user/emails */
+func githubIdentity(ctx context.Context, p *Provider, accessToken string) (*Identity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+
+	err := p.get(ctx, "https://api.github.com/user", accessToken, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	err = p.get(ctx, "https://api.github.com/user/emails", accessToken, &emails)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Subject: strconv.FormatInt(user.ID, 10),
+		Name:    user.Name,
+	}
+
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+		}
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,256 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Generator turns Go values into schemas. Named struct types are added to the
+components once and referenced with $ref everywhere they appear; maps with
+string keys and interface values (such as an envelope) are described from the
+values they hold, so envelope{"movie": data.Movie{}} documents a response body.
+*/
+type Generator struct {
+	schemas   map[string]*Schema
+	overrides map[reflect.Type]*Schema
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas: make(map[string]*Schema),
+		overrides: map[reflect.Type]*Schema{
+			reflect.TypeFor[time.Time]():       {Type: "string", Format: "date-time"},
+			reflect.TypeFor[json.RawMessage](): {Description: "Any JSON value"},
+		},
+	}
+}
+
+/* Describes t with schema instead of reflecting on it, for types with custom JSON encodings */
+func (g *Generator) Override(t reflect.Type, schema *Schema) {
+	g.overrides[t] = schema
+}
+
+/* Adds a hand-written schema to the components and returns a reference to it */
+func (g *Generator) Define(name string, schema *Schema) *Schema {
+	g.schemas[name] = schema
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+/* Named schemas collected so far, for Components.Schemas */
+func (g *Generator) Schemas() map[string]*Schema {
+	return g.schemas
+}
+
+func (g *Generator) Schema(v any) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+
+	rv := reflect.ValueOf(v)
+
+	/* Only describe the values of a map[string]any, anything else by its type */
+	if rv.Kind() == reflect.Map && rv.Type().Elem().Kind() == reflect.Interface && rv.Len() > 0 {
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for _, key := range rv.MapKeys() {
+			schema.Properties[key.String()] = g.Schema(rv.MapIndex(key).Interface())
+		}
+		return schema
+	}
+
+	return g.typeSchema(rv.Type())
+}
+
+func (g *Generator) typeSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if schema, ok := g.overrides[t]; ok {
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		/* []byte is encoded as base64 by encoding/json */
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	default:
+		return &Schema{}
+	}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	/* Anonymous structs are described inline */
+	if t.Name() == "" {
+		return g.objectSchema(t)
+	}
+
+	name := t.Name()
+	if _, ok := g.schemas[name]; !ok {
+		/* Reserve the name first so self-referencing types terminate */
+		g.schemas[name] = &Schema{}
+		*g.schemas[name] = *g.objectSchema(t)
+	}
+
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (g *Generator) objectSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		/* Embedded structs without a json name are flattened like encoding/json does */
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded := g.objectSchema(ft)
+				for k, v := range embedded.Properties {
+					schema.Properties[k] = v
+				}
+				schema.Required = append(schema.Required, embedded.Required...)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := g.typeSchema(field.Type)
+		if tag := field.Tag.Get("validate"); tag != "" {
+			var required bool
+			property, required = applyRules(property, tag)
+			if required {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+
+		if description := field.Tag.Get("doc"); description != "" {
+			property = withDescription(property, description)
+		}
+
+		schema.Properties[name] = property
+	}
+
+	return schema
+}
+
+/* Copies the constraints of a validator tag onto the schema, see validator.ValidateStruct */
+func applyRules(schema *Schema, tag string) (*Schema, bool) {
+	if schema.Ref != "" {
+		return schema, strings.Contains(","+tag+",", ",required,")
+	}
+
+	s := *schema
+	required := false
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+
+		switch name {
+		case "dive":
+			/* Rules after dive apply to the items */
+			if _, itemRules, found := strings.Cut(tag, "dive,"); found && s.Items != nil {
+				s.Items, _ = applyRules(s.Items, itemRules)
+			}
+			return &s, required
+		case "required":
+			required = true
+		case "min", "max":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			setBound(&s, name, n)
+		case "email":
+			s.Format = "email"
+		case "url":
+			s.Format = "uri"
+		case "uuid":
+			s.Format = "uuid"
+		case "isodate":
+			s.Format = "date"
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				s.Enum = append(s.Enum, value)
+			}
+		case "unique":
+			s.UniqueItems = true
+		}
+	}
+
+	return &s, required
+}
+
+func setBound(s *Schema, rule string, n int) {
+	switch s.Type {
+	case "string":
+		if rule == "min" {
+			s.MinLength = &n
+		} else {
+			s.MaxLength = &n
+		}
+	case "array", "object":
+		if rule == "min" {
+			s.MinItems = &n
+		} else {
+			s.MaxItems = &n
+		}
+	default:
+		f := float64(n)
+		if rule == "min" {
+			s.Minimum = &f
+		} else {
+			s.Maximum = &f
+		}
+	}
+}
+
+/* $ref siblings are ignored in OpenAPI 3.0, so a described reference is wrapped in oneOf */
+func withDescription(schema *Schema, description string) *Schema {
+	if schema.Ref != "" {
+		return &Schema{OneOf: []*Schema{schema}, Description: description}
+	}
+
+	s := *schema
+	s.Description = description
+	return &s
+}
@@ -0,0 +1,210 @@
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+/*
+Minimal Protocol Buffers wire format encoding, enough for hand-written
+messages with scalar, string, bytes and nested message fields.
+*/
+
+type Type int
+
+const (
+	VarintType  Type = 0
+	Fixed64Type Type = 1
+	BytesType   Type = 2
+	Fixed32Type Type = 5
+)
+
+var ErrMalformed = errors.New("protowire: malformed message")
+
+func AppendTag(b []byte, num int, typ Type) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+/* The Append* field helpers skip zero values, as proto3 does for scalars */
+
+func AppendVarint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = AppendTag(b, num, VarintType)
+	return binary.AppendUvarint(b, v)
+}
+
+/* int32 and int64 fields; negative values take ten bytes as in the spec */
+func AppendInt(b []byte, num int, v int64) []byte {
+	return AppendVarint(b, num, uint64(v))
+}
+
+/* sint32 and sint64 fields, zigzag encoded so small negative values stay short too */
+func AppendSint(b []byte, num int, v int64) []byte {
+	return AppendVarint(b, num, uint64(v<<1)^uint64(v>>63))
+}
+
+func AppendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+
+	return AppendVarint(b, num, 1)
+}
+
+func AppendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	return AppendBytes(b, num, []byte(s))
+}
+
+func AppendBytes(b []byte, num int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+
+	return AppendMessage(b, num, v)
+}
+
+/* Nested messages are written even when empty so their presence is kept */
+func AppendMessage(b []byte, num int, v []byte) []byte {
+	b = AppendTag(b, num, BytesType)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+/* Repeated string fields are one field per element */
+func AppendStrings(b []byte, num int, values []string) []byte {
+	for _, s := range values {
+		b = AppendTag(b, num, BytesType)
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+
+	return b
+}
+
+/*
+Decoder walks the fields of an encoded message:
+
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Int()
+		}
+	}
+	return d.Err()
+
+Fields that are not read are skipped.
+*/
+type Decoder struct {
+	b   []byte
+	num int
+	typ Type
+	/* Raw value of the current field */
+	varint uint64
+	bytes  []byte
+	err    error
+}
+
+func NewDecoder(b []byte) *Decoder {
+	return &Decoder{b: b}
+}
+
+func (d *Decoder) Next() bool {
+	if d.err != nil || len(d.b) == 0 {
+		return false
+	}
+
+	tag, n := binary.Uvarint(d.b)
+	if n <= 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+		d.err = ErrMalformed
+		return false
+	}
+	d.b = d.b[n:]
+
+	d.num, d.typ = int(tag>>3), Type(tag&7)
+	d.bytes = nil
+
+	switch d.typ {
+	case VarintType:
+		d.varint, n = binary.Uvarint(d.b)
+		if n <= 0 {
+			d.err = ErrMalformed
+			return false
+		}
+		d.b = d.b[n:]
+
+	case BytesType:
+		length, n := binary.Uvarint(d.b)
+		if n <= 0 || length > uint64(len(d.b)-n) {
+			d.err = ErrMalformed
+			return false
+		}
+		d.bytes = d.b[n : n+int(length)]
+		d.b = d.b[n+int(length):]
+
+	case Fixed64Type, Fixed32Type:
+		size := 8
+		if d.typ == Fixed32Type {
+			size = 4
+		}
+		if len(d.b) < size {
+			d.err = ErrMalformed
+			return false
+		}
+		d.bytes = d.b[:size]
+		d.b = d.b[size:]
+
+	default:
+		d.err = ErrMalformed
+		return false
+	}
+
+	return true
+}
+
+func (d *Decoder) Num() int {
+	return d.num
+}
+
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+func (d *Decoder) Int() int64 {
+	d.expect(VarintType)
+	return int64(d.varint)
+}
+
+/* A field written by AppendSint */
+func (d *Decoder) Sint() int64 {
+	d.expect(VarintType)
+	return int64(d.varint>>1) ^ -int64(d.varint&1)
+}
+
+func (d *Decoder) Bool() bool {
+	d.expect(VarintType)
+	return d.varint != 0
+}
+
+func (d *Decoder) String() string {
+	return string(d.Bytes())
+}
+
+/* The returned slice points into the message being decoded */
+func (d *Decoder) Bytes() []byte {
+	d.expect(BytesType)
+	return d.bytes
+}
+
+func (d *Decoder) expect(typ Type) {
+	if d.typ != typ && d.err == nil {
+		d.err = ErrMalformed
+	}
+}
@@ -0,0 +1,261 @@
+package protowire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestAppendKnownEncodings(t *testing.T) {
+	/* The examples of the protobuf encoding guide */
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"varint 150", AppendVarint(nil, 1, 150), "089601"},
+		{"string testing", AppendString(nil, 2, "testing"), "120774657374696e67"},
+		{"nested message", AppendMessage(nil, 3, AppendVarint(nil, 1, 150)), "1a03089601"},
+		{"negative int", AppendInt(nil, 1, -2), "08feffffffffffffffff01"},
+		{"bool", AppendBool(nil, 1, true), "0801"},
+		{"sint -2", AppendSint(nil, 1, -2), "0803"},
+		{"large field number", AppendVarint(nil, 1000, 1), "c03e01"},
+		{"repeated strings", AppendStrings(nil, 4, []string{"a", "bc"}), "22016122026263"},
+		{"empty message kept", AppendMessage(nil, 5, nil), "2a00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(tt.b); got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+/* proto3 leaves out scalar fields with their zero value */
+func TestAppendSkipsZero(t *testing.T) {
+	var b []byte
+	b = AppendVarint(b, 1, 0)
+	b = AppendInt(b, 2, 0)
+	b = AppendSint(b, 3, 0)
+	b = AppendBool(b, 4, false)
+	b = AppendString(b, 5, "")
+	b = AppendBytes(b, 6, nil)
+	b = AppendStrings(b, 7, nil)
+
+	if len(b) != 0 {
+		t.Errorf("got %x; want nothing", b)
+	}
+}
+
+func TestVarintRoundTrip(t *testing.T) {
+	values := []int64{1, 127, 128, 300, 16383, 16384, math.MaxInt32, math.MaxInt32 + 1, math.MaxInt64, -1, -128, math.MinInt32, math.MinInt64}
+
+	for _, v := range values {
+		b := AppendInt(nil, 1, v)
+
+		d := NewDecoder(b)
+		if !d.Next() {
+			t.Fatalf("%d: no field decoded: %v", v, d.Err())
+		}
+		if got := d.Int(); got != v || d.Num() != 1 {
+			t.Errorf("got field %d = %d; want 1 = %d", d.Num(), got, v)
+		}
+		if d.Next() || d.Err() != nil {
+			t.Errorf("%d: got trailing data or error %v", v, d.Err())
+		}
+	}
+}
+
+func TestZigzagRoundTrip(t *testing.T) {
+	tests := []struct {
+		v       int64
+		encoded uint64
+	}{
+		{-1, 1},
+		{1, 2},
+		{-2, 3},
+		{2147483647, 4294967294},
+		{-2147483648, 4294967295},
+		{math.MaxInt64, math.MaxUint64 - 1},
+		{math.MinInt64, math.MaxUint64},
+	}
+
+	for _, tt := range tests {
+		b := AppendSint(nil, 1, tt.v)
+
+		/* The raw varint is the zigzag value */
+		want := AppendVarint(nil, 1, tt.encoded)
+		if !bytes.Equal(b, want) {
+			t.Errorf("AppendSint(%d) = %x; want %x", tt.v, b, want)
+		}
+
+		d := NewDecoder(b)
+		if !d.Next() {
+			t.Fatalf("%d: no field decoded: %v", tt.v, d.Err())
+		}
+		if got := d.Sint(); got != tt.v {
+			t.Errorf("got %d; want %d", got, tt.v)
+		}
+	}
+
+	/* Small negative values stay short, unlike AppendInt */
+	if n, m := len(AppendSint(nil, 1, -1)), len(AppendInt(nil, 1, -1)); n != 2 || m != 11 {
+		t.Errorf("got %d bytes for sint -1 and %d for int -1; want 2 and 11", n, m)
+	}
+}
+
+func TestLengthDelimitedRoundTrip(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 300))
+	binary := []byte{0, 1, 2, 0xff}
+	nested := AppendString(AppendInt(nil, 1, 42), 2, "inner")
+
+	var b []byte
+	b = AppendString(b, 1, "héllo")
+	b = AppendString(b, 2, long)
+	b = AppendBytes(b, 3, binary)
+	b = AppendMessage(b, 4, nested)
+	b = AppendStrings(b, 5, []string{"a", "", "c"})
+	b = AppendBool(b, 6, true)
+
+	var (
+		s1, s2   string
+		raw      []byte
+		id       int64
+		inner    string
+		repeated []string
+		flag     bool
+	)
+
+	d := NewDecoder(b)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			s1 = d.String()
+		case 2:
+			s2 = d.String()
+		case 3:
+			raw = d.Bytes()
+		case 4:
+			nd := NewDecoder(d.Bytes())
+			for nd.Next() {
+				switch nd.Num() {
+				case 1:
+					id = nd.Int()
+				case 2:
+					inner = nd.String()
+				}
+			}
+			if err := nd.Err(); err != nil {
+				t.Fatal(err)
+			}
+		case 5:
+			repeated = append(repeated, d.String())
+		case 6:
+			flag = d.Bool()
+		}
+	}
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case s1 != "héllo", s2 != long:
+		t.Errorf("got strings %q and %d bytes", s1, len(s2))
+	case !bytes.Equal(raw, binary):
+		t.Errorf("got bytes %x; want %x", raw, binary)
+	case id != 42 || inner != "inner":
+		t.Errorf("got nested %d %q; want 42 \"inner\"", id, inner)
+	case !slices.Equal(repeated, []string{"a", "", "c"}):
+		t.Errorf("got repeated %q", repeated)
+	case !flag:
+		t.Error("got bool false; want true")
+	}
+}
+
+func TestDecoderSkipsUnknownFields(t *testing.T) {
+	var b []byte
+	b = AppendVarint(b, 9, 1)
+	b = AppendTag(b, 10, Fixed64Type)
+	b = append(b, 1, 2, 3, 4, 5, 6, 7, 8)
+	b = AppendTag(b, 11, Fixed32Type)
+	b = append(b, 1, 2, 3, 4)
+	b = AppendString(b, 12, "ignored")
+	b = AppendInt(b, 1, 7)
+
+	var id int64
+	d := NewDecoder(b)
+	for d.Next() {
+		if d.Num() == 1 {
+			id = d.Int()
+		}
+	}
+
+	if d.Err() != nil || id != 7 {
+		t.Errorf("got id %d and error %v; want 7 and nil", id, d.Err())
+	}
+}
+
+func TestDecoderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"truncated tag", []byte{0x80}},
+		{"field number zero", []byte{0x00, 0x01}},
+		{"truncated varint", []byte{0x08, 0x96}},
+		{"varint overflow", append([]byte{0x08}, bytes.Repeat([]byte{0xff}, 11)...)},
+		{"length past the end", []byte{0x12, 0x05, 'a', 'b'}},
+		{"huge length", append([]byte{0x12}, 0xff, 0xff, 0xff, 0xff, 0x0f)},
+		{"truncated fixed64", []byte{0x09, 1, 2, 3}},
+		{"truncated fixed32", []byte{0x0d, 1, 2}},
+		{"start group", []byte{0x0b}},
+		{"end group", []byte{0x0c}},
+		{"wire type 7", []byte{0x0f}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(tt.b)
+			for d.Next() {
+			}
+
+			if !errors.Is(d.Err(), ErrMalformed) {
+				t.Errorf("got error %v; want %v", d.Err(), ErrMalformed)
+			}
+		})
+	}
+}
+
+/* Reading a field as the wrong type is an error rather than garbage */
+func TestDecoderWrongType(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		read func(d *Decoder)
+	}{
+		{"string as int", AppendString(nil, 1, "x"), func(d *Decoder) { d.Int() }},
+		{"int as string", AppendInt(nil, 1, 1), func(d *Decoder) { _ = d.String() }},
+		{"int as bytes", AppendInt(nil, 1, 1), func(d *Decoder) { d.Bytes() }},
+		{"bytes as sint", AppendBytes(nil, 1, []byte{1}), func(d *Decoder) { d.Sint() }},
+		{"bytes as bool", AppendBytes(nil, 1, []byte{1}), func(d *Decoder) { d.Bool() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(tt.b)
+			if !d.Next() {
+				t.Fatal(d.Err())
+			}
+			tt.read(d)
+
+			if !errors.Is(d.Err(), ErrMalformed) || d.Next() {
+				t.Errorf("got error %v; want %v and no more fields", d.Err(), ErrMalformed)
+			}
+		})
+	}
+}
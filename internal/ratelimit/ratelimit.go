@@ -0,0 +1,109 @@
+/*
+Package ratelimit keeps a token bucket per client key, e.g. an IP address or a
+user, and reports what is left of it for the X-RateLimit-* headers. Memory keeps
+the buckets of one process, Redis shares them between the API's instances.
+*/
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+/* Rate requests per second on average, with bursts of up to Burst */
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+/* A zero rate doesn't limit anything */
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+type Result struct {
+	Allowed bool
+	/* The size of the bucket */
+	Limit     int
+	Remaining int
+	/* Until the bucket is full again */
+	Reset time.Duration
+	/* Until the next request is allowed, only set when this one wasn't */
+	RetryAfter time.Duration
+}
+
+type Store interface {
+	/* Takes a token from key's bucket */
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+/* Buckets of one process, see Allow */
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+/* Buckets idle for 3 minutes are removed, a minute at a time */
+func NewMemory() *Memory {
+	m := &Memory{buckets: make(map[string]*bucket)}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			m.mu.Lock()
+			for key, b := range m.buckets {
+				if time.Since(b.lastSeen) > 3*time.Minute {
+					delete(m.buckets, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}()
+
+	return m
+}
+
+/* Takes a token from key's bucket, which starts over when its limit changes */
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, found := m.buckets[key]
+	if !found || b.limiter.Limit() != rate.Limit(limit.Rate) || b.limiter.Burst() != limit.Burst {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		m.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	allowed := b.limiter.AllowN(now, 1)
+	tokens := b.limiter.TokensAt(now)
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: max(int(math.Floor(tokens)), 0),
+		Reset:     seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+
+	return result, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(max(s, 0) * float64(time.Second))
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/redis"
+)
+
+/*
+GCRA, the generic cell rate algorithm: a key holds the theoretical arrival time
+(TAT) of the next request, in microseconds of the Redis server's clock so the
+clocks of the API's instances don't matter. A request is allowed while the TAT
+is less than a burst of emission intervals ahead of now. It behaves like the
+token bucket of Memory, with a single value per key.
+*/
+const gcraScript = `
+local interval = tonumber(ARGV[1])
+local tolerance = interval * tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local allowed = 0
+local retry_after = 0
+
+if tat + interval - tolerance <= now then
+	allowed = 1
+	tat = tat + interval
+	-- %.0f, tostring would write large numbers in exponent notation
+	redis.call('SET', KEYS[1], string.format('%.0f', tat), 'PX', math.ceil((tat - now) / 1000))
+else
+	retry_after = tat + interval - tolerance - now
+end
+
+local remaining = math.floor((now - (tat - tolerance)) / interval)
+if remaining < 0 then
+	remaining = 0
+end
+
+return {allowed, remaining, tat - now, retry_after}
+`
+
+var gcraSHA = func() string {
+	sum := sha1.Sum([]byte(gcraScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+/* Buckets shared by every instance using the same Redis, keys are prefixed with prefix */
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (s *Redis) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	interval := strconv.FormatInt(int64(float64(time.Second/time.Microsecond)/limit.Rate), 10)
+	args := []string{"1", s.prefix + key, interval, strconv.Itoa(limit.Burst)}
+
+	/* The script is only sent when Redis doesn't have it cached yet */
+	reply, err := s.client.Do(ctx, append([]string{"EVALSHA", gcraSHA}, args...)...)
+
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) && replyErr.Kind() == "NOSCRIPT" {
+		reply, err = s.client.Do(ctx, append([]string{"EVAL", gcraScript}, args...)...)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 4 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+	}
+
+	var n [4]int64
+	for i, v := range values {
+		n[i], ok = v.(int64)
+		if !ok {
+			return Result{}, fmt.Errorf("ratelimit: unexpected reply %v", reply)
+		}
+	}
+
+	return Result{
+		Allowed:    n[0] == 1,
+		Limit:      limit.Burst,
+		Remaining:  int(n[1]),
+		Reset:      time.Duration(n[2]) * time.Microsecond,
+		RetryAfter: time.Duration(n[3]) * time.Microsecond,
+	}, nil
+}
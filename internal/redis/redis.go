@@ -0,0 +1,261 @@
+/*
+Package redis is a small Redis client speaking RESP2 over a pool of
+connections. It only sends commands and reads their replies, there is no
+pipelining and no pub/sub.
+*/
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* An error reply, e.g. "NOSCRIPT No matching script" */
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+/* The first word of the error, e.g. "NOSCRIPT" */
+func (e Error) Kind() string {
+	kind, _, _ := strings.Cut(string(e), " ")
+	return kind
+}
+
+type Client struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+
+	/* Idle connections, the channel's capacity is the most that are kept */
+	idle chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+/* Takes a URL like redis://:password@localhost:6379/0, rediss:// for TLS */
+func Open(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("redis: invalid URL scheme %q, must be redis or rediss", u.Scheme)
+	}
+
+	c := &Client{
+		addr: u.Host,
+		tls:  u.Scheme == "rediss",
+		idle: make(chan *conn, 16),
+	}
+
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		c.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid database %q", db)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+/*
+Sends a command and returns its reply: a string, an int64, nil for a nil bulk
+string, or a []any of those. Error replies are returned as Error.
+*/
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	cn.SetDeadline(deadline)
+
+	reply, err := cn.do(args)
+
+	/* A connection is only reused after a complete reply, an error reply included */
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		cn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+	return reply, err
+}
+
+/* Closes the idle connections, those in use are closed when they are put back */
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	var (
+		nc  net.Conn
+		err error
+	)
+
+	if c.tls {
+		nc, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	cn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.username != "" {
+			args = []string{"AUTH", c.username, c.password}
+		}
+
+		if _, err := cn.do(args); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	if c.db != 0 {
+		if _, err := cn.do([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *conn) do(args []string) (any, error) {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_, err := cn.Write([]byte(b.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	return cn.read()
+}
+
+/* Reads a reply, the first byte tells its type */
+func (cn *conn) read() (any, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(cn.r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		/* Elements that are error replies don't fail the whole reply */
+		values := make([]any, n)
+		for i := range values {
+			values[i], err = cn.read()
+			var replyErr Error
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
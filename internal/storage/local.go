@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* Local stores objects as files under Dir, for development and single servers */
+type Local struct {
+	Dir     string
+	baseURL string
+}
+
+/* baseURL is where Dir is served from, e.g. "/uploads" */
+func NewLocal(dir, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{Dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	path := filepath.Join(l.Dir, filepath.FromSlash(key))
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	/* Write to a temporary file first so a failed upload never leaves a partial object */
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, io.LimitReader(r, size))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	err := os.Remove(filepath.Join(l.Dir, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
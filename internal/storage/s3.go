@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/* hex(sha256("")), the payload hash of requests without a body */
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+/*
+S3 stores objects in a bucket of any S3-compatible service (AWS, MinIO,
+Cloudflare R2...) using path-style URLs and Signature Version 4. Objects are
+expected to be publicly readable through the bucket's policy or a CDN.
+*/
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	/* Where objects are served from, endpoint/bucket unless configured */
+	baseURL string
+
+	client *http.Client
+}
+
+type S3Config struct {
+	/* e.g. https://s3.eu-north-1.amazonaws.com or http://localhost:9000 */
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	/* Public base URL, e.g. a CDN in front of the bucket; optional */
+	BaseURL string
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: invalid S3 endpoint %q", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" || cfg.Region == "" {
+		return nil, fmt.Errorf("storage: S3 bucket and region must be provided")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = endpoint.String() + "/" + cfg.Bucket
+	}
+
+	return &S3{
+		endpoint:  endpoint,
+		region:    cfg.Region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		client:    &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	/* INFO: Signing the payload would mean reading it twice, S3 accepts unsigned payloads */
+	s.sign(req, "UNSIGNED-PAYLOAD", time.Now())
+
+	return s.do(req)
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+
+	s.sign(req, emptyPayloadHash, time.Now())
+
+	/* S3 answers 204 whether the object existed or not */
+	return s.do(req)
+}
+
+func (s *S3) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *S3) objectURL(key string) string {
+	return s.endpoint.String() + "/" + s.bucket + "/" + key
+}
+
+func (s *S3) do(req *http.Request) error {
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		/* The XML error document names the problem, e.g. <Code>AccessDenied</Code> */
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("storage: S3 %s %s: %s: %s", req.Method, req.URL.Path, res.Status, body)
+	}
+
+	io.Copy(io.Discard, res.Body)
+
+	return nil
+}
+
+/*
+Adds an AWS Signature Version 4 Authorization header, see
+https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+Only host and the x-amz-* headers are signed.
+*/
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
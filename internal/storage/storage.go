@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+/*
+Storage keeps uploaded files, e.g. movie posters, and tells where the public
+can fetch them. Keys are slash separated paths such as "posters/1-ab12.jpg".
+*/
+type Storage interface {
+	/* Stores size bytes from r under key, replacing any existing object */
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	/* Removes the object, deleting a missing key is not an error */
+	Delete(ctx context.Context, key string) error
+	/* Public URL of the object */
+	URL(key string) string
+}
+
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+/* Keys must stay inside the storage root once joined to a directory or URL */
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	for segment := range strings.SplitSeq(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,143 @@
+/*
+Package totp implements time-based one-time passwords (RFC 6238) as used by
+authenticator apps: HMAC-SHA1, 6 digits and 30 second steps. Cipher encrypts
+the shared secrets for storage.
+*/
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	digits = 6
+	period = 30
+	/* Steps either side of the current one that are accepted, for clock drift */
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+/* 160 bits, the size RFC 4226 recommends */
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+/* The time step t falls in, codes are valid for one step */
+func Step(t time.Time) int64 {
+	return t.Unix() / period
+}
+
+/* The code for a step, zero padded to 6 digits */
+func Code(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	/* Dynamic truncation, RFC 4226 section 5.3 */
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1_000_000)
+}
+
+/*
+Checks code against the steps around t and returns the step it matched, so the
+caller can refuse a code that was already used.
+*/
+func Verify(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for step := now - skew; step <= now+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+/* The secret as users type it into an authenticator app */
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+/* The otpauth:// URI authenticator apps read from a QR code */
+func URI(issuer, account string, secret []byte) string {
+	values := url.Values{}
+	values.Set("secret", EncodeSecret(secret))
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(digits))
+	values.Set("period", fmt.Sprint(period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: values.Encode(),
+	}
+
+	return u.String()
+}
+
+/* Encrypts secrets with AES-256-GCM, the nonce is stored in front of the ciphertext */
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, errors.New("totp: key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+func (c *Cipher) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c *Cipher) Open(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.New("totp: ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,58 @@
+package validator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mohafarman/greenlight/internal/i18n"
+)
+
+/*
+ValidationError carries field errors across layers, so a model can report e.g. a
+duplicate email found by a constraint violation and the handler still answers
+with the usual 422 body. Err optionally holds the sentinel error that caused it.
+*/
+type ValidationError struct {
+	Errors map[string][]string
+	Err    error
+}
+
+/* Returns a ValidationError with a single field error wrapping err */
+func NewFieldError(err error, key, message string) *ValidationError {
+	return &ValidationError{
+		Errors: map[string][]string{key: {message}},
+		Err:    err,
+	}
+}
+
+func (e *ValidationError) Error() string {
+	/* For logs and the gRPC status, which aren't translated */
+	errors := Localize(e.Errors, i18n.DefaultLocale)
+
+	keys := make([]string, 0, len(errors))
+	for key := range errors {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s: %s", key, strings.Join(errors[key], ", ")))
+	}
+
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+/* Returns nil if there are no errors, otherwise a *ValidationError holding them */
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+
+	return &ValidationError{Errors: v.Errors}
+}
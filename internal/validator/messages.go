@@ -0,0 +1,65 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mohafarman/greenlight/internal/i18n"
+)
+
+/*
+Keyed messages start with messageMark and keep their arguments after
+messageSeparator, neither can appear in a literal message typed in the code.
+*/
+const (
+	messageMark      = "\x00"
+	messageSeparator = "\x1f"
+)
+
+/*
+Message records a message key of the i18n catalogues instead of literal English,
+e.g. v.CheckField(ok, "title", Message("validation.max_chars", 100)). The key and
+its arguments are packed into the string so Errors keeps its shape, Localize
+writes them out in the client's language. Arguments are kept as text, the
+catalogues format them with %s.
+*/
+func Message(key string, args ...any) string {
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, messageMark+key)
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprint(arg))
+	}
+
+	return strings.Join(parts, messageSeparator)
+}
+
+/* Translates a message made by Message into locale, literal messages are returned as they are */
+func LocalizeMessage(message, locale string) string {
+	packed, ok := strings.CutPrefix(message, messageMark)
+	if !ok {
+		return message
+	}
+
+	parts := strings.Split(packed, messageSeparator)
+
+	args := make([]any, 0, len(parts)-1)
+	for _, arg := range parts[1:] {
+		args = append(args, arg)
+	}
+
+	return i18n.Translate(locale, parts[0], args...)
+}
+
+/* Returns a copy of errors with every message translated into locale */
+func Localize(errors map[string][]string, locale string) map[string][]string {
+	localized := make(map[string][]string, len(errors))
+
+	for key, messages := range errors {
+		localized[key] = make([]string, len(messages))
+		for i, message := range messages {
+			localized[key][i] = LocalizeMessage(message, locale)
+		}
+	}
+
+	return localized
+}
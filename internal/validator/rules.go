@@ -0,0 +1,58 @@
+package validator
+
+import (
+	"fmt"
+	"sync"
+)
+
+/* Reports whether value satisfies the rule, param is the text after "=" in a tag */
+type RuleFunc func(value any, param string) bool
+
+type rule struct {
+	fn      RuleFunc
+	message string
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   = make(map[string]rule)
+)
+
+/*
+RegisterRule makes a named rule available to struct tags and CheckRule, so domain
+rules such as "imdb_id" or "slug" can be shared between inputs. It panics if the
+name is already taken, registration is expected to happen from init().
+*/
+func RegisterRule(name, message string, fn RuleFunc) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	if isBuiltinRule(name) {
+		panic(fmt.Sprintf("validator: rule %q is a built-in rule", name))
+	}
+
+	if _, exists := rules[name]; exists {
+		panic(fmt.Sprintf("validator: rule %q registered twice", name))
+	}
+
+	rules[name] = rule{fn: fn, message: message}
+}
+
+func lookupRule(name string) (rule, bool) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	r, ok := rules[name]
+	return r, ok
+}
+
+/* Runs the registered rule name against value and records its message under key on failure */
+func (v *Validator) CheckRule(key, name string, value any) {
+	r, ok := lookupRule(name)
+	if !ok {
+		/* Logical error in our codebase */
+		panic(fmt.Sprintf("validator: unknown rule %q for %q", name, key))
+	}
+
+	v.CheckField(r.fn(value, ""), key, r.message)
+}
@@ -0,0 +1,236 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+ValidateStruct checks every field of the struct s (or pointer to struct) against
+the rules declared in its `validate` tag, built-in or added with RegisterRule, e.g.
+
+	Title string `json:"title" validate:"required,max=100"`
+
+Errors are keyed by the field's json name. Nested structs and slices of structs
+are validated too, with keys such as "credits[2].name", and "dive" applies the
+rules after it to every element of a slice:
+
+	Genres []string `json:"genres" validate:"required,max=5,dive,required"`
+
+Custom rules can still be added to v with CheckField before or after calling
+ValidateStruct.
+*/
+func ValidateStruct(v *Validator, s any) {
+	rv := reflect.ValueOf(s)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		/* Logical error in our codebase */
+		panic(fmt.Sprintf("validator: ValidateStruct called with non-struct type %s", rv.Type()))
+	}
+
+	validateStruct(v, "", rv)
+}
+
+func validateStruct(v *Validator, prefix string, rv reflect.Value) {
+	rt := rv.Type()
+
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+
+		tag := field.Tag.Get("validate")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		key := FieldKey(prefix, fieldKey(field))
+		fv := rv.Field(i)
+
+		if tag != "" {
+			checkRules(v, key, fv, strings.Split(tag, ","))
+		}
+
+		validateNested(v, key, fv)
+	}
+}
+
+/* Descends into struct fields and slices of structs so their own tags are checked */
+func validateNested(v *Validator, key string, fv reflect.Value) {
+	for fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return
+		}
+		fv = fv.Elem()
+	}
+
+	switch fv.Kind() {
+	case reflect.Struct:
+		validateStruct(v, key, fv)
+	case reflect.Slice, reflect.Array:
+		for i := range fv.Len() {
+			validateNested(v, IndexKey(key, i), fv.Index(i))
+		}
+	}
+}
+
+func checkRules(v *Validator, key string, fv reflect.Value, rules []string) {
+	for i, rule := range rules {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+
+		if name == "dive" {
+			/* Like checkRule skips a nil pointer, there is nothing to dive into */
+			for fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					return
+				}
+				fv = fv.Elem()
+			}
+
+			if fv.Kind() != reflect.Slice && fv.Kind() != reflect.Array {
+				panic(fmt.Sprintf("validator: dive rule used on %s", fv.Type()))
+			}
+
+			for j := range fv.Len() {
+				checkRules(v, IndexKey(key, j), fv.Index(j), rules[i+1:])
+			}
+			return
+		}
+
+		checkRule(v, key, fv, name, param)
+	}
+}
+
+/* Uses the json name of a field so error keys match the request body */
+func fieldKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return strings.ToLower(field.Name)
+	}
+
+	return name
+}
+
+func checkRule(v *Validator, key string, fv reflect.Value, name, param string) {
+	/* A nil pointer only fails "required", every other rule is skipped */
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			if name == "required" {
+				v.AddError(key, Message("validation.required"))
+			}
+			return
+		}
+		fv = fv.Elem()
+	}
+
+	switch name {
+	case "required":
+		v.CheckField(!isZero(fv), key, Message("validation.required"))
+
+	case "min":
+		n := ruleParam(name, param)
+		switch fv.Kind() {
+		case reflect.String:
+			v.CheckField(MinChars(fv.String(), n), key, Message("validation.min_chars", n))
+		case reflect.Slice, reflect.Array, reflect.Map:
+			v.CheckField(Min(fv.Len(), n), key, Message("validation.min_items", n))
+		default:
+			v.CheckField(Min(numeric(fv), float64(n)), key, Message("validation.min", n))
+		}
+
+	case "max":
+		n := ruleParam(name, param)
+		switch fv.Kind() {
+		case reflect.String:
+			v.CheckField(MaxChars(fv.String(), n), key, Message("validation.max_chars", n))
+		case reflect.Slice, reflect.Array, reflect.Map:
+			v.CheckField(Max(fv.Len(), n), key, Message("validation.max_items", n))
+		default:
+			v.CheckField(Max(numeric(fv), float64(n)), key, Message("validation.max", n))
+		}
+
+	case "email":
+		v.CheckField(Matches(fv.String(), EmailRX), key, Message("validation.email"))
+
+	case "url":
+		v.CheckField(IsURL(fv.String()), key, Message("validation.url"))
+
+	case "uuid":
+		v.CheckField(IsUUID(fv.String()), key, Message("validation.uuid"))
+
+	case "isodate":
+		v.CheckField(IsISODate(fv.String()), key, Message("validation.iso_date"))
+
+	case "oneof":
+		v.CheckField(PermittedValue(fmt.Sprint(fv.Interface()), strings.Fields(param)...), key, Message("validation.one_of", strings.Join(strings.Fields(param), ", ")))
+
+	case "unique":
+		v.CheckField(uniqueValues(fv), key, Message("validation.unique"))
+
+	default:
+		r, ok := lookupRule(name)
+		if !ok {
+			panic(fmt.Sprintf("validator: unknown rule %q for %q", name, key))
+		}
+
+		v.CheckField(r.fn(fv.Interface(), param), key, r.message)
+	}
+}
+
+func isBuiltinRule(name string) bool {
+	return PermittedValue(name, "required", "min", "max", "email", "url", "uuid", "isodate", "oneof", "unique", "dive")
+}
+
+func ruleParam(name, param string) int {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		panic(fmt.Sprintf("validator: rule %q requires an integer parameter, got %q", name, param))
+	}
+
+	return n
+}
+
+/* Blank strings and empty slices count as zero so "required" matches NotBlank */
+func isZero(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.String:
+		return !NotBlank(fv.String())
+	case reflect.Slice, reflect.Map:
+		return fv.Len() == 0
+	default:
+		return fv.IsZero()
+	}
+}
+
+func numeric(fv reflect.Value) float64 {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return fv.Float()
+	default:
+		panic(fmt.Sprintf("validator: numeric rule used on %s", fv.Type()))
+	}
+}
+
+func uniqueValues(fv reflect.Value) bool {
+	if fv.Kind() != reflect.Slice && fv.Kind() != reflect.Array {
+		panic(fmt.Sprintf("validator: unique rule used on %s", fv.Type()))
+	}
+
+	seen := make(map[any]bool, fv.Len())
+
+	for i := range fv.Len() {
+		seen[fv.Index(i).Interface()] = true
+	}
+
+	return len(seen) == fv.Len()
+}
@@ -0,0 +1,74 @@
+package validator
+
+import (
+	"reflect"
+	"testing"
+)
+
+type diveInput struct {
+	Genres  []string   `json:"genres" validate:"dive,required"`
+	Tags    *[]string  `json:"tags" validate:"dive,required,max=5"`
+	Aliases *[]*string `json:"aliases" validate:"dive,required"`
+}
+
+func TestValidateStructDive(t *testing.T) {
+	blank := " "
+	name := "alias"
+
+	tests := []struct {
+		name  string
+		input diveInput
+		want  map[string][]string
+	}{
+		{
+			name:  "nil slices",
+			input: diveInput{},
+			want:  map[string][]string{},
+		},
+		{
+			name:  "empty slices",
+			input: diveInput{Genres: []string{}, Tags: &[]string{}, Aliases: &[]*string{}},
+			want:  map[string][]string{},
+		},
+		{
+			name:  "valid elements",
+			input: diveInput{Genres: []string{"drama"}, Tags: &[]string{"cult"}, Aliases: &[]*string{&name}},
+			want:  map[string][]string{},
+		},
+		{
+			name:  "invalid elements",
+			input: diveInput{Genres: []string{"drama", ""}, Tags: &[]string{"classics"}, Aliases: &[]*string{nil, &blank}},
+			want: map[string][]string{
+				"genres[1]":  {Message("validation.required")},
+				"tags[0]":    {Message("validation.max_chars", 5)},
+				"aliases[0]": {Message("validation.required")},
+				"aliases[1]": {Message("validation.required")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			ValidateStruct(v, &tt.input)
+
+			if !reflect.DeepEqual(v.Errors, tt.want) {
+				t.Errorf("got errors %v; want %v", v.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateStructDiveNonSlice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("dive on a string didn't panic")
+		}
+	}()
+
+	input := struct {
+		Title string `json:"title" validate:"dive,required"`
+	}{Title: "x"}
+
+	ValidateStruct(New(), &input)
+}
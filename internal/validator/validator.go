@@ -1,7 +1,10 @@
 package validator
 
 import (
+	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -11,15 +14,33 @@ type Integer interface {
 	~int | ~int32
 }
 
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+		~float32 | ~float64
+}
+
+/* ISO 8601 calendar date, e.g. 2006-01-02 */
+const ISODateLayout = time.DateOnly
+
+var UUIDRX = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+/*
+Every failing rule for a field is kept, keyed by field name. Warnings are soft
+rules: they don't make the input invalid, handlers return them alongside the
+resource for the client to show.
+*/
 type Validator struct {
-	Errors map[string]string
+	Errors   map[string][]string
+	Warnings map[string][]string
 }
 
 func New() *Validator {
 	return &Validator{
-		Errors: make(map[string]string),
+		Errors:   make(map[string][]string),
+		Warnings: make(map[string][]string),
 	}
 }
 
@@ -27,10 +48,13 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+/* Appends message to the errors for key, the same message is only recorded once */
 func (v *Validator) AddError(key, message string) {
-	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+	if slices.Contains(v.Errors[key], message) {
+		return
 	}
+
+	v.Errors[key] = append(v.Errors[key], message)
 }
 
 func (v *Validator) CheckField(ok bool, key, message string) {
@@ -39,6 +63,80 @@ func (v *Validator) CheckField(ok bool, key, message string) {
 	}
 }
 
+/*
+CheckGroup is CheckField for rules about how several fields relate, e.g. "year
+is required when runtime is given": the message is recorded against each of
+keys, as any of them may be the one to fix. See RequiredIf and AllOrNone.
+*/
+func (v *Validator) CheckGroup(ok bool, message string, keys ...string) {
+	if !ok {
+		for _, key := range keys {
+			v.AddError(key, message)
+		}
+	}
+}
+
+/* Like AddError, a warning doesn't make v invalid */
+func (v *Validator) AddWarning(key, message string) {
+	if slices.Contains(v.Warnings[key], message) {
+		return
+	}
+
+	v.Warnings[key] = append(v.Warnings[key], message)
+}
+
+/* CheckField for soft rules, recording a warning instead of an error */
+func (v *Validator) CheckWarning(ok bool, key, message string) {
+	if !ok {
+		v.AddWarning(key, message)
+	}
+}
+
+/* Copies every error and warning of other into v, with each key nested under prefix */
+func (v *Validator) Merge(prefix string, other *Validator) {
+	for key, messages := range other.Errors {
+		for _, message := range messages {
+			v.AddError(FieldKey(prefix, key), message)
+		}
+	}
+
+	for key, messages := range other.Warnings {
+		for _, message := range messages {
+			v.AddWarning(FieldKey(prefix, key), message)
+		}
+	}
+}
+
+/*
+Each runs fn against every element of items with a fresh Validator and merges
+the results under indexed keys, e.g. "movies[2].title". Errors added by fn with
+an empty key belong to the element itself ("genres[0]").
+*/
+func Each[T any](v *Validator, key string, items []T, fn func(v *Validator, item T)) {
+	for i, item := range items {
+		ev := New()
+		fn(ev, item)
+		v.Merge(IndexKey(key, i), ev)
+	}
+}
+
+/* Returns the key for element i of key, e.g. "genres[0]" */
+func IndexKey(key string, i int) string {
+	return fmt.Sprintf("%s[%d]", key, i)
+}
+
+/* Joins a parent and child key with a dot, e.g. "movies[2].genres" */
+func FieldKey(parent, key string) string {
+	switch {
+	case parent == "":
+		return key
+	case key == "":
+		return parent
+	default:
+		return parent + "." + key
+	}
+}
+
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	for i := range permittedValues {
 		if value == permittedValues[i] {
@@ -64,6 +162,36 @@ func NotNegative[T Integer](value T) bool {
 	return value > 0
 }
 
+/* Returns true if value is greater than or equal to min */
+func Min[T Number](value, min T) bool {
+	return value >= min
+}
+
+/* Returns true if value is less than or equal to max */
+func Max[T Number](value, max T) bool {
+	return value <= max
+}
+
+/* Returns true if min <= value <= max */
+func Between[T Number](value, min, max T) bool {
+	return value >= min && value <= max
+}
+
+/* Returns true if value is one of list */
+func In(value string, list ...string) bool {
+	return PermittedValue(value, list...)
+}
+
+/* Returns true if value is none of list */
+func NotIn(value string, list ...string) bool {
+	return !PermittedValue(value, list...)
+}
+
+func MinChars(value string, n int) bool {
+	/* Return true if value contains at least n characters */
+	return utf8.RuneCountInString(value) >= n
+}
+
 func MaxChars(value string, n int) bool {
 	/* Return true if value contains no more than n characters */
 	return utf8.RuneCountInString(value) <= n
@@ -77,6 +205,76 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+/* Returns true if value is an absolute http(s) URL with a host */
+func IsURL(value string) bool {
+	u, err := url.ParseRequestURI(value)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func IsUUID(value string) bool {
+	return UUIDRX.MatchString(value)
+}
+
+/* Returns true if value is a valid date in the 2006-01-02 format */
+func IsISODate(value string) bool {
+	_, err := time.Parse(ISODateLayout, value)
+	return err == nil
+}
+
+/* Returns true if t lies within [start, end], a zero start or end leaves that side open */
+func DateBetween(t, start, end time.Time) bool {
+	if !start.IsZero() && t.Before(start) {
+		return false
+	}
+
+	if !end.IsZero() && t.After(end) {
+		return false
+	}
+
+	return true
+}
+
+/* Returns true if low <= high, a nil bound leaves the range open on that side */
+func ValidRange[T Number](low, high *T) bool {
+	return low == nil || high == nil || *low <= *high
+}
+
+/* Returns true if start is before end, a nil start or end leaves the range open on that side */
+func ValidDateRange(start, end *time.Time) bool {
+	return start == nil || end == nil || start.Before(*end)
+}
+
+/* Returns true if present or the condition doesn't hold, e.g. RequiredIf(year != 0, runtime != 0) */
+func RequiredIf(present, condition bool) bool {
+	return present || !condition
+}
+
+/* Returns true if every field is present or none is, e.g. both bounds of a range */
+func AllOrNone(present ...bool) bool {
+	return !slices.Contains(present, true) || !slices.Contains(present, false)
+}
+
+/* Returns true if at most one of the fields is present, for alternatives that exclude each other */
+func AtMostOne(present ...bool) bool {
+	count := 0
+	for _, p := range present {
+		if p {
+			count++
+		}
+	}
+
+	return count <= 1
+}
+
+/* Returns true if exactly one of the fields is present */
+func ExactlyOne(present ...bool) bool {
+	return AtMostOne(present...) && slices.Contains(present, true)
+}
+
 /* Returns true if all values in a generic slice are unique */
 func Unique[T comparable](values []T) bool {
 	uniqueValues := make(map[T]bool)
@@ -0,0 +1,254 @@
+package validator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNumberRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   float64
+		min     float64
+		max     float64
+		wantMin bool
+		wantMax bool
+	}{
+		{"below", 0, 1, 10, false, true},
+		{"at min", 1, 1, 10, true, true},
+		{"inside", 5.5, 1, 10, true, true},
+		{"at max", 10, 1, 10, true, true},
+		{"above", 10.1, 1, 10, true, false},
+		{"negative", -3, -5, -1, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Min(tt.value, tt.min); got != tt.wantMin {
+				t.Errorf("Min(%v, %v) = %t; want %t", tt.value, tt.min, got, tt.wantMin)
+			}
+			if got := Max(tt.value, tt.max); got != tt.wantMax {
+				t.Errorf("Max(%v, %v) = %t; want %t", tt.value, tt.max, got, tt.wantMax)
+			}
+			want := tt.wantMin && tt.wantMax
+			if got := Between(tt.value, tt.min, tt.max); got != want {
+				t.Errorf("Between(%v, %v, %v) = %t; want %t", tt.value, tt.min, tt.max, got, want)
+			}
+		})
+	}
+}
+
+func TestInNotIn(t *testing.T) {
+	list := []string{"csv", "json"}
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"csv", true},
+		{"json", true},
+		{"xml", false},
+		{"CSV", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := In(tt.value, list...); got != tt.want {
+				t.Errorf("In(%q) = %t; want %t", tt.value, got, tt.want)
+			}
+			if got := NotIn(tt.value, list...); got == tt.want {
+				t.Errorf("NotIn(%q) = %t; want %t", tt.value, got, !tt.want)
+			}
+		})
+	}
+
+	if In("csv") || !NotIn("csv") {
+		t.Error("an empty list must contain nothing")
+	}
+}
+
+func TestChars(t *testing.T) {
+	tests := []struct {
+		value   string
+		n       int
+		wantMin bool
+		wantMax bool
+	}{
+		{"", 0, true, true},
+		{"abc", 3, true, true},
+		{"ab", 3, false, true},
+		{"abcd", 3, true, false},
+		/* Counted in runes, not bytes */
+		{"åäö", 3, true, true},
+		{"日本語です", 5, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := MinChars(tt.value, tt.n); got != tt.wantMin {
+				t.Errorf("MinChars(%q, %d) = %t; want %t", tt.value, tt.n, got, tt.wantMin)
+			}
+			if got := MaxChars(tt.value, tt.n); got != tt.wantMax {
+				t.Errorf("MaxChars(%q, %d) = %t; want %t", tt.value, tt.n, got, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"https://example.com", true},
+		{"http://example.com:8080/hooks?x=1", true},
+		{"ftp://example.com", false},
+		{"javascript:alert(1)", false},
+		{"https://", false},
+		{"/relative/path", false},
+		{"example.com", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := IsURL(tt.value); got != tt.want {
+				t.Errorf("IsURL(%q) = %t; want %t", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsUUID(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"0F8FAD5B-D9CB-469F-A165-70867728950E", true},
+		{"0f8fad5bd9cb469fa16570867728950e", false},
+		{"0f8fad5b-d9cb-469f-a165-70867728950", false},
+		{"0f8fad5b-d9cb-469f-a165-70867728950e1", false},
+		{"zf8fad5b-d9cb-469f-a165-70867728950e", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := IsUUID(tt.value); got != tt.want {
+				t.Errorf("IsUUID(%q) = %t; want %t", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsISODate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"2024-02-29", true},
+		{"1999-12-31", true},
+		{"2023-02-29", false},
+		{"2024-13-01", false},
+		{"2024-1-01", false},
+		{"01/02/2024", false},
+		{"2024-01-01T00:00:00Z", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := IsISODate(tt.value); got != tt.want {
+				t.Errorf("IsISODate(%q) = %t; want %t", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateBetween(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		t     time.Time
+		start time.Time
+		end   time.Time
+		want  bool
+	}{
+		{"inside", start.AddDate(0, 6, 0), start, end, true},
+		{"at start", start, start, end, true},
+		{"at end", end, start, end, true},
+		{"before", start.Add(-time.Second), start, end, false},
+		{"after", end.Add(time.Second), start, end, false},
+		{"open start", start.AddDate(-10, 0, 0), time.Time{}, end, true},
+		{"open end", end.AddDate(10, 0, 0), start, time.Time{}, true},
+		{"open end before start", start.Add(-time.Second), start, time.Time{}, false},
+		{"unbounded", time.Time{}, time.Time{}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DateBetween(tt.t, tt.start, tt.end); got != tt.want {
+				t.Errorf("DateBetween(%v, %v, %v) = %t; want %t", tt.t, tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	tests := []struct {
+		present    []bool
+		allOrNone  bool
+		atMostOne  bool
+		exactlyOne bool
+	}{
+		{[]bool{}, true, true, false},
+		{[]bool{false, false}, true, true, false},
+		{[]bool{true, false}, false, true, true},
+		{[]bool{false, true}, false, true, true},
+		{[]bool{true, true}, true, false, false},
+		{[]bool{true, true, false}, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.present), func(t *testing.T) {
+			if got := AllOrNone(tt.present...); got != tt.allOrNone {
+				t.Errorf("AllOrNone = %t; want %t", got, tt.allOrNone)
+			}
+			if got := AtMostOne(tt.present...); got != tt.atMostOne {
+				t.Errorf("AtMostOne = %t; want %t", got, tt.atMostOne)
+			}
+			if got := ExactlyOne(tt.present...); got != tt.exactlyOne {
+				t.Errorf("ExactlyOne = %t; want %t", got, tt.exactlyOne)
+			}
+		})
+	}
+
+	for _, tt := range []struct{ present, condition, want bool }{
+		{false, false, true},
+		{false, true, false},
+		{true, false, true},
+		{true, true, true},
+	} {
+		if got := RequiredIf(tt.present, tt.condition); got != tt.want {
+			t.Errorf("RequiredIf(%t, %t) = %t; want %t", tt.present, tt.condition, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGroup(t *testing.T) {
+	v := New()
+	v.CheckGroup(true, "unused", "a", "b")
+	v.CheckGroup(false, Message("validation.invalid_range", "a", "b"), "a", "b")
+
+	for _, key := range []string{"a", "b"} {
+		got := Localize(v.Errors, "en")[key]
+		if len(got) != 1 || got[0] != "a must not be greater than b" {
+			t.Errorf("got %s errors %q; want [\"a must not be greater than b\"]", key, got)
+		}
+	}
+}
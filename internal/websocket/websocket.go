@@ -0,0 +1,320 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A minimal server side of RFC 6455 for pushing messages to clients. Text
+messages sent by the client in a single frame can be read from Messages, other
+messages are discarded; pings are answered and a close frame from the client
+ends the connection.
+*/
+
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+/* Close codes, see RFC 6455 section 7.4 and the IANA registry */
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseTryAgainLater   = 1013
+)
+
+/* Client messages are small or discarded, so anything bigger is a misbehaving client */
+const maxFrameSize = 64 << 10
+
+/* Text messages kept for Messages, more are dropped until they are read */
+const messageBuffer = 16
+
+const writeTimeout = 10 * time.Second
+
+/* From RFC 6455 section 1.3 */
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+type Conn struct {
+	conn net.Conn
+	brw  *bufio.ReadWriter
+
+	/* Guards writes, pongs are sent from the read loop */
+	mu     sync.Mutex
+	closed bool
+
+	messages chan []byte
+
+	done chan struct{}
+	err  error
+}
+
+/* Reports whether r asks for a WebSocket upgrade */
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+/*
+Completes the opening handshake and takes over the connection. On error
+nothing has been written to w, so the caller can still send an error response.
+*/
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		return nil, fmt.Errorf("%w: not a websocket upgrade request", ErrBadHandshake)
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: unsupported version, only 13 is supported", ErrBadHandshake)
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	/* The server's read and write timeouts no longer apply */
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	brw.WriteString("Upgrade: websocket\r\n")
+	brw.WriteString("Connection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Conn{conn: conn, brw: brw, messages: make(chan []byte, messageBuffer), done: make(chan struct{})}
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+/* Closed once the client has closed the connection or it failed */
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+/* The client's text messages, closed with Done */
+func (c *Conn) Messages() <-chan []byte {
+	return c.messages
+}
+
+/* Why Done was closed, nil if the client closed normally */
+func (c *Conn) Err() error {
+	<-c.done
+	return c.err
+}
+
+func (c *Conn) WriteText(message []byte) error {
+	return c.writeFrame(opText, message)
+}
+
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+/* Sends a close frame and closes the connection, safe to call more than once */
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+
+	err := c.writeFrame(opClose, payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	/* Server frames are never fragmented nor masked */
+	header := []byte{0x80 | opcode}
+
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	c.brw.Write(header)
+	c.brw.Write(payload)
+
+	return c.brw.Flush()
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.done)
+	defer close(c.messages)
+
+	for {
+		opcode, fin, payload, err := c.readFrame()
+		if err != nil {
+			var code int
+			switch {
+			case errors.Is(err, errFrameTooBig):
+				code = CloseMessageTooBig
+			case errors.Is(err, errProtocol):
+				code = CloseProtocolError
+			}
+
+			if code != 0 {
+				c.Close(code, "")
+			}
+
+			c.err = err
+			return
+		}
+
+		switch opcode {
+		case opText:
+			/* Fragmented messages aren't put back together, the reader of Messages can't tell */
+			if !fin {
+				continue
+			}
+
+			select {
+			case c.messages <- payload:
+			default:
+			}
+		case opPing:
+			c.writeFrame(opPong, payload)
+		case opClose:
+			/* Echo the status code back, as required before closing */
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.Close(code, "")
+			return
+		}
+	}
+}
+
+var (
+	errProtocol    = errors.New("websocket: protocol error")
+	errFrameTooBig = errors.New("websocket: frame too big")
+)
+
+/* The frame's opcode, whether it is the last of its message and its unmasked payload */
+func (c *Conn) readFrame() (byte, bool, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.brw, header[:]); err != nil {
+		return 0, false, nil, err
+	}
+
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	if header[0]&0x70 != 0 {
+		return 0, false, nil, fmt.Errorf("%w: reserved bits set", errProtocol)
+	}
+
+	/* Clients must mask every frame */
+	if !masked {
+		return 0, false, nil, fmt.Errorf("%w: unmasked client frame", errProtocol)
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.brw, ext[:]); err != nil {
+			return 0, false, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.brw, ext[:]); err != nil {
+			return 0, false, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if opcode >= opClose && length > 125 {
+		return 0, false, nil, fmt.Errorf("%w: control frame too long", errProtocol)
+	}
+
+	if length > maxFrameSize {
+		return 0, false, nil, errFrameTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.brw, mask[:]); err != nil {
+		return 0, false, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.brw, payload); err != nil {
+		return 0, false, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return opcode, header[0]&0x80 != 0, payload, nil
+}
+
+/* Whether a comma separated header contains token, case insensitively */
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for v := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mohafarman/greenlight/internal/data"
+)
+
+/* Claims and records the attempts at outbox entries, i.e. data.OutboxModel */
+type OutboxStore interface {
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*data.OutboxEntry, error)
+	RecordAttempt(ctx context.Context, id int64, next time.Time, err error) error
+}
+
+/* Delivers an entry of one kind, called again with the same entry after a failure */
+type OutboxHandler func(ctx context.Context, entry *data.OutboxEntry) error
+
+type DispatcherConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	/* Attempts before an entry is dead, including the first one */
+	MaxAttempts int
+	/* Delay before the first retry, doubled for every retry after that */
+	BaseDelay time.Duration
+	/* Of a single attempt */
+	Timeout time.Duration
+}
+
+/*
+Dispatcher polls the outbox for pending entries and hands each one to the
+handler of its kind. Entries are delivered at least once, since an attempt is
+recorded after it is made; several dispatchers can poll the same outbox.
+*/
+type Dispatcher struct {
+	store    OutboxStore
+	cfg      DispatcherConfig
+	logger   *slog.Logger
+	handlers map[string]OutboxHandler
+}
+
+func NewDispatcher(store OutboxStore, cfg DispatcherConfig, logger *slog.Logger) *Dispatcher {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	cfg.BatchSize = max(cfg.BatchSize, 1)
+	cfg.MaxAttempts = max(cfg.MaxAttempts, 1)
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = time.Minute
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	return &Dispatcher{
+		store:    store,
+		cfg:      cfg,
+		logger:   logger,
+		handlers: make(map[string]OutboxHandler),
+	}
+}
+
+/* Register the handlers before calling Run */
+func (d *Dispatcher) Handle(kind string, handler OutboxHandler) {
+	d.handlers[kind] = handler
+}
+
+/* Polls until ctx is cancelled, an attempt in progress is cancelled with it */
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		/* Lease long enough to cover a whole batch of timed out attempts */
+		entries, err := d.store.Claim(ctx, d.cfg.BatchSize, time.Duration(d.cfg.BatchSize)*d.cfg.Timeout+time.Minute)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Error(err.Error(), "job", "outbox")
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				/* Claimed again once the lease is over */
+				return
+			}
+
+			d.deliver(ctx, entry)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, entry *data.OutboxEntry) {
+	err := d.call(ctx, entry)
+
+	var next time.Time
+
+	attempt := entry.Attempts + 1
+
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		/* Cut short by the shutdown rather than failed, left to the lease */
+		return
+	case attempt < d.cfg.MaxAttempts:
+		next = time.Now().Add(d.cfg.BaseDelay << (attempt - 1))
+	default:
+		d.logger.Error(err.Error(),
+			"job", "outbox "+entry.Kind,
+			"outbox_id", entry.ID,
+			"attempts", attempt,
+			"dead_letter", true,
+		)
+	}
+
+	/* Recorded even when ctx is cancelled meanwhile, the attempt was made */
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 3*time.Second)
+	defer cancel()
+
+	err = d.store.RecordAttempt(recordCtx, entry.ID, next, err)
+	if err != nil {
+		d.logger.Error(err.Error(), "outbox_id", entry.ID)
+	}
+}
+
+/* An unknown kind or a panicking handler fails like a handler returning an error */
+func (d *Dispatcher) call(ctx context.Context, entry *data.OutboxEntry) (err error) {
+	handler, ok := d.handlers[entry.Kind]
+	if !ok {
+		return fmt.Errorf("worker: no outbox handler for %q", entry.Kind)
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	return handler(ctx, entry)
+}
@@ -0,0 +1,229 @@
+/*
+Package worker runs jobs on a fixed number of goroutines from a bounded queue,
+retrying failed jobs with exponential backoff. Jobs that still fail after the
+last attempt are logged as dead letters and dropped.
+
+Dispatcher delivers the entries of the database outbox in the same way, but
+from the database, so they outlive a restart.
+*/
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull   = errors.New("worker: queue is full")
+	ErrQueueClosed = errors.New("worker: queue is shut down")
+)
+
+type Config struct {
+	Workers   int
+	QueueSize int
+	/* Attempts before a job is dead, including the first one */
+	MaxAttempts int
+	/* Delay before the first retry, doubled for every retry after that */
+	BaseDelay time.Duration
+}
+
+type job struct {
+	/* Shown in the logs, e.g. "welcome email" */
+	name    string
+	run     func(ctx context.Context) error
+	attempt int
+}
+
+type Stats struct {
+	Queued    int   `json:"queued"`
+	Running   int64 `json:"running"`
+	Succeeded int64 `json:"succeeded"`
+	Retried   int64 `json:"retried"`
+	Dead      int64 `json:"dead"`
+}
+
+type Queue struct {
+	cfg    Config
+	logger *slog.Logger
+	jobs   chan *job
+
+	mu     sync.Mutex
+	closed bool
+
+	/* Jobs enqueued and not yet done with, including those waiting for a retry */
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+
+	/* Cancels running jobs and skips the remaining retries when draining times out */
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	running   atomic.Int64
+	succeeded atomic.Int64
+	retried   atomic.Int64
+	dead      atomic.Int64
+}
+
+/* Starts cfg.Workers goroutines, stop them with Shutdown */
+func New(cfg Config, logger *slog.Logger) *Queue {
+	cfg.Workers = max(cfg.Workers, 1)
+	cfg.QueueSize = max(cfg.QueueSize, 1)
+	cfg.MaxAttempts = max(cfg.MaxAttempts, 1)
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = time.Second
+	}
+
+	q := &Queue{
+		cfg:    cfg,
+		logger: logger,
+		jobs:   make(chan *job, cfg.QueueSize),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+
+	for range cfg.Workers {
+		q.workers.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+/* Never blocks, a full queue is reported as ErrQueueFull */
+func (q *Queue) Enqueue(name string, run func(ctx context.Context) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	/* Counted before the send, a worker may be done with it before the select returns */
+	q.pending.Add(1)
+
+	select {
+	case q.jobs <- &job{name: name, run: run}:
+		return nil
+	default:
+		q.pending.Done()
+		return ErrQueueFull
+	}
+}
+
+func (q *Queue) Stats() Stats {
+	return Stats{
+		Queued:    len(q.jobs),
+		Running:   q.running.Load(),
+		Succeeded: q.succeeded.Load(),
+		Retried:   q.retried.Load(),
+		Dead:      q.dead.Load(),
+	}
+}
+
+/*
+Stops accepting jobs and waits for the queued ones, and their retries, to
+finish. When ctx expires first the running jobs are cancelled and what is left
+is logged as dead.
+*/
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		q.pending.Wait()
+		close(drained)
+	}()
+
+	var err error
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		q.cancel()
+		<-drained
+	}
+
+	q.cancel()
+	close(q.jobs)
+	q.workers.Wait()
+
+	return err
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+
+	for j := range q.jobs {
+		q.run(j)
+	}
+}
+
+func (q *Queue) run(j *job) {
+	j.attempt++
+
+	if q.ctx.Err() != nil {
+		q.bury(j, q.ctx.Err())
+		return
+	}
+
+	q.running.Add(1)
+	err := q.call(j)
+	q.running.Add(-1)
+
+	switch {
+	case err == nil:
+		q.succeeded.Add(1)
+		q.pending.Done()
+	case j.attempt >= q.cfg.MaxAttempts:
+		q.bury(j, err)
+	default:
+		q.retried.Add(1)
+		q.retry(j, q.cfg.BaseDelay<<(j.attempt-1))
+	}
+}
+
+/* A panicking job fails like one returning an error */
+func (q *Queue) call(j *job) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+
+	return j.run(q.ctx)
+}
+
+/*
+Puts the job back after delay. The channel isn't closed before every pending
+job is done, so the send can't panic; it blocks a timer goroutine rather than a
+worker when the queue is full.
+*/
+func (q *Queue) retry(j *job, delay time.Duration) {
+	go func() {
+		select {
+		case <-time.After(delay):
+		case <-q.ctx.Done():
+		}
+
+		q.jobs <- j
+	}()
+}
+
+/* The dead letter, only the log is left of it */
+func (q *Queue) bury(j *job, err error) {
+	q.dead.Add(1)
+	q.pending.Done()
+
+	q.logger.Error(err.Error(),
+		"job", j.name,
+		"attempts", j.attempt,
+		"dead_letter", true,
+	)
+}